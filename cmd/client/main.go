@@ -2,31 +2,112 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/Hiddan13/file_grpc/api/proto/pb"
+	"github.com/Hiddan13/file_grpc/internal/config"
+	"github.com/Hiddan13/file_grpc/internal/filesync"
+	"github.com/Hiddan13/file_grpc/internal/tracing"
 
+	"go.opentelemetry.io/otel"
+	otelCodes "go.opentelemetry.io/otel/codes"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
 )
 
 var (
 	serverAddr = flag.String("address", "localhost:50051", "server address")
-	action     = flag.String("action", "", "upload/download/list")
-	filename   = flag.String("file", "", "file to upload or download")
+	action     = flag.String("action", "", "upload/download/download-many/archive/list/set-metadata/versions/restore/sync/push/pull/limits/set-limits/transfers/cancel/reindex/gc/scrub/config")
+	filename   = flag.String("file", "", "file to upload or download; upload also takes a directory or glob, download-many a comma separated list")
+	clientID   = flag.String("client-id", "", "client label sent as x-client-id")
+	version    = flag.Int("version", 0, "file version for download/restore (0 - current)")
+	metaFlag   = flag.String("meta", "", "user metadata key=value,key2=value2 for upload/set-metadata")
+	tagsFlag   = flag.String("tags", "", "comma separated tags for upload/set-metadata, tag filter for list")
+	ctypeFlag  = flag.String("content-type", "", "MIME type for upload (empty - detected by the server)")
+	ttlFlag    = flag.Duration("ttl", 0, "upload: remove the file after this time (0 - keep current)")
+	prefix     = flag.String("prefix", "", "filename prefix filter for list/archive/sync")
+	format     = flag.String("format", "tar", "archive format: tar, tar.gz or zip")
+	outFile    = flag.String("out", "", "output file for archive (default archive.<format>)")
+	extract    = flag.String("extract", "", "upload: extract the archive (tar, tar.gz or zip) into separate files")
+	streamFlag = flag.Bool("stream", false, "upload through UploadStream with progress and resumption")
+	uploadID   = flag.String("upload-id", "", "upload: resume the interrupted stream upload with this id (implies -stream)")
+
+	ifNoneMatch       = flag.Bool("if-none-match", false, "upload only if the file does not exist")
+	ifMatch           = flag.String("if-match", "", "upload only if the current etag matches (* - file exists)")
+	ifUnmodifiedSince = flag.String("if-unmodified-since", "", "upload only if the file was not modified after this RFC3339 time")
+
+	adminToken    = flag.String("admin-token", "", "token for admin actions")
+	uploadLimit   = flag.Int("upload-limit", 0, "new upload limit for set-limits (0 - keep)")
+	downloadLimit = flag.Int("download-limit", 0, "new download limit for set-limits (0 - keep)")
+	listLimit     = flag.Int("list-limit", 0, "new list limit for set-limits (0 - keep)")
+	transferID    = flag.String("id", "", "transfer id for cancel")
+	olderThan     = flag.Duration("older-than", time.Hour, "gc removes temp files older than this")
+	dryRun        = flag.Bool("dry-run", false, "gc/sync/push/pull only list files that would be changed")
+	repairMeta    = flag.Bool("repair", false, "scrub brings metadata in line with the disk")
+	quarantine    = flag.Bool("quarantine", false, "scrub moves corrupt files to .quarantine")
+
+	destAddr   = flag.String("dest", "", "sync: destination server address, -address is the source")
+	dirFlag    = flag.String("dir", "", "push/pull: local directory to mirror")
+	deleteFlag = flag.Bool("delete", false, "sync/push/pull: remove destination files missing on the source")
+	bwLimit    = flag.Int("bwlimit", 0, "sync/push/pull: transfer limit in bytes per second (0 - unlimited)")
+	parallel   = flag.Int("parallel", 0, "sync/push/pull: concurrent transfers (0 - sync one, push/pull free server slots)")
+	checksum   = flag.Bool("checksum", false, "push/pull: compare sha256 even if size and mtime match")
+	watch      = flag.Bool("watch", false, "push: keep watching the directory and push changes")
+
+	traceExporter = flag.String("trace-exporter", "none", "none/otlp/file")
+	otlpEndpoint  = flag.String("otlp-endpoint", "localhost:4317", "OTLP collector address")
+	traceFile     = flag.String("trace-file", "./client_traces.json", "file for the file exporter")
 )
 
 func main() {
 	flag.Parse()
 
-	conn, err := grpc.Dial(*serverAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	shutdownTracing, err := tracing.Setup(context.Background(), "file_grpc-client", &config.Config{
+		TracingExporter: *traceExporter,
+		OTLPEndpoint:    *otlpEndpoint,
+		TracingFile:     *traceFile,
+	})
+	if err != nil {
+		log.Fatalf("failed to init tracing: %v", err)
+	}
+
+	// Корневой спан на всё действие клиента
+	ctx, span := otel.Tracer("github.com/Hiddan13/file_grpc/cmd/client").Start(context.Background(), "client."+*action)
+	if *clientID != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "x-client-id", *clientID)
+	}
+
+	err = run(ctx)
 	if err != nil {
-		log.Fatalf("did not connect: %v", err)
+		span.RecordError(err)
+		span.SetStatus(otelCodes.Error, err.Error())
+	}
+	// log.Fatal не выполняет defer: спан и трассировку закрываем до выхода
+	span.End()
+	shutdownTracing(context.Background())
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run выполняет действие -action
+func run(ctx context.Context) error {
+	conn, err := dial(*serverAddr)
+	if err != nil {
+		return err
 	}
 	defer conn.Close()
 	client := pb.NewFileServiceClient(conn)
@@ -34,33 +115,96 @@ func main() {
 	switch *action {
 	case "upload":
 		if *filename == "" {
-			log.Fatal("filename required for upload")
+			return errors.New("filename required for upload")
+		}
+		// Директория или шаблон загружаются одним стримом
+		paths, ok, err := batchPaths(*filename)
+		if err != nil {
+			return err
 		}
-		uploadFile(client, *filename)
+		if ok {
+			return uploadMany(ctx, client, paths)
+		}
+		if *streamFlag || *uploadID != "" {
+			return uploadStream(ctx, client, *filename)
+		}
+		return uploadFile(ctx, client, *filename)
 	case "download":
 		if *filename == "" {
-			log.Fatal("filename required for download")
+			return errors.New("filename required for download")
+		}
+		return downloadFile(ctx, client, *filename, *version)
+	case "download-many":
+		if *filename == "" {
+			return errors.New("filenames required for download-many")
 		}
-		downloadFile(client, *filename)
+		return downloadMany(ctx, client, splitList(*filename))
+	case "archive":
+		return downloadArchive(ctx, client, splitList(*filename))
 	case "list":
-		listFiles(client)
+		return listFiles(ctx, client, splitList(*tagsFlag))
+	case "set-metadata":
+		if *filename == "" {
+			return errors.New("filename required for set-metadata")
+		}
+		return setMetadata(ctx, client, *filename)
+	case "versions":
+		if *filename == "" {
+			return errors.New("filename required for versions")
+		}
+		return listVersions(ctx, client, *filename)
+	case "restore":
+		if *filename == "" || *version <= 0 {
+			return errors.New("filename and version required for restore")
+		}
+		return restoreVersion(ctx, client, *filename, *version)
+	case "sync":
+		if *destAddr == "" {
+			return errors.New("destination address (-dest) required for sync")
+		}
+		dest, err := dial(*destAddr)
+		if err != nil {
+			return err
+		}
+		defer dest.Close()
+		return syncServers(ctx, client, pb.NewFileServiceClient(dest))
+	case "push", "pull":
+		if *dirFlag == "" {
+			return fmt.Errorf("directory (-dir) required for %s", *action)
+		}
+		return syncDir(ctx, client, *action, *dirFlag)
+	case "limits", "set-limits", "transfers", "cancel", "reindex", "gc", "scrub", "config":
+		ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+*adminToken)
+		return runAdmin(ctx, pb.NewAdminServiceClient(conn), *action)
 	default:
-		log.Fatal("unknown action, use upload/download/list")
+		return errors.New("unknown action, use upload/download/download-many/archive/list/set-metadata/versions/restore/sync/push/pull/limits/set-limits/transfers/cancel/reindex/gc/scrub/config")
 	}
 }
 
-func uploadFile(client pb.FileServiceClient, filename string) {
+func dial(addr string) (*grpc.ClientConn, error) {
+	conn, err := grpc.Dial(addr,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithChainUnaryInterceptor(tracing.UnaryClientInterceptor()),
+		grpc.WithChainStreamInterceptor(tracing.StreamClientInterceptor()),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("did not connect to %s: %w", addr, err)
+	}
+	return conn, nil
+}
+
+func uploadFile(ctx context.Context, client pb.FileServiceClient, filename string) error {
 	data, err := os.ReadFile(filename)
 	if err != nil {
-		log.Fatalf("failed to read file: %v", err)
+		return fmt.Errorf("failed to read file: %w", err)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
 	stream, err := client.Upload(ctx)
 	if err != nil {
-		log.Fatalf("failed to start upload: %v", err)
+		return fmt.Errorf("failed to start upload: %w", err)
 	}
 
 	// Отправляем частями по 64KB
@@ -70,29 +214,395 @@ func uploadFile(client pb.FileServiceClient, filename string) {
 		if end > len(data) {
 			end = len(data)
 		}
-		err := stream.Send(&pb.UploadRequest{
+		req := &pb.UploadRequest{
 			Filename: filename,
 			Chunk:    data[i:end],
-		})
+		}
+		// Условия записи и метаданные передаются в первом сообщении
+		if i == 0 {
+			if err := setUploadOptions(req); err != nil {
+				return err
+			}
+		}
+		err := stream.Send(req)
 		if err != nil {
-			log.Fatalf("failed to send chunk: %v", err)
+			return fmt.Errorf("failed to send chunk: %w", err)
 		}
 	}
 
 	resp, err := stream.CloseAndRecv()
 	if err != nil {
-		log.Fatalf("upload failed: %v", err)
+		return fmt.Errorf("upload failed: %w", err)
+	}
+	printUploadResult(resp)
+	return nil
+}
+
+// setUploadOptions заполняет параметры первого сообщения загрузки из флагов
+func setUploadOptions(req *pb.UploadRequest) error {
+	md, err := parseMetadata(*metaFlag)
+	if err != nil {
+		return err
+	}
+	req.IfNoneMatch = *ifNoneMatch
+	req.IfMatch = *ifMatch
+	req.IfUnmodifiedSince = *ifUnmodifiedSince
+	req.Metadata = md
+	req.Tags = splitList(*tagsFlag)
+	req.ContentType = *ctypeFlag
+	req.TtlSeconds = int64(ttlFlag.Seconds())
+	req.Extract = *extract
+	return nil
+}
+
+func printUploadResult(resp *pb.UploadResponse) {
+	fmt.Printf("Uploaded: %s, size=%d bytes, etag=%s, type=%s\n", resp.Message, resp.Size, resp.Etag, resp.ContentType)
+	if resp.ExpiresAt != "" {
+		fmt.Printf("Expires at: %s\n", resp.ExpiresAt)
+	}
+	for _, f := range resp.Extracted {
+		if f.Code != 0 {
+			fmt.Printf("FAILED %s: %s: %s\n", f.Filename, codes.Code(f.Code), f.Error)
+			continue
+		}
+		fmt.Printf("OK     %s: size=%d bytes, etag=%s, type=%s\n", f.Filename, f.Result.GetSize(), f.Result.GetEtag(), f.Result.GetContentType())
+	}
+}
+
+// Сколько байт uploadStream отправляет сверх подтверждённых сервером
+const streamWindow = 4 << 20
+
+// uploadStream загружает файл через UploadStream: показывает сохранённый
+// сервером объём и продолжает прерванную загрузку с -upload-id
+func uploadStream(ctx context.Context, client pb.FileServiceClient, filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat file: %w", err)
+	}
+	total := info.Size()
+
+	ctx, cancel := context.WithTimeout(ctx, time.Hour)
+	defer cancel()
+	stream, err := client.UploadStream(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to start upload: %w", err)
+	}
+
+	header := &pb.UploadRequest{Filename: filepath.Base(filename)}
+	if err := setUploadOptions(header); err != nil {
+		return err
+	}
+	if err := stream.Send(&pb.UploadStreamRequest{Msg: &pb.UploadStreamRequest_Header{
+		Header: &pb.UploadStreamHeader{File: header, UploadId: *uploadID},
+	}}); err != nil {
+		return fmt.Errorf("failed to send header: %w", err)
+	}
+	first, err := stream.Recv()
+	if err != nil {
+		return fmt.Errorf("upload failed: %w", err)
+	}
+	id, offset := first.GetAck().GetUploadId(), first.GetAck().GetCommittedOffset()
+	if offset > total {
+		return fmt.Errorf("server has %d bytes of upload %s, file is only %d bytes", offset, id, total)
+	}
+	fmt.Printf("Upload id: %s (resume with -upload-id %s), starting at %d of %d bytes\n", id, id, offset, total)
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek: %w", err)
+	}
+
+	// Отправитель не уходит дальше streamWindow байт от подтверждённого
+	var committed atomic.Int64
+	committed.Store(offset)
+	acked := make(chan struct{}, 1)
+	sendErr := make(chan error, 1)
+	go func() {
+		defer close(sendErr)
+		sent := offset
+		for {
+			for sent-committed.Load() >= streamWindow {
+				select {
+				case <-acked:
+				case <-ctx.Done():
+					sendErr <- ctx.Err()
+					return
+				}
+			}
+			// Новый буфер на каждый чанк: отправленное сообщение нельзя менять
+			buf := make([]byte, 64*1024)
+			n, err := f.Read(buf)
+			if n > 0 {
+				if err := stream.Send(&pb.UploadStreamRequest{Msg: &pb.UploadStreamRequest_Chunk{Chunk: buf[:n]}}); err != nil {
+					sendErr <- err
+					return
+				}
+				sent += int64(n)
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				sendErr <- err
+				return
+			}
+		}
+		sendErr <- stream.CloseSend()
+	}()
+
+	for {
+		resp, err := stream.Recv()
+		if err != nil {
+			fmt.Println()
+			return fmt.Errorf("upload failed at %d bytes, resume with -upload-id %s: %w", committed.Load(), id, err)
+		}
+		if result := resp.GetResult(); result != nil {
+			fmt.Println()
+			if err := <-sendErr; err != nil && err != io.EOF {
+				return fmt.Errorf("failed to send: %w", err)
+			}
+			printUploadResult(result)
+			return nil
+		}
+		committed.Store(resp.GetAck().GetCommittedOffset())
+		select {
+		case acked <- struct{}{}:
+		default:
+		}
+		fmt.Printf("\rCommitted %d of %d bytes (%d%%)", committed.Load(), total, 100*committed.Load()/max(total, 1))
+	}
+}
+
+// batchPaths раскрывает директорию (файлы верхнего уровня) или шаблон.
+// false - это путь к одному файлу.
+func batchPaths(pattern string) ([]string, bool, error) {
+	var paths []string
+	if info, err := os.Stat(pattern); err == nil {
+		if !info.IsDir() {
+			return nil, false, nil
+		}
+		entries, err := os.ReadDir(pattern)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to read directory: %w", err)
+		}
+		for _, e := range entries {
+			paths = append(paths, filepath.Join(pattern, e.Name()))
+		}
+	} else if strings.ContainsAny(pattern, "*?[") {
+		var err error
+		if paths, err = filepath.Glob(pattern); err != nil {
+			return nil, false, fmt.Errorf("invalid pattern: %w", err)
+		}
+	} else {
+		return nil, false, nil
+	}
+
+	files := paths[:0]
+	for _, p := range paths {
+		if info, err := os.Stat(p); err == nil && info.Mode().IsRegular() {
+			files = append(files, p)
+		}
+	}
+	if len(files) == 0 {
+		return nil, false, fmt.Errorf("no files match %s", pattern)
+	}
+	return files, true, nil
+}
+
+// uploadMany загружает файлы одним стримом UploadMany под их базовыми именами
+func uploadMany(ctx context.Context, client pb.FileServiceClient, paths []string) error {
+	md, err := parseMetadata(*metaFlag)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Minute)
+	defer cancel()
+
+	stream, err := client.UploadMany(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to start upload: %w", err)
+	}
+
+	// Отправляем в отдельной горутине: результаты приходят по мере записи
+	sendErr := make(chan error, 1)
+	go func() {
+		defer close(sendErr)
+		const chunkSize = 64 * 1024
+		for _, path := range paths {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				log.Printf("skip %s: %v", path, err)
+				continue
+			}
+			header := &pb.UploadRequest{
+				Filename:          filepath.Base(path),
+				Chunk:             data[:min(chunkSize, len(data))],
+				IfNoneMatch:       *ifNoneMatch,
+				IfMatch:           *ifMatch,
+				IfUnmodifiedSince: *ifUnmodifiedSince,
+				Metadata:          md,
+				Tags:              splitList(*tagsFlag),
+				ContentType:       *ctypeFlag,
+				TtlSeconds:        int64(ttlFlag.Seconds()),
+			}
+			if err := stream.Send(&pb.UploadManyRequest{Msg: &pb.UploadManyRequest_Header{Header: header}}); err != nil {
+				sendErr <- err
+				return
+			}
+			for i := chunkSize; i < len(data); i += chunkSize {
+				chunk := data[i:min(i+chunkSize, len(data))]
+				if err := stream.Send(&pb.UploadManyRequest{Msg: &pb.UploadManyRequest_Chunk{Chunk: chunk}}); err != nil {
+					sendErr <- err
+					return
+				}
+			}
+		}
+		sendErr <- stream.CloseSend()
+	}()
+
+	uploaded, failed := 0, 0
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("upload failed: %w", err)
+		}
+		if resp.Code != 0 {
+			failed++
+			fmt.Printf("FAILED %s: %s: %s\n", resp.Filename, codes.Code(resp.Code), resp.Error)
+			continue
+		}
+		uploaded++
+		fmt.Printf("OK     %s: size=%d bytes, etag=%s, type=%s\n", resp.Filename, resp.Result.GetSize(), resp.Result.GetEtag(), resp.Result.GetContentType())
+	}
+	if err := <-sendErr; err != nil && err != io.EOF {
+		return fmt.Errorf("failed to send: %w", err)
+	}
+	fmt.Printf("Uploaded %d of %d files, failed %d\n", uploaded, len(paths), failed)
+	return nil
+}
+
+// downloadMany скачивает файлы одним стримом DownloadMany в downloaded_<имя>
+func downloadMany(ctx context.Context, client pb.FileServiceClient, filenames []string) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Minute)
+	defer cancel()
+
+	stream, err := client.DownloadMany(ctx, &pb.DownloadManyRequest{Filenames: filenames})
+	if err != nil {
+		return fmt.Errorf("failed to start download: %w", err)
+	}
+
+	var (
+		current *pb.DownloadManyHeader
+		data    []byte
+		saved   int
+	)
+	save := func() error {
+		if current == nil || current.Code != 0 {
+			return nil
+		}
+		outName := "downloaded_" + current.Filename
+		if err := os.WriteFile(outName, data, 0644); err != nil {
+			return fmt.Errorf("failed to save file: %w", err)
+		}
+		saved++
+		fmt.Printf("OK     %s (%d bytes, %s) to %s\n", current.Filename, len(data), current.ContentType, outName)
+		return nil
+	}
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("download failed: %w", err)
+		}
+		if h := resp.GetHeader(); h != nil {
+			if err := save(); err != nil {
+				return err
+			}
+			current, data = h, nil
+			if h.Code != 0 {
+				fmt.Printf("FAILED %s: %s: %s\n", h.Filename, codes.Code(h.Code), h.Error)
+			}
+			continue
+		}
+		data = append(data, resp.GetChunk()...)
+	}
+	if err := save(); err != nil {
+		return err
+	}
+	fmt.Printf("Downloaded %d of %d files\n", saved, len(filenames))
+	return nil
+}
+
+// downloadArchive скачивает архив файлов по списку имён, а без него - по
+// -prefix и -tags, и пишет его в -out по мере получения
+func downloadArchive(ctx context.Context, client pb.FileServiceClient, filenames []string) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Minute)
+	defer cancel()
+
+	stream, err := client.DownloadArchive(ctx, &pb.DownloadArchiveRequest{
+		Filenames: filenames,
+		Prefix:    *prefix,
+		Tags:      splitList(*tagsFlag),
+		Format:    *format,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to start download: %w", err)
+	}
+
+	outName := *outFile
+	if outName == "" {
+		outName = "archive." + *format
+	}
+	// Файл создаём после первого ответа, чтобы при ошибке не оставлять пустой
+	var out *os.File
+	var size int
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if out != nil {
+				out.Close()
+				os.Remove(outName)
+			}
+			return fmt.Errorf("archive download failed: %w", err)
+		}
+		if out == nil {
+			if out, err = os.Create(outName); err != nil {
+				return fmt.Errorf("failed to create file: %w", err)
+			}
+		}
+		if _, err := out.Write(resp.Chunk); err != nil {
+			return fmt.Errorf("failed to write archive: %w", err)
+		}
+		size += len(resp.Chunk)
+	}
+	if out == nil {
+		return errors.New("empty archive")
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("failed to save archive: %w", err)
 	}
-	fmt.Printf("Uploaded: %s, size=%d bytes\n", resp.Message, resp.Size)
+	fmt.Printf("Downloaded archive (%d bytes) to %s\n", size, outName)
+	return nil
 }
 
-func downloadFile(client pb.FileServiceClient, filename string) {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+func downloadFile(ctx context.Context, client pb.FileServiceClient, filename string, version int) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
-	stream, err := client.Download(ctx, &pb.DownloadRequest{Filename: filename})
+	stream, err := client.Download(ctx, &pb.DownloadRequest{Filename: filename, Version: int32(version)})
 	if err != nil {
-		log.Fatalf("failed to start download: %v", err)
+		return fmt.Errorf("failed to start download: %w", err)
 	}
 
 	var data []byte
@@ -102,32 +612,352 @@ func downloadFile(client pb.FileServiceClient, filename string) {
 			break
 		}
 		if err != nil {
-			log.Fatalf("failed to receive chunk: %v", err)
+			return fmt.Errorf("failed to receive chunk: %w", err)
 		}
 		data = append(data, resp.Chunk...)
 	}
+	// Заголовок с MIME типом приходит вместе с первым сообщением
+	var contentType string
+	if md, err := stream.Header(); err == nil {
+		if v := md.Get("x-content-type"); len(v) > 0 {
+			contentType = v[0]
+		}
+	}
 
-	// Сохраняем как downloaded_<имя>
+	// Сохраняем как downloaded_<имя>, версию - как downloaded_v<N>_<имя>
 	outName := "downloaded_" + filename
+	if version > 0 {
+		outName = fmt.Sprintf("downloaded_v%d_%s", version, filename)
+	}
 	err = os.WriteFile(outName, data, 0644)
 	if err != nil {
-		log.Fatalf("failed to save file: %v", err)
+		return fmt.Errorf("failed to save file: %w", err)
 	}
-	fmt.Printf("Downloaded %s (%d bytes) to %s\n", filename, len(data), outName)
+	fmt.Printf("Downloaded %s (%d bytes, %s) to %s\n", filename, len(data), contentType, outName)
+	return nil
 }
 
-func listFiles(client pb.FileServiceClient) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func listFiles(ctx context.Context, client pb.FileServiceClient, tags []string) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	resp, err := client.ListFiles(ctx, &pb.Empty{})
+	resp, err := client.ListFiles(ctx, &pb.ListFilesRequest{Tags: tags, Prefix: *prefix})
 	if err != nil {
-		log.Fatalf("failed to list files: %v", err)
+		return fmt.Errorf("failed to list files: %w", err)
 	}
 
-	fmt.Printf("%-20s | %-25s | %-25s | %s\n", "Filename", "Created At", "Updated At", "Size (bytes)")
-	fmt.Println("--------------------------------------------------------------------------------")
+	fmt.Printf("%-20s | %-25s | %-25s | %-25s | %-9s | %-12s | %-64s | %-24s | %s\n", "Filename", "Created At", "Updated At", "Last Accessed", "Downloads", "Size (bytes)", "ETag", "Type", "Tags")
+	fmt.Println("-----------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------")
 	for _, f := range resp.Files {
-		fmt.Printf("%-20s | %-25s | %-25s | %d\n", f.Filename, f.CreatedAt, f.UpdatedAt, f.Size)
+		fmt.Printf("%-20s | %-25s | %-25s | %-25s | %-9d | %-12d | %-64s | %-24s | %s\n", f.Filename, f.CreatedAt, f.UpdatedAt, f.LastAccessedAt, f.DownloadCount, f.Size, f.Etag, f.ContentType, strings.Join(f.Tags, ","))
+	}
+	return nil
+}
+
+func setMetadata(ctx context.Context, client pb.FileServiceClient, filename string) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	md, err := parseMetadata(*metaFlag)
+	if err != nil {
+		return err
+	}
+	resp, err := client.SetMetadata(ctx, &pb.SetMetadataRequest{
+		Filename: filename,
+		Metadata: md,
+		Tags:     splitList(*tagsFlag),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set metadata: %w", err)
+	}
+	fmt.Printf("%s: metadata=%v, tags=%s\n", resp.Filename, resp.Metadata, strings.Join(resp.Tags, ","))
+	return nil
+}
+
+// splitList разбирает "a,b,c", пустая строка - nil
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+// parseMetadata разбирает "k=v,k2=v2"
+func parseMetadata(s string) (map[string]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	md := make(map[string]string)
+	for _, kv := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid metadata %q, expected key=value", kv)
+		}
+		md[k] = v
+	}
+	return md, nil
+}
+
+// syncOptions - параметры sync/push/pull из флагов
+func syncOptions() filesync.Options {
+	return filesync.Options{
+		Prefix:      *prefix,
+		DryRun:      *dryRun,
+		Delete:      *deleteFlag,
+		BytesPerSec: *bwLimit,
+		Parallel:    *parallel,
+		Checksum:    *checksum,
+		OnAction: func(a filesync.Action) {
+			if a.Err != nil {
+				fmt.Printf("FAILED %-8s %s: %v\n", a.Kind, a.Filename, a.Err)
+				return
+			}
+			fmt.Printf("%-15s %s\n", a.Kind, a.Filename)
+		},
+	}
+}
+
+// printSyncResult печатает итог и вернёт false, если были ошибки
+func printSyncResult(res filesync.Result) bool {
+	verb := "Synced"
+	if *dryRun {
+		verb = "Would sync"
+	}
+	fmt.Printf("%s %d files: copied %d (%d bytes), metadata %d, deleted %d, unchanged %d, failed %d\n",
+		verb, res.Checked, res.Copied, res.Bytes, res.Updated, res.Deleted, res.Unchanged, res.Failed)
+	return res.Failed == 0
+}
+
+// syncServers переносит на dst недостающие и изменённые файлы src
+func syncServers(ctx context.Context, src, dst pb.FileServiceClient) error {
+	res, err := filesync.Sync(ctx, src, dst, syncOptions())
+	if err != nil {
+		return fmt.Errorf("sync failed: %w", err)
+	}
+	if !printSyncResult(res) {
+		return fmt.Errorf("sync failed for %d files", res.Failed)
+	}
+	return nil
+}
+
+// syncDir зеркалирует директорию на сервер (push) или с сервера (pull).
+// С -watch push повторяется после каждого изменения в директории до Ctrl+C.
+func syncDir(ctx context.Context, client pb.FileServiceClient, action, dir string) error {
+	if *watch {
+		if action != "push" {
+			return errors.New("-watch is supported only for push")
+		}
+		ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		log.Printf("watching %s, Ctrl+C to stop", dir)
+		err := filesync.Watch(ctx, client, dir, syncOptions(), func(res filesync.Result, err error) {
+			if err != nil {
+				log.Printf("push failed: %v", err)
+				return
+			}
+			printSyncResult(res)
+		})
+		if err != nil {
+			return fmt.Errorf("watch failed: %w", err)
+		}
+		return nil
+	}
+
+	run := filesync.Push
+	if action == "pull" {
+		run = filesync.Pull
+	}
+	res, err := run(ctx, client, dir, syncOptions())
+	if err != nil {
+		return fmt.Errorf("%s failed: %w", action, err)
+	}
+	if !printSyncResult(res) {
+		return fmt.Errorf("%s failed for %d files", action, res.Failed)
+	}
+	return nil
+}
+
+func listVersions(ctx context.Context, client pb.FileServiceClient, filename string) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	resp, err := client.ListVersions(ctx, &pb.ListVersionsRequest{Filename: filename})
+	if err != nil {
+		return fmt.Errorf("failed to list versions: %w", err)
+	}
+	fmt.Printf("%-8s | %-25s | %s\n", "Version", "Archived At", "Size (bytes)")
+	fmt.Println("--------------------------------------------------")
+	for _, v := range resp.Versions {
+		fmt.Printf("%-8d | %-25s | %d\n", v.Version, v.ArchivedAt, v.Size)
+	}
+	return nil
+}
+
+func restoreVersion(ctx context.Context, client pb.FileServiceClient, filename string, version int) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	if _, err := client.RestoreVersion(ctx, &pb.RestoreVersionRequest{Filename: filename, Version: int32(version)}); err != nil {
+		return fmt.Errorf("failed to restore version: %w", err)
+	}
+	fmt.Printf("Restored %s from version %d\n", filename, version)
+	return nil
+}
+
+func runAdmin(ctx context.Context, admin pb.AdminServiceClient, action string) error {
+	switch action {
+	case "limits":
+		return showLimits(ctx, admin)
+	case "set-limits":
+		return setLimits(ctx, admin)
+	case "transfers":
+		return listTransfers(ctx, admin)
+	case "cancel":
+		if *transferID == "" {
+			return errors.New("id required for cancel")
+		}
+		return cancelTransfer(ctx, admin, *transferID)
+	case "reindex":
+		return reindex(ctx, admin)
+	case "gc":
+		return collectGarbage(ctx, admin)
+	case "scrub":
+		return scrub(ctx, admin)
+	case "config":
+		return showConfig(ctx, admin)
+	}
+	return nil
+}
+
+func showLimits(ctx context.Context, client pb.AdminServiceClient) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	resp, err := client.GetLimits(ctx, &pb.Empty{})
+	if err != nil {
+		return fmt.Errorf("failed to get limits: %w", err)
+	}
+	printLimits(resp)
+	return nil
+}
+
+func setLimits(ctx context.Context, client pb.AdminServiceClient) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	resp, err := client.SetLimits(ctx, &pb.SetLimitsRequest{
+		UploadLimit:   int32(*uploadLimit),
+		DownloadLimit: int32(*downloadLimit),
+		ListLimit:     int32(*listLimit),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set limits: %w", err)
+	}
+	printLimits(resp)
+	return nil
+}
+
+func printLimits(resp *pb.LimitsResponse) {
+	fmt.Printf("%-10s | %-6s | %-6s | %s\n", "Limit", "Max", "In use", "Queued")
+	fmt.Println("------------------------------------------")
+	for _, l := range []struct {
+		name string
+		st   *pb.LimitStatus
+	}{{"upload", resp.Upload}, {"download", resp.Download}, {"list", resp.List}} {
+		fmt.Printf("%-10s | %-6d | %-6d | %d\n", l.name, l.st.GetLimit(), l.st.GetInUse(), l.st.GetQueued())
+	}
+}
+
+func listTransfers(ctx context.Context, client pb.AdminServiceClient) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	resp, err := client.ListTransfers(ctx, &pb.Empty{})
+	if err != nil {
+		return fmt.Errorf("failed to list transfers: %w", err)
+	}
+	fmt.Printf("%-6s | %-8s | %-20s | %-21s | %-12s | %s\n", "ID", "Kind", "Filename", "Peer", "Bytes", "Started At")
+	fmt.Println("-----------------------------------------------------------------------------------------------")
+	for _, t := range resp.Transfers {
+		fmt.Printf("%-6s | %-8s | %-20s | %-21s | %-12d | %s\n", t.Id, t.Kind, t.Filename, t.Peer, t.Bytes, t.StartedAt)
+	}
+	return nil
+}
+
+func cancelTransfer(ctx context.Context, client pb.AdminServiceClient, id string) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	if _, err := client.CancelTransfer(ctx, &pb.CancelTransferRequest{Id: id}); err != nil {
+		return fmt.Errorf("failed to cancel transfer: %w", err)
+	}
+	fmt.Printf("Transfer %s cancelled\n", id)
+	return nil
+}
+
+func reindex(ctx context.Context, client pb.AdminServiceClient) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	resp, err := client.Reindex(ctx, &pb.Empty{})
+	if err != nil {
+		return fmt.Errorf("reindex failed: %w", err)
+	}
+	fmt.Printf("Reindexed: files=%d, added=%d, removed=%d\n", resp.Files, resp.Added, resp.Removed)
+	return nil
+}
+
+func collectGarbage(ctx context.Context, client pb.AdminServiceClient) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	resp, err := client.CollectGarbage(ctx, &pb.CollectGarbageRequest{
+		OlderThanSeconds: int64(olderThan.Seconds()),
+		DryRun:           *dryRun,
+	})
+	if err != nil {
+		return fmt.Errorf("gc failed: %w", err)
+	}
+	verb := "Removed"
+	if *dryRun {
+		verb = "Would remove"
+	}
+	for _, name := range resp.Removed {
+		fmt.Println(name)
+	}
+	fmt.Printf("%s %d temp files\n", verb, len(resp.Removed))
+	return nil
+}
+
+func scrub(ctx context.Context, client pb.AdminServiceClient) error {
+	// Пересчёт sha256 всего хранилища может занять время
+	ctx, cancel := context.WithTimeout(ctx, time.Hour)
+	defer cancel()
+
+	resp, err := client.Scrub(ctx, &pb.ScrubRequest{RepairMetadata: *repairMeta, Quarantine: *quarantine})
+	if err != nil {
+		return fmt.Errorf("scrub failed: %w", err)
+	}
+	if len(resp.Issues) > 0 {
+		fmt.Printf("%-30s %-18s %-50s %s\n", "Filename", "Issue", "Detail", "Action")
+	}
+	for _, issue := range resp.Issues {
+		fmt.Printf("%-30s %-18s %-50s %s\n", issue.Filename, issue.Kind, issue.Detail, issue.Action)
+	}
+	fmt.Printf("Checked %d files (%d bytes), issues: %d\n", resp.Checked, resp.Bytes, len(resp.Issues))
+	return nil
+}
+
+func showConfig(ctx context.Context, client pb.AdminServiceClient) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	resp, err := client.GetConfig(ctx, &pb.Empty{})
+	if err != nil {
+		return fmt.Errorf("failed to get config: %w", err)
 	}
+	fmt.Print(resp.Yaml)
+	fmt.Println()
+	printLimits(resp.Limits)
+	return nil
 }
@@ -1,45 +1,309 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"log"
 	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
 
 	"github.com/Hiddan13/file_grpc/api/proto/pb"
+	"github.com/Hiddan13/file_grpc/internal/cluster"
 	"github.com/Hiddan13/file_grpc/internal/config"
+	"github.com/Hiddan13/file_grpc/internal/health"
+	"github.com/Hiddan13/file_grpc/internal/ratelimit"
+	"github.com/Hiddan13/file_grpc/internal/replication"
 	"github.com/Hiddan13/file_grpc/internal/repository"
 	"github.com/Hiddan13/file_grpc/internal/service"
+	"github.com/Hiddan13/file_grpc/internal/tracing"
 	grpcTransport "github.com/Hiddan13/file_grpc/internal/transport/grpc"
 
 	"google.golang.org/grpc"
+	grpcHealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
 func main() {
-	cfg := config.Load()
+	cfg, flags, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("invalid config: %v", err)
+	}
+	if flags.PrintConfig {
+		if err := cfg.Print(os.Stdout); err != nil {
+			log.Fatalf("failed to print config: %v", err)
+		}
+		return
+	}
+	if err := cfg.PrepareStorage(); err != nil {
+		log.Fatalf("invalid config: %v", err)
+	}
+
+	// SIGINT/SIGTERM запускают мягкую остановку
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// init трассировки
+	shutdownTracing, err := tracing.Setup(context.Background(), "file_grpc-server", cfg)
+	if err != nil {
+		log.Fatalf("failed to init tracing: %v", err)
+	}
+	defer shutdownTracing(context.Background())
 
 	// init репозитория
-	repo, err := repository.NewFilesRepository(cfg.StoragePath)
+	repo, err := repository.NewFilesRepository(cfg.StoragePath, repository.Options{
+		Versioning: cfg.Versioning,
+		AccessTime: repository.AccessTimeMode(cfg.AccessTime),
+		Durability: repository.DurabilityMode(cfg.Durability),
+	})
 	if err != nil {
 		log.Fatalf("failed to init repository: %v", err)
 	}
+	// Временные файлы записей, прерванных падением сервера
+	if removed, err := repo.RemoveStaleTemp(ctx); err != nil {
+		log.Fatalf("failed to clean temp files: %v", err)
+	} else if len(removed) > 0 {
+		log.Printf("removed stale temp files: %v", removed)
+	}
+	// Метаданные хранятся в памяти - восстанавливаем их по файлам на диске
+	if res, err := repo.Reindex(ctx); err != nil {
+		log.Fatalf("failed to index storage: %v", err)
+	} else {
+		log.Printf("storage indexed: %d files", res.Files)
+	}
+
+	// Журнал репликации: изменения файлов записываются для рассылки ведомым
+	var fileRepo repository.Repository = repo
+	var primary *replication.Primary
+	if len(cfg.ReplicationFollowers) > 0 {
+		replLog, err := replication.OpenLog(filepath.Join(cfg.StoragePath, repository.ReplicationDir))
+		if err != nil {
+			log.Fatalf("failed to open replication log: %v", err)
+		}
+		defer replLog.Close()
+		fileRepo = replication.NewRecorder(repo, replLog)
+		primary = replication.NewPrimary(repo, replLog, cfg.AdminToken, cfg.ReplicationRetryInterval)
+		for _, addr := range cfg.ReplicationFollowers {
+			conn, err := replication.Dial(addr, cfg.ReplicationRetryInterval)
+			if err != nil {
+				log.Fatalf("failed to dial follower %s: %v", addr, err)
+			}
+			defer conn.Close()
+			primary.AddFollower(addr, conn)
+		}
+	}
 
 	// init сервиса
-	fileservice := service.NewFileService(repo)
+	fileservice := service.NewFileService(fileRepo)
 
 	// Создаём gRPC сервер
 	lis, err := net.Listen("tcp", cfg.GRPCPort)
 	if err != nil {
 		log.Fatalf("failed to listen: %v", err)
 	}
-	grpcServer := grpc.NewServer()
+	clientLimits := ratelimit.NewManager(cfg.ClientIDHeader, cfg.ClientLimits, cfg.ClientLimitOverrides)
+	grpcServer := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(
+			tracing.UnaryServerInterceptor(),
+			grpcTransport.AdminAuthUnaryInterceptor(cfg.AdminToken),
+			clientLimits.UnaryServerInterceptor(),
+		),
+		grpc.ChainStreamInterceptor(
+			tracing.StreamServerInterceptor(),
+			grpcTransport.AdminAuthStreamInterceptor(cfg.AdminToken),
+			clientLimits.StreamServerInterceptor(),
+		),
+	)
 
 	// Регистрация обработчиков
-	fileServer := grpcTransport.NewFileServer(fileservice, cfg.UploadLimit, cfg.DownloadLimit, cfg.ListLimit)
-	pb.RegisterFileServiceServer(grpcServer, fileServer)
+	fileServer := grpcTransport.NewFileServer(fileservice, cfg.UploadLimit, cfg.DownloadLimit, cfg.ListLimit, grpcTransport.Admission{
+		Wait:        cfg.AdmissionMode == "wait",
+		QueueLength: cfg.AdmissionQueueLength,
+		MaxWait:     cfg.AdmissionMaxWait,
+	})
+	if err := fileServer.SetExtractLimits(extractLimits(cfg)); err != nil {
+		log.Fatalf("invalid extract limits: %v", err)
+	}
+	// В кластере FileService маршрутизирует запросы на узлы-владельцы
+	var fileHandler pb.FileServiceServer = fileServer
+	var node *cluster.Node
+	if len(cfg.ClusterNodes) > 0 {
+		node = cluster.NewNode(fileServer, fileservice, cluster.Options{
+			Self:        cfg.ClusterSelf,
+			Nodes:       cfg.ClusterNodes,
+			Replicas:    cfg.ClusterReplicas,
+			WriteQuorum: cfg.ClusterWriteQuorum,
+			Token:       cfg.AdminToken,
+			Identity:    clientLimits.Identity,
+		})
+		// Запросы, пересланные узлами, приходят от их адресов; лимиты
+		// применяются к исходному клиенту
+		clientLimits.TrustForwarded(func(ctx context.Context) bool {
+			return grpcTransport.ValidAdminToken(ctx, cfg.AdminToken)
+		})
+		for _, addr := range cfg.ClusterNodes {
+			conn, err := replication.Dial(addr, cfg.ReplicationRetryInterval)
+			if err != nil {
+				log.Fatalf("failed to dial cluster node %s: %v", addr, err)
+			}
+			defer conn.Close()
+			node.AddPeer(addr, conn)
+		}
+		fileHandler = node
+	}
+	pb.RegisterFileServiceServer(grpcServer, fileHandler)
+	adminService := grpcTransport.NewAdminServer(fileServer, cfg)
+	pb.RegisterAdminServiceServer(grpcServer, adminService)
+	// Приём изменений от первичного сервера, если этот сервер - ведомый
+	replicationServer := replication.NewServer(fileservice)
+	pb.RegisterReplicationServiceServer(grpcServer, replicationServer)
+
+	// SIGHUP перечитывает .env и применяет новые лимиты. GetConfig отдаёт
+	// applied: остальные поля применяются только при перезапуске.
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		applied := *cfg
+		for range hup {
+			newCfg, _, err := config.Load(os.Args[1:])
+			if err != nil {
+				log.Printf("SIGHUP: invalid config, keeping current limits: %v", err)
+				continue
+			}
+			log.Printf("SIGHUP: reloading limits: upload=%d, download=%d, list=%d", newCfg.UploadLimit, newCfg.DownloadLimit, newCfg.ListLimit)
+			if err := fileServer.SetLimits(newCfg.UploadLimit, newCfg.DownloadLimit, newCfg.ListLimit); err != nil {
+				log.Printf("SIGHUP: failed to apply limits: %v", err)
+				continue
+			}
+			applied.UploadLimit, applied.DownloadLimit, applied.ListLimit = newCfg.UploadLimit, newCfg.DownloadLimit, newCfg.ListLimit
+			if err := fileServer.SetExtractLimits(extractLimits(newCfg)); err != nil {
+				log.Printf("SIGHUP: failed to apply extract limits: %v", err)
+			} else {
+				applied.ExtractMaxEntries, applied.ExtractMaxSizeMB = newCfg.ExtractMaxEntries, newCfg.ExtractMaxSizeMB
+			}
+			clientLimits.SetLimits(newCfg.ClientLimits, newCfg.ClientLimitOverrides)
+			applied.ClientLimits, applied.ClientLimitOverrides = newCfg.ClientLimits, newCfg.ClientLimitOverrides
+			reloaded := applied
+			adminService.SetConfig(&reloaded)
+		}
+	}()
+
+	// grpc.health.v1 + HTTP пробы на админском порту
+	healthServer := grpcHealth.NewServer()
+	healthpb.RegisterHealthServer(grpcServer, healthServer)
+	checker := health.NewChecker(cfg.StoragePath, cfg.MinFreeDiskMB, healthServer, pb.FileService_ServiceDesc.ServiceName)
+	go checker.Run(ctx, cfg.HealthCheckInterval)
+	adminMux := http.NewServeMux()
+	adminMux.Handle("/", checker.Handler())
+	adminMux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		fileservice.WriteScrubMetrics(w)
+		replicationServer.WriteMetrics(w)
+		if primary != nil {
+			primary.WriteMetrics(w)
+		}
+		if node != nil {
+			node.WriteMetrics(w)
+		}
+	})
+	adminServer := &http.Server{Addr: cfg.AdminPort, Handler: adminMux}
+	go func() {
+		log.Printf("admin HTTP listening on %s", cfg.AdminPort)
+		if err := adminServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("failed to serve admin HTTP: %v", err)
+		}
+	}()
+
+	// Очистка старых версий
+	if cfg.Versioning {
+		go fileservice.RunRetention(ctx, cfg.VersionRetentionInterval, service.Retention{
+			KeepLast: cfg.VersionKeepLast,
+			MaxAge:   cfg.VersionMaxAge,
+		})
+		log.Printf("versioning: keep last=%d, max age=%s", cfg.VersionKeepLast, cfg.VersionMaxAge)
+	}
+
+	// Удаление файлов по TTL и правилам жизненного цикла
+	lifecycle := service.Lifecycle{DryRun: cfg.LifecycleDryRun}
+	for _, rule := range cfg.LifecycleRules {
+		lifecycle.Rules = append(lifecycle.Rules, service.LifecycleRule{Prefix: rule.Prefix, NotAccessedFor: rule.NotAccessedFor})
+	}
+	go fileservice.RunLifecycle(ctx, cfg.LifecycleInterval, lifecycle)
+	log.Printf("lifecycle: interval=%s, rules=%d, dry run=%t", cfg.LifecycleInterval, len(lifecycle.Rules), cfg.LifecycleDryRun)
+
+	// Проверка целостности хранилища
+	if cfg.ScrubInterval > 0 {
+		go fileservice.RunScrub(ctx, cfg.ScrubInterval, repository.ScrubOptions{
+			RepairMetadata: cfg.ScrubRepairMetadata,
+			Quarantine:     cfg.ScrubQuarantine,
+		})
+		log.Printf("scrub: interval=%s, repair metadata=%t, quarantine=%t", cfg.ScrubInterval, cfg.ScrubRepairMetadata, cfg.ScrubQuarantine)
+	}
+
+	// Рассылка изменений ведомым
+	if primary != nil {
+		go primary.Run(ctx)
+		log.Printf("replication: followers=%v, retry=%s", cfg.ReplicationFollowers, cfg.ReplicationRetryInterval)
+	}
+
+	// Перенос файлов на узлы-владельцы после смены состава кластера
+	if node != nil {
+		go node.RunRebalance(ctx, cfg.ClusterRebalanceInterval)
+		log.Printf("cluster: node %s, rebalance interval=%s", node, cfg.ClusterRebalanceInterval)
+	}
 
 	log.Printf("gRPC server listening on %s", cfg.GRPCPort)
 	log.Printf("storage path: %s", cfg.StoragePath)
 	log.Printf("limits: upload=%d, download=%d, list=%d", cfg.UploadLimit, cfg.DownloadLimit, cfg.ListLimit)
-	if err := grpcServer.Serve(lis); err != nil {
-		log.Fatalf("failed to serve: %v", err)
+	log.Printf("admission: %s, queue=%d, max wait=%s", cfg.AdmissionMode, cfg.AdmissionQueueLength, cfg.AdmissionMaxWait)
+	log.Printf("tracing: %s", cfg.TracingExporter)
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- grpcServer.Serve(lis)
+	}()
+
+	select {
+	case err := <-serveErr:
+		if err != nil {
+			log.Fatalf("failed to serve: %v", err)
+		}
+		return
+	case <-ctx.Done():
+	}
+	stop()
+
+	// Остановка: снимаем готовность, ждём активные передачи, затем GracefulStop
+	log.Printf("shutting down, drain timeout %s", cfg.ShutdownTimeout)
+	checker.Shutdown()
+	drainCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := fileServer.Drain(drainCtx); err != nil {
+		// Оставшиеся стримы будут отменены; незавершённые загрузки не сохраняются
+		log.Printf("drain timeout exceeded, forcing stop")
+		grpcServer.Stop()
+	} else {
+		grpcServer.GracefulStop()
+	}
+	adminServer.Close()
+	// relatime держит время доступа в памяти - сохраняем его до выхода
+	if err := repo.FlushAccessTimes(); err != nil {
+		log.Printf("failed to save access times: %v", err)
+	}
+	log.Printf("server stopped")
+}
+
+// extractLimits - ограничения распаковки архивов из конфига
+func extractLimits(cfg *config.Config) grpcTransport.ExtractLimits {
+	return grpcTransport.ExtractLimits{
+		MaxEntries: cfg.ExtractMaxEntries,
+		MaxBytes:   int64(cfg.ExtractMaxSizeMB) << 20,
 	}
 }
@@ -0,0 +1,532 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/Hiddan13/file_grpc/api/proto/pb"
+	"github.com/Hiddan13/file_grpc/internal/ratelimit"
+	"github.com/Hiddan13/file_grpc/internal/service"
+	"github.com/Hiddan13/file_grpc/internal/tracing"
+	grpcTransport "github.com/Hiddan13/file_grpc/internal/transport/grpc"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelCodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+var tracer = otel.Tracer("github.com/Hiddan13/file_grpc/internal/cluster")
+
+// Ключ metadata запроса, пересланного другим узлом: такой запрос с admin
+// токеном обслуживается локально, без повторной маршрутизации
+const forwardedKey = "x-cluster-forwarded"
+
+// Размер очереди сообщений Upload к одному владельцу
+const relayBuffer = 4
+
+// Options - состав кластера и размещение файлов
+type Options struct {
+	// Адрес этого узла, как его видят остальные; должен быть в Nodes
+	Self string
+	// Адреса всех узлов, включая этот
+	Nodes []string
+	// Сколько узлов хранит каждый файл
+	Replicas int
+	// Сколько владельцев должны подтвердить запись, 0 - все Replicas
+	WriteQuorum int
+	// admin токен для ReplicationService других узлов; им же узел
+	// подтверждает, что запрос переслан им, а не клиентом
+	Token string
+	// Клиент запроса для лимитов владельца (ratelimit.Manager.Identity),
+	// nil - не передавать
+	Identity func(context.Context) string
+}
+
+// Node - FileService узла кластера. Upload, Download, ListFiles,
+// SetMetadata и Delete маршрутизируются на узлы-владельцы файла по кольцу
+// консистентного хеширования, остальные RPC выполняются на этом узле.
+type Node struct {
+	pb.FileServiceServer // локальный сервер
+
+	local       *grpcTransport.FileServer
+	fileService *service.FileService
+	self        string
+	ring        *Ring
+	replicas    int
+	quorum      int
+	token       string
+	identity    func(context.Context) string
+	peers       map[string]*peer
+
+	stats rebalanceStats
+}
+
+// peer - клиенты другого узла (или этого же - запросы к себе идут тем же
+// путём, что и к остальным)
+type peer struct {
+	files pb.FileServiceClient
+	repl  pb.ReplicationServiceClient
+}
+
+// NewNode оборачивает локальный FileService local. fileService нужен для
+// перераспределения файлов этого узла.
+func NewNode(local *grpcTransport.FileServer, fileService *service.FileService, opts Options) *Node {
+	quorum := opts.WriteQuorum
+	if quorum <= 0 || quorum > opts.Replicas {
+		quorum = opts.Replicas
+	}
+	return &Node{
+		FileServiceServer: local,
+		local:             local,
+		fileService:       fileService,
+		self:              opts.Self,
+		ring:              NewRing(opts.Nodes),
+		replicas:          opts.Replicas,
+		quorum:            quorum,
+		token:             opts.Token,
+		identity:          opts.Identity,
+		peers:             make(map[string]*peer),
+	}
+}
+
+// AddPeer задаёт соединение с узлом addr, в том числе с самим собой.
+// Вызывается для каждого узла до приёма запросов.
+func (n *Node) AddPeer(addr string, conn grpc.ClientConnInterface) {
+	n.peers[addr] = &peer{files: pb.NewFileServiceClient(conn), repl: pb.NewReplicationServiceClient(conn)}
+}
+
+// Owners - узлы, хранящие файл, в порядке предпочтения
+func (n *Node) Owners(filename string) []string {
+	return n.ring.Owners(filename, n.replicas)
+}
+
+func (n *Node) peer(addr string) (*peer, error) {
+	p, ok := n.peers[addr]
+	if !ok {
+		return nil, status.Errorf(codes.Internal, "no connection to node %s", addr)
+	}
+	return p, nil
+}
+
+// forwarded - запрос пришёл от другого узла. Без admin токена forwardedKey
+// мог бы передать клиент, чтобы обойти маршрутизацию и кворум.
+func (n *Node) forwarded(ctx context.Context) bool {
+	return len(metadata.ValueFromIncomingContext(ctx, forwardedKey)) > 0 && grpcTransport.ValidAdminToken(ctx, n.token)
+}
+
+// nodeContext - контекст собственного запроса узла к другим узлам
+func (n *Node) nodeContext(ctx context.Context) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+n.token, forwardedKey, n.self)
+}
+
+// forwardContext - контекст пересылки запроса клиента. Metadata клиента не
+// переносится, кроме него самого - владелец применяет к запросу лимиты
+// этого клиента; трассировку добавляет interceptor соединения.
+func (n *Node) forwardContext(ctx context.Context) context.Context {
+	ctx = n.nodeContext(ctx)
+	if n.identity != nil {
+		ctx = metadata.AppendToOutgoingContext(ctx, ratelimit.ForwardedClientKey, n.identity(ctx))
+	}
+	return ctx
+}
+
+// result - ответ одного узла
+type result[T any] struct {
+	node string
+	v    T
+	err  error
+}
+
+// fanOut вызывает call на узлах nodes параллельно, результаты в порядке nodes
+func fanOut[T any](ctx context.Context, n *Node, nodes []string, call func(context.Context, *peer) (T, error)) []result[T] {
+	ctx = n.forwardContext(ctx)
+	results := make([]result[T], len(nodes))
+	var wg sync.WaitGroup
+	for i, node := range nodes {
+		results[i].node = node
+		p, err := n.peer(node)
+		if err != nil {
+			results[i].err = err
+			continue
+		}
+		wg.Go(func() { results[i].v, results[i].err = call(ctx, p) })
+	}
+	wg.Wait()
+	return results
+}
+
+// written - итог записи на владельцев: ответ первого успешного, если
+// запись подтвердили не меньше quorum узлов. Если не удалось нигде,
+// возвращается ошибка первого владельца - например, ALREADY_EXISTS.
+func written[T any](n *Node, op, filename string, results []result[T]) (T, error) {
+	var first T
+	var firstErr error
+	ok := 0
+	for _, r := range results {
+		if r.err != nil {
+			log.Printf("[CLUSTER] %s %s на %s: %v", op, filename, r.node, r.err)
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			continue
+		}
+		if ok == 0 {
+			first = r.v
+		}
+		ok++
+	}
+	var zero T
+	switch {
+	case ok >= min(n.quorum, len(results)):
+		return first, nil
+	case ok == 0:
+		return zero, firstErr
+	default:
+		return zero, status.Errorf(codes.Unavailable, "%s %s: confirmed by %d of %d owners, quorum %d: %v", op, filename, ok, len(results), n.quorum, firstErr)
+	}
+}
+
+// Upload пересылает файл всем владельцам по мере приёма, не собирая его в
+// памяти. Вызов учитывается в upload_limit и списке передач этого узла;
+// владельцы пересланную загрузку повторно не допускают.
+func (n *Node) Upload(stream pb.FileService_UploadServer) (err error) {
+	if n.forwarded(stream.Context()) {
+		return n.FileServiceServer.Upload(&uploadStream{FileService_UploadServer: stream, ctx: grpcTransport.Admitted(stream.Context())})
+	}
+	ctx, span := tracer.Start(stream.Context(), "Node.Upload", trace.WithSpanKind(trace.SpanKindServer))
+	defer func() { endSpan(span, err) }()
+
+	return n.local.RelayUpload(&uploadStream{FileService_UploadServer: stream, ctx: ctx}, func(ctx context.Context, recv func() (*pb.UploadRequest, error)) error {
+		first, err := recv()
+		if err == io.EOF {
+			return status.Error(codes.InvalidArgument, "no data received")
+		}
+		if err != nil {
+			return err
+		}
+		if first.GetExtract() != "" {
+			// Файлы архива принадлежат разным узлам
+			return status.Error(codes.Unimplemented, "extract is not supported in cluster mode")
+		}
+		filename := first.GetFilename()
+		owners := n.Owners(filename)
+		span.SetAttributes(tracing.AttrFilename.String(filename), attribute.StringSlice("cluster.owners", owners))
+
+		results, err := n.relayUpload(ctx, owners, first, recv)
+		if err != nil {
+			return err
+		}
+		resp, err := written(n, "upload", filename, results)
+		if err != nil {
+			return err
+		}
+		log.Printf("[CLUSTER] upload %s: владельцы %v", filename, owners)
+		return stream.SendAndClose(resp)
+	})
+}
+
+// uploadStream - Upload с другим контекстом
+type uploadStream struct {
+	pb.FileService_UploadServer
+	ctx context.Context
+}
+
+func (s *uploadStream) Context() context.Context {
+	return s.ctx
+}
+
+// relayUpload передаёт сообщения клиента, начиная с first, на узлы owners
+// параллельно. Медленный владелец задерживает приём, но не больше
+// relayBuffer сообщений на каждого. Если поток клиента оборвался,
+// владельцы получают отмену и неполный файл не сохраняют.
+func (n *Node) relayUpload(ctx context.Context, owners []string, first *pb.UploadRequest, recv func() (*pb.UploadRequest, error)) ([]result[*pb.UploadResponse], error) {
+	ctx, cancel := context.WithCancel(n.forwardContext(ctx))
+	defer cancel()
+
+	results := make([]result[*pb.UploadResponse], len(owners))
+	queues := make([]chan *pb.UploadRequest, len(owners))
+	var wg sync.WaitGroup
+	for i, node := range owners {
+		results[i].node = node
+		queues[i] = make(chan *pb.UploadRequest, relayBuffer)
+		wg.Go(func() {
+			results[i].v, results[i].err = n.sendUpload(ctx, node, queues[i])
+			// Владелец отказал - остальные сообщения ему не нужны
+			for range queues[i] {
+			}
+		})
+	}
+
+	msg, err := first, error(nil)
+	for ; err == nil; msg, err = recv() {
+		for _, q := range queues {
+			q <- msg
+		}
+	}
+	if err != io.EOF {
+		cancel()
+	}
+	for _, q := range queues {
+		close(q)
+	}
+	wg.Wait()
+	if err != io.EOF {
+		return nil, err
+	}
+	return results, nil
+}
+
+// sendUpload передаёт узлу node сообщения из msgs одним вызовом Upload
+func (n *Node) sendUpload(ctx context.Context, node string, msgs <-chan *pb.UploadRequest) (*pb.UploadResponse, error) {
+	p, err := n.peer(node)
+	if err != nil {
+		return nil, err
+	}
+	cs, err := p.files.Upload(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for msg := range msgs {
+		if err := cs.Send(msg); err != nil {
+			// io.EOF - узел завершил вызов, ошибку вернёт CloseAndRecv
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+	}
+	return cs.CloseAndRecv()
+}
+
+// Download отдаёт файл с первого владельца, у которого он есть. Если ни у
+// одного владельца файла нет, спрашиваются остальные узлы: до
+// перераспределения файл может лежать на прежнем владельце или на узле,
+// принявшем UploadMany.
+func (n *Node) Download(req *pb.DownloadRequest, stream pb.FileService_DownloadServer) (err error) {
+	if n.forwarded(stream.Context()) {
+		return n.FileServiceServer.Download(req, stream)
+	}
+	ctx, span := tracer.Start(stream.Context(), "Node.Download", trace.WithSpanKind(trace.SpanKindServer))
+	defer func() { endSpan(span, err) }()
+	owners := n.Owners(req.GetFilename())
+	span.SetAttributes(tracing.AttrFilename.String(req.GetFilename()), attribute.StringSlice("cluster.owners", owners))
+
+	nodes := slices.Clone(owners)
+	for _, node := range n.ring.Nodes() {
+		if !slices.Contains(owners, node) {
+			nodes = append(nodes, node)
+		}
+	}
+	var lastErr error
+	for _, node := range nodes {
+		p, err := n.peer(node)
+		if err != nil {
+			return err
+		}
+		cs, err := p.files.Download(n.forwardContext(ctx), req)
+		var first *pb.DownloadResponse
+		if err == nil {
+			first, err = cs.Recv()
+		}
+		if err != nil && err != io.EOF {
+			// NOT_FOUND - только если файла нет на всех ответивших узлах
+			if lastErr == nil || status.Code(lastErr) == codes.NotFound {
+				lastErr = err
+			}
+			if tryNextNode(err) {
+				log.Printf("[CLUSTER] download %s с %s: %v, пробуем следующий узел", req.GetFilename(), node, err)
+				continue
+			}
+			return err
+		}
+		// До первого чанка клиенту ничего не отправлено, поэтому владельца
+		// можно было сменить; дальше - только пересылка
+		span.SetAttributes(attribute.String("cluster.node", node))
+		if h, err := cs.Header(); err == nil {
+			if v := h.Get(grpcTransport.ContentTypeHeader); len(v) > 0 {
+				stream.SetHeader(metadata.Pairs(grpcTransport.ContentTypeHeader, v[0]))
+			}
+		}
+		for first != nil {
+			if err := stream.Send(first); err != nil {
+				return err
+			}
+			if first, err = cs.Recv(); err == io.EOF {
+				break
+			} else if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return lastErr
+}
+
+// tryNextNode - ошибка одного узла, после которой стоит спросить
+// следующий: у этого нет копии, он недоступен или перегружен
+func tryNextNode(err error) bool {
+	switch status.Code(err) {
+	case codes.NotFound, codes.Unavailable, codes.ResourceExhausted, codes.DeadlineExceeded:
+		return true
+	}
+	return false
+}
+
+// ListFiles собирает списки всех узлов. Копия файла с более поздним
+// UpdatedAt важнее. Пока недоступно меньше узлов, чем Replicas, у каждого
+// файла остаётся хотя бы один ответивший владелец и список полон.
+func (n *Node) ListFiles(ctx context.Context, req *pb.ListFilesRequest) (_ *pb.ListFilesResponse, err error) {
+	if n.forwarded(ctx) {
+		return n.FileServiceServer.ListFiles(ctx, req)
+	}
+	ctx, span := tracer.Start(ctx, "Node.ListFiles", trace.WithSpanKind(trace.SpanKindServer))
+	defer func() { endSpan(span, err) }()
+
+	results := fanOut(ctx, n, n.ring.Nodes(), func(ctx context.Context, p *peer) (*pb.ListFilesResponse, error) {
+		return p.files.ListFiles(ctx, req)
+	})
+	merged := make(map[string]*pb.FileInfo)
+	failed := 0
+	var lastErr error
+	for _, r := range results {
+		if r.err != nil {
+			log.Printf("[CLUSTER] list на %s: %v", r.node, r.err)
+			failed++
+			lastErr = r.err
+			continue
+		}
+		for _, info := range r.v.GetFiles() {
+			if cur, ok := merged[info.GetFilename()]; !ok || updatedAt(info).After(updatedAt(cur)) {
+				merged[info.GetFilename()] = info
+			}
+		}
+	}
+	if failed >= n.replicas {
+		return nil, status.Errorf(codes.Unavailable, "%d of %d nodes unavailable, list would be incomplete: %v", failed, len(results), lastErr)
+	}
+	files := make([]*pb.FileInfo, 0, len(merged))
+	for _, name := range slices.Sorted(maps.Keys(merged)) {
+		files = append(files, merged[name])
+	}
+	span.SetAttributes(attribute.Int("files.count", len(files)), attribute.Int("cluster.failed", failed))
+	return &pb.ListFilesResponse{Files: files}, nil
+}
+
+// SetMetadata меняет метаданные на всех владельцах
+func (n *Node) SetMetadata(ctx context.Context, req *pb.SetMetadataRequest) (_ *pb.FileInfo, err error) {
+	if n.forwarded(ctx) {
+		return n.FileServiceServer.SetMetadata(ctx, req)
+	}
+	ctx, span := tracer.Start(ctx, "Node.SetMetadata", trace.WithSpanKind(trace.SpanKindServer))
+	defer func() { endSpan(span, err) }()
+
+	results := fanOut(ctx, n, n.Owners(req.GetFilename()), func(ctx context.Context, p *peer) (*pb.FileInfo, error) {
+		return p.files.SetMetadata(ctx, req)
+	})
+	return written(n, "metadata", req.GetFilename(), results)
+}
+
+// Delete удаляет файл на всех узлах: до перераспределения копия может
+// лежать и не на владельце. Условие IfMatch проверяется на первом владельце,
+// который файл хранит: устаревшая копия на другом узле не должна сорвать
+// удаление, уже выполненное на остальных. Если какой-то узел не ответил,
+// вызов вернёт ошибку с числом узлов, где файл уже удалён, - иначе
+// оставшаяся копия вернулась бы к владельцам при перераспределении.
+func (n *Node) Delete(ctx context.Context, req *pb.DeleteRequest) (_ *pb.Empty, err error) {
+	if n.forwarded(ctx) {
+		return n.FileServiceServer.Delete(ctx, req)
+	}
+	ctx, span := tracer.Start(ctx, "Node.Delete", trace.WithSpanKind(trace.SpanKindServer))
+	defer func() { endSpan(span, err) }()
+	span.SetAttributes(tracing.AttrFilename.String(req.GetFilename()))
+
+	// Сначала владельцы по порядку, пока один не удалит файл с проверкой
+	// условия; дальше условие уже проверено
+	nodes := slices.Clone(n.Owners(req.GetFilename()))
+	deleted := 0
+	var notFound error
+	for len(nodes) > 0 && deleted == 0 {
+		node := nodes[0]
+		nodes = nodes[1:]
+		r := fanOut(ctx, n, []string{node}, func(ctx context.Context, p *peer) (*pb.Empty, error) {
+			return p.files.Delete(ctx, req)
+		})[0]
+		switch {
+		case r.err == nil:
+			deleted++
+		case status.Code(r.err) == codes.NotFound:
+			notFound = r.err
+		default:
+			log.Printf("[CLUSTER] delete %s на %s: %v", req.GetFilename(), node, r.err)
+			return nil, r.err
+		}
+	}
+
+	rest := &pb.DeleteRequest{Filename: req.GetFilename()}
+	if deleted == 0 {
+		// Файл не нашёлся ни у одного владельца - условие проверят узлы,
+		// на которых он остался до перераспределения
+		rest.IfMatch = req.GetIfMatch()
+	}
+	for _, node := range n.ring.Nodes() {
+		if !slices.Contains(n.Owners(req.GetFilename()), node) {
+			nodes = append(nodes, node)
+		}
+	}
+	results := fanOut(ctx, n, nodes, func(ctx context.Context, p *peer) (*pb.Empty, error) {
+		return p.files.Delete(ctx, rest)
+	})
+	var failed error
+	for _, r := range results {
+		switch {
+		case r.err == nil:
+			deleted++
+		case status.Code(r.err) == codes.NotFound:
+			notFound = r.err
+		default:
+			log.Printf("[CLUSTER] delete %s на %s: %v", req.GetFilename(), r.node, r.err)
+			if failed == nil {
+				failed = r.err
+			}
+		}
+	}
+	span.SetAttributes(attribute.Int("cluster.deleted", deleted))
+	switch {
+	case failed != nil && deleted > 0:
+		return nil, status.Errorf(status.Code(failed), "delete %s: deleted on %d nodes, not on all: %v", req.GetFilename(), deleted, failed)
+	case failed != nil:
+		return nil, failed
+	case deleted == 0:
+		return nil, notFound
+	}
+	return &pb.Empty{}, nil
+}
+
+func updatedAt(info *pb.FileInfo) time.Time {
+	t, _ := time.Parse(time.RFC3339, info.GetUpdatedAt())
+	return t
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(otelCodes.Error, err.Error())
+	}
+	span.End()
+}
+
+// String - узел и размещение для логов
+func (n *Node) String() string {
+	return fmt.Sprintf("%s (узлов %d, копий %d, кворум записи %d)", n.self, len(n.ring.Nodes()), n.replicas, n.quorum)
+}
@@ -0,0 +1,452 @@
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Hiddan13/file_grpc/api/proto/pb"
+	"github.com/Hiddan13/file_grpc/internal/config"
+	"github.com/Hiddan13/file_grpc/internal/ratelimit"
+	"github.com/Hiddan13/file_grpc/internal/replication"
+	"github.com/Hiddan13/file_grpc/internal/repository"
+	"github.com/Hiddan13/file_grpc/internal/service"
+	grpcTransport "github.com/Hiddan13/file_grpc/internal/transport/grpc"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	grpcPeer "google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const testToken = "secret"
+
+// testPeerKey - адрес клиента для теста: через bufconn все приходят с одного
+const testPeerKey = "x-test-peer"
+
+// peerStream подменяет адрес клиента стрима
+type peerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *peerStream) Context() context.Context { return s.ctx }
+
+// asPeer ставит стриму адрес из testPeerKey, если он передан
+func asPeer(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if ips := metadata.ValueFromIncomingContext(ss.Context(), testPeerKey); len(ips) > 0 {
+		addr := &net.TCPAddr{IP: net.ParseIP(ips[0]), Port: 40000}
+		ss = &peerStream{ServerStream: ss, ctx: grpcPeer.NewContext(ss.Context(), &grpcPeer.Peer{Addr: addr})}
+	}
+	return handler(srv, ss)
+}
+
+// testNode - узел кластера в памяти процесса; перезапуск сохраняет хранилище
+type testNode struct {
+	dir  string
+	svc  *service.FileService
+	node *Node
+	srv  *grpc.Server
+	lis  atomic.Pointer[bufconn.Listener]
+}
+
+// testCluster - узлы и соединения с ними, общие для узлов и теста
+type testCluster struct {
+	t        *testing.T
+	replicas int
+	quorum   int
+	nodes    map[string]*testNode
+	conns    map[string]*grpc.ClientConn
+
+	mu        sync.Mutex
+	forwarded map[string][]string // клиенты пересланных Upload по узлам
+}
+
+func newTestCluster(t *testing.T, replicas, quorum int, names ...string) *testCluster {
+	c := &testCluster{t: t, replicas: replicas, quorum: quorum, nodes: make(map[string]*testNode), conns: make(map[string]*grpc.ClientConn), forwarded: make(map[string][]string)}
+	for _, name := range names {
+		c.add(name)
+	}
+	for _, name := range names {
+		c.start(name, names)
+	}
+	return c
+}
+
+// add заводит хранилище и соединение узла name, не запуская его
+func (c *testCluster) add(name string) {
+	n := &testNode{dir: c.t.TempDir()}
+	bc := backoff.DefaultConfig
+	bc.BaseDelay, bc.MaxDelay = 10*time.Millisecond, 50*time.Millisecond
+	conn, err := grpc.NewClient("passthrough:///"+name,
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			lis := n.lis.Load()
+			if lis == nil {
+				return nil, fmt.Errorf("node %s is not started", name)
+			}
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithConnectParams(grpc.ConnectParams{Backoff: bc}),
+	)
+	require.NoError(c.t, err)
+	c.nodes[name], c.conns[name] = n, conn
+	c.t.Cleanup(func() {
+		conn.Close()
+		if n.srv != nil {
+			n.srv.Stop()
+		}
+	})
+}
+
+// start (пере)запускает узел name с составом кластера members
+func (c *testCluster) start(name string, members []string) {
+	n := c.nodes[name]
+	if n.srv != nil {
+		n.srv.Stop()
+	}
+	repo, err := repository.NewFilesRepository(n.dir, repository.Options{})
+	require.NoError(c.t, err)
+	_, err = repo.Reindex(context.Background())
+	require.NoError(c.t, err)
+	n.svc = service.NewFileService(repo)
+	// Как в main: одна передача на клиента
+	limits := ratelimit.NewManager("x-client-id", config.ClientLimits{MaxConcurrent: 1}, nil)
+	limits.TrustForwarded(func(ctx context.Context) bool { return grpcTransport.ValidAdminToken(ctx, testToken) })
+
+	n.node = NewNode(grpcTransport.NewFileServer(n.svc, 10, 10, 10, grpcTransport.Admission{}), n.svc, Options{
+		Self:        name,
+		Nodes:       members,
+		Replicas:    c.replicas,
+		WriteQuorum: c.quorum,
+		Token:       testToken,
+		Identity:    limits.Identity,
+	})
+	for _, member := range members {
+		n.node.AddPeer(member, c.conns[member])
+	}
+	record := func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if ids := metadata.ValueFromIncomingContext(ss.Context(), ratelimit.ForwardedClientKey); len(ids) > 0 && info.FullMethod == pb.FileService_Upload_FullMethodName {
+			c.mu.Lock()
+			c.forwarded[name] = append(c.forwarded[name], ids...)
+			c.mu.Unlock()
+		}
+		return handler(srv, ss)
+	}
+	n.srv = grpc.NewServer(
+		grpc.ChainUnaryInterceptor(grpcTransport.AdminAuthUnaryInterceptor(testToken), limits.UnaryServerInterceptor()),
+		grpc.ChainStreamInterceptor(asPeer, grpcTransport.AdminAuthStreamInterceptor(testToken), record, limits.StreamServerInterceptor()),
+	)
+	pb.RegisterFileServiceServer(n.srv, n.node)
+	pb.RegisterReplicationServiceServer(n.srv, replication.NewServer(n.svc))
+	lis := bufconn.Listen(1 << 20)
+	n.lis.Store(lis)
+	go n.srv.Serve(lis)
+}
+
+func (c *testCluster) stop(name string) {
+	c.nodes[name].srv.Stop()
+}
+
+func (c *testCluster) client(name string) pb.FileServiceClient {
+	return pb.NewFileServiceClient(c.conns[name])
+}
+
+// holders - узлы, на диске которых есть файл
+func (c *testCluster) holders(filename string) []string {
+	var names []string
+	for name, n := range c.nodes {
+		if _, err := n.svc.GetFile(context.Background(), filename); err == nil {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+func upload(t *testing.T, client pb.FileServiceClient, first *pb.UploadRequest, data []byte) error {
+	t.Helper()
+	return uploadCtx(t, context.Background(), client, first, data)
+}
+
+func uploadCtx(t *testing.T, ctx context.Context, client pb.FileServiceClient, first *pb.UploadRequest, data []byte) error {
+	t.Helper()
+	stream, err := client.Upload(ctx)
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(first))
+	require.NoError(t, stream.Send(&pb.UploadRequest{Chunk: data}))
+	_, err = stream.CloseAndRecv()
+	return err
+}
+
+func download(t *testing.T, client pb.FileServiceClient, filename string) ([]byte, error) {
+	t.Helper()
+	stream, err := client.Download(context.Background(), &pb.DownloadRequest{Filename: filename})
+	require.NoError(t, err)
+	var data []byte
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			return data, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, resp.GetChunk()...)
+	}
+}
+
+func sorted(names []string) []string {
+	names = slices.Clone(names)
+	slices.Sort(names)
+	return names
+}
+
+// ---------------------------------------------------------------------
+// Маршрутизация запросов
+// ---------------------------------------------------------------------
+func TestNode_Routing(t *testing.T) {
+	c := newTestCluster(t, 2, 0, "n1", "n2", "n3")
+	var names []string
+	for i := range 12 {
+		name := fmt.Sprintf("file-%d.txt", i)
+		names = append(names, name)
+		require.NoError(t, upload(t, c.client("n1"), &pb.UploadRequest{Filename: name}, []byte("data "+name)))
+	}
+
+	t.Run("upload lands on owners", func(t *testing.T) {
+		for _, name := range names {
+			assert.Equal(t, sorted(c.nodes["n1"].node.Owners(name)), c.holders(name), name)
+		}
+	})
+
+	t.Run("download from any node", func(t *testing.T) {
+		for _, name := range names {
+			data, err := download(t, c.client("n3"), name)
+			require.NoError(t, err)
+			assert.Equal(t, "data "+name, string(data))
+		}
+		_, err := download(t, c.client("n2"), "missing.txt")
+		assert.Equal(t, codes.NotFound, status.Code(err))
+	})
+
+	t.Run("list merges nodes", func(t *testing.T) {
+		resp, err := c.client("n2").ListFiles(context.Background(), &pb.ListFilesRequest{})
+		require.NoError(t, err)
+		var listed []string
+		for _, info := range resp.GetFiles() {
+			listed = append(listed, info.GetFilename())
+		}
+		assert.Equal(t, sorted(names), listed)
+	})
+
+	t.Run("metadata on owners", func(t *testing.T) {
+		_, err := c.client("n3").SetMetadata(context.Background(), &pb.SetMetadataRequest{
+			Filename: names[0],
+			Metadata: map[string]string{"team": "ops"},
+		})
+		require.NoError(t, err)
+		for _, owner := range c.nodes["n3"].node.Owners(names[0]) {
+			meta, err := c.nodes[owner].svc.GetFileInfo(context.Background(), names[0])
+			require.NoError(t, err)
+			assert.Equal(t, "ops", meta.Metadata["team"], owner)
+		}
+	})
+
+	t.Run("delete on all nodes", func(t *testing.T) {
+		_, err := c.client("n2").Delete(context.Background(), &pb.DeleteRequest{Filename: names[1]})
+		require.NoError(t, err)
+		assert.Empty(t, c.holders(names[1]))
+		_, err = c.client("n2").Delete(context.Background(), &pb.DeleteRequest{Filename: names[1]})
+		assert.Equal(t, codes.NotFound, status.Code(err))
+	})
+
+	t.Run("extract rejected", func(t *testing.T) {
+		err := upload(t, c.client("n1"), &pb.UploadRequest{Filename: "a.tar", Extract: "tar"}, []byte("x"))
+		assert.Equal(t, codes.Unimplemented, status.Code(err))
+	})
+
+	t.Run("download before rebalance", func(t *testing.T) {
+		// Файл на узле, который им не владеет, - как после UploadMany
+		name := ""
+		for i := 0; name == ""; i++ {
+			if candidate := fmt.Sprintf("local-%d.txt", i); !slices.Contains(c.nodes["n1"].node.Owners(candidate), "n1") {
+				name = candidate
+			}
+		}
+		_, err := c.nodes["n1"].svc.SaveFile(context.Background(), name, []byte("local"), repository.SaveOptions{})
+		require.NoError(t, err)
+		data, err := download(t, c.client("n2"), name)
+		require.NoError(t, err)
+		assert.Equal(t, "local", string(data))
+	})
+}
+
+// ---------------------------------------------------------------------
+// Пересылка запросов между узлами
+// ---------------------------------------------------------------------
+func TestNode_Forwarding(t *testing.T) {
+	c := newTestCluster(t, 2, 0, "n1", "n2", "n3")
+	// Файл, которым n1 не владеет
+	name := ""
+	for i := 0; name == ""; i++ {
+		if candidate := fmt.Sprintf("file-%d.txt", i); !slices.Contains(c.nodes["n1"].node.Owners(candidate), "n1") {
+			name = candidate
+		}
+	}
+	owners := sorted(c.nodes["n1"].node.Owners(name))
+
+	t.Run("client identity forwarded", func(t *testing.T) {
+		ctx := metadata.AppendToOutgoingContext(context.Background(), testPeerKey, "10.1.2.3")
+		require.NoError(t, uploadCtx(t, ctx, c.client("n1"), &pb.UploadRequest{Filename: name}, []byte("data")))
+		for _, owner := range owners {
+			c.mu.Lock()
+			assert.Equal(t, []string{"10.1.2.3"}, c.forwarded[owner], owner)
+			c.mu.Unlock()
+		}
+	})
+
+	t.Run("forwarded header without token is routed", func(t *testing.T) {
+		ctx := metadata.AppendToOutgoingContext(context.Background(), forwardedKey, "n2")
+		require.NoError(t, uploadCtx(t, ctx, c.client("n1"), &pb.UploadRequest{Filename: name}, []byte("forged")))
+		assert.Equal(t, owners, c.holders(name), "запрос не сохранён на n1 в обход владельцев")
+	})
+
+	t.Run("streamed in chunks", func(t *testing.T) {
+		stream, err := c.client("n1").Upload(context.Background())
+		require.NoError(t, err)
+		require.NoError(t, stream.Send(&pb.UploadRequest{Filename: name}))
+		var want []byte
+		for i := range 3 * relayBuffer {
+			chunk := bytes.Repeat([]byte{byte('a' + i)}, 1024)
+			want = append(want, chunk...)
+			require.NoError(t, stream.Send(&pb.UploadRequest{Chunk: chunk}))
+		}
+		_, err = stream.CloseAndRecv()
+		require.NoError(t, err)
+		for _, owner := range owners {
+			data, err := c.nodes[owner].svc.GetFile(context.Background(), name)
+			require.NoError(t, err)
+			assert.Equal(t, want, data, owner)
+		}
+	})
+}
+
+// ---------------------------------------------------------------------
+// Условное удаление
+// ---------------------------------------------------------------------
+func TestNode_DeleteIfMatch(t *testing.T) {
+	ctx := context.Background()
+	c := newTestCluster(t, 2, 0, "n1", "n2", "n3")
+	require.NoError(t, upload(t, c.client("n1"), &pb.UploadRequest{Filename: "a.txt"}, []byte("current")))
+	owners := c.nodes["n1"].node.Owners("a.txt")
+	meta, err := c.nodes[owners[0]].svc.GetFileInfo(ctx, "a.txt")
+	require.NoError(t, err)
+	// Устаревшая копия на втором владельце
+	_, err = c.nodes[owners[1]].svc.SaveFile(ctx, "a.txt", []byte("stale"), repository.SaveOptions{})
+	require.NoError(t, err)
+
+	_, err = c.client("n2").Delete(ctx, &pb.DeleteRequest{Filename: "a.txt", IfMatch: "other"})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	assert.Equal(t, sorted(owners), c.holders("a.txt"), "ничего не удалено")
+
+	_, err = c.client("n2").Delete(ctx, &pb.DeleteRequest{Filename: "a.txt", IfMatch: meta.ETag})
+	require.NoError(t, err)
+	assert.Empty(t, c.holders("a.txt"))
+}
+
+// ---------------------------------------------------------------------
+// Кворум записи и недоступный узел
+// ---------------------------------------------------------------------
+func TestNode_Quorum(t *testing.T) {
+	t.Run("all owners required", func(t *testing.T) {
+		c := newTestCluster(t, 2, 0, "n1", "n2")
+		c.stop("n2")
+		err := upload(t, c.client("n1"), &pb.UploadRequest{Filename: "a.txt"}, []byte("a"))
+		assert.Equal(t, codes.Unavailable, status.Code(err))
+	})
+
+	t.Run("quorum of one", func(t *testing.T) {
+		c := newTestCluster(t, 2, 1, "n1", "n2")
+		// Файл, первый владелец которого - останавливаемый узел
+		name := ""
+		for i := 0; name == ""; i++ {
+			if candidate := fmt.Sprintf("file-%d.txt", i); c.nodes["n1"].node.Owners(candidate)[0] == "n2" {
+				name = candidate
+			}
+		}
+		require.NoError(t, upload(t, c.client("n1"), &pb.UploadRequest{Filename: name}, []byte("data")))
+		c.stop("n2")
+
+		require.NoError(t, upload(t, c.client("n1"), &pb.UploadRequest{Filename: name}, []byte("newer")))
+		data, err := download(t, c.client("n1"), name)
+		require.NoError(t, err)
+		assert.Equal(t, "newer", string(data))
+
+		// Недоступен один узел из Replicas - список полон
+		resp, err := c.client("n1").ListFiles(context.Background(), &pb.ListFilesRequest{})
+		require.NoError(t, err)
+		assert.Len(t, resp.GetFiles(), 1)
+	})
+}
+
+// ---------------------------------------------------------------------
+// Перераспределение после добавления узла
+// ---------------------------------------------------------------------
+func TestNode_Rebalance(t *testing.T) {
+	c := newTestCluster(t, 1, 0, "n1", "n2")
+	var names []string
+	for i := range 30 {
+		name := fmt.Sprintf("file-%d.txt", i)
+		names = append(names, name)
+		require.NoError(t, upload(t, c.client("n1"), &pb.UploadRequest{Filename: name, Tags: []string{"t"}}, []byte("data "+name)))
+	}
+
+	members := []string{"n1", "n2", "n3"}
+	c.add("n3")
+	for _, name := range members {
+		c.start(name, members)
+	}
+	var total RebalanceResult
+	for _, name := range members {
+		res, err := c.nodes[name].node.Rebalance(context.Background())
+		require.NoError(t, err)
+		total.Copied += res.Copied
+		total.Removed += res.Removed
+		total.Failed += res.Failed
+	}
+	assert.Positive(t, total.Copied)
+	assert.Equal(t, total.Copied, total.Removed)
+	assert.Zero(t, total.Failed)
+
+	for _, name := range names {
+		owners := c.nodes["n3"].node.Owners(name)
+		assert.Equal(t, owners, c.holders(name), name)
+		meta, err := c.nodes[owners[0]].svc.GetFileInfo(context.Background(), name)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"t"}, meta.Tags)
+	}
+
+	// Повторный запуск ничего не меняет
+	res, err := c.nodes["n1"].node.Rebalance(context.Background())
+	require.NoError(t, err)
+	assert.Zero(t, res.Copied+res.Removed+res.Failed)
+
+	var buf bytes.Buffer
+	require.NoError(t, c.nodes["n1"].node.WriteMetrics(&buf))
+	assert.Contains(t, buf.String(), "file_grpc_cluster_nodes 3\n")
+	assert.Contains(t, buf.String(), "file_grpc_cluster_rebalance_runs_total 2\n")
+}
@@ -0,0 +1,208 @@
+package cluster
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/Hiddan13/file_grpc/api/proto/pb"
+	"github.com/Hiddan13/file_grpc/internal/replication"
+	"github.com/Hiddan13/file_grpc/internal/repository"
+	"github.com/Hiddan13/file_grpc/internal/service"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// RebalanceResult - итог перераспределения файлов одного узла
+type RebalanceResult struct {
+	Checked int // локальных файлов проверено
+	Copied  int // копий передано владельцам
+	Removed int // локальных копий удалено: узел больше не владелец
+	Failed  int // передач и удалений с ошибкой
+}
+
+// rebalanceStats - итоги перераспределений для метрик
+type rebalanceStats struct {
+	mu      sync.Mutex
+	runs    int64
+	copied  int64
+	removed int64
+	failed  int64
+	last    RebalanceResult
+	lastAt  time.Time
+}
+
+// Rebalance доводит размещение локальных файлов до кольца: передаёт файл
+// владельцам, у которых его нет или он старее, и удаляет локальную копию,
+// если этот узел больше не владелец и все владельцы файл подтвердили.
+// Недоступный узел пропускается - копии ему уйдут при следующем запуске,
+// а локальная копия до тех пор остаётся.
+func (n *Node) Rebalance(ctx context.Context) (res RebalanceResult, err error) {
+	ctx, span := tracer.Start(ctx, "Node.Rebalance")
+	defer func() { endSpan(span, err) }()
+	ctx = n.nodeContext(ctx)
+
+	local, err := n.fileService.ListFiles(ctx, service.ListFilter{})
+	if err != nil {
+		return res, err
+	}
+	// Файлы других узлов; nil - узел не ответил
+	remote := make(map[string]map[string]*pb.FileInfo)
+	for _, node := range n.ring.Nodes() {
+		if node == n.self {
+			continue
+		}
+		p, err := n.peer(node)
+		if err != nil {
+			return res, err
+		}
+		resp, err := p.files.ListFiles(ctx, &pb.ListFilesRequest{})
+		if err != nil {
+			log.Printf("[CLUSTER] перераспределение: %s недоступен: %v", node, err)
+			remote[node] = nil
+			continue
+		}
+		files := make(map[string]*pb.FileInfo, len(resp.GetFiles()))
+		for _, info := range resp.GetFiles() {
+			files[info.GetFilename()] = info
+		}
+		remote[node] = files
+	}
+
+	for _, m := range local {
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
+		res.Checked++
+		owners := n.Owners(m.Filename)
+		placed := 0 // владельцев, у которых файл точно есть
+		for _, owner := range owners {
+			if owner == n.self {
+				placed++
+				continue
+			}
+			files := remote[owner]
+			if files == nil {
+				continue
+			}
+			// В списке время с точностью до секунды
+			if info, ok := files[m.Filename]; ok && (info.GetEtag() == m.ETag || !m.UpdatedAt.Truncate(time.Second).After(updatedAt(info))) {
+				// Та же или более новая копия
+				placed++
+				continue
+			}
+			if err := n.push(ctx, owner, m.Filename); err != nil {
+				log.Printf("[CLUSTER] перераспределение: %s на %s: %v", m.Filename, owner, err)
+				res.Failed++
+				continue
+			}
+			res.Copied++
+			placed++
+		}
+		if slices.Contains(owners, n.self) || placed < len(owners) {
+			continue
+		}
+		// etag защищает от записи, пришедшей после списка
+		err := n.fileService.DeleteFile(ctx, m.Filename, repository.Preconditions{IfMatch: m.ETag})
+		if err != nil {
+			log.Printf("[CLUSTER] перераспределение: удаление %s: %v", m.Filename, err)
+			res.Failed++
+			continue
+		}
+		res.Removed++
+	}
+	span.SetAttributes(
+		attribute.Int("files.checked", res.Checked),
+		attribute.Int("files.copied", res.Copied),
+		attribute.Int("files.removed", res.Removed),
+	)
+
+	st := &n.stats
+	st.mu.Lock()
+	st.runs++
+	st.copied += int64(res.Copied)
+	st.removed += int64(res.Removed)
+	st.failed += int64(res.Failed)
+	st.last, st.lastAt = res, time.Now()
+	st.mu.Unlock()
+	return res, nil
+}
+
+// push передаёт локальный файл узлу node через ReplicationService
+func (n *Node) push(ctx context.Context, node, filename string) error {
+	p, err := n.peer(node)
+	if err != nil {
+		return err
+	}
+	data, err := n.fileService.GetFile(ctx, filename)
+	if err != nil {
+		return err
+	}
+	meta, err := n.fileService.GetFileInfo(ctx, filename)
+	if err != nil {
+		return err
+	}
+	info := replication.FileInfo(meta)
+	sum := sha256.Sum256(data)
+	info.Etag, info.Size = hex.EncodeToString(sum[:]), int64(len(data))
+	return replication.Send(ctx, p.repl, &pb.ReplicateHeader{Op: replication.OpPut, File: info}, data)
+}
+
+// RunRebalance перераспределяет файлы сразу и затем каждые interval до
+// отмены ctx
+func (n *Node) RunRebalance(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		res, err := n.Rebalance(ctx)
+		switch {
+		case ctx.Err() != nil:
+			return
+		case err != nil:
+			log.Printf("[CLUSTER] ошибка перераспределения: %v", err)
+		case res.Copied+res.Removed+res.Failed > 0:
+			log.Printf("[CLUSTER] перераспределение: проверено %d, скопировано %d, удалено %d, ошибок %d", res.Checked, res.Copied, res.Removed, res.Failed)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// WriteMetrics пишет состояние узла кластера в текстовом формате Prometheus
+func (n *Node) WriteMetrics(w io.Writer) error {
+	st := &n.stats
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	var lastAt int64
+	if !st.lastAt.IsZero() {
+		lastAt = st.lastAt.Unix()
+	}
+
+	metric := func(name, typ, help string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	}
+	metric("file_grpc_cluster_nodes", "gauge", "Nodes in the cluster membership.")
+	fmt.Fprintf(w, "file_grpc_cluster_nodes %d\n", len(n.ring.Nodes()))
+	metric("file_grpc_cluster_rebalance_runs_total", "counter", "Completed rebalance passes.")
+	fmt.Fprintf(w, "file_grpc_cluster_rebalance_runs_total %d\n", st.runs)
+	metric("file_grpc_cluster_rebalance_copied_total", "counter", "File copies sent to owning nodes.")
+	fmt.Fprintf(w, "file_grpc_cluster_rebalance_copied_total %d\n", st.copied)
+	metric("file_grpc_cluster_rebalance_removed_total", "counter", "Local copies removed after ownership moved.")
+	fmt.Fprintf(w, "file_grpc_cluster_rebalance_removed_total %d\n", st.removed)
+	metric("file_grpc_cluster_rebalance_failed_total", "counter", "Copies and removals that failed.")
+	fmt.Fprintf(w, "file_grpc_cluster_rebalance_failed_total %d\n", st.failed)
+	metric("file_grpc_cluster_rebalance_last_run_timestamp_seconds", "gauge", "End of the last completed pass, 0 - none yet.")
+	fmt.Fprintf(w, "file_grpc_cluster_rebalance_last_run_timestamp_seconds %d\n", lastAt)
+	metric("file_grpc_cluster_local_files", "gauge", "Local files checked by the last completed pass.")
+	_, err := fmt.Fprintf(w, "file_grpc_cluster_local_files %d\n", st.last.Checked)
+	return err
+}
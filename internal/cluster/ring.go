@@ -0,0 +1,77 @@
+package cluster
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/binary"
+	"slices"
+	"strconv"
+)
+
+// Сколько точек на кольце у каждого узла: чем больше, тем ровнее файлы
+// делятся между узлами
+const virtualNodes = 128
+
+// Ring - консистентное хеширование: узлы и имена файлов отображаются на
+// кольцо uint64, владельцы файла - первые разные узлы по часовой стрелке от
+// его хеша. При добавлении узла переезжает только часть файлов, которая
+// теперь попадает на новый узел.
+type Ring struct {
+	nodes  []string
+	points []point // по возрастанию hash
+}
+
+type point struct {
+	hash uint64
+	node string
+}
+
+// NewRing строит кольцо; повторы в nodes не учитываются
+func NewRing(nodes []string) *Ring {
+	r := &Ring{}
+	for _, node := range nodes {
+		if slices.Contains(r.nodes, node) {
+			continue
+		}
+		r.nodes = append(r.nodes, node)
+		for i := range virtualNodes {
+			r.points = append(r.points, point{hash: hashKey(node + "#" + strconv.Itoa(i)), node: node})
+		}
+	}
+	slices.SortFunc(r.points, func(a, b point) int {
+		if a.hash != b.hash {
+			return cmp.Compare(a.hash, b.hash)
+		}
+		return cmp.Compare(a.node, b.node)
+	})
+	return r
+}
+
+// Nodes - все узлы кольца
+func (r *Ring) Nodes() []string {
+	return slices.Clone(r.nodes)
+}
+
+// Owners вернёт n узлов, хранящих файл, в порядке предпочтения. Если узлов
+// меньше n - все узлы.
+func (r *Ring) Owners(filename string, n int) []string {
+	n = min(n, len(r.nodes))
+	if n <= 0 {
+		return nil
+	}
+	h := hashKey(filename)
+	i, _ := slices.BinarySearchFunc(r.points, h, func(p point, h uint64) int { return cmp.Compare(p.hash, h) })
+	owners := make([]string, 0, n)
+	for j := 0; len(owners) < n; j++ {
+		node := r.points[(i+j)%len(r.points)].node
+		if !slices.Contains(owners, node) {
+			owners = append(owners, node)
+		}
+	}
+	return owners
+}
+
+func hashKey(key string) uint64 {
+	sum := sha256.Sum256([]byte(key))
+	return binary.BigEndian.Uint64(sum[:8])
+}
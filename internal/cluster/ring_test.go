@@ -0,0 +1,64 @@
+package cluster
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// ---------------------------------------------------------------------
+// Ring: владельцы файла
+// ---------------------------------------------------------------------
+func TestRing_Owners(t *testing.T) {
+	r := NewRing([]string{"a:1", "b:1", "c:1"})
+
+	t.Run("deterministic and distinct", func(t *testing.T) {
+		for i := range 100 {
+			name := fmt.Sprintf("file-%d", i)
+			owners := r.Owners(name, 2)
+			assert.Len(t, owners, 2)
+			assert.NotEqual(t, owners[0], owners[1])
+			assert.Equal(t, owners, NewRing([]string{"c:1", "a:1", "b:1"}).Owners(name, 2), "порядок узлов не важен")
+		}
+	})
+
+	t.Run("capped by node count", func(t *testing.T) {
+		assert.ElementsMatch(t, []string{"a:1", "b:1", "c:1"}, r.Owners("x", 5))
+		assert.Nil(t, NewRing(nil).Owners("x", 2))
+	})
+
+	t.Run("duplicates ignored", func(t *testing.T) {
+		d := NewRing([]string{"a:1", "b:1", "a:1"})
+		assert.Equal(t, []string{"a:1", "b:1"}, d.Nodes())
+		assert.Len(t, d.Owners("x", 3), 2)
+	})
+}
+
+// ---------------------------------------------------------------------
+// Ring: распределение
+// ---------------------------------------------------------------------
+func TestRing_Distribution(t *testing.T) {
+	const files = 3000
+	before := NewRing([]string{"a:1", "b:1", "c:1"})
+	after := NewRing([]string{"a:1", "b:1", "c:1", "d:1"})
+
+	count := make(map[string]int)
+	moved := 0
+	for i := range files {
+		name := fmt.Sprintf("file-%d", i)
+		old, cur := before.Owners(name, 1)[0], after.Owners(name, 1)[0]
+		count[old]++
+		if old != cur {
+			// Файлы переезжают только на новый узел
+			assert.Equal(t, "d:1", cur, name)
+			moved++
+		}
+	}
+	for node, n := range count {
+		assert.Greater(t, n, files/5, "узел %s получил слишком мало файлов", node)
+	}
+	// Около четверти файлов, а не все
+	assert.Greater(t, moved, files/8)
+	assert.Less(t, moved, files*2/5)
+}
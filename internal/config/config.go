@@ -1,48 +1,545 @@
 package config
 
 import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
 	"log"
+	"maps"
 	"os"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
 )
 
+// ClientLimits - ограничения на одного клиента, 0 - без ограничения
+type ClientLimits struct {
+	MaxConcurrent  int     `yaml:"max_concurrent"`   // одновременных Upload/Download
+	RequestsPerSec float64 `yaml:"requests_per_sec"` // новых запросов в секунду
+	RequestBurst   int     `yaml:"request_burst"`
+	BytesPerSec    int     `yaml:"bytes_per_sec"` // скорость Upload и Download (каждого направления)
+}
+
+// LifecycleRule - удалять файлы с префиксом Prefix ("" - все), к которым не
+// обращались дольше NotAccessedFor
+type LifecycleRule struct {
+	Prefix         string        `yaml:"prefix"`
+	NotAccessedFor time.Duration `yaml:"not_accessed_for"`
+}
+
 type Config struct {
-	StoragePath   string
-	UploadLimit   int
-	DownloadLimit int
-	ListLimit     int
-	GRPCPort      string
+	StoragePath   string `yaml:"storage_path"`
+	UploadLimit   int    `yaml:"upload_limit"`
+	DownloadLimit int    `yaml:"download_limit"`
+	ListLimit     int    `yaml:"list_limit"`
+	GRPCPort      string `yaml:"grpc_port"`
+
+	// Очередь при достижении лимитов: reject (сразу ResourceExhausted) или wait
+	AdmissionMode        string        `yaml:"admission_mode"`
+	AdmissionQueueLength int           `yaml:"admission_queue_length"`
+	AdmissionMaxWait     time.Duration `yaml:"admission_max_wait"`
+
+	// Лимиты на клиента. Клиент определяется по IP, переопределения задаются
+	// по IP. Заголовок ClientIDHeader клиент выбирает сам, поэтому он только
+	// метка клиента в логе.
+	ClientIDHeader       string                  `yaml:"client_id_header"`
+	ClientLimits         ClientLimits            `yaml:"client_limits"`
+	ClientLimitOverrides map[string]ClientLimits `yaml:"client_limit_overrides"`
+
+	// Трассировка: none, otlp или file
+	TracingExporter string `yaml:"tracing_exporter"`
+	OTLPEndpoint    string `yaml:"otlp_endpoint"`
+	TracingFile     string `yaml:"tracing_file"`
+
+	// Health-check и HTTP пробы
+	AdminPort           string        `yaml:"admin_port"`
+	HealthCheckInterval time.Duration `yaml:"health_check_interval"`
+	MinFreeDiskMB       int           `yaml:"min_free_disk_mb"`
+
+	// Сколько ждать завершения активных передач при остановке
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+
+	// Токен для AdminService, пустой - админские RPC отключены
+	AdminToken string `yaml:"admin_token"`
+
+	// Версионирование: при перезаписи предыдущее содержимое сохраняется.
+	// Версии сверх VersionKeepLast последних или старше VersionMaxAge
+	// удаляются раз в VersionRetentionInterval, 0 - без ограничения.
+	Versioning               bool          `yaml:"versioning"`
+	VersionKeepLast          int           `yaml:"version_keep_last"`
+	VersionMaxAge            time.Duration `yaml:"version_max_age"`
+	VersionRetentionInterval time.Duration `yaml:"version_retention_interval"`
+
+	// Обновление времени последнего доступа при скачивании: off, relatime
+	// (не чаще раза в сутки и после изменения файла) или strict (каждый раз)
+	AccessTime string `yaml:"access_time"`
+
+	// Сброс записи на диск: none, file (fsync файла) или full (fsync файла
+	// и директории). Запись атомарна в любом режиме.
+	Durability string `yaml:"durability"`
+
+	// Очистка раз в LifecycleInterval: файлы с истёкшим сроком жизни (TTL
+	// при загрузке) и попавшие под LifecycleRules. В режиме LifecycleDryRun
+	// файлы только пишутся в лог.
+	LifecycleInterval time.Duration   `yaml:"lifecycle_interval"`
+	LifecycleDryRun   bool            `yaml:"lifecycle_dry_run"`
+	LifecycleRules    []LifecycleRule `yaml:"lifecycle_rules"`
+
+	// Проверка целостности хранилища раз в ScrubInterval (0 - только через
+	// AdminService.Scrub). Найденное можно чинить: метаданные приводятся к
+	// диску, повреждённые файлы переносятся в .quarantine.
+	ScrubInterval       time.Duration `yaml:"scrub_interval"`
+	ScrubRepairMetadata bool          `yaml:"scrub_repair_metadata"`
+	ScrubQuarantine     bool          `yaml:"scrub_quarantine"`
+
+	// Асинхронная репликация: изменения файлов пишутся в журнал и
+	// рассылаются ведомым серверам (host:port). Ведомые должны принимать
+	// тот же AdminToken. После ошибки попытка повторяется через
+	// ReplicationRetryInterval.
+	ReplicationFollowers     []string      `yaml:"replication_followers"`
+	ReplicationRetryInterval time.Duration `yaml:"replication_retry_interval"`
+
+	// Кластер: узлы ClusterNodes (host:port, включая этот - ClusterSelf)
+	// делят файлы по кольцу консистентного хеширования, каждый файл хранят
+	// ClusterReplicas узлов. Запись успешна, когда её подтвердили
+	// ClusterWriteQuorum владельцев (0 - все). Раз в
+	// ClusterRebalanceInterval узел переносит файлы, которыми больше не
+	// владеет. Узлы должны принимать один AdminToken.
+	ClusterNodes             []string      `yaml:"cluster_nodes"`
+	ClusterSelf              string        `yaml:"cluster_self"`
+	ClusterReplicas          int           `yaml:"cluster_replicas"`
+	ClusterWriteQuorum       int           `yaml:"cluster_write_quorum"`
+	ClusterRebalanceInterval time.Duration `yaml:"cluster_rebalance_interval"`
+
+	// Ограничения распаковки архива при загрузке (Upload с extract)
+	ExtractMaxEntries int `yaml:"extract_max_entries"`
+	ExtractMaxSizeMB  int `yaml:"extract_max_size_mb"`
+}
+
+// Flags - параметры командной строки сервера, не входящие в конфиг
+type Flags struct {
+	ConfigFile  string
+	PrintConfig bool
+}
+
+// Default вернёт конфиг со значениями по умолчанию
+func Default() *Config {
+	return &Config{
+		StoragePath:   "./uploads_default",
+		UploadLimit:   10,
+		DownloadLimit: 10,
+		ListLimit:     100,
+		GRPCPort:      ":50051",
+
+		AdmissionMode:        "reject",
+		AdmissionQueueLength: 100,
+		AdmissionMaxWait:     30 * time.Second,
+
+		ClientLimits:         ClientLimits{RequestBurst: 10},
+		ClientLimitOverrides: map[string]ClientLimits{},
+
+		TracingExporter: "none",
+		OTLPEndpoint:    "localhost:4317",
+		TracingFile:     "./traces.json",
+
+		AdminPort:           ":8080",
+		HealthCheckInterval: 10 * time.Second,
+		MinFreeDiskMB:       100,
+
+		ShutdownTimeout: 30 * time.Second,
+
+		VersionRetentionInterval: time.Hour,
+
+		AccessTime: "relatime",
+		Durability: "full",
+
+		LifecycleInterval: 10 * time.Minute,
+
+		ScrubInterval: 24 * time.Hour,
+
+		ReplicationRetryInterval: 5 * time.Second,
+
+		ClusterReplicas:          2,
+		ClusterRebalanceInterval: 10 * time.Minute,
+
+		ExtractMaxEntries: 1000,
+		ExtractMaxSizeMB:  512,
+	}
+}
+
+// field - один параметр конфига: имя флага, переменная окружения и разбор
+// строкового значения
+type field struct {
+	flag  string
+	env   string
+	usage string
+	set   func(string) error
+}
+
+func (c *Config) fields() []field {
+	return []field{
+		{"storage-path", "STORAGE_PATH", "directory for stored files", stringVar(&c.StoragePath)},
+		{"upload-limit", "UPLOAD_LIMIT", "concurrent uploads", intVar(&c.UploadLimit)},
+		{"download-limit", "DOWNLOAD_LIMIT", "concurrent downloads", intVar(&c.DownloadLimit)},
+		{"list-limit", "LIST_LIMIT", "concurrent list requests", intVar(&c.ListLimit)},
+		{"grpc-port", "GRPC_PORT", "gRPC listen address", stringVar(&c.GRPCPort)},
+
+		{"admission-mode", "ADMISSION_MODE", "reject or wait when a limit is reached", stringVar(&c.AdmissionMode)},
+		{"admission-queue-length", "ADMISSION_QUEUE_LENGTH", "max queued requests per limit", intVar(&c.AdmissionQueueLength)},
+		{"admission-max-wait", "ADMISSION_MAX_WAIT", "max time in the admission queue", durationVar(&c.AdmissionMaxWait)},
+
+		{"client-id-header", "CLIENT_ID_HEADER", "metadata key with a client label for logs", stringVar(&c.ClientIDHeader)},
+		{"client-max-concurrent", "CLIENT_MAX_CONCURRENT", "concurrent transfers per client", intVar(&c.ClientLimits.MaxConcurrent)},
+		{"client-requests-per-sec", "CLIENT_REQUESTS_PER_SEC", "requests per second per client", floatVar(&c.ClientLimits.RequestsPerSec)},
+		{"client-request-burst", "CLIENT_REQUEST_BURST", "request burst per client", intVar(&c.ClientLimits.RequestBurst)},
+		{"client-bytes-per-sec", "CLIENT_BYTES_PER_SEC", "bytes per second per client", intVar(&c.ClientLimits.BytesPerSec)},
+
+		{"tracing-exporter", "TRACING_EXPORTER", "none, otlp or file", stringVar(&c.TracingExporter)},
+		{"otlp-endpoint", "OTLP_ENDPOINT", "OTLP collector address", stringVar(&c.OTLPEndpoint)},
+		{"tracing-file", "TRACING_FILE", "file for the file exporter", stringVar(&c.TracingFile)},
+
+		{"admin-port", "ADMIN_PORT", "admin HTTP listen address", stringVar(&c.AdminPort)},
+		{"health-check-interval", "HEALTH_CHECK_INTERVAL", "storage health check period", durationVar(&c.HealthCheckInterval)},
+		{"min-free-disk-mb", "MIN_FREE_DISK_MB", "min free disk space", intVar(&c.MinFreeDiskMB)},
+
+		{"shutdown-timeout", "SHUTDOWN_TIMEOUT", "drain timeout on shutdown", durationVar(&c.ShutdownTimeout)},
+
+		{"admin-token", "ADMIN_TOKEN", "token for AdminService", stringVar(&c.AdminToken)},
+
+		{"versioning", "VERSIONING", "keep previous content on overwrite (true/false)", boolVar(&c.Versioning)},
+		{"version-keep-last", "VERSION_KEEP_LAST", "versions to keep per file", intVar(&c.VersionKeepLast)},
+		{"version-max-age", "VERSION_MAX_AGE", "max age of a version", durationVar(&c.VersionMaxAge)},
+		{"version-retention-interval", "VERSION_RETENTION_INTERVAL", "version retention period", durationVar(&c.VersionRetentionInterval)},
+
+		{"access-time", "ACCESS_TIME", "last access time tracking: off, relatime or strict", stringVar(&c.AccessTime)},
+		{"durability", "DURABILITY", "fsync on write: none, file or full", stringVar(&c.Durability)},
+
+		{"lifecycle-interval", "LIFECYCLE_INTERVAL", "expired files cleanup period", durationVar(&c.LifecycleInterval)},
+		{"lifecycle-dry-run", "LIFECYCLE_DRY_RUN", "only log files the cleanup would remove (true/false)", boolVar(&c.LifecycleDryRun)},
+		{"lifecycle-rules", "LIFECYCLE_RULES", "remove files not accessed for a period: prefix=duration;prefix2=duration", lifecycleRulesVar(&c.LifecycleRules)},
+		{"scrub-interval", "SCRUB_INTERVAL", "storage integrity check period (0 - off)", durationVar(&c.ScrubInterval)},
+		{"scrub-repair-metadata", "SCRUB_REPAIR_METADATA", "scrub brings metadata in line with the disk (true/false)", boolVar(&c.ScrubRepairMetadata)},
+		{"scrub-quarantine", "SCRUB_QUARANTINE", "scrub moves corrupt files to .quarantine (true/false)", boolVar(&c.ScrubQuarantine)},
+		{"replication-followers", "REPLICATION_FOLLOWERS", "follower addresses to replicate to: host:port,host2:port", listVar(&c.ReplicationFollowers)},
+		{"replication-retry-interval", "REPLICATION_RETRY_INTERVAL", "retry period for an unreachable follower", durationVar(&c.ReplicationRetryInterval)},
+
+		{"cluster-nodes", "CLUSTER_NODES", "cluster node addresses including this one: host:port,host2:port", listVar(&c.ClusterNodes)},
+		{"cluster-self", "CLUSTER_SELF", "address of this node as listed in cluster nodes", stringVar(&c.ClusterSelf)},
+		{"cluster-replicas", "CLUSTER_REPLICAS", "nodes storing each file", intVar(&c.ClusterReplicas)},
+		{"cluster-write-quorum", "CLUSTER_WRITE_QUORUM", "owners that must confirm a write (0 - all replicas)", intVar(&c.ClusterWriteQuorum)},
+		{"cluster-rebalance-interval", "CLUSTER_REBALANCE_INTERVAL", "period of moving files to their owning nodes", durationVar(&c.ClusterRebalanceInterval)},
+		{"extract-max-entries", "EXTRACT_MAX_ENTRIES", "max files in an uploaded archive", intVar(&c.ExtractMaxEntries)},
+		{"extract-max-size-mb", "EXTRACT_MAX_SIZE_MB", "max extracted size of an uploaded archive", intVar(&c.ExtractMaxSizeMB)},
+	}
+}
+
+func stringVar(p *string) func(string) error {
+	return func(v string) error {
+		*p = v
+		return nil
+	}
+}
+
+func boolVar(p *bool) func(string) error {
+	return func(v string) error {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("not a boolean")
+		}
+		*p = b
+		return nil
+	}
+}
+
+func intVar(p *int) func(string) error {
+	return func(v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("not an integer")
+		}
+		*p = n
+		return nil
+	}
+}
+
+func floatVar(p *float64) func(string) error {
+	return func(v string) error {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return fmt.Errorf("not a number")
+		}
+		*p = f
+		return nil
+	}
+}
+
+func durationVar(p *time.Duration) func(string) error {
+	return func(v string) error {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("not a duration")
+		}
+		*p = d
+		return nil
+	}
+}
+
+// listVar разбирает список через запятую, пустые элементы пропускаются
+func listVar(p *[]string) func(string) error {
+	return func(v string) error {
+		var items []string
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		*p = items
+		return nil
+	}
 }
 
-func Load() *Config {
+// lifecycleRulesVar разбирает правила вида "tmp-=24h;=720h", пустой
+// префикс - все файлы
+func lifecycleRulesVar(p *[]LifecycleRule) func(string) error {
+	return func(v string) error {
+		var rules []LifecycleRule
+		for _, entry := range strings.Split(v, ";") {
+			entry = strings.TrimSpace(entry)
+			if entry == "" {
+				continue
+			}
+			prefix, period, ok := strings.Cut(entry, "=")
+			if !ok {
+				return fmt.Errorf("invalid rule %q, want prefix=duration", entry)
+			}
+			d, err := time.ParseDuration(strings.TrimSpace(period))
+			if err != nil {
+				return fmt.Errorf("rule %q: not a duration", entry)
+			}
+			rules = append(rules, LifecycleRule{Prefix: strings.TrimSpace(prefix), NotAccessedFor: d})
+		}
+		*p = rules
+		return nil
+	}
+}
+
+var (
+	envFileMu   sync.Mutex
+	envFileKeys = make(map[string]bool) // что выставили из .env в прошлый раз
+)
+
+// loadEnvFile (пере)читывает .env и возвращает выставленные из него ключи.
+// Ключи, удалённые из файла (или вместе с файлом), сбрасываются.
+func loadEnvFile() (map[string]bool, error) {
+	vars, err := godotenv.Read()
+	envFileMu.Lock()
+	defer envFileMu.Unlock()
+	for k := range envFileKeys {
+		if _, ok := vars[k]; !ok {
+			os.Unsetenv(k)
+		}
+	}
+	prev := envFileKeys
+	envFileKeys = make(map[string]bool)
+	for k, v := range vars {
+		// Переменные, заданные не из .env, файл не переопределяет
+		if _, set := os.LookupEnv(k); set && !prev[k] {
+			continue
+		}
+		os.Setenv(k, v)
+		envFileKeys[k] = true
+	}
+	return maps.Clone(envFileKeys), err
+}
+
+// Load собирает конфиг по приоритету: значения по умолчанию < .env < YAML
+// файл (-config или CONFIG_FILE) < переменные окружения < флаги args.
+// Возвращает ошибку, если какое-то значение не разбирается или конфиг не
+// проходит Validate. Безопасно вызывать повторно (SIGHUP).
+func Load(args []string) (*Config, *Flags, error) {
+	cfg := Default()
+	fields := cfg.fields()
+	flags := &Flags{}
+
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	fs.StringVar(&flags.ConfigFile, "config", "", "YAML config file (env CONFIG_FILE)")
+	fs.BoolVar(&flags.PrintConfig, "print-config", false, "print effective config and exit")
+	for _, f := range fields {
+		fs.String(f.flag, "", fmt.Sprintf("%s (env %s)", f.usage, f.env))
+	}
+	fs.String("client-limit-overrides", "", "per-client limits id=field:value,...;id2=... (env CLIENT_LIMIT_OVERRIDES)")
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
 	// Загружаем .env файл, если он существует
-	err := godotenv.Load()
+	fromEnvFile, err := loadEnvFile()
 	if err != nil {
 		log.Println("No .env file found, using environment variables or defaults")
 	}
-	return &Config{
-		StoragePath:   getEnv("STORAGE_PATH", "./uploads_default"),
-		UploadLimit:   getEnvAsInt("UPLOAD_LIMIT", 10),
-		DownloadLimit: getEnvAsInt("DOWNLOAD_LIMIT", 10),
-		ListLimit:     getEnvAsInt("LIST_LIMIT", 100),
-		GRPCPort:      getEnv("GRPC_PORT", ":50051"),
+
+	// setFromEnv применяет переменные окружения: из .env (fromFile) или
+	// заданные процессу
+	var errs []error
+	setFromEnv := func(fromFile bool) {
+		for _, f := range fields {
+			if fromEnvFile[f.env] != fromFile {
+				continue
+			}
+			if val := os.Getenv(f.env); val != "" {
+				if err := f.set(val); err != nil {
+					errs = append(errs, fmt.Errorf("%s: invalid value %q: %w", f.env, val, err))
+				}
+			}
+		}
+	}
+
+	// 1. .env - ниже YAML файла, иначе закоммиченный .env перекрыл бы -config
+	setFromEnv(true)
+
+	// 2. YAML файл
+	if flags.ConfigFile == "" {
+		flags.ConfigFile = os.Getenv("CONFIG_FILE")
+	}
+	var overrideNodes map[string]yaml.Node
+	if flags.ConfigFile != "" {
+		if overrideNodes, err = cfg.loadFile(flags.ConfigFile); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// 3. Переменные окружения
+	setFromEnv(false)
+	var overrideSpec, overrideFrom string
+	if !fromEnvFile["CLIENT_LIMIT_OVERRIDES"] || overrideNodes == nil {
+		overrideSpec, overrideFrom = os.Getenv("CLIENT_LIMIT_OVERRIDES"), "CLIENT_LIMIT_OVERRIDES"
+	}
+
+	// 4. Флаги - только явно заданные
+	byFlag := make(map[string]field, len(fields))
+	for _, f := range fields {
+		byFlag[f.flag] = f
+	}
+	fs.Visit(func(fl *flag.Flag) {
+		if fl.Name == "client-limit-overrides" {
+			overrideSpec, overrideFrom = fl.Value.String(), "-client-limit-overrides"
+			return
+		}
+		if f, ok := byFlag[fl.Name]; ok {
+			if err := f.set(fl.Value.String()); err != nil {
+				errs = append(errs, fmt.Errorf("-%s: invalid value %q: %w", fl.Name, fl.Value.String(), err))
+			}
+		}
+	})
+
+	// Переопределения лимитов клиентов наследуют итоговые ClientLimits
+	if overrideSpec != "" {
+		overrides, err := parseClientLimitOverrides(overrideSpec, cfg.ClientLimits)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", overrideFrom, err))
+		}
+		cfg.ClientLimitOverrides = overrides
+	} else if overrideNodes != nil {
+		cfg.ClientLimitOverrides = make(map[string]ClientLimits, len(overrideNodes))
+		for id, node := range overrideNodes {
+			limits := cfg.ClientLimits
+			if err := node.Decode(&limits); err != nil {
+				errs = append(errs, fmt.Errorf("client_limit_overrides.%s: %w", id, err))
+			}
+			cfg.ClientLimitOverrides[id] = limits
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, nil, errors.Join(errs...)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, nil, err
 	}
+	return cfg, flags, nil
 }
 
-func getEnv(key, defaultValue string) string {
-	if val := os.Getenv(key); val != "" {
-		return val
+// loadFile накладывает YAML файл на конфиг, неизвестные ключи - ошибка.
+// Переопределения клиентов возвращаются отдельно, чтобы разобрать их поверх
+// итоговых ClientLimits.
+func (c *Config) loadFile(path string) (map[string]yaml.Node, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+
+	var raw struct {
+		Overrides map[string]yaml.Node `yaml:"client_limit_overrides"`
 	}
-	return defaultValue
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return raw.Overrides, nil
 }
 
-func getEnvAsInt(key string, defaultValue int) int {
-	if val := os.Getenv(key); val != "" {
-		if intVal, err := strconv.Atoi(val); err == nil {
-			return intVal
+// Print выводит итоговый конфиг в YAML, скрывая секреты
+func (c *Config) Print(w io.Writer) error {
+	out := *c
+	if out.AdminToken != "" {
+		out.AdminToken = "<redacted>"
+	}
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(&out); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// parseClientLimitOverrides разбирает строку вида
+// "10.0.0.5=max_concurrent:20,bytes_per_sec:0;backup=requests_per_sec:100".
+// Не указанные поля берутся из defaults.
+func parseClientLimitOverrides(val string, defaults ClientLimits) (map[string]ClientLimits, error) {
+	overrides := make(map[string]ClientLimits)
+	var errs []error
+	for _, entry := range strings.Split(val, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, fields, ok := strings.Cut(entry, "=")
+		id = strings.TrimSpace(id)
+		if !ok || id == "" {
+			errs = append(errs, fmt.Errorf("invalid entry %q", entry))
+			continue
+		}
+		limits := defaults
+		for _, field := range strings.Split(fields, ",") {
+			key, v, _ := strings.Cut(strings.TrimSpace(field), ":")
+			var err error
+			switch key {
+			case "max_concurrent":
+				err = intVar(&limits.MaxConcurrent)(v)
+			case "requests_per_sec":
+				err = floatVar(&limits.RequestsPerSec)(v)
+			case "request_burst":
+				err = intVar(&limits.RequestBurst)(v)
+			case "bytes_per_sec":
+				err = intVar(&limits.BytesPerSec)(v)
+			default:
+				err = fmt.Errorf("unknown field")
+			}
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: field %q: %w", id, field, err))
+			}
 		}
+		overrides[id] = limits
 	}
-	return defaultValue
+	return overrides, errors.Join(errs...)
 }
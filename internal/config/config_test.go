@@ -0,0 +1,293 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ---------------------------------------------------------------------
+// Вспомогательные функции
+// ---------------------------------------------------------------------
+func storageArgs(t *testing.T, args ...string) []string {
+	t.Helper()
+	return append([]string{"-storage-path", t.TempDir()}, args...)
+}
+
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	return path
+}
+
+// ---------------------------------------------------------------------
+// Load: приоритет источников
+// ---------------------------------------------------------------------
+func TestLoad(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		cfg, flags, err := Load(storageArgs(t))
+		require.NoError(t, err)
+		assert.Equal(t, 10, cfg.UploadLimit)
+		assert.Equal(t, 100, cfg.ListLimit)
+		assert.Equal(t, ":50051", cfg.GRPCPort)
+		assert.False(t, flags.PrintConfig)
+	})
+
+	t.Run("file < env < flags", func(t *testing.T) {
+		path := writeConfigFile(t, `
+upload_limit: 20
+download_limit: 30
+list_limit: 40
+admission_max_wait: 5s
+client_limits:
+  bytes_per_sec: 1000
+`)
+		t.Setenv("DOWNLOAD_LIMIT", "31")
+		t.Setenv("LIST_LIMIT", "41")
+
+		cfg, _, err := Load(storageArgs(t, "-config", path, "-list-limit", "42"))
+		require.NoError(t, err)
+		assert.Equal(t, 20, cfg.UploadLimit)
+		assert.Equal(t, 31, cfg.DownloadLimit)
+		assert.Equal(t, 42, cfg.ListLimit)
+		assert.Equal(t, 5*time.Second, cfg.AdmissionMaxWait)
+		assert.Equal(t, 1000, cfg.ClientLimits.BytesPerSec)
+		assert.Equal(t, 10, cfg.ClientLimits.RequestBurst) // из значений по умолчанию
+	})
+
+	t.Run(".env < file < env", func(t *testing.T) {
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, ".env"), []byte("STORAGE_PATH="+dir+"\nGRPC_PORT=:50051\nUPLOAD_LIMIT=5\nDOWNLOAD_LIMIT=5\n"), 0644))
+		storage := t.TempDir()
+		path := writeConfigFile(t, "storage_path: "+storage+"\ngrpc_port: \":6000\"\nupload_limit: 20\n")
+		t.Cleanup(func() { loadEnvFile() }) // после возврата в исходную директорию сбросит ключи .env
+		t.Chdir(dir)
+		t.Setenv("UPLOAD_LIMIT", "30")
+
+		cfg, _, err := Load([]string{"-config", path})
+		require.NoError(t, err)
+		assert.Equal(t, storage, cfg.StoragePath)
+		assert.Equal(t, ":6000", cfg.GRPCPort)
+		assert.Equal(t, 30, cfg.UploadLimit)
+		assert.Equal(t, 5, cfg.DownloadLimit) // YAML не задаёт - берётся из .env
+	})
+
+	t.Run("file overrides inherit client limits", func(t *testing.T) {
+		path := writeConfigFile(t, `
+client_limits:
+  max_concurrent: 2
+  bytes_per_sec: 1000
+client_limit_overrides:
+  10.0.0.7:
+    max_concurrent: 10
+`)
+		cfg, _, err := Load(storageArgs(t, "-config", path))
+		require.NoError(t, err)
+		assert.Equal(t, ClientLimits{MaxConcurrent: 10, BytesPerSec: 1000, RequestBurst: 10}, cfg.ClientLimitOverrides["10.0.0.7"])
+	})
+
+	t.Run("env overrides", func(t *testing.T) {
+		t.Setenv("CLIENT_BYTES_PER_SEC", "500")
+		t.Setenv("CLIENT_LIMIT_OVERRIDES", "10.0.0.5=max_concurrent:20;10.0.0.7=requests_per_sec:100")
+
+		cfg, _, err := Load(storageArgs(t))
+		require.NoError(t, err)
+		assert.Equal(t, 20, cfg.ClientLimitOverrides["10.0.0.5"].MaxConcurrent)
+		assert.Equal(t, 500, cfg.ClientLimitOverrides["10.0.0.5"].BytesPerSec)
+		assert.Equal(t, 100.0, cfg.ClientLimitOverrides["10.0.0.7"].RequestsPerSec)
+	})
+
+	t.Run("lifecycle rules", func(t *testing.T) {
+		path := writeConfigFile(t, `
+lifecycle_rules:
+  - prefix: tmp-
+    not_accessed_for: 24h
+`)
+		cfg, _, err := Load(storageArgs(t, "-config", path))
+		require.NoError(t, err)
+		assert.Equal(t, []LifecycleRule{{Prefix: "tmp-", NotAccessedFor: 24 * time.Hour}}, cfg.LifecycleRules)
+
+		t.Setenv("LIFECYCLE_RULES", "scratch-=1h; =720h")
+		cfg, _, err = Load(storageArgs(t, "-config", path))
+		require.NoError(t, err)
+		assert.Equal(t, []LifecycleRule{
+			{Prefix: "scratch-", NotAccessedFor: time.Hour},
+			{Prefix: "", NotAccessedFor: 720 * time.Hour},
+		}, cfg.LifecycleRules)
+	})
+
+	t.Run("replication followers", func(t *testing.T) {
+		t.Setenv("ADMIN_TOKEN", "secret")
+		t.Setenv("REPLICATION_FOLLOWERS", "standby-1:50051, ,standby-2:50051")
+		cfg, _, err := Load(storageArgs(t))
+		require.NoError(t, err)
+		assert.Equal(t, []string{"standby-1:50051", "standby-2:50051"}, cfg.ReplicationFollowers)
+	})
+}
+
+// ---------------------------------------------------------------------
+// Load: ошибки
+// ---------------------------------------------------------------------
+func TestLoad_Errors(t *testing.T) {
+	t.Run("invalid int in env", func(t *testing.T) {
+		t.Setenv("UPLOAD_LIMIT", "ten")
+		_, _, err := Load(storageArgs(t))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "UPLOAD_LIMIT")
+	})
+
+	t.Run("invalid bool in env", func(t *testing.T) {
+		t.Setenv("VERSIONING", "maybe")
+		_, _, err := Load(storageArgs(t))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "VERSIONING")
+	})
+
+	t.Run("invalid flag value", func(t *testing.T) {
+		_, _, err := Load(storageArgs(t, "-shutdown-timeout", "soon"))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "-shutdown-timeout")
+	})
+
+	t.Run("invalid lifecycle rule", func(t *testing.T) {
+		_, _, err := Load(storageArgs(t, "-lifecycle-rules", "tmp-"))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "-lifecycle-rules")
+	})
+
+	t.Run("unknown key in file", func(t *testing.T) {
+		path := writeConfigFile(t, "upload_limt: 5\n")
+		_, _, err := Load(storageArgs(t, "-config", path))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "upload_limt")
+	})
+
+	t.Run("invalid override", func(t *testing.T) {
+		_, _, err := Load(storageArgs(t, "-client-limit-overrides", "alice=speed:1"))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "unknown field")
+	})
+}
+
+// ---------------------------------------------------------------------
+// Validate
+// ---------------------------------------------------------------------
+func TestConfig_Validate(t *testing.T) {
+	valid := func(t *testing.T) *Config {
+		cfg := Default()
+		cfg.StoragePath = t.TempDir()
+		return cfg
+	}
+
+	t.Run("defaults are valid", func(t *testing.T) {
+		assert.NoError(t, valid(t).Validate())
+	})
+
+	tests := []struct {
+		name   string
+		modify func(*Config)
+		want   string
+	}{
+		{"zero upload limit", func(c *Config) { c.UploadLimit = 0 }, "upload_limit"},
+		{"negative list limit", func(c *Config) { c.ListLimit = -1 }, "list_limit"},
+		{"bad admission mode", func(c *Config) { c.AdmissionMode = "queue" }, "admission_mode"},
+		{"malformed grpc port", func(c *Config) { c.GRPCPort = "50051" }, "grpc_port"},
+		{"grpc port out of range", func(c *Config) { c.GRPCPort = ":70000" }, "grpc_port"},
+		{"bad tracing exporter", func(c *Config) { c.TracingExporter = "jaeger" }, "tracing_exporter"},
+		{"negative version keep last", func(c *Config) { c.VersionKeepLast = -1 }, "version_keep_last"},
+		{"bad access time", func(c *Config) { c.AccessTime = "atime" }, "access_time"},
+		{"bad durability", func(c *Config) { c.Durability = "fsync" }, "durability"},
+		{"negative scrub interval", func(c *Config) { c.ScrubInterval = -time.Hour }, "scrub_interval"},
+		{"zero replication retry", func(c *Config) { c.ReplicationRetryInterval = 0 }, "replication_retry_interval"},
+		{"malformed follower", func(c *Config) { c.AdminToken = "t"; c.ReplicationFollowers = []string{"follower"} }, "replication_followers[0]"},
+		{"followers without admin token", func(c *Config) { c.ReplicationFollowers = []string{"follower:50051"} }, "requires admin_token"},
+		{"zero cluster replicas", func(c *Config) { c.ClusterReplicas = 0 }, "cluster_replicas"},
+		{"quorum above replicas", func(c *Config) { c.ClusterWriteQuorum = 3 }, "cluster_write_quorum"},
+		{"cluster self not a member", func(c *Config) {
+			c.AdminToken = "t"
+			c.ClusterNodes, c.ClusterSelf = []string{"a:50051", "b:50051"}, "c:50051"
+		}, "cluster_self"},
+		{"duplicate cluster node", func(c *Config) {
+			c.AdminToken = "t"
+			c.ClusterNodes, c.ClusterSelf = []string{"a:50051", "a:50051"}, "a:50051"
+		}, "duplicate node"},
+		{"cluster with followers", func(c *Config) {
+			c.AdminToken = "t"
+			c.ClusterNodes, c.ClusterSelf = []string{"a:50051"}, "a:50051"
+			c.ReplicationFollowers = []string{"b:50051"}
+		}, "mutually exclusive"},
+		{"zero extract entries", func(c *Config) { c.ExtractMaxEntries = 0 }, "extract_max_entries"},
+		{"zero lifecycle rule period", func(c *Config) { c.LifecycleRules = []LifecycleRule{{Prefix: "tmp-"}} }, "lifecycle_rules[0]"},
+		{"lifecycle rule without access time", func(c *Config) {
+			c.AccessTime = "off"
+			c.LifecycleRules = []LifecycleRule{{Prefix: "tmp-", NotAccessedFor: 720 * time.Hour}}
+		}, "requires access_time"},
+		{"negative client limit", func(c *Config) { c.ClientLimits.BytesPerSec = -1 }, "client_limits.bytes_per_sec"},
+		{"client limit override by name", func(c *Config) {
+			c.ClientLimitOverrides = map[string]ClientLimits{"backup": {}}
+		}, "must be an IP address"},
+		{"storage path is a file", func(c *Config) {
+			f := filepath.Join(c.StoragePath, "file")
+			os.WriteFile(f, []byte("x"), 0644)
+			c.StoragePath = f
+		}, "storage_path"},
+		{"storage path under a file", func(c *Config) {
+			f := filepath.Join(c.StoragePath, "file")
+			os.WriteFile(f, []byte("x"), 0644)
+			c.StoragePath = filepath.Join(f, "sub")
+		}, "storage_path"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := valid(t)
+			tt.modify(cfg)
+			err := cfg.Validate()
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.want)
+		})
+	}
+
+	t.Run("no side effects", func(t *testing.T) {
+		cfg := valid(t)
+		cfg.StoragePath = filepath.Join(cfg.StoragePath, "missing")
+		require.NoError(t, cfg.Validate())
+		assert.NoDirExists(t, cfg.StoragePath)
+
+		require.NoError(t, cfg.PrepareStorage())
+		assert.DirExists(t, cfg.StoragePath)
+		entries, err := os.ReadDir(cfg.StoragePath)
+		require.NoError(t, err)
+		assert.Empty(t, entries)
+	})
+
+	t.Run("reports all errors", func(t *testing.T) {
+		cfg := valid(t)
+		cfg.UploadLimit = 0
+		cfg.DownloadLimit = 0
+		err := cfg.Validate()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "upload_limit")
+		assert.Contains(t, err.Error(), "download_limit")
+	})
+}
+
+// ---------------------------------------------------------------------
+// Print
+// ---------------------------------------------------------------------
+func TestConfig_Print(t *testing.T) {
+	cfg := Default()
+	cfg.AdminToken = "secret"
+
+	var buf bytes.Buffer
+	require.NoError(t, cfg.Print(&buf))
+	out := buf.String()
+	assert.Contains(t, out, "upload_limit: 10")
+	assert.Contains(t, out, "shutdown_timeout: 30s")
+	assert.NotContains(t, out, "secret")
+}
@@ -0,0 +1,162 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+)
+
+// Validate проверяет значения конфига и возвращает все найденные ошибки.
+// Файловую систему не меняет: директорию хранилища готовит PrepareStorage.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.UploadLimit > 0, "upload_limit must be positive, got %d", c.UploadLimit)
+	check(c.DownloadLimit > 0, "download_limit must be positive, got %d", c.DownloadLimit)
+	check(c.ListLimit > 0, "list_limit must be positive, got %d", c.ListLimit)
+
+	check(c.AdmissionMode == "reject" || c.AdmissionMode == "wait", "admission_mode must be reject or wait, got %q", c.AdmissionMode)
+	check(c.AdmissionQueueLength >= 0, "admission_queue_length must not be negative, got %d", c.AdmissionQueueLength)
+	check(c.AdmissionMaxWait >= 0, "admission_max_wait must not be negative, got %s", c.AdmissionMaxWait)
+
+	checkLimits := func(name string, l ClientLimits) {
+		check(l.MaxConcurrent >= 0, "%s.max_concurrent must not be negative", name)
+		check(l.RequestsPerSec >= 0, "%s.requests_per_sec must not be negative", name)
+		check(l.RequestBurst >= 0, "%s.request_burst must not be negative", name)
+		check(l.BytesPerSec >= 0, "%s.bytes_per_sec must not be negative", name)
+	}
+	checkLimits("client_limits", c.ClientLimits)
+	for id, l := range c.ClientLimitOverrides {
+		checkLimits("client_limit_overrides."+id, l)
+		// Клиент определяется по адресу: заголовок client_id_header - только метка
+		check(net.ParseIP(id) != nil, "client_limit_overrides key %q must be an IP address", id)
+	}
+
+	switch c.TracingExporter {
+	case "none", "otlp", "file":
+	default:
+		check(false, "tracing_exporter must be none, otlp or file, got %q", c.TracingExporter)
+	}
+
+	check(c.HealthCheckInterval > 0, "health_check_interval must be positive, got %s", c.HealthCheckInterval)
+	check(c.MinFreeDiskMB >= 0, "min_free_disk_mb must not be negative, got %d", c.MinFreeDiskMB)
+	check(c.ShutdownTimeout >= 0, "shutdown_timeout must not be negative, got %s", c.ShutdownTimeout)
+
+	check(c.VersionKeepLast >= 0, "version_keep_last must not be negative, got %d", c.VersionKeepLast)
+	check(c.VersionMaxAge >= 0, "version_max_age must not be negative, got %s", c.VersionMaxAge)
+	check(c.VersionRetentionInterval > 0, "version_retention_interval must be positive, got %s", c.VersionRetentionInterval)
+
+	switch c.AccessTime {
+	case "off", "relatime", "strict":
+	default:
+		check(false, "access_time must be off, relatime or strict, got %q", c.AccessTime)
+	}
+	switch c.Durability {
+	case "none", "file", "full":
+	default:
+		check(false, "durability must be none, file or full, got %q", c.Durability)
+	}
+
+	check(c.LifecycleInterval > 0, "lifecycle_interval must be positive, got %s", c.LifecycleInterval)
+	for i, rule := range c.LifecycleRules {
+		check(rule.NotAccessedFor > 0, "lifecycle_rules[%d].not_accessed_for must be positive, got %s", i, rule.NotAccessedFor)
+		// Иначе правило удалило бы файлы, которые скачивают
+		check(c.AccessTime != "off", "lifecycle_rules[%d] requires access_time relatime or strict: with off downloads are not tracked", i)
+	}
+
+	check(c.ScrubInterval >= 0, "scrub_interval must not be negative, got %s", c.ScrubInterval)
+
+	check(c.ReplicationRetryInterval > 0, "replication_retry_interval must be positive, got %s", c.ReplicationRetryInterval)
+	for i, addr := range c.ReplicationFollowers {
+		if err := validateAddr(addr); err != nil {
+			errs = append(errs, fmt.Errorf("replication_followers[%d]: %w", i, err))
+		}
+	}
+	check(len(c.ReplicationFollowers) == 0 || c.AdminToken != "", "replication_followers requires admin_token: followers accept it for ReplicationService")
+
+	check(c.ClusterReplicas > 0, "cluster_replicas must be positive, got %d", c.ClusterReplicas)
+	check(c.ClusterWriteQuorum >= 0 && c.ClusterWriteQuorum <= c.ClusterReplicas, "cluster_write_quorum must be between 0 and cluster_replicas, got %d", c.ClusterWriteQuorum)
+	check(c.ClusterRebalanceInterval > 0, "cluster_rebalance_interval must be positive, got %s", c.ClusterRebalanceInterval)
+	if len(c.ClusterNodes) > 0 {
+		seen := make(map[string]bool, len(c.ClusterNodes))
+		for i, addr := range c.ClusterNodes {
+			if err := validateAddr(addr); err != nil {
+				errs = append(errs, fmt.Errorf("cluster_nodes[%d]: %w", i, err))
+			}
+			check(!seen[addr], "cluster_nodes: duplicate node %q", addr)
+			seen[addr] = true
+		}
+		check(seen[c.ClusterSelf], "cluster_self %q must be one of cluster_nodes", c.ClusterSelf)
+		check(c.AdminToken != "", "cluster_nodes requires admin_token: nodes accept it for ReplicationService")
+		check(len(c.ReplicationFollowers) == 0, "cluster_nodes and replication_followers are mutually exclusive")
+	}
+
+	check(c.ExtractMaxEntries > 0, "extract_max_entries must be positive, got %d", c.ExtractMaxEntries)
+	check(c.ExtractMaxSizeMB > 0, "extract_max_size_mb must be positive, got %d", c.ExtractMaxSizeMB)
+
+	if err := validateAddr(c.GRPCPort); err != nil {
+		errs = append(errs, fmt.Errorf("grpc_port: %w", err))
+	}
+	if err := validateAddr(c.AdminPort); err != nil {
+		errs = append(errs, fmt.Errorf("admin_port: %w", err))
+	}
+	if err := validateStoragePath(c.StoragePath); err != nil {
+		errs = append(errs, fmt.Errorf("storage_path: %w", err))
+	}
+
+	return errors.Join(errs...)
+}
+
+// validateAddr проверяет адрес вида host:port или :port
+func validateAddr(addr string) error {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("malformed address %q: %w", addr, err)
+	}
+	n, err := strconv.Atoi(port)
+	if err != nil || n < 0 || n > 65535 {
+		return fmt.Errorf("malformed address %q: invalid port", addr)
+	}
+	return nil
+}
+
+// validateStoragePath только читает: путь задан и, если существует, это директория
+func validateStoragePath(path string) error {
+	if path == "" {
+		return fmt.Errorf("must not be empty")
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%q is not a directory", path)
+	}
+	return nil
+}
+
+// PrepareStorage создаёт директорию хранилища, если её нет, и проверяет её
+// на запись. Вызывается один раз при старте сервера, не для -print-config
+// и не при перечитывании конфига.
+func (c *Config) PrepareStorage() error {
+	if err := os.MkdirAll(c.StoragePath, 0755); err != nil {
+		return fmt.Errorf("storage_path: cannot create directory: %w", err)
+	}
+	f, err := os.CreateTemp(c.StoragePath, ".tmp-writecheck-*")
+	if err != nil {
+		return fmt.Errorf("storage_path: directory is not writable: %w", err)
+	}
+	f.Close()
+	os.Remove(f.Name())
+	return nil
+}
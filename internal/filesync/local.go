@@ -0,0 +1,413 @@
+package filesync
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Hiddan13/file_grpc/api/proto/pb"
+
+	"github.com/fsnotify/fsnotify"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/time/rate"
+)
+
+// Временные файлы Pull до переименования; при обходе директории пропускаются
+const pullTempPrefix = ".filesync-"
+
+// Размер чанка Upload при Push
+const pushChunkSize = 64 * 1024
+
+// Сколько ждать после изменения в директории, прежде чем запустить Push:
+// изменения, пришедшие подряд, собираются в один проход
+const watchDelay = 500 * time.Millisecond
+
+// localFile - файл синхронизируемой директории
+type localFile struct {
+	size    int64
+	modTime time.Time
+}
+
+// Push доводит файлы сервера до локальной директории dir. Файл передаётся,
+// если его нет на сервере или отличается размер; если размер тот же, а файл
+// изменён не раньше, чем на сервере (или задан Checksum), сравнивается
+// sha256 с etag. Учитываются только обычные непустые файлы верхнего уровня dir:
+// имена на сервере не содержат '/'. С Delete удаляются файлы сервера,
+// которых нет в директории.
+func Push(ctx context.Context, client pb.FileServiceClient, dir string, opts Options) (res Result, err error) {
+	ctx, span := tracer.Start(ctx, "filesync.Push")
+	defer func() { endSpan(span, err) }()
+	span.SetAttributes(attribute.String("sync.dir", dir), attribute.Bool("sync.dry_run", opts.DryRun))
+
+	local, err := scanDir(dir, opts.Prefix)
+	if err != nil {
+		return res, err
+	}
+	remote, err := listFiles(ctx, client, opts.Prefix)
+	if err != nil {
+		return res, err
+	}
+	var actions []Action
+	for _, name := range slices.Sorted(maps.Keys(local)) {
+		res.Checked++
+		l, r := local[name], remote[name]
+		delete(remote, name)
+		a := Action{Kind: ActionCopy, Filename: name, Size: l.size, dst: r, path: filepath.Join(dir, name)}
+		if !pushChanged(a.path, l, r, opts.Checksum) {
+			res.Unchanged++
+			continue
+		}
+		actions = append(actions, a)
+	}
+	if opts.Delete {
+		for _, name := range slices.Sorted(maps.Keys(remote)) {
+			actions = append(actions, Action{Kind: ActionDelete, Filename: name, dst: remote[name]})
+		}
+	}
+
+	parallel := opts.Parallel
+	if parallel <= 0 {
+		parallel = freeSlots(ctx, client, (*pb.LimitsResponse).GetUpload)
+	}
+	span.SetAttributes(attribute.Int("sync.parallel", parallel))
+	limiter := newLimiter(opts.BytesPerSec)
+	err = execute(ctx, actions, opts, parallel, &res, func(ctx context.Context, a Action) error {
+		if a.Kind == ActionDelete {
+			_, err := client.Delete(ctx, &pb.DeleteRequest{Filename: a.Filename, IfMatch: a.dst.GetEtag()})
+			return err
+		}
+		return uploadFile(ctx, client, a, limiter)
+	})
+	return res, err
+}
+
+// pushChanged - локальный файл отличается от файла сервера r
+func pushChanged(path string, l localFile, r *pb.FileInfo, checksum bool) bool {
+	if r == nil || r.GetSize() != l.size {
+		return true
+	}
+	// На сервере время с точностью до секунды: изменение в ту же секунду
+	// проверяется по содержимому
+	if !checksum && l.modTime.Truncate(time.Second).Before(updatedAt(r)) {
+		return false
+	}
+	sum, err := fileSum(path)
+	// Не прочитался - ошибку покажет передача
+	return err != nil || sum != r.GetEtag()
+}
+
+// Pull доводит локальную директорию dir до файлов сервера. Файл скачивается,
+// если его нет в директории или отличается размер; если размер тот же, а
+// время изменения не совпадает с временем на сервере (или задан Checksum),
+// сравнивается sha256 с etag. Скачанному файлу ставится время изменения с
+// сервера, поэтому следующий Pull обходится без чтения содержимого. Файл
+// пишется во временный и переименовывается, только если его sha256 совпал с
+// etag. С Delete удаляются локальные файлы, которых нет на сервере.
+func Pull(ctx context.Context, client pb.FileServiceClient, dir string, opts Options) (res Result, err error) {
+	ctx, span := tracer.Start(ctx, "filesync.Pull")
+	defer func() { endSpan(span, err) }()
+	span.SetAttributes(attribute.String("sync.dir", dir), attribute.Bool("sync.dry_run", opts.DryRun))
+
+	local, err := scanDir(dir, opts.Prefix)
+	if err != nil {
+		return res, err
+	}
+	remote, err := listFiles(ctx, client, opts.Prefix)
+	if err != nil {
+		return res, err
+	}
+	// Имя приходит от сервера и станет путём в dir: не даём ему выйти наружу
+	for name := range remote {
+		if err := checkLocalName(name); err != nil {
+			return res, err
+		}
+	}
+	var actions []Action
+	for _, name := range slices.Sorted(maps.Keys(remote)) {
+		res.Checked++
+		r := remote[name]
+		l, ok := local[name]
+		delete(local, name)
+		a := Action{Kind: ActionCopy, Filename: name, Size: r.GetSize(), src: r, path: filepath.Join(dir, name)}
+		if ok && !pullChanged(a.path, l, r, opts.Checksum, opts.DryRun) {
+			res.Unchanged++
+			continue
+		}
+		actions = append(actions, a)
+	}
+	if opts.Delete {
+		for _, name := range slices.Sorted(maps.Keys(local)) {
+			actions = append(actions, Action{Kind: ActionDelete, Filename: name, path: filepath.Join(dir, name)})
+		}
+	}
+
+	parallel := opts.Parallel
+	if parallel <= 0 {
+		parallel = freeSlots(ctx, client, (*pb.LimitsResponse).GetDownload)
+	}
+	span.SetAttributes(attribute.Int("sync.parallel", parallel))
+	limiter := newLimiter(opts.BytesPerSec)
+	err = execute(ctx, actions, opts, parallel, &res, func(ctx context.Context, a Action) error {
+		if a.Kind == ActionDelete {
+			return os.Remove(a.path)
+		}
+		return downloadFile(ctx, client, a, limiter)
+	})
+	return res, err
+}
+
+// checkLocalName проверяет, что имя файла сервера - просто имя файла в
+// директории: без разделителей пути, ".." и не абсолютное
+func checkLocalName(name string) error {
+	if !filepath.IsLocal(name) || strings.ContainsAny(name, `/\`) || strings.Contains(name, "..") {
+		return fmt.Errorf("unsafe filename from server: %q", name)
+	}
+	return nil
+}
+
+// pullChanged - локальный файл отличается от файла сервера r. Если
+// отличалось только время изменения, оно исправляется (кроме dryRun).
+func pullChanged(path string, l localFile, r *pb.FileInfo, checksum, dryRun bool) bool {
+	if r.GetSize() != l.size {
+		return true
+	}
+	if !checksum && l.modTime.Equal(updatedAt(r)) {
+		return false
+	}
+	sum, err := fileSum(path)
+	if err != nil || sum != r.GetEtag() {
+		return true
+	}
+	if !dryRun {
+		os.Chtimes(path, time.Time{}, updatedAt(r))
+	}
+	return false
+}
+
+// Watch выполняет Push сразу и после каждого изменения в dir, которое
+// сообщает inotify. pass получает итог каждого прохода. Возвращает nil при
+// отмене ctx, иначе ошибку наблюдения за директорией.
+func Watch(ctx context.Context, client pb.FileServiceClient, dir string, opts Options, pass func(Result, error)) error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	if err := w.Add(dir); err != nil {
+		return fmt.Errorf("watch %s: %w", dir, err)
+	}
+
+	pass(Push(ctx, client, dir, opts))
+	var delay <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case ev, ok := <-w.Events:
+			if !ok {
+				return nil
+			}
+			// Смена прав не меняет содержимое
+			if ev.Op == fsnotify.Chmod || strings.HasPrefix(filepath.Base(ev.Name), pullTempPrefix) {
+				continue
+			}
+			delay = time.After(watchDelay)
+		case err, ok := <-w.Errors:
+			if !ok {
+				return nil
+			}
+			return fmt.Errorf("watch %s: %w", dir, err)
+		case <-delay:
+			delay = nil
+			pass(Push(ctx, client, dir, opts))
+		}
+	}
+}
+
+// scanDir - обычные непустые файлы верхнего уровня dir с префиксом prefix
+func scanDir(dir, prefix string) (map[string]localFile, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]localFile, len(entries))
+	for _, e := range entries {
+		name := e.Name()
+		if !e.Type().IsRegular() || !strings.HasPrefix(name, prefix) || strings.HasPrefix(name, pullTempPrefix) {
+			continue
+		}
+		info, err := e.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			// Удалён во время обхода
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		// Пустые файлы сервер не хранит
+		if info.Size() == 0 {
+			continue
+		}
+		files[name] = localFile{size: info.Size(), modTime: info.ModTime()}
+	}
+	return files, nil
+}
+
+func listFiles(ctx context.Context, client pb.FileServiceClient, prefix string) (map[string]*pb.FileInfo, error) {
+	resp, err := client.ListFiles(ctx, &pb.ListFilesRequest{Prefix: prefix})
+	if err != nil {
+		return nil, fmt.Errorf("list files: %w", err)
+	}
+	files := make(map[string]*pb.FileInfo, len(resp.GetFiles()))
+	for _, info := range resp.GetFiles() {
+		files[info.GetFilename()] = info
+	}
+	return files, nil
+}
+
+// freeSlots - свободные места в лимите сервера, не меньше одного. Сервер без
+// GetLimits - одно.
+func freeSlots(ctx context.Context, client pb.FileServiceClient, limit func(*pb.LimitsResponse) *pb.LimitStatus) int {
+	resp, err := client.GetLimits(ctx, &pb.Empty{})
+	if err != nil {
+		return 1
+	}
+	l := limit(resp)
+	return max(int(l.GetLimit()-l.GetInUse()), 1)
+}
+
+// uploadFile передаёт локальный файл на сервер, читая его по частям
+func uploadFile(ctx context.Context, client pb.FileServiceClient, a Action, limiter *rate.Limiter) error {
+	f, err := os.Open(a.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	up, err := client.Upload(ctx)
+	if err != nil {
+		return fmt.Errorf("upload: %w", err)
+	}
+	req := &pb.UploadRequest{Filename: a.Filename}
+	// Файл на сервере мог измениться после сравнения
+	if a.dst == nil {
+		req.IfNoneMatch = true
+	} else {
+		req.IfMatch = a.dst.GetEtag()
+	}
+	buf := make([]byte, pushChunkSize)
+	for {
+		n, err := f.Read(buf)
+		if n > 0 {
+			if err := wait(ctx, limiter, n); err != nil {
+				return err
+			}
+			req.Chunk = buf[:n]
+			if err := up.Send(req); err != nil {
+				// io.EOF - сервер завершил вызов, ошибку вернёт CloseAndRecv
+				if err == io.EOF {
+					break
+				}
+				return fmt.Errorf("upload: %w", err)
+			}
+			// Условия записи - только в первом сообщении
+			req = &pb.UploadRequest{}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if _, err := up.CloseAndRecv(); err != nil {
+		return fmt.Errorf("upload: %w", err)
+	}
+	return nil
+}
+
+// downloadFile скачивает файл во временный файл рядом и переименовывает его,
+// если sha256 совпал с etag из списка
+func downloadFile(ctx context.Context, client pb.FileServiceClient, a Action, limiter *rate.Limiter) (err error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	down, err := client.Download(ctx, &pb.DownloadRequest{Filename: a.Filename})
+	if err != nil {
+		return fmt.Errorf("download: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(a.path), pullTempPrefix+"*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+	h := sha256.New()
+	w := io.MultiWriter(tmp, h)
+	for {
+		resp, err := down.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("download: %w", err)
+		}
+		if err := wait(ctx, limiter, len(resp.GetChunk())); err != nil {
+			return err
+		}
+		if _, err := w.Write(resp.GetChunk()); err != nil {
+			return err
+		}
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != a.src.GetEtag() {
+		return fmt.Errorf("file changed during transfer: etag %s, listed %s", sum, a.src.GetEtag())
+	}
+	// CreateTemp создаёт файл только для владельца
+	if err := tmp.Chmod(0644); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chtimes(tmp.Name(), time.Time{}, updatedAt(a.src)); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), a.path)
+}
+
+func fileSum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func updatedAt(info *pb.FileInfo) time.Time {
+	t, _ := time.Parse(time.RFC3339, info.GetUpdatedAt())
+	return t
+}
@@ -0,0 +1,213 @@
+package filesync
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Hiddan13/file_grpc/api/proto/pb"
+	"github.com/Hiddan13/file_grpc/internal/repository"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+)
+
+func writeLocal(t *testing.T, dir, name, data string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, []byte(data), 0644))
+	return path
+}
+
+func readLocal(t *testing.T, dir, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	require.NoError(t, err)
+	return string(data)
+}
+
+// ---------------------------------------------------------------------
+// Push
+// ---------------------------------------------------------------------
+func TestPush(t *testing.T) {
+	ctx := context.Background()
+	svc, client := newTestServer(t)
+	dir := t.TempDir()
+	writeLocal(t, dir, "a.txt", "aaa")
+	writeLocal(t, dir, "b.txt", "new b")
+	writeLocal(t, dir, "empty.txt", "")
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "sub"), 0755))
+	writeLocal(t, filepath.Join(dir, "sub"), "c.txt", "c")
+	save(t, svc, "b.txt", "old", repository.SaveOptions{Tags: []string{"keep"}})
+	save(t, svc, "extra.txt", "extra", repository.SaveOptions{})
+
+	res, err := Push(ctx, client, dir, Options{Delete: true})
+	require.NoError(t, err)
+	assert.Equal(t, Result{Checked: 2, Copied: 2, Deleted: 1, Bytes: 8}, res)
+	assert.Equal(t, "aaa", content(t, svc, "a.txt"))
+	assert.Equal(t, "new b", content(t, svc, "b.txt"))
+	_, err = svc.GetFileInfo(ctx, "extra.txt")
+	assert.ErrorIs(t, err, repository.ErrNotFound)
+	meta, err := svc.GetFileInfo(ctx, "b.txt")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"keep"}, meta.Tags, "метаданные на сервере сохраняются")
+
+	// Повторный запуск ничего не передаёт
+	res, err = Push(ctx, client, dir, Options{Delete: true})
+	require.NoError(t, err)
+	assert.Equal(t, Result{Checked: 2, Unchanged: 2}, res)
+
+	t.Run("same size, newer mtime", func(t *testing.T) {
+		future := time.Now().Add(time.Hour)
+		path := writeLocal(t, dir, "a.txt", "bbb")
+		require.NoError(t, os.Chtimes(path, future, future))
+		// Содержимое b.txt то же, изменилось только время
+		require.NoError(t, os.Chtimes(filepath.Join(dir, "b.txt"), future, future))
+
+		res, err := Push(ctx, client, dir, Options{})
+		require.NoError(t, err)
+		assert.Equal(t, Result{Checked: 2, Unchanged: 1, Copied: 1, Bytes: 3}, res)
+		assert.Equal(t, "bbb", content(t, svc, "a.txt"))
+	})
+
+	t.Run("checksum", func(t *testing.T) {
+		past := time.Now().Add(-time.Hour)
+		path := writeLocal(t, dir, "a.txt", "ccc")
+		require.NoError(t, os.Chtimes(path, past, past))
+
+		res, err := Push(ctx, client, dir, Options{})
+		require.NoError(t, err)
+		assert.Equal(t, 2, res.Unchanged, "по размеру и времени изменение не видно")
+
+		res, err = Push(ctx, client, dir, Options{Checksum: true})
+		require.NoError(t, err)
+		assert.Equal(t, 1, res.Copied)
+		assert.Equal(t, "ccc", content(t, svc, "a.txt"))
+	})
+}
+
+// ---------------------------------------------------------------------
+// Pull
+// ---------------------------------------------------------------------
+func TestPull(t *testing.T) {
+	ctx := context.Background()
+	svc, client := newTestServer(t)
+	dir := t.TempDir()
+	save(t, svc, "x.txt", "xxx", repository.SaveOptions{})
+	save(t, svc, "y.txt", "new y", repository.SaveOptions{})
+	writeLocal(t, dir, "y.txt", "old")
+	writeLocal(t, dir, "z.txt", "extra")
+
+	res, err := Pull(ctx, client, dir, Options{Delete: true, Parallel: 2})
+	require.NoError(t, err)
+	assert.Equal(t, Result{Checked: 2, Copied: 2, Deleted: 1, Bytes: 8}, res)
+	assert.Equal(t, "xxx", readLocal(t, dir, "x.txt"))
+	assert.Equal(t, "new y", readLocal(t, dir, "y.txt"))
+	assert.NoFileExists(t, filepath.Join(dir, "z.txt"))
+
+	meta, err := svc.GetFileInfo(ctx, "x.txt")
+	require.NoError(t, err)
+	info, err := os.Stat(filepath.Join(dir, "x.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, meta.UpdatedAt.Truncate(time.Second), info.ModTime(), "время изменения с сервера")
+	assert.Equal(t, os.FileMode(0644), info.Mode().Perm())
+
+	res, err = Pull(ctx, client, dir, Options{Delete: true})
+	require.NoError(t, err)
+	assert.Equal(t, Result{Checked: 2, Unchanged: 2}, res)
+
+	t.Run("checksum", func(t *testing.T) {
+		// Локальная правка того же размера с прежним временем
+		path := filepath.Join(dir, "x.txt")
+		writeLocal(t, dir, "x.txt", "zzz")
+		require.NoError(t, os.Chtimes(path, info.ModTime(), info.ModTime()))
+
+		res, err := Pull(ctx, client, dir, Options{})
+		require.NoError(t, err)
+		assert.Equal(t, 2, res.Unchanged)
+
+		res, err = Pull(ctx, client, dir, Options{Checksum: true})
+		require.NoError(t, err)
+		assert.Equal(t, 1, res.Copied)
+		assert.Equal(t, "xxx", readLocal(t, dir, "x.txt"))
+	})
+
+	t.Run("dry run", func(t *testing.T) {
+		save(t, svc, "w.txt", "www", repository.SaveOptions{})
+		res, err := Pull(ctx, client, dir, Options{DryRun: true})
+		require.NoError(t, err)
+		assert.Equal(t, 1, res.Copied)
+		assert.NoFileExists(t, filepath.Join(dir, "w.txt"))
+	})
+}
+
+// listOnlyClient отдаёт заданный список файлов; остальные вызовы не ожидаются
+type listOnlyClient struct {
+	pb.FileServiceClient
+	files []*pb.FileInfo
+}
+
+func (c listOnlyClient) ListFiles(ctx context.Context, in *pb.ListFilesRequest, opts ...grpc.CallOption) (*pb.ListFilesResponse, error) {
+	return &pb.ListFilesResponse{Files: c.files}, nil
+}
+
+func TestPull_UnsafeNames(t *testing.T) {
+	for _, name := range []string{"../evil", "../../.bashrc", "sub/file", "/etc/passwd", `..\evil`, ".."} {
+		t.Run(name, func(t *testing.T) {
+			root := t.TempDir()
+			dir := filepath.Join(root, "dir")
+			require.NoError(t, os.Mkdir(dir, 0755))
+			client := listOnlyClient{files: []*pb.FileInfo{{Filename: "ok.txt", Size: 1}, {Filename: name, Size: 1}}}
+
+			_, err := Pull(context.Background(), client, dir, Options{Parallel: 1})
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "unsafe filename")
+			entries, err := os.ReadDir(root)
+			require.NoError(t, err)
+			assert.Len(t, entries, 1, "ничего не записано рядом с dir")
+		})
+	}
+}
+
+// ---------------------------------------------------------------------
+// Параллельность и наблюдение за директорией
+// ---------------------------------------------------------------------
+func TestFreeSlots(t *testing.T) {
+	_, client := newTestServer(t)
+	assert.Equal(t, 10, freeSlots(context.Background(), client, (*pb.LimitsResponse).GetUpload))
+}
+
+func TestWatch(t *testing.T) {
+	svc, client := newTestServer(t)
+	dir := t.TempDir()
+	writeLocal(t, dir, "first.txt", "1")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	passes := make(chan Result, 10)
+	done := make(chan error, 1)
+	go func() {
+		done <- Watch(ctx, client, dir, Options{Delete: true}, func(res Result, err error) {
+			assert.NoError(t, err)
+			passes <- res
+		})
+	}()
+
+	res := <-passes
+	assert.Equal(t, 1, res.Copied)
+	writeLocal(t, dir, "second.txt", "2")
+	require.NoError(t, os.Remove(filepath.Join(dir, "first.txt")))
+
+	select {
+	case res = <-passes:
+		assert.Equal(t, Result{Checked: 1, Copied: 1, Deleted: 1, Bytes: 1}, res)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no pass after directory change")
+	}
+	assert.Equal(t, "2", content(t, svc, "second.txt"))
+
+	cancel()
+	assert.NoError(t, <-done)
+}
@@ -0,0 +1,358 @@
+package filesync
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/Hiddan13/file_grpc/api/proto/pb"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelCodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var tracer = otel.Tracer("github.com/Hiddan13/file_grpc/internal/filesync")
+
+// Options - параметры синхронизации
+type Options struct {
+	// Только файлы, имя которых начинается с Prefix
+	Prefix string
+	// Только показать, что было бы сделано
+	DryRun bool
+	// Удалять на приёмнике файлы, которых нет в источнике
+	Delete bool
+	// Ограничение скорости передачи содержимого, 0 - без ограничения
+	BytesPerSec int
+	// Одновременных передач. 0 - для Sync одна, для Push и Pull - сколько
+	// свободно в лимите сервера
+	Parallel int
+	// Push и Pull: сверять sha256 содержимого, даже если размер и время
+	// изменения совпадают
+	Checksum bool
+	// Вызывается после каждого действия (при DryRun - вместо него)
+	OnAction func(Action)
+}
+
+// ActionKind - что делается с файлом на приёмнике
+type ActionKind string
+
+const (
+	// Передать содержимое: файла нет или он отличается
+	ActionCopy ActionKind = "copy"
+	// Содержимое то же, заменить метаданные и теги
+	ActionMetadata ActionKind = "metadata"
+	// Файла нет в источнике
+	ActionDelete ActionKind = "delete"
+)
+
+// Action - действие над одним файлом
+type Action struct {
+	Kind     ActionKind
+	Filename string
+	Size     int64 // байт содержимого для передачи, 0 - кроме copy
+	Err      error // nil - успешно или DryRun
+
+	src  *pb.FileInfo // файл на сервере-источнике
+	dst  *pb.FileInfo // файл на сервере-приёмнике, nil - его нет
+	path string       // Push и Pull: локальный файл
+}
+
+// Result - итог синхронизации
+type Result struct {
+	Checked   int   // файлов в источнике
+	Unchanged int   // совпали с приёмником
+	Copied    int   // переданы
+	Updated   int   // заменены только метаданные
+	Deleted   int   // удалены на приёмнике
+	Failed    int   // действий с ошибкой
+	Bytes     int64 // передано байт содержимого
+}
+
+// Sync доводит файлы приёмника dst до источника src. Файл передаётся, если
+// его нет на приёмнике или отличается etag либо заданный MIME тип; если
+// отличаются только метаданные и теги - они заменяются без передачи
+// содержимого. Запись условная (по etag приёмника), так что файл, изменённый
+// на приёмнике во время синхронизации, не затирается, а попадает в Failed.
+// Ошибка одного файла не прерывает синхронизацию; Sync возвращает ошибку,
+// только если не удалось получить списки файлов или ctx отменён.
+func Sync(ctx context.Context, src, dst pb.FileServiceClient, opts Options) (res Result, err error) {
+	ctx, span := tracer.Start(ctx, "filesync.Sync")
+	defer func() { endSpan(span, err) }()
+	span.SetAttributes(attribute.String("sync.prefix", opts.Prefix), attribute.Bool("sync.dry_run", opts.DryRun))
+
+	actions, res, err := plan(ctx, src, dst, opts)
+	if err != nil {
+		return res, err
+	}
+	limiter := newLimiter(opts.BytesPerSec)
+	err = execute(ctx, actions, opts, max(opts.Parallel, 1), &res, func(ctx context.Context, a Action) error {
+		return apply(ctx, src, dst, a, limiter)
+	})
+	if err != nil {
+		return res, err
+	}
+	span.SetAttributes(
+		attribute.Int("files.copied", res.Copied),
+		attribute.Int("files.deleted", res.Deleted),
+		attribute.Int("files.failed", res.Failed),
+		attribute.Int64("sync.bytes", res.Bytes),
+	)
+	return res, nil
+}
+
+// plan сравнивает списки файлов источника и приёмника
+func plan(ctx context.Context, src, dst pb.FileServiceClient, opts Options) ([]Action, Result, error) {
+	var res Result
+	srcList, err := src.ListFiles(ctx, &pb.ListFilesRequest{Prefix: opts.Prefix})
+	if err != nil {
+		return nil, res, fmt.Errorf("list source: %w", err)
+	}
+	dstList, err := dst.ListFiles(ctx, &pb.ListFilesRequest{Prefix: opts.Prefix})
+	if err != nil {
+		return nil, res, fmt.Errorf("list destination: %w", err)
+	}
+	dstFiles := make(map[string]*pb.FileInfo, len(dstList.GetFiles()))
+	for _, info := range dstList.GetFiles() {
+		dstFiles[info.GetFilename()] = info
+	}
+
+	var actions []Action
+	for _, s := range srcList.GetFiles() {
+		res.Checked++
+		d := dstFiles[s.GetFilename()]
+		delete(dstFiles, s.GetFilename())
+		a := Action{Filename: s.GetFilename(), src: s, dst: d}
+		switch {
+		// Пустой MIME тип источника (файлы старых версий) приёмник определит сам
+		case d == nil || d.GetEtag() != s.GetEtag() || s.GetContentType() != "" && d.GetContentType() != s.GetContentType():
+			a.Kind, a.Size = ActionCopy, s.GetSize()
+		case !sameMetadata(s, d):
+			a.Kind = ActionMetadata
+		default:
+			res.Unchanged++
+			continue
+		}
+		actions = append(actions, a)
+	}
+	if opts.Delete {
+		for _, name := range slices.Sorted(maps.Keys(dstFiles)) {
+			actions = append(actions, Action{Kind: ActionDelete, Filename: name, dst: dstFiles[name]})
+		}
+	}
+	return actions, res, nil
+}
+
+// execute выполняет действия в parallel потоков и подводит итог в res.
+// OnAction вызывается по одному, без гонок.
+func execute(ctx context.Context, actions []Action, opts Options, parallel int, res *Result, apply func(context.Context, Action) error) error {
+	var mu sync.Mutex
+	jobs := make(chan Action)
+	var wg sync.WaitGroup
+	for range parallel {
+		wg.Go(func() {
+			for a := range jobs {
+				if !opts.DryRun {
+					a.Err = retry(ctx, func() error { return apply(ctx, a) })
+				}
+				mu.Lock()
+				res.add(a)
+				if opts.OnAction != nil {
+					opts.OnAction(a)
+				}
+				mu.Unlock()
+			}
+		})
+	}
+send:
+	for _, a := range actions {
+		select {
+		case jobs <- a:
+		case <-ctx.Done():
+			break send
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	return ctx.Err()
+}
+
+// add учитывает завершённое действие
+func (r *Result) add(a Action) {
+	switch {
+	case a.Err != nil:
+		r.Failed++
+	case a.Kind == ActionCopy:
+		r.Copied++
+		r.Bytes += a.Size
+	case a.Kind == ActionMetadata:
+		r.Updated++
+	case a.Kind == ActionDelete:
+		r.Deleted++
+	}
+}
+
+// Повторы при RESOURCE_EXHAUSTED: сервер занят или исчерпан лимит клиента
+const (
+	retryAttempts = 5
+	retryDelay    = 200 * time.Millisecond
+)
+
+// retry повторяет fn, пока сервер отвечает RESOURCE_EXHAUSTED, с
+// удваивающейся паузой
+func retry(ctx context.Context, fn func() error) error {
+	delay := retryDelay
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if status.Code(err) != codes.ResourceExhausted || attempt == retryAttempts {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+func sameMetadata(a, b *pb.FileInfo) bool {
+	return maps.Equal(a.GetMetadata(), b.GetMetadata()) && slices.Equal(a.GetTags(), b.GetTags())
+}
+
+// apply выполняет действие на приёмнике
+func apply(ctx context.Context, src, dst pb.FileServiceClient, a Action, limiter *rate.Limiter) error {
+	switch a.Kind {
+	case ActionCopy:
+		if err := copyFile(ctx, src, dst, a, limiter); err != nil {
+			return err
+		}
+		// Upload без метаданных оставляет прежние метаданные приёмника
+		if a.dst == nil || sameMetadata(a.src, a.dst) {
+			return nil
+		}
+		fallthrough
+	case ActionMetadata:
+		_, err := dst.SetMetadata(ctx, &pb.SetMetadataRequest{
+			Filename: a.Filename,
+			Metadata: a.src.GetMetadata(),
+			Tags:     a.src.GetTags(),
+		})
+		return err
+	case ActionDelete:
+		_, err := dst.Delete(ctx, &pb.DeleteRequest{Filename: a.Filename, IfMatch: a.dst.GetEtag()})
+		return err
+	}
+	return fmt.Errorf("unknown action %q", a.Kind)
+}
+
+// copyFile передаёт содержимое стримом из Download в Upload, не собирая
+// файл в памяти
+func copyFile(ctx context.Context, src, dst pb.FileServiceClient, a Action, limiter *rate.Limiter) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	down, err := src.Download(ctx, &pb.DownloadRequest{Filename: a.Filename})
+	if err != nil {
+		return fmt.Errorf("download: %w", err)
+	}
+	up, err := dst.Upload(ctx)
+	if err != nil {
+		return fmt.Errorf("upload: %w", err)
+	}
+	req := &pb.UploadRequest{
+		Filename:    a.Filename,
+		Metadata:    a.src.GetMetadata(),
+		Tags:        a.src.GetTags(),
+		ContentType: a.src.GetContentType(),
+		TtlSeconds:  ttlSeconds(a.src),
+	}
+	// Приёмник мог измениться после сравнения списков
+	if a.dst == nil {
+		req.IfNoneMatch = true
+	} else {
+		req.IfMatch = a.dst.GetEtag()
+	}
+	for {
+		resp, err := down.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("download: %w", err)
+		}
+		if err := wait(ctx, limiter, len(resp.GetChunk())); err != nil {
+			return err
+		}
+		req.Chunk = resp.GetChunk()
+		if err := up.Send(req); err != nil {
+			// io.EOF - приёмник завершил вызов, ошибку вернёт CloseAndRecv
+			if err == io.EOF {
+				break
+			}
+			return fmt.Errorf("upload: %w", err)
+		}
+		// Параметры записи - только в первом сообщении
+		req = &pb.UploadRequest{}
+	}
+	resp, err := up.CloseAndRecv()
+	if err != nil {
+		return fmt.Errorf("upload: %w", err)
+	}
+	if resp.GetEtag() != a.src.GetEtag() {
+		return fmt.Errorf("source changed during transfer: etag %s, listed %s", resp.GetEtag(), a.src.GetEtag())
+	}
+	return nil
+}
+
+// ttlSeconds - оставшийся срок жизни файла источника, 0 - бессрочно
+func ttlSeconds(info *pb.FileInfo) int64 {
+	if info.GetExpiresAt() == "" {
+		return 0
+	}
+	expires, err := time.Parse(time.RFC3339, info.GetExpiresAt())
+	if err != nil {
+		return 0
+	}
+	// Уже истёкший файл удалит очистка приёмника
+	return max(int64(time.Until(expires).Seconds()), 1)
+}
+
+// newLimiter - ограничение скорости, nil - без ограничения
+func newLimiter(bytesPerSec int) *rate.Limiter {
+	if bytesPerSec <= 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Limit(bytesPerSec), bytesPerSec)
+}
+
+// wait ждёт, пока ограничение скорости пропустит n байт
+func wait(ctx context.Context, limiter *rate.Limiter, n int) error {
+	if limiter == nil {
+		return nil
+	}
+	for n > 0 {
+		k := min(n, limiter.Burst())
+		if err := limiter.WaitN(ctx, k); err != nil {
+			return err
+		}
+		n -= k
+	}
+	return nil
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(otelCodes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,158 @@
+package filesync
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Hiddan13/file_grpc/api/proto/pb"
+	"github.com/Hiddan13/file_grpc/internal/repository"
+	"github.com/Hiddan13/file_grpc/internal/service"
+	grpcTransport "github.com/Hiddan13/file_grpc/internal/transport/grpc"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// ---------------------------------------------------------------------
+// Вспомогательные функции
+// ---------------------------------------------------------------------
+
+// newTestServer поднимает FileService в памяти и вернёт сервис и клиента
+func newTestServer(t *testing.T) (*service.FileService, pb.FileServiceClient) {
+	t.Helper()
+	repo, err := repository.NewFilesRepository(t.TempDir(), repository.Options{})
+	require.NoError(t, err)
+	svc := service.NewFileService(repo)
+
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	pb.RegisterFileServiceServer(srv, grpcTransport.NewFileServer(svc, 10, 10, 10, grpcTransport.Admission{}))
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return svc, pb.NewFileServiceClient(conn)
+}
+
+func save(t *testing.T, svc *service.FileService, name, data string, opts repository.SaveOptions) {
+	t.Helper()
+	_, err := svc.SaveFile(context.Background(), name, []byte(data), opts)
+	require.NoError(t, err)
+}
+
+func content(t *testing.T, svc *service.FileService, name string) string {
+	t.Helper()
+	data, err := svc.GetFile(context.Background(), name)
+	require.NoError(t, err)
+	return string(data)
+}
+
+// ---------------------------------------------------------------------
+// Sync
+// ---------------------------------------------------------------------
+func TestSync(t *testing.T) {
+	ctx := context.Background()
+	srcSvc, src := newTestServer(t)
+	dstSvc, dst := newTestServer(t)
+
+	save(t, srcSvc, "new.txt", "new", repository.SaveOptions{Metadata: map[string]string{"owner": "ann"}, Tags: []string{"a"}})
+	save(t, srcSvc, "changed.txt", "v2", repository.SaveOptions{})
+	save(t, srcSvc, "same.txt", "same", repository.SaveOptions{})
+	save(t, srcSvc, "meta.txt", "meta", repository.SaveOptions{Tags: []string{"b"}})
+	save(t, dstSvc, "changed.txt", "v1", repository.SaveOptions{Metadata: map[string]string{"stale": "1"}})
+	save(t, dstSvc, "same.txt", "same", repository.SaveOptions{})
+	save(t, dstSvc, "meta.txt", "meta", repository.SaveOptions{})
+	save(t, dstSvc, "extra.txt", "extra", repository.SaveOptions{})
+
+	var actions []Action
+	res, err := Sync(ctx, src, dst, Options{OnAction: func(a Action) { actions = append(actions, a) }})
+	require.NoError(t, err)
+	assert.Equal(t, Result{Checked: 4, Unchanged: 1, Copied: 2, Updated: 1, Bytes: 5}, res)
+	assert.Len(t, actions, 3)
+	for _, a := range actions {
+		assert.NoError(t, a.Err, a.Filename)
+	}
+
+	assert.Equal(t, "new", content(t, dstSvc, "new.txt"))
+	assert.Equal(t, "v2", content(t, dstSvc, "changed.txt"))
+	assert.Equal(t, "extra", content(t, dstSvc, "extra.txt"), "без Delete лишние файлы остаются")
+
+	meta, err := dstSvc.GetFileInfo(ctx, "new.txt")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"owner": "ann"}, meta.Metadata)
+	assert.Equal(t, []string{"a"}, meta.Tags)
+	meta, err = dstSvc.GetFileInfo(ctx, "changed.txt")
+	require.NoError(t, err)
+	assert.Empty(t, meta.Metadata, "метаданные приёмника заменены метаданными источника")
+	meta, err = dstSvc.GetFileInfo(ctx, "meta.txt")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b"}, meta.Tags)
+
+	// Повторный запуск ничего не передаёт
+	res, err = Sync(ctx, src, dst, Options{})
+	require.NoError(t, err)
+	assert.Equal(t, Result{Checked: 4, Unchanged: 4}, res)
+}
+
+func TestSync_Delete(t *testing.T) {
+	ctx := context.Background()
+	srcSvc, src := newTestServer(t)
+	dstSvc, dst := newTestServer(t)
+	save(t, srcSvc, "keep.txt", "keep", repository.SaveOptions{})
+	save(t, dstSvc, "keep.txt", "keep", repository.SaveOptions{})
+	save(t, dstSvc, "extra.txt", "extra", repository.SaveOptions{})
+	save(t, dstSvc, "other-extra.txt", "extra", repository.SaveOptions{})
+
+	res, err := Sync(ctx, src, dst, Options{Delete: true, Prefix: "extra"})
+	require.NoError(t, err)
+	assert.Equal(t, 1, res.Deleted, "только файлы с префиксом")
+
+	_, err = dstSvc.GetFileInfo(ctx, "extra.txt")
+	assert.ErrorIs(t, err, repository.ErrNotFound)
+	assert.Equal(t, "keep", content(t, dstSvc, "keep.txt"))
+	assert.Equal(t, "extra", content(t, dstSvc, "other-extra.txt"))
+}
+
+func TestSync_DryRun(t *testing.T) {
+	ctx := context.Background()
+	srcSvc, src := newTestServer(t)
+	dstSvc, dst := newTestServer(t)
+	save(t, srcSvc, "new.txt", "new", repository.SaveOptions{})
+	save(t, dstSvc, "extra.txt", "extra", repository.SaveOptions{})
+
+	var kinds []ActionKind
+	res, err := Sync(ctx, src, dst, Options{DryRun: true, Delete: true, OnAction: func(a Action) { kinds = append(kinds, a.Kind) }})
+	require.NoError(t, err)
+	assert.Equal(t, []ActionKind{ActionCopy, ActionDelete}, kinds)
+	assert.Equal(t, Result{Checked: 1, Copied: 1, Deleted: 1, Bytes: 3}, res)
+
+	_, err = dstSvc.GetFileInfo(ctx, "new.txt")
+	assert.ErrorIs(t, err, repository.ErrNotFound)
+	assert.Equal(t, "extra", content(t, dstSvc, "extra.txt"))
+}
+
+func TestSync_BytesPerSec(t *testing.T) {
+	srcSvc, src := newTestServer(t)
+	dstSvc, dst := newTestServer(t)
+	data := bytes.Repeat([]byte("x"), 20<<10)
+	save(t, srcSvc, "big.bin", string(data), repository.SaveOptions{})
+
+	start := time.Now()
+	res, err := Sync(context.Background(), src, dst, Options{BytesPerSec: 10 << 10})
+	require.NoError(t, err)
+	assert.Equal(t, 1, res.Copied)
+	// Первые 10KB - сразу, остальные - через секунду
+	assert.GreaterOrEqual(t, time.Since(start), 900*time.Millisecond)
+	assert.Equal(t, string(data), content(t, dstSvc, "big.bin"))
+}
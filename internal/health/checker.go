@@ -0,0 +1,147 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// Checker проверяет, что хранилище доступно на запись и на диске есть место
+// и транслирует результат в grpc.health.v1 и HTTP пробы.
+type Checker struct {
+	storagePath  string
+	minFreeBytes uint64
+	server       *health.Server
+	services     []string
+
+	mu       sync.RWMutex
+	lastErr  error
+	checked  bool
+	shutdown bool
+}
+
+func NewChecker(storagePath string, minFreeMB int, server *health.Server, services ...string) *Checker {
+	return &Checker{
+		storagePath:  storagePath,
+		minFreeBytes: uint64(minFreeMB) * 1024 * 1024,
+		server:       server,
+		// "" - общий статус сервера
+		services: append([]string{""}, services...),
+	}
+}
+
+// Check выполняет одну проверку и обновляет статусы
+func (c *Checker) Check() error {
+	err := c.probe()
+
+	c.mu.Lock()
+	changed := !c.checked || (err == nil) != (c.lastErr == nil)
+	c.lastErr = err
+	c.checked = true
+	c.mu.Unlock()
+
+	st := healthpb.HealthCheckResponse_SERVING
+	if err != nil {
+		st = healthpb.HealthCheckResponse_NOT_SERVING
+	}
+	for _, svc := range c.services {
+		c.server.SetServingStatus(svc, st)
+	}
+	if changed {
+		if err != nil {
+			log.Printf("[HEALTH] NOT_SERVING: %v", err)
+		} else {
+			log.Printf("[HEALTH] SERVING")
+		}
+	}
+	return err
+}
+
+// Run периодически проверяет хранилище до отмены контекста
+func (c *Checker) Run(ctx context.Context, interval time.Duration) {
+	c.Check()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.Check()
+		}
+	}
+}
+
+// Shutdown переводит все сервисы в NOT_SERVING навсегда, чтобы балансировщик
+// перестал направлять новые запросы на время остановки
+func (c *Checker) Shutdown() {
+	c.mu.Lock()
+	c.shutdown = true
+	c.mu.Unlock()
+	c.server.Shutdown()
+	log.Printf("[HEALTH] NOT_SERVING: shutting down")
+}
+
+// Ready вернёт ошибку последней проверки
+func (c *Checker) Ready() error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.shutdown {
+		return fmt.Errorf("shutting down")
+	}
+	if !c.checked {
+		return fmt.Errorf("not checked yet")
+	}
+	return c.lastErr
+}
+
+// Handler отдаёт /healthz (процесс жив) и /readyz (хранилище готово)
+func (c *Checker) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if err := c.Ready(); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintln(w, "ok")
+	})
+	return mux
+}
+
+func (c *Checker) probe() error {
+	f, err := os.CreateTemp(c.storagePath, ".tmp-healthcheck-*")
+	if err != nil {
+		return fmt.Errorf("storage not writable: %w", err)
+	}
+	name := f.Name()
+	_, werr := f.Write([]byte("ok"))
+	cerr := f.Close()
+	os.Remove(name)
+	if werr != nil {
+		return fmt.Errorf("storage not writable: %w", werr)
+	}
+	if cerr != nil {
+		return fmt.Errorf("storage not writable: %w", cerr)
+	}
+
+	free, err := freeSpace(c.storagePath)
+	if err != nil {
+		return fmt.Errorf("failed to stat disk: %w", err)
+	}
+	if free < c.minFreeBytes {
+		return fmt.Errorf("disk nearly full: %d bytes free, need %d", free, c.minFreeBytes)
+	}
+	return nil
+}
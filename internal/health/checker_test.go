@@ -0,0 +1,87 @@
+package health
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func servingStatus(t *testing.T, s *health.Server, service string) healthpb.HealthCheckResponse_ServingStatus {
+	t.Helper()
+	resp, err := s.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+	require.NoError(t, err)
+	return resp.Status
+}
+
+// ---------------------------------------------------------------------
+// Check
+// ---------------------------------------------------------------------
+func TestChecker_Check(t *testing.T) {
+	t.Run("writable storage is serving", func(t *testing.T) {
+		srv := health.NewServer()
+		c := NewChecker(t.TempDir(), 0, srv, "file.FileService")
+
+		require.NoError(t, c.Check())
+		assert.Equal(t, healthpb.HealthCheckResponse_SERVING, servingStatus(t, srv, ""))
+		assert.Equal(t, healthpb.HealthCheckResponse_SERVING, servingStatus(t, srv, "file.FileService"))
+	})
+
+	t.Run("missing storage is not serving", func(t *testing.T) {
+		srv := health.NewServer()
+		c := NewChecker(filepath.Join(t.TempDir(), "missing"), 0, srv, "file.FileService")
+
+		err := c.Check()
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "storage not writable")
+		assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, servingStatus(t, srv, "file.FileService"))
+	})
+
+	t.Run("disk nearly full", func(t *testing.T) {
+		srv := health.NewServer()
+		// Требуем заведомо больше, чем есть на любом диске
+		c := NewChecker(t.TempDir(), 1<<40, srv)
+
+		err := c.Check()
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "disk nearly full")
+		assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, servingStatus(t, srv, ""))
+	})
+}
+
+// ---------------------------------------------------------------------
+// HTTP пробы
+// ---------------------------------------------------------------------
+func TestChecker_Handler(t *testing.T) {
+	get := func(h http.Handler, path string) int {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Code
+	}
+
+	t.Run("ready after successful check", func(t *testing.T) {
+		c := NewChecker(t.TempDir(), 0, health.NewServer())
+		h := c.Handler()
+
+		assert.Equal(t, http.StatusOK, get(h, "/healthz"))
+		assert.Equal(t, http.StatusServiceUnavailable, get(h, "/readyz")) // ещё не проверяли
+
+		require.NoError(t, c.Check())
+		assert.Equal(t, http.StatusOK, get(h, "/readyz"))
+	})
+
+	t.Run("not ready on failed check", func(t *testing.T) {
+		c := NewChecker(filepath.Join(t.TempDir(), "missing"), 0, health.NewServer())
+		h := c.Handler()
+		c.Check()
+
+		assert.Equal(t, http.StatusOK, get(h, "/healthz"))
+		assert.Equal(t, http.StatusServiceUnavailable, get(h, "/readyz"))
+	})
+}
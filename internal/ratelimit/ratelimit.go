@@ -0,0 +1,292 @@
+package ratelimit
+
+import (
+	"context"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Hiddan13/file_grpc/internal/config"
+
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// Сколько держим состояние клиента без запросов
+	idleTTL       = 10 * time.Minute
+	sweepInterval = time.Minute
+)
+
+// Ключ metadata запроса, пересланного узлом кластера: клиент, от которого
+// узел принял исходный запрос
+const ForwardedClientKey = "x-forwarded-client"
+
+// Manager хранит лимиты и состояние по каждому клиенту
+type Manager struct {
+	idHeader  string
+	defaults  config.ClientLimits
+	overrides map[string]config.ClientLimits
+	// Запрос переслан узлом кластера; nil - узлов нет
+	trusted func(context.Context) bool
+
+	mu        sync.Mutex
+	clients   map[string]*client
+	lastSweep time.Time
+}
+
+type client struct {
+	id string
+
+	// Меняются целиком при SetLimits; nil - без ограничения
+	requests atomic.Pointer[rate.Limiter]
+	upload   atomic.Pointer[rate.Limiter]
+	download atomic.Pointer[rate.Limiter]
+
+	// под Manager.mu
+	limits   config.ClientLimits
+	active   int
+	lastSeen time.Time
+}
+
+func NewManager(idHeader string, defaults config.ClientLimits, overrides map[string]config.ClientLimits) *Manager {
+	return &Manager{
+		idHeader:  strings.ToLower(idHeader),
+		defaults:  defaults,
+		overrides: overrides,
+		clients:   make(map[string]*client),
+	}
+}
+
+// TrustForwarded задаёт проверку, что запрос переслан узлом кластера. Для
+// такого запроса клиент берётся из ForwardedClientKey, а частота запросов и
+// одновременные передачи не проверяются повторно - их учёл узел, принявший
+// запрос от клиента. Вызывается до приёма запросов.
+func (m *Manager) TrustForwarded(trusted func(context.Context) bool) {
+	m.trusted = trusted
+}
+
+func (m *Manager) forwarded(ctx context.Context) bool {
+	return m.trusted != nil && len(metadata.ValueFromIncomingContext(ctx, ForwardedClientKey)) > 0 && m.trusted(ctx)
+}
+
+// SetLimits заменяет лимиты по умолчанию и переопределения (SIGHUP).
+// Уже известные клиенты получают новые лимиты сразу, не теряя счётчик
+// одновременных передач.
+func (m *Manager) SetLimits(defaults config.ClientLimits, overrides map[string]config.ClientLimits) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.defaults, m.overrides = defaults, overrides
+	for id, c := range m.clients {
+		c.setLimits(m.limitsFor(id))
+	}
+}
+
+// limitsFor вызывается под m.mu
+func (m *Manager) limitsFor(id string) config.ClientLimits {
+	if limits, ok := m.overrides[id]; ok {
+		return limits
+	}
+	return m.defaults
+}
+
+// Identity определяет клиента, по которому считаются лимиты и ищутся
+// переопределения: ForwardedClientKey запроса от узла кластера, иначе IP
+// адрес пира. Заголовок idHeader клиент выбирает сам, поэтому он только
+// метка в логе (см. label): иначе, меняя его, клиент получал бы новые
+// лимиты или присваивал себе чужие переопределения.
+func (m *Manager) Identity(ctx context.Context) string {
+	if m.forwarded(ctx) {
+		return metadata.ValueFromIncomingContext(ctx, ForwardedClientKey)[0]
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		addr := p.Addr.String()
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			return host
+		}
+		return addr
+	}
+	return "unknown"
+}
+
+// label - метка клиента из заголовка idHeader для лога, "" - её нет
+func (m *Manager) label(ctx context.Context) string {
+	if m.idHeader == "" {
+		return ""
+	}
+	if vals := metadata.ValueFromIncomingContext(ctx, m.idHeader); len(vals) > 0 && vals[0] != "" {
+		return " (" + vals[0] + ")"
+	}
+	return ""
+}
+
+func (m *Manager) client(id string) *client {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(m.lastSweep) > sweepInterval {
+		for key, c := range m.clients {
+			if c.active == 0 && now.Sub(c.lastSeen) > idleTTL {
+				delete(m.clients, key)
+			}
+		}
+		m.lastSweep = now
+	}
+
+	c, ok := m.clients[id]
+	if !ok {
+		c = &client{id: id}
+		c.setLimits(m.limitsFor(id))
+		m.clients[id] = c
+	}
+	c.lastSeen = now
+	return c
+}
+
+// setLimits вызывается под Manager.mu. Лимитеры с прежними значениями не
+// пересоздаются, чтобы перечитывание конфига не обнуляло их состояние.
+func (c *client) setLimits(limits config.ClientLimits) {
+	if c.limits == limits {
+		return
+	}
+	c.limits = limits
+	c.requests.Store(newLimiter(limits.RequestsPerSec, limits.RequestBurst))
+	c.upload.Store(newLimiter(float64(limits.BytesPerSec), limits.BytesPerSec))
+	c.download.Store(newLimiter(float64(limits.BytesPerSec), limits.BytesPerSec))
+}
+
+func newLimiter(perSec float64, burst int) *rate.Limiter {
+	if perSec <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return rate.NewLimiter(rate.Limit(perSec), burst)
+}
+
+// begin проверяет частоту запросов и число одновременных передач клиента;
+// label дописывается к клиенту в логе
+func (m *Manager) begin(c *client, label string, transfer bool) error {
+	if l := c.requests.Load(); l != nil && !l.Allow() {
+		log.Printf("[RATELIMIT] ОТКАЗ: клиент %s%s превысил частоту запросов (%.1f/с)", c.id, label, float64(l.Limit()))
+		return status.Error(codes.ResourceExhausted, "client request rate exceeded")
+	}
+	if !transfer {
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if c.limits.MaxConcurrent > 0 && c.active >= c.limits.MaxConcurrent {
+		log.Printf("[RATELIMIT] ОТКАЗ: клиент %s%s превысил лимит одновременных передач (%d)", c.id, label, c.limits.MaxConcurrent)
+		return status.Error(codes.ResourceExhausted, "client concurrency limit exceeded")
+	}
+	c.active++
+	return nil
+}
+
+func (m *Manager) end(c *client) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c.active--
+	c.lastSeen = time.Now()
+}
+
+// Ограничиваем только FileService, health и прочие сервисы пропускаем
+func limited(fullMethod string) bool {
+	return strings.HasPrefix(fullMethod, "/file.FileService/")
+}
+
+func (m *Manager) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if !limited(info.FullMethod) {
+			return handler(ctx, req)
+		}
+		c := m.client(m.Identity(ctx))
+		if !m.forwarded(ctx) {
+			if err := m.begin(c, m.label(ctx), false); err != nil {
+				return nil, err
+			}
+		}
+		return handler(withClient(ctx, c), req)
+	}
+}
+
+// StreamServerInterceptor ограничивает стримы (Upload/Download); скорость
+// передачи ограничивается в циклах по чанкам через WaitUpload/WaitDownload
+func (m *Manager) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if !limited(info.FullMethod) {
+			return handler(srv, ss)
+		}
+		c := m.client(m.Identity(ss.Context()))
+		if !m.forwarded(ss.Context()) {
+			if err := m.begin(c, m.label(ss.Context()), true); err != nil {
+				return err
+			}
+			defer m.end(c)
+		}
+		return handler(srv, &serverStream{ServerStream: ss, ctx: withClient(ss.Context(), c)})
+	}
+}
+
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+type clientKey struct{}
+
+func withClient(ctx context.Context, c *client) context.Context {
+	return context.WithValue(ctx, clientKey{}, c)
+}
+
+// WaitUpload ждёт, пока клиенту можно принять ещё n байт
+func WaitUpload(ctx context.Context, n int) error {
+	c, ok := ctx.Value(clientKey{}).(*client)
+	if !ok {
+		return nil
+	}
+	return waitN(ctx, c.upload.Load(), n)
+}
+
+// WaitDownload ждёт, пока клиенту можно отправить ещё n байт
+func WaitDownload(ctx context.Context, n int) error {
+	c, ok := ctx.Value(clientKey{}).(*client)
+	if !ok {
+		return nil
+	}
+	return waitN(ctx, c.download.Load(), n)
+}
+
+// waitN списывает n токенов порциями не больше burst
+func waitN(ctx context.Context, l *rate.Limiter, n int) error {
+	if l == nil {
+		return nil
+	}
+	for n > 0 {
+		step := min(n, l.Burst())
+		if err := l.WaitN(ctx, step); err != nil {
+			if ctx.Err() != nil {
+				return status.FromContextError(ctx.Err()).Err()
+			}
+			// Дедлайн наступит раньше, чем накопятся токены
+			return status.Error(codes.DeadlineExceeded, err.Error())
+		}
+		n -= step
+	}
+	return nil
+}
@@ -0,0 +1,209 @@
+package ratelimit
+
+import (
+	"context"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Hiddan13/file_grpc/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// ---------------------------------------------------------------------
+// Вспомогательные функции
+// ---------------------------------------------------------------------
+func peerCtx(ip string) context.Context {
+	return peer.NewContext(context.Background(), &peer.Peer{
+		Addr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 12345},
+	})
+}
+
+type fakeStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (f *fakeStream) Context() context.Context { return f.ctx }
+
+var uploadInfo = &grpc.StreamServerInfo{FullMethod: "/file.FileService/Upload"}
+
+// ---------------------------------------------------------------------
+// Identity
+// ---------------------------------------------------------------------
+func TestManager_Identity(t *testing.T) {
+	t.Run("peer ip", func(t *testing.T) {
+		m := NewManager("", config.ClientLimits{}, nil)
+		assert.Equal(t, "10.0.0.1", m.Identity(peerCtx("10.0.0.1")))
+	})
+
+	t.Run("header ignored when not configured", func(t *testing.T) {
+		m := NewManager("", config.ClientLimits{}, nil)
+		ctx := metadata.NewIncomingContext(peerCtx("10.0.0.1"), metadata.Pairs("x-client-id", "alice"))
+		assert.Equal(t, "10.0.0.1", m.Identity(ctx))
+	})
+
+	t.Run("header is only a label", func(t *testing.T) {
+		// Иначе клиент менял бы заголовок ради новых лимитов или чужих
+		// переопределений
+		m := NewManager("X-Client-Id", config.ClientLimits{}, map[string]config.ClientLimits{"alice": {}})
+		ctx := metadata.NewIncomingContext(peerCtx("10.0.0.1"), metadata.Pairs("x-client-id", "alice"))
+		assert.Equal(t, "10.0.0.1", m.Identity(ctx))
+		assert.Equal(t, " (alice)", m.label(ctx))
+	})
+
+	t.Run("forwarded by cluster node", func(t *testing.T) {
+		m := NewManager("", config.ClientLimits{}, nil)
+		m.TrustForwarded(func(ctx context.Context) bool {
+			return slices.Contains(metadata.ValueFromIncomingContext(ctx, "authorization"), "Bearer secret")
+		})
+		ctx := metadata.NewIncomingContext(peerCtx("10.0.0.5"), metadata.Pairs(ForwardedClientKey, "10.0.0.1", "authorization", "Bearer secret"))
+		assert.Equal(t, "10.0.0.1", m.Identity(ctx))
+
+		// Без токена заголовок задал бы сам клиент
+		ctx = metadata.NewIncomingContext(peerCtx("10.0.0.5"), metadata.Pairs(ForwardedClientKey, "10.0.0.1"))
+		assert.Equal(t, "10.0.0.5", m.Identity(ctx))
+	})
+}
+
+// ---------------------------------------------------------------------
+// Одновременные передачи
+// ---------------------------------------------------------------------
+func TestManager_Concurrency(t *testing.T) {
+	m := NewManager("", config.ClientLimits{MaxConcurrent: 1}, map[string]config.ClientLimits{
+		"10.0.0.9": {MaxConcurrent: 2},
+	})
+	interceptor := m.StreamServerInterceptor()
+
+	// Держит стрим открытым, пока не закроем release
+	hold := func(ip string, started chan<- error, release <-chan struct{}) {
+		err := interceptor(nil, &fakeStream{ctx: peerCtx(ip)}, uploadInfo, func(srv any, ss grpc.ServerStream) error {
+			started <- nil
+			<-release
+			return nil
+		})
+		if err != nil {
+			started <- err
+		}
+	}
+
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan error, 10)
+
+	go hold("10.0.0.1", started, release)
+	require.NoError(t, <-started)
+
+	// Второй стрим того же клиента - отказ
+	go hold("10.0.0.1", started, release)
+	err := <-started
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	// Другой клиент не затронут
+	go hold("10.0.0.2", started, release)
+	require.NoError(t, <-started)
+
+	// Переопределённый лимит
+	go hold("10.0.0.9", started, release)
+	require.NoError(t, <-started)
+	go hold("10.0.0.9", started, release)
+	require.NoError(t, <-started)
+}
+
+// ---------------------------------------------------------------------
+// Частота запросов
+// ---------------------------------------------------------------------
+func TestManager_RequestRate(t *testing.T) {
+	m := NewManager("", config.ClientLimits{RequestsPerSec: 0.001, RequestBurst: 2}, nil)
+	interceptor := m.UnaryServerInterceptor()
+	info := &grpc.UnaryServerInfo{FullMethod: "/file.FileService/ListFiles"}
+	handler := func(ctx context.Context, req any) (any, error) { return "ok", nil }
+
+	for i := 0; i < 2; i++ {
+		_, err := interceptor(peerCtx("10.0.0.1"), nil, info, handler)
+		require.NoError(t, err)
+	}
+	_, err := interceptor(peerCtx("10.0.0.1"), nil, info, handler)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	// Не FileService - без ограничений
+	_, err = interceptor(peerCtx("10.0.0.1"), nil, &grpc.UnaryServerInfo{FullMethod: "/grpc.health.v1.Health/Check"}, handler)
+	assert.NoError(t, err)
+}
+
+// ---------------------------------------------------------------------
+// Скорость передачи
+// ---------------------------------------------------------------------
+func TestWaitUpload(t *testing.T) {
+	t.Run("no limit without client", func(t *testing.T) {
+		assert.NoError(t, WaitUpload(context.Background(), 1<<20))
+	})
+
+	t.Run("throttles bytes", func(t *testing.T) {
+		m := NewManager("", config.ClientLimits{BytesPerSec: 1000}, nil)
+		ctx := withClient(context.Background(), m.client("10.0.0.1"))
+
+		start := time.Now()
+		// 1000 байт burst сразу + 500 байт ждут ~0.5с
+		require.NoError(t, WaitUpload(ctx, 1500))
+		assert.GreaterOrEqual(t, time.Since(start), 400*time.Millisecond)
+	})
+
+	t.Run("deadline", func(t *testing.T) {
+		m := NewManager("", config.ClientLimits{BytesPerSec: 10}, nil)
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		ctx = withClient(ctx, m.client("10.0.0.1"))
+
+		err := WaitUpload(ctx, 1000)
+		assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+	})
+}
+
+// ---------------------------------------------------------------------
+// SetLimits
+// ---------------------------------------------------------------------
+func TestManager_SetLimits(t *testing.T) {
+	m := NewManager("", config.ClientLimits{MaxConcurrent: 1}, nil)
+	interceptor := m.StreamServerInterceptor()
+
+	release := make(chan struct{})
+	started := make(chan error, 10)
+	hold := func(ip string) {
+		err := interceptor(nil, &fakeStream{ctx: peerCtx(ip)}, uploadInfo, func(srv any, ss grpc.ServerStream) error {
+			started <- nil
+			<-release
+			return nil
+		})
+		if err != nil {
+			started <- err
+		}
+	}
+	defer close(release)
+
+	go hold("10.0.0.1")
+	require.NoError(t, <-started)
+	go hold("10.0.0.1")
+	assert.Equal(t, codes.ResourceExhausted, status.Code(<-started))
+
+	// Уже известный клиент получает новый лимит, идущая передача учтена
+	m.SetLimits(config.ClientLimits{MaxConcurrent: 1}, map[string]config.ClientLimits{"10.0.0.1": {MaxConcurrent: 2}})
+	go hold("10.0.0.1")
+	require.NoError(t, <-started)
+	go hold("10.0.0.1")
+	assert.Equal(t, codes.ResourceExhausted, status.Code(<-started))
+
+	// Скорость передачи меняется для следующих чанков
+	m.SetLimits(config.ClientLimits{BytesPerSec: 10}, nil)
+	ctx, cancel := context.WithTimeout(withClient(context.Background(), m.client("10.0.0.1")), 50*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(WaitUpload(ctx, 1000)))
+}
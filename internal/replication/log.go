@@ -0,0 +1,296 @@
+package replication
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Операции журнала. Ведомому передаётся текущее состояние файла, поэтому
+// операция - лишь подсказка: metadata позволяет не пересылать содержимое.
+const (
+	OpPut      = "put"
+	OpDelete   = "delete"
+	OpMetadata = "metadata"
+)
+
+const (
+	logFile     = "log.jsonl"
+	cursorsFile = "cursors.json"
+	// Есть, пока журнал открыт: остался после падения сервера
+	openMarker = "open"
+)
+
+// Entry - запись журнала: файл Filename изменён операцией Op
+type Entry struct {
+	Seq      uint64    `json:"seq"`
+	Op       string    `json:"op"`
+	Filename string    `json:"filename"`
+	Time     time.Time `json:"time"`
+}
+
+// Log - журнал репликации в директории dir: изменения по одному JSON в
+// строке (log.jsonl) и позиции ведомых (cursors.json). Номера записей
+// растут монотонно и переживают перезапуск. Пока журнал открыт, в dir лежит
+// метка open; Close её убирает.
+type Log struct {
+	dir string
+
+	mu      sync.Mutex
+	f       *os.File
+	entries []Entry // записи после последнего сжатия, по возрастанию Seq
+	cursors map[string]uint64
+	changed chan struct{}
+}
+
+// OpenLog открывает журнал в dir, создавая его при отсутствии. Строка,
+// оборванная падением сервера при записи, отбрасывается. Если прошлый
+// запуск не закрыл журнал (осталась метка open), позиции ведомых
+// сбрасываются: изменение, сделанное перед падением, могло не попасть в
+// журнал, и ведомые синхронизируются полностью.
+func OpenLog(dir string) (*Log, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create replication dir: %w", err)
+	}
+	l := &Log{dir: dir, cursors: make(map[string]uint64), changed: make(chan struct{})}
+
+	path := filepath.Join(dir, logFile)
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read replication log: %w", err)
+	}
+	valid := 0
+	for len(data[valid:]) > 0 {
+		line, _, ok := bytes.Cut(data[valid:], []byte("\n"))
+		if !ok {
+			break
+		}
+		var e Entry
+		if err := json.Unmarshal(line, &e); err != nil {
+			return nil, fmt.Errorf("corrupt replication log at byte %d: %w", valid, err)
+		}
+		l.entries = append(l.entries, e)
+		valid += len(line) + 1
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open replication log: %w", err)
+	}
+	// Хвост без перевода строки - недописанная запись
+	if err := f.Truncate(int64(valid)); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to truncate replication log: %w", err)
+	}
+	if _, err := f.Seek(int64(valid), 0); err != nil {
+		f.Close()
+		return nil, err
+	}
+	l.f = f
+
+	data, err = os.ReadFile(filepath.Join(dir, cursorsFile))
+	if err == nil {
+		err = json.Unmarshal(data, &l.cursors)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		f.Close()
+		return nil, fmt.Errorf("failed to read replication cursors: %w", err)
+	}
+
+	marker := filepath.Join(dir, openMarker)
+	if _, err := os.Stat(marker); err == nil && len(l.cursors) > 0 {
+		log.Printf("[REPLICATION] журнал не был закрыт, ведомые будут синхронизированы заново")
+		clear(l.cursors)
+		if err := l.writeCursors(); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to reset replication cursors: %w", err)
+		}
+	}
+	if err := writeFileAtomic(marker, nil); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to mark replication log open: %w", err)
+	}
+	return l, nil
+}
+
+// Close закрывает файл журнала и убирает метку open
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.f.Close(); err != nil {
+		return err
+	}
+	return os.Remove(filepath.Join(l.dir, openMarker))
+}
+
+// Append добавляет запись и сбрасывает её на диск
+func (l *Log) Append(op, filename string) (Entry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e := Entry{Seq: l.head() + 1, Op: op, Filename: filename, Time: time.Now().UTC()}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return Entry{}, err
+	}
+	if _, err := l.f.Write(append(line, '\n')); err != nil {
+		return Entry{}, fmt.Errorf("failed to append to replication log: %w", err)
+	}
+	if err := l.f.Sync(); err != nil {
+		return Entry{}, fmt.Errorf("failed to sync replication log: %w", err)
+	}
+	l.entries = append(l.entries, e)
+	close(l.changed)
+	l.changed = make(chan struct{})
+	return e, nil
+}
+
+// Changed закроется при следующем Append
+func (l *Log) Changed() <-chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.changed
+}
+
+// Head - номер последней записи, 0 - журнал пуст
+func (l *Log) Head() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.head()
+}
+
+func (l *Log) head() uint64 {
+	if len(l.entries) == 0 {
+		return 0
+	}
+	return l.entries[len(l.entries)-1].Seq
+}
+
+// Len - сколько записей хранится после сжатия
+func (l *Log) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.entries)
+}
+
+// Since вернёт до limit записей после seq. ok=false, если нужные записи
+// уже удалены сжатием или seq впереди журнала - тогда ведомому нужна
+// полная синхронизация.
+func (l *Log) Since(seq uint64, limit int) (entries []Entry, ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if seq > l.head() {
+		return nil, false
+	}
+	if seq == l.head() {
+		return nil, true
+	}
+	// Записи идут подряд: первая после seq лежит по смещению от начала
+	first := l.entries[0].Seq
+	if seq+1 < first {
+		return nil, false
+	}
+	i := int(seq + 1 - first)
+	return append([]Entry(nil), l.entries[i:min(i+limit, len(l.entries))]...), true
+}
+
+// Cursor - до какой записи включительно ведомый name получил изменения.
+// ok=false - ведомый ещё не синхронизирован.
+func (l *Log) Cursor(name string) (seq uint64, ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	seq, ok = l.cursors[name]
+	return seq, ok
+}
+
+// SetCursor запоминает позицию ведомого на диске
+func (l *Log) SetCursor(name string, seq uint64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.cursors[name] = seq
+	return l.writeCursors()
+}
+
+// ResetCursors забывает позиции всех ведомых: при следующей попытке они
+// синхронизируются полностью. Нужен, когда изменение не попало в журнал.
+func (l *Log) ResetCursors() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	clear(l.cursors)
+	err := l.writeCursors()
+	close(l.changed)
+	l.changed = make(chan struct{})
+	return err
+}
+
+func (l *Log) writeCursors() error {
+	data, err := json.Marshal(l.cursors)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(l.dir, cursorsFile), data)
+}
+
+// Compact удаляет записи до upTo включительно, кроме последней: по ней
+// после перезапуска продолжается нумерация
+func (l *Log) Compact(upTo uint64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	n := 0
+	for n < len(l.entries)-1 && l.entries[n].Seq <= upTo {
+		n++
+	}
+	if n == 0 {
+		return nil
+	}
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	enc := json.NewEncoder(w)
+	for _, e := range l.entries[n:] {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	path := filepath.Join(l.dir, logFile)
+	if err := writeFileAtomic(path, buf.Bytes()); err != nil {
+		return fmt.Errorf("failed to compact replication log: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to reopen replication log: %w", err)
+	}
+	l.f.Close()
+	l.f = f
+	l.entries = append([]Entry(nil), l.entries[n:]...)
+	return nil
+}
+
+// writeFileAtomic пишет файл через временный и переименование
+func writeFileAtomic(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	err = errors.Join(err, f.Close())
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
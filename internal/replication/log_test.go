@@ -0,0 +1,163 @@
+package replication
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func openTestLog(t *testing.T, dir string) *Log {
+	t.Helper()
+	l, err := OpenLog(dir)
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+func appendEntries(t *testing.T, l *Log, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		_, err := l.Append(OpPut, name)
+		require.NoError(t, err)
+	}
+}
+
+// ---------------------------------------------------------------------
+// Log
+// ---------------------------------------------------------------------
+func TestLog(t *testing.T) {
+	t.Run("append and read since", func(t *testing.T) {
+		l := openTestLog(t, t.TempDir())
+		assert.Equal(t, uint64(0), l.Head())
+
+		changed := l.Changed()
+		e, err := l.Append(OpDelete, "a.txt")
+		require.NoError(t, err)
+		assert.Equal(t, uint64(1), e.Seq)
+		select {
+		case <-changed:
+		default:
+			t.Fatal("Changed must be closed by Append")
+		}
+		appendEntries(t, l, "b.txt", "c.txt")
+
+		entries, ok := l.Since(1, 10)
+		require.True(t, ok)
+		require.Len(t, entries, 2)
+		assert.Equal(t, "b.txt", entries[0].Filename)
+		assert.Equal(t, uint64(3), entries[1].Seq)
+
+		entries, ok = l.Since(0, 1)
+		require.True(t, ok)
+		assert.Equal(t, []string{"a.txt"}, []string{entries[0].Filename})
+		assert.Equal(t, OpDelete, entries[0].Op)
+
+		entries, ok = l.Since(3, 10)
+		assert.True(t, ok)
+		assert.Empty(t, entries)
+
+		_, ok = l.Since(4, 10)
+		assert.False(t, ok, "cursor ahead of the log")
+	})
+
+	t.Run("survives reopen", func(t *testing.T) {
+		dir := t.TempDir()
+		l, err := OpenLog(dir)
+		require.NoError(t, err)
+		appendEntries(t, l, "a.txt", "b.txt")
+		require.NoError(t, l.SetCursor("standby:50051", 1))
+		require.NoError(t, l.Close())
+
+		l = openTestLog(t, dir)
+		assert.Equal(t, uint64(2), l.Head())
+		cursor, ok := l.Cursor("standby:50051")
+		assert.True(t, ok)
+		assert.Equal(t, uint64(1), cursor)
+		_, ok = l.Cursor("other:50051")
+		assert.False(t, ok)
+
+		e, err := l.Append(OpPut, "c.txt")
+		require.NoError(t, err)
+		assert.Equal(t, uint64(3), e.Seq)
+	})
+
+	t.Run("unclean shutdown resets cursors", func(t *testing.T) {
+		dir := t.TempDir()
+		l, err := OpenLog(dir)
+		require.NoError(t, err)
+		appendEntries(t, l, "a.txt")
+		require.NoError(t, l.SetCursor("standby:50051", 1))
+		// Падение: файл закрыт системой, метка open осталась
+		require.NoError(t, l.f.Close())
+
+		l = openTestLog(t, dir)
+		_, ok := l.Cursor("standby:50051")
+		assert.False(t, ok, "изменение перед падением могло не попасть в журнал")
+		assert.Equal(t, uint64(1), l.Head())
+	})
+
+	t.Run("torn last line is dropped", func(t *testing.T) {
+		dir := t.TempDir()
+		l, err := OpenLog(dir)
+		require.NoError(t, err)
+		appendEntries(t, l, "a.txt")
+		require.NoError(t, l.Close())
+
+		f, err := os.OpenFile(filepath.Join(dir, logFile), os.O_WRONLY|os.O_APPEND, 0644)
+		require.NoError(t, err)
+		f.WriteString(`{"seq":2,"op":"pu`)
+		f.Close()
+
+		l = openTestLog(t, dir)
+		assert.Equal(t, uint64(1), l.Head())
+		e, err := l.Append(OpPut, "b.txt")
+		require.NoError(t, err)
+		assert.Equal(t, uint64(2), e.Seq)
+
+		l.Close()
+		l = openTestLog(t, dir)
+		assert.Equal(t, uint64(2), l.Head())
+	})
+
+	t.Run("compact keeps numbering", func(t *testing.T) {
+		dir := t.TempDir()
+		l, err := OpenLog(dir)
+		require.NoError(t, err)
+		appendEntries(t, l, "a.txt", "b.txt", "c.txt")
+
+		require.NoError(t, l.Compact(2))
+		assert.Equal(t, 1, l.Len())
+		_, ok := l.Since(1, 10)
+		assert.False(t, ok, "entry 2 was compacted away")
+		entries, ok := l.Since(2, 10)
+		require.True(t, ok)
+		assert.Equal(t, "c.txt", entries[0].Filename)
+
+		// Последняя запись остаётся, даже если все её получили
+		require.NoError(t, l.Compact(3))
+		assert.Equal(t, 1, l.Len())
+
+		appendEntries(t, l, "d.txt")
+		require.NoError(t, l.Close())
+		l = openTestLog(t, dir)
+		assert.Equal(t, uint64(4), l.Head())
+		assert.Equal(t, 2, l.Len())
+	})
+
+	t.Run("reset cursors", func(t *testing.T) {
+		l := openTestLog(t, t.TempDir())
+		require.NoError(t, l.SetCursor("standby:50051", 0))
+		changed := l.Changed()
+		require.NoError(t, l.ResetCursors())
+		_, ok := l.Cursor("standby:50051")
+		assert.False(t, ok)
+		select {
+		case <-changed:
+		default:
+			t.Fatal("followers must be woken up after reset")
+		}
+	})
+}
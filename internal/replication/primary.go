@@ -0,0 +1,415 @@
+package replication
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/Hiddan13/file_grpc/api/proto/pb"
+	"github.com/Hiddan13/file_grpc/internal/repository"
+	"github.com/Hiddan13/file_grpc/internal/tracing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+var tracer = otel.Tracer("github.com/Hiddan13/file_grpc/internal/replication")
+
+const (
+	// Сколько записей журнала читать за раз
+	batchSize = 100
+	// Журнал сжимается, когда в нём больше записей, чем compactThreshold,
+	// до позиции самого отстающего ведомого
+	compactThreshold = 1000
+	// Больше maxLogEntries записей не хранится, даже если ведомый отстал:
+	// такому ведомому дешевле полная синхронизация
+	maxLogEntries = 100000
+	// Размер чанка содержимого в Replicate
+	chunkSize = 64 * 1024
+)
+
+// Primary рассылает изменения из журнала ведомым серверам. Каждый ведомый
+// обслуживается отдельно: недоступный не задерживает остальных, а после
+// восстановления получает пропущенное с сохранённой позиции.
+type Primary struct {
+	repo      repository.Repository
+	log       *Log
+	token     string
+	retry     time.Duration
+	followers []*follower
+}
+
+// follower - ведомый сервер и статистика для метрик
+type follower struct {
+	name   string
+	repl   pb.ReplicationServiceClient
+	files  pb.FileServiceClient
+	mu     sync.Mutex
+	errors int64
+	bytes  int64
+	syncs  int64 // полных синхронизаций
+	// Последняя ошибка, nil - последняя попытка успешна
+	lastErr error
+}
+
+// NewPrimary создаёт рассылку журнала l. Файлы читаются из repo, ведомым
+// передаётся admin токен token, после ошибки попытка повторяется через
+// retry.
+func NewPrimary(repo repository.Repository, l *Log, token string, retry time.Duration) *Primary {
+	return &Primary{repo: repo, log: l, token: token, retry: retry}
+}
+
+// AddFollower добавляет ведомого, name - его адрес, по нему же хранится
+// позиция в журнале. Вызывается до Run.
+func (p *Primary) AddFollower(name string, conn grpc.ClientConnInterface) {
+	p.followers = append(p.followers, &follower{
+		name:  name,
+		repl:  pb.NewReplicationServiceClient(conn),
+		files: pb.NewFileServiceClient(conn),
+	})
+}
+
+// Run рассылает изменения до отмены ctx
+func (p *Primary) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, f := range p.followers {
+		wg.Go(func() { p.run(ctx, f) })
+	}
+	wg.Wait()
+}
+
+func (p *Primary) run(ctx context.Context, f *follower) {
+	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+p.token)
+	for {
+		// Канал берём до чтения журнала, чтобы не пропустить запись
+		changed := p.log.Changed()
+		err := p.catchUp(ctx, f)
+		if ctx.Err() != nil {
+			return
+		}
+		f.mu.Lock()
+		wasFailing := f.lastErr != nil
+		f.lastErr = err
+		if err != nil {
+			f.errors++
+		}
+		f.mu.Unlock()
+
+		if err != nil {
+			log.Printf("[REPLICATION] %s: ошибка, повтор через %s: %v", f.name, p.retry, err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(p.retry):
+			}
+			continue
+		}
+		if wasFailing {
+			log.Printf("[REPLICATION] %s: догнал журнал, позиция %d", f.name, p.log.Head())
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-changed:
+		}
+	}
+}
+
+// catchUp передаёт ведомому всё, что он ещё не получил
+func (p *Primary) catchUp(ctx context.Context, f *follower) error {
+	cursor, known := p.log.Cursor(f.name)
+	if _, ok := p.log.Since(cursor, 0); !known || !ok {
+		if err := p.fullSync(ctx, f); err != nil {
+			return fmt.Errorf("full sync: %w", err)
+		}
+		cursor, _ = p.log.Cursor(f.name)
+	}
+	for {
+		entries, ok := p.log.Since(cursor, batchSize)
+		if !ok {
+			// Журнал сжат или сброшен, пока ведомый догонял
+			return p.catchUp(ctx, f)
+		}
+		if len(entries) == 0 {
+			return nil
+		}
+		for i, e := range entries {
+			// Передаётся текущее состояние файла, поэтому из нескольких
+			// изменений одного файла в пачке достаточно последнего
+			superseded := slices.ContainsFunc(entries[i+1:], func(next Entry) bool { return next.Filename == e.Filename })
+			if !superseded {
+				if err := p.push(ctx, f, e.Op, e.Filename, e.Seq); err != nil {
+					return fmt.Errorf("%s %s (seq %d): %w", e.Op, e.Filename, e.Seq, err)
+				}
+			}
+			cursor = e.Seq
+		}
+		if err := p.log.SetCursor(f.name, cursor); err != nil {
+			return err
+		}
+		p.compact()
+	}
+}
+
+// fullSync приводит ведомого к текущему состоянию: передаёт файлы, которых
+// у него нет или которые отличаются, и удаляет лишние. Позиция ставится
+// на запись журнала, бывшую последней до начала: более поздние изменения
+// будут переданы ещё раз, что безопасно.
+func (p *Primary) fullSync(ctx context.Context, f *follower) error {
+	ctx, span := tracer.Start(ctx, "Primary.fullSync")
+	defer span.End()
+
+	head := p.log.Head()
+	local, err := p.repo.List(ctx)
+	if err != nil {
+		return err
+	}
+	resp, err := f.files.ListFiles(ctx, &pb.ListFilesRequest{})
+	if err != nil {
+		return fmt.Errorf("list follower files: %w", err)
+	}
+	remote := make(map[string]*pb.FileInfo, len(resp.GetFiles()))
+	for _, info := range resp.GetFiles() {
+		remote[info.GetFilename()] = info
+	}
+
+	sent := 0
+	for _, m := range local {
+		if info, ok := remote[m.Filename]; ok && sameFile(m, info) {
+			delete(remote, m.Filename)
+			continue
+		}
+		delete(remote, m.Filename)
+		if err := p.push(ctx, f, OpPut, m.Filename, head); err != nil {
+			return fmt.Errorf("put %s: %w", m.Filename, err)
+		}
+		sent++
+	}
+	for _, name := range slices.Sorted(maps.Keys(remote)) {
+		if err := p.push(ctx, f, OpDelete, name, head); err != nil {
+			return fmt.Errorf("delete %s: %w", name, err)
+		}
+	}
+	if err := p.log.SetCursor(f.name, head); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	f.syncs++
+	f.mu.Unlock()
+	span.SetAttributes(attribute.Int("files.sent", sent), attribute.Int("files.deleted", len(remote)))
+	log.Printf("[REPLICATION] %s: полная синхронизация, передано %d, удалено %d, позиция %d", f.name, sent, len(remote), head)
+	return nil
+}
+
+// sameFile - совпадают ли содержимое и метаданные файла на ведомом
+func sameFile(m repository.FileMeta, info *pb.FileInfo) bool {
+	return m.ETag == info.GetEtag() &&
+		m.ContentType == info.GetContentType() &&
+		maps.Equal(m.Metadata, info.GetMetadata()) &&
+		slices.Equal(m.Tags, info.GetTags())
+}
+
+// push передаёт ведомому текущее состояние файла. op=metadata сначала
+// пробует обновить только метаданные, если содержимое у ведомого то же.
+func (p *Primary) push(ctx context.Context, f *follower, op, filename string, seq uint64) error {
+	if op != OpDelete {
+		meta, err := p.repo.Stat(ctx, filename)
+		switch {
+		case errors.Is(err, repository.ErrNotFound):
+			op = OpDelete
+		case err != nil:
+			return err
+		case op == OpMetadata:
+			err := p.send(ctx, f, &pb.ReplicateHeader{Seq: seq, Op: OpMetadata, File: FileInfo(meta)}, nil)
+			if c := status.Code(err); c != codes.FailedPrecondition && c != codes.NotFound {
+				return err
+			}
+			// У ведомого другое содержимое - передаём файл целиком
+			op = OpPut
+		}
+	}
+	if op == OpDelete {
+		return p.send(ctx, f, &pb.ReplicateHeader{Seq: seq, Op: OpDelete, File: &pb.FileInfo{Filename: filename}}, nil)
+	}
+
+	data, err := p.repo.Get(ctx, filename)
+	if errors.Is(err, repository.ErrNotFound) {
+		return p.send(ctx, f, &pb.ReplicateHeader{Seq: seq, Op: OpDelete, File: &pb.FileInfo{Filename: filename}}, nil)
+	}
+	if err != nil {
+		return err
+	}
+	meta, err := p.repo.Stat(ctx, filename)
+	if err != nil {
+		return err
+	}
+	// Метаданные прочитаны отдельно от содержимого: etag и размер берём
+	// по тому, что действительно передаётся
+	info := FileInfo(meta)
+	sum := sha256.Sum256(data)
+	info.Etag, info.Size = hex.EncodeToString(sum[:]), int64(len(data))
+	return p.send(ctx, f, &pb.ReplicateHeader{Seq: seq, Op: OpPut, File: info}, data)
+}
+
+// send передаёт изменение ведомому и учитывает переданные байты
+func (p *Primary) send(ctx context.Context, f *follower, hdr *pb.ReplicateHeader, data []byte) error {
+	if err := Send(ctx, f.repl, hdr, data); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	f.bytes += int64(len(data))
+	f.mu.Unlock()
+	return nil
+}
+
+// Send вызывает Replicate: заголовок, затем data чанками
+func Send(ctx context.Context, client pb.ReplicationServiceClient, hdr *pb.ReplicateHeader, data []byte) error {
+	stream, err := client.Replicate(ctx)
+	if err != nil {
+		return err
+	}
+	if err := stream.Send(&pb.ReplicateRequest{Msg: &pb.ReplicateRequest_Header{Header: hdr}}); err != nil && err != io.EOF {
+		return err
+	}
+	for i := 0; i < len(data); i += chunkSize {
+		chunk := data[i:min(i+chunkSize, len(data))]
+		if err := stream.Send(&pb.ReplicateRequest{Msg: &pb.ReplicateRequest_Chunk{Chunk: chunk}}); err != nil {
+			// io.EOF - ведомый завершил вызов, ошибку вернёт CloseAndRecv
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+	}
+	_, err = stream.CloseAndRecv()
+	return err
+}
+
+// compact сжимает журнал до позиции самого отстающего ведомого
+func (p *Primary) compact() {
+	n := p.log.Len()
+	if n <= compactThreshold {
+		return
+	}
+	upTo := p.log.Head()
+	for _, f := range p.followers {
+		cursor, ok := p.log.Cursor(f.name)
+		if !ok {
+			cursor = 0
+		}
+		upTo = min(upTo, cursor)
+	}
+	if n > maxLogEntries {
+		upTo = max(upTo, p.log.Head()-maxLogEntries)
+	}
+	if err := p.log.Compact(upTo); err != nil {
+		log.Printf("[REPLICATION] %v", err)
+	}
+}
+
+// FileInfo - метаданные для ReplicateHeader
+func FileInfo(m repository.FileMeta) *pb.FileInfo {
+	info := &pb.FileInfo{
+		Filename:    m.Filename,
+		CreatedAt:   m.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:   m.UpdatedAt.Format(time.RFC3339),
+		Size:        m.Size,
+		Etag:        m.ETag,
+		Metadata:    m.Metadata,
+		Tags:        m.Tags,
+		ContentType: m.ContentType,
+	}
+	if !m.ExpiresAt.IsZero() {
+		info.ExpiresAt = m.ExpiresAt.Format(time.RFC3339)
+	}
+	return info
+}
+
+// WriteMetrics пишет состояние рассылки в текстовом формате Prometheus
+func (p *Primary) WriteMetrics(w io.Writer) error {
+	head := p.log.Head()
+	metric := func(name, typ, help string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	}
+	metric("file_grpc_replication_log_head_seq", "gauge", "Sequence number of the last replication log entry.")
+	fmt.Fprintf(w, "file_grpc_replication_log_head_seq %d\n", head)
+	metric("file_grpc_replication_log_entries", "gauge", "Entries kept in the replication log.")
+	fmt.Fprintf(w, "file_grpc_replication_log_entries %d\n", p.log.Len())
+
+	type row struct {
+		acked, lag           uint64
+		lagSeconds           float64
+		errors, bytes, syncs int64
+		up                   int
+	}
+	rows := make([]row, len(p.followers))
+	for i, f := range p.followers {
+		r := &rows[i]
+		cursor, known := p.log.Cursor(f.name)
+		r.acked = cursor
+		if head > cursor {
+			r.lag = head - cursor
+		}
+		if entries, ok := p.log.Since(cursor, 1); known && ok && len(entries) > 0 {
+			r.lagSeconds = time.Since(entries[0].Time).Seconds()
+		} else if !known || !ok {
+			// Ждёт полной синхронизации: возраст отставания неизвестен
+			r.lagSeconds = -1
+		}
+		f.mu.Lock()
+		r.errors, r.bytes, r.syncs = f.errors, f.bytes, f.syncs
+		if f.lastErr == nil {
+			r.up = 1
+		}
+		f.mu.Unlock()
+	}
+
+	write := func(name, typ, help string, value func(row) string) {
+		metric(name, typ, help)
+		for i, f := range p.followers {
+			fmt.Fprintf(w, "%s{follower=%q} %s\n", name, f.name, value(rows[i]))
+		}
+	}
+	write("file_grpc_replication_acked_seq", "gauge", "Last log entry delivered to the follower.",
+		func(r row) string { return fmt.Sprint(r.acked) })
+	write("file_grpc_replication_lag_entries", "gauge", "Log entries not yet delivered to the follower.",
+		func(r row) string { return fmt.Sprint(r.lag) })
+	write("file_grpc_replication_lag_seconds", "gauge", "Age of the oldest undelivered entry, -1 - waiting for a full sync.",
+		func(r row) string { return fmt.Sprintf("%g", r.lagSeconds) })
+	write("file_grpc_replication_up", "gauge", "1 if the last attempt to reach the follower succeeded.",
+		func(r row) string { return fmt.Sprint(r.up) })
+	write("file_grpc_replication_errors_total", "counter", "Failed replication attempts.",
+		func(r row) string { return fmt.Sprint(r.errors) })
+	write("file_grpc_replication_bytes_total", "counter", "File content bytes sent to the follower.",
+		func(r row) string { return fmt.Sprint(r.bytes) })
+	write("file_grpc_replication_full_syncs_total", "counter", "Full synchronizations of the follower.",
+		func(r row) string { return fmt.Sprint(r.syncs) })
+	return nil
+}
+
+// Dial открывает соединение с ведомым addr. Переподключение идёт не реже
+// раза в retry, чтобы вернувшийся ведомый подхватывался без долгой паузы.
+func Dial(addr string, retry time.Duration) (*grpc.ClientConn, error) {
+	bc := backoff.DefaultConfig
+	bc.MaxDelay = retry
+	return grpc.NewClient(addr,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithConnectParams(grpc.ConnectParams{Backoff: bc}),
+		grpc.WithChainUnaryInterceptor(tracing.UnaryClientInterceptor()),
+		grpc.WithChainStreamInterceptor(tracing.StreamClientInterceptor()),
+	)
+}
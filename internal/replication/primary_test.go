@@ -0,0 +1,345 @@
+package replication
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Hiddan13/file_grpc/api/proto/pb"
+	"github.com/Hiddan13/file_grpc/internal/repository"
+	"github.com/Hiddan13/file_grpc/internal/service"
+	grpcTransport "github.com/Hiddan13/file_grpc/internal/transport/grpc"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const testToken = "secret"
+
+// testFollower - ведомый сервер в памяти процесса; его можно остановить и
+// запустить заново на том же хранилище
+type testFollower struct {
+	t    *testing.T
+	dir  string
+	svc  *service.FileService
+	srv  *grpc.Server
+	lis  atomic.Pointer[bufconn.Listener]
+	conn *grpc.ClientConn
+}
+
+func newTestFollower(t *testing.T) *testFollower {
+	f := &testFollower{t: t, dir: t.TempDir()}
+	f.start()
+	bc := backoff.DefaultConfig
+	bc.BaseDelay, bc.MaxDelay = 10*time.Millisecond, 50*time.Millisecond
+	conn, err := grpc.NewClient("passthrough:///follower",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return f.lis.Load().DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithConnectParams(grpc.ConnectParams{Backoff: bc}),
+	)
+	require.NoError(t, err)
+	f.conn = conn
+	t.Cleanup(func() {
+		conn.Close()
+		f.stop()
+	})
+	return f
+}
+
+// start поднимает сервер: хранилище индексируется заново, как при запуске
+func (f *testFollower) start() {
+	repo, err := repository.NewFilesRepository(f.dir, repository.Options{Versioning: true})
+	require.NoError(f.t, err)
+	_, err = repo.Reindex(context.Background())
+	require.NoError(f.t, err)
+	f.svc = service.NewFileService(repo)
+
+	lis := bufconn.Listen(1 << 20)
+	f.srv = grpc.NewServer(
+		grpc.UnaryInterceptor(grpcTransport.AdminAuthUnaryInterceptor(testToken)),
+		grpc.StreamInterceptor(grpcTransport.AdminAuthStreamInterceptor(testToken)),
+	)
+	pb.RegisterFileServiceServer(f.srv, grpcTransport.NewFileServer(f.svc, 10, 10, 10, grpcTransport.Admission{}))
+	pb.RegisterReplicationServiceServer(f.srv, NewServer(f.svc))
+	f.lis.Store(lis)
+	go f.srv.Serve(lis)
+}
+
+func (f *testFollower) stop() {
+	f.srv.Stop()
+}
+
+// content вернёт содержимое файла на ведомом, nil - файла нет
+func (f *testFollower) content(name string) []byte {
+	data, err := f.svc.GetFile(context.Background(), name)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// testPrimary - первичный сервер: запись идёт через Recorder, рассылка
+// запущена в фоне
+type testPrimary struct {
+	repo *repository.FilesRepository
+	log  *Log
+	svc  *service.FileService
+	p    *Primary
+}
+
+// newTestPrimary создаёт первичный сервер; before вызывается до запуска
+// рассылки и пишет в хранилище мимо журнала
+func newTestPrimary(t *testing.T, f *testFollower, before func(repo *repository.FilesRepository)) *testPrimary {
+	dir := t.TempDir()
+	repo, err := repository.NewFilesRepository(dir, repository.Options{})
+	require.NoError(t, err)
+	if before != nil {
+		before(repo)
+	}
+	l := openTestLog(t, filepath.Join(dir, repository.ReplicationDir))
+	p := NewPrimary(repo, l, testToken, 20*time.Millisecond)
+	p.AddFollower("follower", f.conn)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		p.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return &testPrimary{repo: repo, log: l, svc: service.NewFileService(NewRecorder(repo, l)), p: p}
+}
+
+func (tp *testPrimary) metrics(t *testing.T) string {
+	var buf bytes.Buffer
+	require.NoError(t, tp.p.WriteMetrics(&buf))
+	return buf.String()
+}
+
+// caughtUp - ведомый получил всё из журнала
+func (tp *testPrimary) caughtUp() bool {
+	cursor, ok := tp.log.Cursor("follower")
+	return ok && cursor == tp.log.Head()
+}
+
+func eventually(t *testing.T, cond func() bool, msg string) {
+	t.Helper()
+	require.Eventually(t, cond, 5*time.Second, 10*time.Millisecond, msg)
+}
+
+// ---------------------------------------------------------------------
+// Primary: рассылка изменений
+// ---------------------------------------------------------------------
+func TestPrimary_Replicates(t *testing.T) {
+	ctx := context.Background()
+	f := newTestFollower(t)
+	tp := newTestPrimary(t, f, nil)
+
+	_, err := tp.svc.SaveFile(ctx, "a.txt", []byte("hello"), repository.SaveOptions{
+		Metadata:    map[string]string{"owner": "ops"},
+		Tags:        []string{"red"},
+		ContentType: "text/plain",
+	})
+	require.NoError(t, err)
+	eventually(t, func() bool { return bytes.Equal(f.content("a.txt"), []byte("hello")) }, "upload replicated")
+	info, err := f.svc.GetFileInfo(ctx, "a.txt")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"owner": "ops"}, info.Metadata)
+	assert.Equal(t, []string{"red"}, info.Tags)
+	assert.Equal(t, "text/plain", info.ContentType)
+
+	_, err = tp.svc.SaveFile(ctx, "a.txt", []byte("hello, world"), repository.SaveOptions{})
+	require.NoError(t, err)
+	eventually(t, func() bool { return bytes.Equal(f.content("a.txt"), []byte("hello, world")) }, "overwrite replicated")
+
+	_, err = tp.svc.SetMetadata(ctx, "a.txt", map[string]string{"owner": "dev"}, nil)
+	require.NoError(t, err)
+	eventually(t, func() bool {
+		info, err := f.svc.GetFileInfo(ctx, "a.txt")
+		return err == nil && info.Metadata["owner"] == "dev" && len(info.Tags) == 0
+	}, "metadata replicated")
+	// Метаданные применены без пересылки содержимого: новой версии нет
+	versions, err := f.svc.ListVersions(ctx, "a.txt")
+	require.NoError(t, err)
+	assert.Len(t, versions, 1)
+
+	require.NoError(t, tp.svc.DeleteFile(ctx, "a.txt", repository.Preconditions{}))
+	eventually(t, func() bool { return f.content("a.txt") == nil }, "delete replicated")
+
+	eventually(t, tp.caughtUp, "cursor reaches the head")
+	m := tp.metrics(t)
+	assert.Contains(t, m, `file_grpc_replication_lag_entries{follower="follower"} 0`)
+	assert.Contains(t, m, `file_grpc_replication_up{follower="follower"} 1`)
+	assert.Contains(t, m, "file_grpc_replication_log_head_seq 4")
+}
+
+// ---------------------------------------------------------------------
+// Primary: догоняет ведомого после перезапуска
+// ---------------------------------------------------------------------
+func TestPrimary_CatchUpAfterFollowerRestart(t *testing.T) {
+	ctx := context.Background()
+	f := newTestFollower(t)
+	tp := newTestPrimary(t, f, nil)
+
+	_, err := tp.svc.SaveFile(ctx, "x.txt", []byte("x"), repository.SaveOptions{})
+	require.NoError(t, err)
+	eventually(t, func() bool { return f.content("x.txt") != nil }, "first upload replicated")
+	eventually(t, tp.caughtUp, "cursor reaches the head")
+
+	f.stop()
+	_, err = tp.svc.SaveFile(ctx, "y.txt", []byte("y"), repository.SaveOptions{})
+	require.NoError(t, err)
+	require.NoError(t, tp.svc.DeleteFile(ctx, "x.txt", repository.Preconditions{}))
+
+	eventually(t, func() bool {
+		m := tp.metrics(t)
+		return bytes.Contains([]byte(m), []byte(`file_grpc_replication_up{follower="follower"} 0`))
+	}, "follower reported down")
+	m := tp.metrics(t)
+	assert.Contains(t, m, `file_grpc_replication_lag_entries{follower="follower"} 2`)
+	assert.NotContains(t, m, `file_grpc_replication_errors_total{follower="follower"} 0`)
+
+	f.start()
+	eventually(t, func() bool { return f.content("y.txt") != nil && f.content("x.txt") == nil }, "missed changes delivered")
+	eventually(t, tp.caughtUp, "cursor reaches the head")
+	assert.Contains(t, tp.metrics(t), `file_grpc_replication_lag_entries{follower="follower"} 0`)
+}
+
+// ---------------------------------------------------------------------
+// Primary: полная синхронизация нового ведомого
+// ---------------------------------------------------------------------
+func TestPrimary_FullSync(t *testing.T) {
+	ctx := context.Background()
+	f := newTestFollower(t)
+	_, err := f.svc.SaveFile(ctx, "same.txt", []byte("same"), repository.SaveOptions{})
+	require.NoError(t, err)
+	_, err = f.svc.SaveFile(ctx, "stale.txt", []byte("stale"), repository.SaveOptions{})
+	require.NoError(t, err)
+	_, err = f.svc.SaveFile(ctx, "changed.txt", []byte("old"), repository.SaveOptions{})
+	require.NoError(t, err)
+
+	// Файлы, записанные до включения репликации, в журнал не попали
+	tp := newTestPrimary(t, f, func(repo *repository.FilesRepository) {
+		for name, data := range map[string]string{"same.txt": "same", "changed.txt": "new", "only.txt": "only"} {
+			_, err := repo.Save(ctx, name, []byte(data), repository.SaveOptions{})
+			require.NoError(t, err)
+		}
+	})
+
+	eventually(t, tp.caughtUp, "full sync finished")
+	assert.Equal(t, []byte("new"), f.content("changed.txt"))
+	assert.Equal(t, []byte("only"), f.content("only.txt"))
+	assert.Nil(t, f.content("stale.txt"), "extraneous file removed")
+	versions, err := f.svc.ListVersions(ctx, "same.txt")
+	require.NoError(t, err)
+	assert.Empty(t, versions, "identical file is not rewritten")
+	assert.Contains(t, tp.metrics(t), `file_grpc_replication_full_syncs_total{follower="follower"} 1`)
+}
+
+// ---------------------------------------------------------------------
+// Server: приём изменений ведомым
+// ---------------------------------------------------------------------
+func TestServer_Replicate(t *testing.T) {
+	f := newTestFollower(t)
+	client := pb.NewReplicationServiceClient(f.conn)
+	authCtx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+testToken)
+
+	replicate := func(ctx context.Context, hdr *pb.ReplicateHeader, chunks ...[]byte) error {
+		stream, err := client.Replicate(ctx)
+		require.NoError(t, err)
+		require.NoError(t, stream.Send(&pb.ReplicateRequest{Msg: &pb.ReplicateRequest_Header{Header: hdr}}))
+		for _, c := range chunks {
+			stream.Send(&pb.ReplicateRequest{Msg: &pb.ReplicateRequest_Chunk{Chunk: c}})
+		}
+		_, err = stream.CloseAndRecv()
+		return err
+	}
+	put := func(name, etag string) *pb.ReplicateHeader {
+		return &pb.ReplicateHeader{Seq: 7, Op: OpPut, File: &pb.FileInfo{Filename: name, Etag: etag}}
+	}
+	// sha256("data")
+	const etag = "3a6eb0790f39ac87c94f3856b2dd2c5d110e6811602261a9a923d3bb23adc8b7"
+
+	t.Run("requires admin token", func(t *testing.T) {
+		err := replicate(context.Background(), put("a.txt", etag), []byte("data"))
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	})
+
+	t.Run("checksum mismatch", func(t *testing.T) {
+		err := replicate(authCtx, put("a.txt", etag), []byte("tampered"))
+		assert.Equal(t, codes.DataLoss, status.Code(err))
+		assert.Nil(t, f.content("a.txt"))
+	})
+
+	t.Run("put and delete", func(t *testing.T) {
+		require.NoError(t, replicate(authCtx, put("a.txt", etag), []byte("da"), []byte("ta")))
+		assert.Equal(t, []byte("data"), f.content("a.txt"))
+
+		del := &pb.ReplicateHeader{Seq: 8, Op: OpDelete, File: &pb.FileInfo{Filename: "a.txt"}}
+		require.NoError(t, replicate(authCtx, del))
+		assert.Nil(t, f.content("a.txt"))
+		// Повтор удаления безопасен
+		require.NoError(t, replicate(authCtx, del))
+	})
+
+	t.Run("metadata for different content", func(t *testing.T) {
+		require.NoError(t, replicate(authCtx, put("b.txt", etag), []byte("data")))
+		err := replicate(authCtx, &pb.ReplicateHeader{Seq: 9, Op: OpMetadata, File: &pb.FileInfo{Filename: "b.txt", Etag: "other"}})
+		assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+		err = replicate(authCtx, &pb.ReplicateHeader{Seq: 9, Op: OpMetadata, File: &pb.FileInfo{Filename: "missing.txt"}})
+		assert.Equal(t, codes.NotFound, status.Code(err))
+	})
+
+	t.Run("invalid requests", func(t *testing.T) {
+		err := replicate(authCtx, &pb.ReplicateHeader{Op: "rename", File: &pb.FileInfo{Filename: "a.txt"}})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		err = replicate(authCtx, &pb.ReplicateHeader{Op: OpDelete, File: &pb.FileInfo{Filename: "../a.txt"}})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+}
+
+// ---------------------------------------------------------------------
+// Recorder
+// ---------------------------------------------------------------------
+func TestRecorder(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	repo, err := repository.NewFilesRepository(dir, repository.Options{})
+	require.NoError(t, err)
+	l := openTestLog(t, filepath.Join(dir, repository.ReplicationDir))
+	rec := NewRecorder(repo, l)
+
+	_, err = rec.Save(ctx, "a.txt", []byte("a"), repository.SaveOptions{})
+	require.NoError(t, err)
+	_, err = rec.SetMetadata(ctx, "a.txt", map[string]string{"k": "v"}, nil)
+	require.NoError(t, err)
+	require.NoError(t, rec.Delete(ctx, "a.txt", repository.Preconditions{}))
+	// Неудачные операции в журнал не попадают
+	assert.Error(t, rec.Delete(ctx, "a.txt", repository.Preconditions{}))
+	_, err = rec.Save(ctx, "b.txt", []byte("b"), repository.SaveOptions{Cond: repository.Preconditions{IfMatch: "*"}})
+	assert.Error(t, err)
+
+	entries, ok := l.Since(0, 10)
+	require.True(t, ok)
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.Op+" "+e.Filename)
+	}
+	assert.Equal(t, []string{"put a.txt", "metadata a.txt", "delete a.txt"}, got)
+}
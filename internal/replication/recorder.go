@@ -0,0 +1,90 @@
+package replication
+
+import (
+	"context"
+	"log"
+
+	"github.com/Hiddan13/file_grpc/internal/repository"
+)
+
+// Recorder - репозиторий, записывающий в журнал каждое успешное изменение
+// файлов: запись, удаление, метаданные, восстановление версии и исправления
+// Scrub. Запись в журнал идёт после изменения, чтобы ведомый не получил
+// состояние раньше, чем оно появится на первичном сервере. Изменение,
+// после которого сервер упал, не дописав журнал, ловит OpenLog: журнал
+// остался открытым, и ведомые синхронизируются полностью.
+type Recorder struct {
+	repository.Repository
+	log *Log
+}
+
+func NewRecorder(repo repository.Repository, l *Log) *Recorder {
+	return &Recorder{Repository: repo, log: l}
+}
+
+// record добавляет запись в журнал. Если это не удалось, изменение уже
+// сделано - ведомые не узнают о нём из журнала, поэтому их позиции
+// сбрасываются и при следующей попытке они синхронизируются полностью.
+func (r *Recorder) record(op, filename string) {
+	if _, err := r.log.Append(op, filename); err != nil {
+		log.Printf("[REPLICATION] ошибка журнала для %s, ведомые будут синхронизированы заново: %v", filename, err)
+		if err := r.log.ResetCursors(); err != nil {
+			log.Printf("[REPLICATION] ошибка сброса позиций ведомых: %v", err)
+		}
+	}
+}
+
+func (r *Recorder) Save(ctx context.Context, filename string, data []byte, opts repository.SaveOptions) (repository.FileMeta, error) {
+	meta, err := r.Repository.Save(ctx, filename, data, opts)
+	if err == nil {
+		r.record(OpPut, filename)
+	}
+	return meta, err
+}
+
+func (r *Recorder) Delete(ctx context.Context, filename string, cond repository.Preconditions) error {
+	err := r.Repository.Delete(ctx, filename, cond)
+	if err == nil {
+		r.record(OpDelete, filename)
+	}
+	return err
+}
+
+func (r *Recorder) SetMetadata(ctx context.Context, filename string, metadata map[string]string, tags []string) (repository.FileMeta, error) {
+	meta, err := r.Repository.SetMetadata(ctx, filename, metadata, tags)
+	if err == nil {
+		r.record(OpMetadata, filename)
+	}
+	return meta, err
+}
+
+func (r *Recorder) RestoreVersion(ctx context.Context, filename string, version int) error {
+	err := r.Repository.RestoreVersion(ctx, filename, version)
+	if err == nil {
+		r.record(OpPut, filename)
+	}
+	return err
+}
+
+// Scrub записывает файлы, которые проверка исправила или убрала в карантин
+func (r *Recorder) Scrub(ctx context.Context, opts repository.ScrubOptions) (repository.ScrubReport, error) {
+	report, err := r.Repository.Scrub(ctx, opts)
+	for _, issue := range report.Issues {
+		if issue.Action != "" {
+			r.record(OpPut, issue.Filename)
+		}
+	}
+	return report, err
+}
+
+// Reindex не знает, какие файлы добавились или пропали, поэтому при
+// расхождении ведомые синхронизируются полностью
+func (r *Recorder) Reindex(ctx context.Context) (repository.ReindexResult, error) {
+	res, err := r.Repository.Reindex(ctx)
+	if err == nil && res.Added+res.Removed > 0 {
+		if err := r.log.ResetCursors(); err != nil {
+			log.Printf("[REPLICATION] ошибка сброса позиций ведомых: %v", err)
+		}
+	}
+	return res, err
+}
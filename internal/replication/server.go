@@ -0,0 +1,189 @@
+package replication
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/Hiddan13/file_grpc/api/proto/pb"
+	"github.com/Hiddan13/file_grpc/internal/repository"
+	"github.com/Hiddan13/file_grpc/internal/service"
+	"github.com/Hiddan13/file_grpc/internal/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	grpcCodes "google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Server - ReplicationService ведомого: применяет изменения первичного
+// сервера через FileService
+type Server struct {
+	pb.UnimplementedReplicationServiceServer
+	fileService *service.FileService
+
+	applied   atomic.Int64 // применено изменений
+	lastSeq   atomic.Uint64
+	appliedAt atomic.Int64 // unix время последнего изменения
+}
+
+func NewServer(fileService *service.FileService) *Server {
+	return &Server{fileService: fileService}
+}
+
+func (s *Server) Replicate(stream pb.ReplicationService_ReplicateServer) (err error) {
+	ctx, span := tracer.Start(stream.Context(), "ReplicationServer.Replicate", trace.WithSpanKind(trace.SpanKindServer))
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
+	req, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	hdr := req.GetHeader()
+	if hdr == nil {
+		return status.Error(grpcCodes.InvalidArgument, "first message must be a header")
+	}
+	info := hdr.GetFile()
+	filename := info.GetFilename()
+	span.SetAttributes(tracing.AttrFilename.String(filename), attribute.String("replication.op", hdr.GetOp()), attribute.Int64("replication.seq", int64(hdr.GetSeq())))
+
+	switch hdr.GetOp() {
+	case OpDelete:
+		err = s.fileService.DeleteFile(ctx, filename, repository.Preconditions{})
+		if errors.Is(err, repository.ErrNotFound) {
+			err = nil
+		}
+	case OpMetadata:
+		err = s.applyMetadata(stream, info)
+	case OpPut:
+		err = s.applyPut(stream, info)
+	default:
+		return status.Errorf(grpcCodes.InvalidArgument, "unknown op %q", hdr.GetOp())
+	}
+	if err != nil {
+		if _, ok := status.FromError(err); !ok {
+			err = replicationStatus(err)
+		}
+		log.Printf("[REPLICATION] ошибка применения %s %s (seq %d): %v", hdr.GetOp(), filename, hdr.GetSeq(), err)
+		return err
+	}
+	s.applied.Add(1)
+	s.lastSeq.Store(hdr.GetSeq())
+	s.appliedAt.Store(time.Now().Unix())
+	log.Printf("[REPLICATION] применено: %s %s (seq %d)", hdr.GetOp(), filename, hdr.GetSeq())
+	return stream.SendAndClose(&pb.ReplicateResponse{Seq: hdr.GetSeq()})
+}
+
+// applyMetadata меняет только метаданные и теги. Если содержимое у ведомого
+// другое, вернёт FailedPrecondition - первичный пришлёт файл целиком.
+func (s *Server) applyMetadata(stream pb.ReplicationService_ReplicateServer, info *pb.FileInfo) error {
+	ctx := stream.Context()
+	current, err := s.fileService.GetFileInfo(ctx, info.GetFilename())
+	if err != nil {
+		return err
+	}
+	if current.ETag != info.GetEtag() {
+		return status.Error(grpcCodes.FailedPrecondition, "content differs from the primary")
+	}
+	_, err = s.fileService.SetMetadata(ctx, info.GetFilename(), info.GetMetadata(), info.GetTags())
+	return err
+}
+
+// applyPut принимает содержимое и сохраняет файл. Совпадающее содержимое
+// не перезаписывается, чтобы не плодить версии на ведомом.
+func (s *Server) applyPut(stream pb.ReplicationService_ReplicateServer, info *pb.FileInfo) error {
+	ctx := stream.Context()
+	var buf bytes.Buffer
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		chunk, ok := req.GetMsg().(*pb.ReplicateRequest_Chunk)
+		if !ok {
+			return status.Error(grpcCodes.InvalidArgument, "expected chunk after the header")
+		}
+		buf.Write(chunk.Chunk)
+	}
+	data := buf.Bytes()
+	sum := sha256.Sum256(data)
+	if etag := hex.EncodeToString(sum[:]); etag != info.GetEtag() {
+		return status.Errorf(grpcCodes.DataLoss, "checksum mismatch: got %s, want %s", etag, info.GetEtag())
+	}
+
+	current, err := s.fileService.GetFileInfo(ctx, info.GetFilename())
+	if err == nil && current.ETag == info.GetEtag() && current.ContentType == info.GetContentType() {
+		if maps.Equal(current.Metadata, info.GetMetadata()) && slices.Equal(current.Tags, info.GetTags()) {
+			return nil
+		}
+		_, err = s.fileService.SetMetadata(ctx, info.GetFilename(), info.GetMetadata(), info.GetTags())
+		return err
+	}
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		return err
+	}
+
+	opts := repository.SaveOptions{
+		// Не nil: пустые метаданные первичного заменяют текущие
+		Metadata:    info.GetMetadata(),
+		Tags:        info.GetTags(),
+		ContentType: info.GetContentType(),
+	}
+	if opts.Metadata == nil {
+		opts.Metadata = map[string]string{}
+	}
+	if opts.Tags == nil {
+		opts.Tags = []string{}
+	}
+	if v := info.GetExpiresAt(); v != "" {
+		if opts.ExpiresAt, err = time.Parse(time.RFC3339, v); err != nil {
+			return status.Errorf(grpcCodes.InvalidArgument, "invalid expires_at: %v", err)
+		}
+	}
+	_, err = s.fileService.SaveFile(ctx, info.GetFilename(), data, opts)
+	return err
+}
+
+// replicationStatus переводит ошибку сервиса в gRPC статус
+func replicationStatus(err error) error {
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		return status.Error(grpcCodes.NotFound, err.Error())
+	case errors.Is(err, service.ErrInvalidFilename), errors.Is(err, service.ErrInvalidMetadata):
+		return status.Error(grpcCodes.InvalidArgument, err.Error())
+	default:
+		return status.Error(grpcCodes.Internal, err.Error())
+	}
+}
+
+// WriteMetrics пишет, сколько изменений ведомый принял от первичного
+func (s *Server) WriteMetrics(w io.Writer) error {
+	metric := func(name, typ, help string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	}
+	metric("file_grpc_replication_applied_total", "counter", "Changes applied from the primary.")
+	fmt.Fprintf(w, "file_grpc_replication_applied_total %d\n", s.applied.Load())
+	metric("file_grpc_replication_applied_seq", "gauge", "Primary log entry of the last applied change.")
+	fmt.Fprintf(w, "file_grpc_replication_applied_seq %d\n", s.lastSeq.Load())
+	metric("file_grpc_replication_last_applied_timestamp_seconds", "gauge", "When the last change was applied, 0 - none yet.")
+	_, err := fmt.Fprintf(w, "file_grpc_replication_last_applied_timestamp_seconds %d\n", s.appliedAt.Load())
+	return err
+}
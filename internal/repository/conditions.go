@@ -0,0 +1,49 @@
+package repository
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// check сверяет условия записи с текущими метаданными файла
+func (c Preconditions) check(filename string, current FileMeta, exists bool) error {
+	if c.IfNoneMatch && exists {
+		return fmt.Errorf("file %s: %w", filename, ErrAlreadyExists)
+	}
+	if c.IfMatch != "" {
+		if !exists {
+			return fmt.Errorf("%w: file %s does not exist", ErrPreconditionFailed, filename)
+		}
+		if c.IfMatch != "*" && c.IfMatch != current.ETag {
+			return fmt.Errorf("%w: etag of %s is %s", ErrPreconditionFailed, filename, current.ETag)
+		}
+	}
+	// Время в FileInfo передаётся с точностью до секунды
+	if !c.IfUnmodifiedSince.IsZero() && exists && current.UpdatedAt.Truncate(time.Second).After(c.IfUnmodifiedSince) {
+		return fmt.Errorf("%w: %s modified at %s", ErrPreconditionFailed, filename, current.UpdatedAt.Format(time.RFC3339))
+	}
+	return nil
+}
+
+func etagOf(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// fileETag считает etag файла на диске, не читая его целиком в память
+func fileETag(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,111 @@
+package repository
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ---------------------------------------------------------------------
+// Условная запись
+// ---------------------------------------------------------------------
+func TestFilesRepository_SavePreconditions(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("etag changes with content", func(t *testing.T) {
+		repo, _ := setupTestRepo(t)
+		first := mustSave(t, repo, "a.txt", []byte("v1"))
+		second := mustSave(t, repo, "a.txt", []byte("v2"))
+		assert.NotEmpty(t, first.ETag)
+		assert.NotEqual(t, first.ETag, second.ETag)
+		assert.Equal(t, etagOf([]byte("v2")), second.ETag)
+	})
+
+	t.Run("if none match", func(t *testing.T) {
+		repo, _ := setupTestRepo(t)
+		_, err := repo.Save(ctx, "a.txt", []byte("v1"), SaveOptions{Cond: Preconditions{IfNoneMatch: true}})
+		require.NoError(t, err)
+		_, err = repo.Save(ctx, "a.txt", []byte("v2"), SaveOptions{Cond: Preconditions{IfNoneMatch: true}})
+		assert.ErrorIs(t, err, ErrAlreadyExists)
+
+		data, err := repo.Get(ctx, "a.txt")
+		require.NoError(t, err)
+		assert.Equal(t, []byte("v1"), data)
+	})
+
+	t.Run("if match", func(t *testing.T) {
+		repo, _ := setupTestRepo(t)
+		meta := mustSave(t, repo, "a.txt", []byte("v1"))
+
+		_, err := repo.Save(ctx, "a.txt", []byte("v2"), SaveOptions{Cond: Preconditions{IfMatch: meta.ETag}})
+		require.NoError(t, err)
+		// etag уже устарел
+		_, err = repo.Save(ctx, "a.txt", []byte("v3"), SaveOptions{Cond: Preconditions{IfMatch: meta.ETag}})
+		assert.ErrorIs(t, err, ErrPreconditionFailed)
+	})
+
+	t.Run("if match any", func(t *testing.T) {
+		repo, _ := setupTestRepo(t)
+		_, err := repo.Save(ctx, "a.txt", []byte("v1"), SaveOptions{Cond: Preconditions{IfMatch: "*"}})
+		assert.ErrorIs(t, err, ErrPreconditionFailed)
+
+		mustSave(t, repo, "a.txt", []byte("v1"))
+		_, err = repo.Save(ctx, "a.txt", []byte("v2"), SaveOptions{Cond: Preconditions{IfMatch: "*"}})
+		assert.NoError(t, err)
+	})
+
+	t.Run("if unmodified since", func(t *testing.T) {
+		repo, _ := setupTestRepo(t)
+		mustSave(t, repo, "a.txt", []byte("v1"))
+
+		_, err := repo.Save(ctx, "a.txt", []byte("v2"), SaveOptions{Cond: Preconditions{IfUnmodifiedSince: time.Now().Add(-time.Hour)}})
+		assert.ErrorIs(t, err, ErrPreconditionFailed)
+		_, err = repo.Save(ctx, "a.txt", []byte("v2"), SaveOptions{Cond: Preconditions{IfUnmodifiedSince: time.Now()}})
+		assert.NoError(t, err)
+	})
+
+	t.Run("concurrent create only one wins", func(t *testing.T) {
+		repo, _ := setupTestRepo(t)
+		const goroutines = 20
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		created := 0
+		for i := 0; i < goroutines; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := repo.Save(ctx, "a.txt", []byte("data"), SaveOptions{Cond: Preconditions{IfNoneMatch: true}}); err == nil {
+					mu.Lock()
+					created++
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, 1, created)
+	})
+}
+
+// ---------------------------------------------------------------------
+// Reindex восстанавливает etag
+// ---------------------------------------------------------------------
+func TestFilesRepository_ReindexETag(t *testing.T) {
+	dir := t.TempDir()
+	repo, err := NewFilesRepository(dir, Options{})
+	require.NoError(t, err)
+	meta := mustSave(t, repo, "a.txt", []byte("data"))
+
+	restarted, err := NewFilesRepository(dir, Options{})
+	require.NoError(t, err)
+	_, err = restarted.Reindex(context.Background())
+	require.NoError(t, err)
+
+	list, err := restarted.List(context.Background())
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	assert.Equal(t, meta.ETag, list[0].ETag)
+}
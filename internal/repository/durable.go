@@ -0,0 +1,121 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// syncFile - нужен ли fsync содержимого
+func (r *FilesRepository) syncFile() bool {
+	return r.opts.Durability != DurabilityNone
+}
+
+// syncDirs - нужен ли fsync директории после переименования
+func (r *FilesRepository) syncDirs() bool {
+	return r.opts.Durability == "" || r.opts.Durability == DurabilityFull
+}
+
+// writeTemp записывает data во временный файл (TempPrefix) в dir и вернёт
+// его путь. Файл в той же директории, чтобы переименование было атомарным.
+func (r *FilesRepository) writeTemp(dir string, data []byte) (string, error) {
+	f, err := os.CreateTemp(dir, TempPrefix+"*")
+	if err != nil {
+		return "", err
+	}
+	tmp := f.Name()
+	_, err = f.Write(data)
+	if err == nil && r.syncFile() {
+		err = f.Sync()
+	}
+	if err == nil {
+		err = f.Chmod(0644)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	return tmp, nil
+}
+
+// commitTemp атомарно заменяет path временным файлом: читатели видят либо
+// старое, либо новое содержимое целиком
+func (r *FilesRepository) commitTemp(tmp, path string) error {
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if r.syncDirs() {
+		return syncDir(filepath.Dir(path))
+	}
+	return nil
+}
+
+// writeFileAtomic - writeTemp и commitTemp одним вызовом
+func (r *FilesRepository) writeFileAtomic(path string, data []byte) error {
+	tmp, err := r.writeTemp(filepath.Dir(path), data)
+	if err != nil {
+		return err
+	}
+	return r.commitTemp(tmp, path)
+}
+
+// syncDir сбрасывает на диск запись директории (созданные и
+// переименованные файлы). Windows не умеет fsync директории, там
+// переименование сбрасывает сама ФС.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	return errors.Join(err, d.Close())
+}
+
+// RemoveStaleTemp удаляет временные файлы записей, прерванных падением
+// сервера. Вызывается при старте, до приёма запросов: идущих записей ещё
+// нет, поэтому возраст не проверяется. Незавершённые загрузки UploadStream
+// остаются - их можно продолжить.
+func (r *FilesRepository) RemoveStaleTemp(ctx context.Context) ([]string, error) {
+	_, span := tracer.Start(ctx, "FilesRepository.RemoveStaleTemp")
+	defer span.End()
+
+	removed := []string{}
+	for _, dir := range []string{r.storagePath, filepath.Join(r.storagePath, MetaDir)} {
+		entries, err := os.ReadDir(dir)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "read dir failed")
+			return removed, fmt.Errorf("failed to read %s: %w", dir, err)
+		}
+		for _, e := range entries {
+			name := e.Name()
+			if !e.Type().IsRegular() || !strings.HasPrefix(name, TempPrefix) || strings.HasPrefix(name, uploadPrefix) {
+				continue
+			}
+			if err := os.Remove(filepath.Join(dir, name)); err != nil && !os.IsNotExist(err) {
+				return removed, fmt.Errorf("failed to remove %s: %w", name, err)
+			}
+			rel, _ := filepath.Rel(r.storagePath, filepath.Join(dir, name))
+			removed = append(removed, rel)
+		}
+	}
+	span.SetAttributes(attribute.Int("files.removed", len(removed)))
+	return removed, nil
+}
@@ -0,0 +1,95 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ---------------------------------------------------------------------
+// Атомарная запись
+// ---------------------------------------------------------------------
+func TestFilesRepository_DurableSave(t *testing.T) {
+	ctx := context.Background()
+
+	for _, mode := range []DurabilityMode{"", DurabilityNone, DurabilityFile, DurabilityFull} {
+		t.Run("mode "+string(mode), func(t *testing.T) {
+			tmpDir := t.TempDir()
+			repo, err := NewFilesRepository(tmpDir, Options{Durability: mode, Versioning: true})
+			require.NoError(t, err)
+			mustSave(t, repo, "a.txt", []byte("v1"))
+			mustSave(t, repo, "a.txt", []byte("v2"))
+
+			data, err := repo.Get(ctx, "a.txt")
+			require.NoError(t, err)
+			assert.Equal(t, "v2", string(data))
+			info, err := os.Stat(filepath.Join(tmpDir, "a.txt"))
+			require.NoError(t, err)
+			assert.Equal(t, os.FileMode(0644), info.Mode().Perm())
+
+			// Временных файлов не остаётся ни в хранилище, ни в метаданных
+			for _, dir := range []string{tmpDir, filepath.Join(tmpDir, MetaDir)} {
+				entries, err := os.ReadDir(dir)
+				require.NoError(t, err)
+				for _, e := range entries {
+					assert.False(t, strings.HasPrefix(e.Name(), TempPrefix), e.Name())
+				}
+			}
+		})
+	}
+
+	t.Run("readers never see partial content", func(t *testing.T) {
+		repo, _ := setupTestRepo(t)
+		contents := [][]byte{bytes.Repeat([]byte("a"), 1<<20), bytes.Repeat([]byte("b"), 1<<20)}
+		mustSave(t, repo, "big.bin", contents[0])
+
+		var wg sync.WaitGroup
+		done := make(chan struct{})
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				mustSave(t, repo, "big.bin", contents[i%2])
+			}
+			close(done)
+		}()
+		for {
+			select {
+			case <-done:
+				wg.Wait()
+				return
+			default:
+			}
+			data, err := repo.Get(ctx, "big.bin")
+			require.NoError(t, err)
+			assert.True(t, bytes.Equal(data, contents[0]) || bytes.Equal(data, contents[1]), "прочитано %d байт смешанного содержимого", len(data))
+		}
+	})
+}
+
+// ---------------------------------------------------------------------
+// RemoveStaleTemp
+// ---------------------------------------------------------------------
+func TestFilesRepository_RemoveStaleTemp(t *testing.T) {
+	ctx := context.Background()
+	repo, tmpDir := setupTestRepo(t)
+	mustSave(t, repo, "user.txt", []byte("data"))
+
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, TempPrefix+"123"), []byte("x"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, MetaDir, TempPrefix+"456"), []byte("x"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, uploadPrefix+"abc"), []byte("x"), 0644))
+
+	removed, err := repo.RemoveStaleTemp(ctx)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{TempPrefix + "123", filepath.Join(MetaDir, TempPrefix+"456")}, removed)
+	assert.FileExists(t, filepath.Join(tmpDir, uploadPrefix+"abc"), "незавершённую загрузку можно продолжить")
+	assert.FileExists(t, filepath.Join(tmpDir, "user.txt"))
+	assert.FileExists(t, filepath.Join(tmpDir, MetaDir, "user.txt.json"))
+}
@@ -0,0 +1,67 @@
+package repository
+
+import "sync"
+
+// fileLocks - блокировки по имени файла: запись берёт lock, чтение rlock.
+// Файлы с разными именами пишутся и читаются параллельно. Запись в map
+// удаляется, когда блокировку больше никто не держит и не ждёт.
+type fileLocks struct {
+	mu    sync.Mutex
+	locks map[string]*fileLock
+}
+
+type fileLock struct {
+	sync.RWMutex
+	refs int
+}
+
+func newFileLocks() *fileLocks {
+	return &fileLocks{locks: make(map[string]*fileLock)}
+}
+
+func (l *fileLocks) acquire(name string) *fileLock {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fl, ok := l.locks[name]
+	if !ok {
+		fl = &fileLock{}
+		l.locks[name] = fl
+	}
+	fl.refs++
+	return fl
+}
+
+func (l *fileLocks) release(name string, fl *fileLock) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if fl.refs--; fl.refs == 0 {
+		delete(l.locks, name)
+	}
+}
+
+// lock захватывает файл на запись, вернёт функцию освобождения
+func (l *fileLocks) lock(name string) func() {
+	fl := l.acquire(name)
+	fl.Lock()
+	return func() {
+		fl.Unlock()
+		l.release(name, fl)
+	}
+}
+
+// rlock захватывает файл на чтение, вернёт функцию освобождения
+func (l *fileLocks) rlock(name string) func() {
+	fl := l.acquire(name)
+	fl.RLock()
+	return func() {
+		fl.RUnlock()
+		l.release(name, fl)
+	}
+}
+
+// len - сколько имён сейчас заблокировано
+func (l *fileLocks) len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.locks)
+}
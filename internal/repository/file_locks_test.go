@@ -0,0 +1,99 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ---------------------------------------------------------------------
+// fileLocks
+// ---------------------------------------------------------------------
+func TestFileLocks(t *testing.T) {
+	l := newFileLocks()
+
+	unlock := l.lock("a.txt")
+
+	t.Run("other file is not blocked", func(t *testing.T) {
+		done := make(chan struct{})
+		go func() {
+			l.rlock("b.txt")()
+			l.lock("b.txt")()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("блокировка другого файла ждёт")
+		}
+	})
+
+	t.Run("reader waits for writer", func(t *testing.T) {
+		read := make(chan struct{})
+		go func() {
+			l.rlock("a.txt")()
+			close(read)
+		}()
+		select {
+		case <-read:
+			t.Fatal("чтение не дождалось записи")
+		case <-time.After(50 * time.Millisecond):
+		}
+		unlock()
+		<-read
+	})
+
+	assert.Equal(t, 0, l.len(), "освобождённые блокировки удаляются")
+}
+
+// ---------------------------------------------------------------------
+// Чтение во время перезаписи
+// ---------------------------------------------------------------------
+func TestFilesRepository_ReadDuringOverwrite(t *testing.T) {
+	ctx := context.Background()
+	// С версионированием текущий файл на время замены переносится в версии
+	repo, err := NewFilesRepository(t.TempDir(), Options{Versioning: true, Durability: DurabilityNone})
+	require.NoError(t, err)
+	contents := [][]byte{[]byte("first version"), []byte("second version")}
+	mustSave(t, repo, "doc.bin", contents[0])
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	errs := make(chan error, 4)
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				data, err := repo.Get(ctx, "doc.bin")
+				if err == nil && !bytes.Equal(data, contents[0]) && !bytes.Equal(data, contents[1]) {
+					err = fmt.Errorf("прочитано %q", data)
+				}
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	for i := 1; i <= 300; i++ {
+		mustSave(t, repo, "doc.bin", contents[i%2])
+	}
+	close(stop)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("файл не должен пропадать на время записи: %v", err)
+	}
+}
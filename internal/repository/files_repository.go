@@ -7,54 +7,202 @@ import (
 	"path/filepath"
 	"sync"
 	"time"
+
+	"github.com/Hiddan13/file_grpc/internal/tracing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 )
 
+var tracer = otel.Tracer("github.com/Hiddan13/file_grpc/internal/repository")
+
 type FilesRepository struct {
 	storagePath string
+	opts        Options
 	mu          sync.RWMutex
 	metadata    map[string]FileMeta
+	// LastAccessedAt, записанное на диск, для файлов, к которым обращались
+	// после загрузки: в памяти время доступа всегда точное, а relatime
+	// решает по записанному, пора ли сохранить его снова. Под mu.
+	atimeFlushed map[string]time.Time
+
+	// Запись файла (проверка условий, перенос в версии, замена содержимого)
+	// держит writeMu на чтение и блокировку файла в files на запись, чтение
+	// содержимого - только блокировку файла. Обход всего хранилища (Reindex,
+	// очистка версий) берёт writeMu целиком и останавливает все записи.
+	writeMu sync.RWMutex
+	files   *fileLocks
+	// Запись метаданных файла на диск (flushMeta)
+	metaFiles *fileLocks
+
+	// Незавершённые загрузки, открытые сейчас через OpenUpload
+	uploadsMu sync.Mutex
+	uploads   map[string]bool
 }
 
-func NewFilesRepository(storagePath string) (*FilesRepository, error) {
+func NewFilesRepository(storagePath string, opts Options) (*FilesRepository, error) {
 	if err := os.MkdirAll(storagePath, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create repo dir: %w", err)
 	}
 	return &FilesRepository{
-		storagePath: storagePath,
-		metadata:    make(map[string]FileMeta),
+		storagePath:  storagePath,
+		opts:         opts,
+		metadata:     make(map[string]FileMeta),
+		atimeFlushed: make(map[string]time.Time),
+		uploads:      make(map[string]bool),
+		files:        newFileLocks(),
+		metaFiles:    newFileLocks(),
 	}, nil
 }
 
-func (r *FilesRepository) Save(ctx context.Context, filename string, data []byte) error {
+// lockFile захватывает файл на запись и не даёт начаться обходу хранилища
+func (r *FilesRepository) lockFile(filename string) func() {
+	r.writeMu.RLock()
+	unlock := r.files.lock(filename)
+	return func() {
+		unlock()
+		r.writeMu.RUnlock()
+	}
+}
+
+// Save записывает файл. Условия opts.Cond проверяются атомарно с записью:
+// при их нарушении вернётся ErrAlreadyExists или ErrPreconditionFailed.
+func (r *FilesRepository) Save(ctx context.Context, filename string, data []byte, opts SaveOptions) (FileMeta, error) {
+	_, span := tracer.Start(ctx, "FilesRepository.Save")
+	defer span.End()
+	span.SetAttributes(tracing.AttrFilename.String(filename), tracing.AttrSize.Int(len(data)))
+
+	// Загрузка отменена (например, при остановке сервера) - не трогаем диск
+	if err := ctx.Err(); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "cancelled")
+		return FileMeta{}, fmt.Errorf("save cancelled: %w", err)
+	}
+
+	defer r.lockFile(filename)()
+
+	r.mu.RLock()
+	current, exists := r.metadata[filename]
+	r.mu.RUnlock()
+	if err := opts.Cond.check(filename, current, exists); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "precondition failed")
+		return FileMeta{}, err
+	}
+
 	fullPath := filepath.Join(r.storagePath, filename)
-	if err := os.WriteFile(fullPath, data, 0644); err != nil {
-		return fmt.Errorf("failed to write file: %w", err)
+
+	// Сначала пишем временный файл: пока он не готов, текущий не трогаем
+	tmp, err := r.writeTemp(r.storagePath, data)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "write failed")
+		return FileMeta{}, fmt.Errorf("failed to write file: %w", err)
 	}
 
-	r.mu.Lock()
-	defer r.mu.Unlock()
-	now := time.Now()
-	if meta, exists := r.metadata[filename]; exists {
-		meta.UpdatedAt = now
-		meta.Size = int64(len(data))
-		r.metadata[filename] = meta
-	} else {
-		r.metadata[filename] = FileMeta{
-			Filename:  filename,
-			CreatedAt: now,
-			UpdatedAt: now,
-			Size:      int64(len(data)),
+	// При версионировании текущее содержимое переносится в версии,
+	// а при ошибке записи возвращается на место
+	var undoArchive func()
+	if r.opts.Versioning {
+		if undoArchive, err = r.archiveCurrent(filename); err != nil {
+			os.Remove(tmp)
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "archive failed")
+			return FileMeta{}, fmt.Errorf("failed to keep previous version: %w", err)
 		}
 	}
-	return nil
+
+	if err := r.commitTemp(tmp, fullPath); err != nil {
+		if undoArchive != nil {
+			undoArchive()
+		}
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "write failed")
+		return FileMeta{}, fmt.Errorf("failed to write file: %w", err)
+	}
+	if undoArchive != nil && r.syncDirs() {
+		if err := syncDir(r.versionsPath(filename)); err != nil {
+			span.RecordError(err)
+		}
+	}
+
+	now := time.Now()
+	meta := current
+	if !exists {
+		meta = FileMeta{Filename: filename, CreatedAt: now}
+	}
+	meta.UpdatedAt = now
+	meta.Size = int64(len(data))
+	meta.ETag = etagOf(data)
+	if opts.Metadata != nil {
+		meta.Metadata = opts.Metadata
+	}
+	if opts.Tags != nil {
+		meta.Tags = opts.Tags
+	}
+	if opts.ContentType != "" {
+		meta.ContentType = opts.ContentType
+	}
+	if !opts.ExpiresAt.IsZero() {
+		meta.ExpiresAt = opts.ExpiresAt
+	}
+
+	meta, err = r.storeMeta(meta)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "meta write failed")
+		return meta, fmt.Errorf("failed to write metadata: %w", err)
+	}
+	return meta, nil
 }
 
 func (r *FilesRepository) Get(ctx context.Context, filename string) ([]byte, error) {
-	fullPath := filepath.Join(r.storagePath, filename)
-	data, err := os.ReadFile(fullPath)
+	_, span := tracer.Start(ctx, "FilesRepository.Get")
+	defer span.End()
+	span.SetAttributes(tracing.AttrFilename.String(filename))
+
+	// Не читаем посреди замены файла при версионировании
+	defer r.files.rlock(filename)()
+	data, err := r.readFile(filename)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "read failed")
+		return nil, err
+	}
+	span.SetAttributes(tracing.AttrSize.Int(len(data)))
+	return data, nil
+}
+
+// GetWithMeta вернёт содержимое файла и его метаданные, прочитанные под
+// одной блокировкой: Save меняет их вместе, и по отдельности после
+// параллельной перезаписи они могли бы описывать разные версии. У файла,
+// появившегося в обход Save, метаданных может не быть - вернутся пустые.
+func (r *FilesRepository) GetWithMeta(ctx context.Context, filename string) ([]byte, FileMeta, error) {
+	_, span := tracer.Start(ctx, "FilesRepository.GetWithMeta")
+	defer span.End()
+	span.SetAttributes(tracing.AttrFilename.String(filename))
+
+	defer r.files.rlock(filename)()
+	data, err := r.readFile(filename)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "read failed")
+		return nil, FileMeta{}, err
+	}
+	r.mu.RLock()
+	meta := r.metadata[filename].clone()
+	r.mu.RUnlock()
+	span.SetAttributes(tracing.AttrSize.Int(len(data)))
+	return data, meta, nil
+}
+
+// readFile читает текущее содержимое файла, вызывается под блокировкой файла
+func (r *FilesRepository) readFile(filename string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(r.storagePath, filename))
 	if err != nil {
 		if os.IsNotExist(err) {
-			return nil, fmt.Errorf("file not found: %s", filename)
+			return nil, fmt.Errorf("file %w: %s", ErrNotFound, filename)
 		}
 		return nil, fmt.Errorf("failed to read file: %w", err)
 	}
@@ -62,22 +210,144 @@ func (r *FilesRepository) Get(ctx context.Context, filename string) ([]byte, err
 }
 
 func (r *FilesRepository) List(ctx context.Context) ([]FileMeta, error) {
+	_, span := tracer.Start(ctx, "FilesRepository.List")
+	defer span.End()
+
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
 	list := make([]FileMeta, 0, len(r.metadata))
 	for _, meta := range r.metadata {
-		list = append(list, meta)
+		list = append(list, meta.clone())
 	}
+	span.SetAttributes(attribute.Int("files.count", len(list)))
 	return list, nil
 }
 
-func (r *FilesRepository) UpdateAccess(ctx context.Context, filename string) error {
+func (r *FilesRepository) Stat(ctx context.Context, filename string) (FileMeta, error) {
+	_, span := tracer.Start(ctx, "FilesRepository.Stat")
+	defer span.End()
+	span.SetAttributes(tracing.AttrFilename.String(filename))
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	meta, exists := r.metadata[filename]
+	if !exists {
+		span.SetStatus(codes.Error, "not found")
+		return FileMeta{}, fmt.Errorf("file %w: %s", ErrNotFound, filename)
+	}
+	return meta.clone(), nil
+}
+
+// SetMetadata заменяет пользовательские метаданные и теги файла
+func (r *FilesRepository) SetMetadata(ctx context.Context, filename string, metadata map[string]string, tags []string) (FileMeta, error) {
+	_, span := tracer.Start(ctx, "FilesRepository.SetMetadata")
+	defer span.End()
+	span.SetAttributes(tracing.AttrFilename.String(filename))
+
+	defer r.lockFile(filename)()
+
+	r.mu.RLock()
+	meta, exists := r.metadata[filename]
+	r.mu.RUnlock()
+	if !exists {
+		span.SetStatus(codes.Error, "not found")
+		return FileMeta{}, fmt.Errorf("file %w: %s", ErrNotFound, filename)
+	}
+	meta.Metadata = metadata
+	meta.Tags = tags
+
+	meta, err := r.storeMeta(meta)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "meta write failed")
+		return meta, fmt.Errorf("failed to write metadata: %w", err)
+	}
+	return meta, nil
+}
+
+// Delete удаляет файл и его метаданные, если он удовлетворяет условиям cond
+// (проверяются атомарно с удалением). При версионировании содержимое
+// сохраняется как версия и его можно вернуть через RestoreVersion.
+func (r *FilesRepository) Delete(ctx context.Context, filename string, cond Preconditions) error {
+	_, span := tracer.Start(ctx, "FilesRepository.Delete")
+	defer span.End()
+	span.SetAttributes(tracing.AttrFilename.String(filename))
+
+	defer r.lockFile(filename)()
+
+	r.mu.RLock()
+	current, exists := r.metadata[filename]
+	r.mu.RUnlock()
+	if !exists {
+		span.SetStatus(codes.Error, "not found")
+		return fmt.Errorf("file %w: %s", ErrNotFound, filename)
+	}
+	if err := cond.check(filename, current, exists); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "precondition failed")
+		return err
+	}
+
+	var err error
+	if r.opts.Versioning {
+		_, err = r.archiveCurrent(filename)
+	} else if err = os.Remove(filepath.Join(r.storagePath, filename)); os.IsNotExist(err) {
+		err = nil
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "remove failed")
+		return fmt.Errorf("failed to delete file: %w", err)
+	}
+
+	r.forget(filename)
+	return nil
+}
+
+// UpdateAccess учитывает скачивание файла. Счётчики меняются в памяти и
+// попадают на диск со следующей записью метаданных. LastAccessedAt (кроме
+// режима off) в памяти обновляется всегда, чтобы очистка по правилам видела
+// настоящее время доступа, а на диск по режиму Options.AccessTime метаданные
+// сохраняются сразу (уже без r.mu).
+func (r *FilesRepository) UpdateAccess(ctx context.Context, filename string, bytesServed int64) error {
+	_, span := tracer.Start(ctx, "FilesRepository.UpdateAccess")
+	defer span.End()
+	span.SetAttributes(tracing.AttrFilename.String(filename), tracing.AttrSize.Int64(bytesServed))
+
 	r.mu.Lock()
-	defer r.mu.Unlock()
-	if meta, exists := r.metadata[filename]; exists {
-		meta.UpdatedAt = time.Now()
-		r.metadata[filename] = meta
+	meta, exists := r.metadata[filename]
+	if !exists {
+		r.mu.Unlock()
+		return nil
+	}
+	meta.DownloadCount++
+	meta.BytesServed += bytesServed
+
+	now := time.Now()
+	touch := false
+	if r.opts.AccessTime == AccessTimeStrict || r.opts.AccessTime == AccessTimeRelatime {
+		// До первого обращения на диске то же, что в памяти
+		flushed, ok := r.atimeFlushed[filename]
+		if !ok {
+			flushed = meta.LastAccessedAt
+			r.atimeFlushed[filename] = flushed
+		}
+		touch = r.opts.AccessTime == AccessTimeStrict ||
+			flushed.Before(meta.UpdatedAt) || now.Sub(flushed) >= RelatimeInterval
+		meta.LastAccessedAt = now
+	}
+	r.metadata[filename] = meta
+	r.mu.Unlock()
+	if !touch {
+		return nil
+	}
+
+	span.SetAttributes(attribute.Bool("file.atime_written", true))
+	if err := r.flushMeta(filename); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "meta write failed")
+		return fmt.Errorf("failed to write metadata: %w", err)
 	}
 	return nil
 }
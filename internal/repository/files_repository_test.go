@@ -19,18 +19,25 @@ import (
 func setupTestRepo(t *testing.T) (*FilesRepository, string) {
 	t.Helper()
 	tmpDir := t.TempDir() // автоматически удалится после теста
-	repo, err := NewFilesRepository(tmpDir)
+	repo, err := NewFilesRepository(tmpDir, Options{})
 	require.NoError(t, err)
 	return repo, tmpDir
 }
 
+func mustSave(t *testing.T, repo *FilesRepository, filename string, data []byte) FileMeta {
+	t.Helper()
+	meta, err := repo.Save(context.Background(), filename, data, SaveOptions{})
+	require.NoError(t, err)
+	return meta
+}
+
 // ---------------------------------------------------------------------
 // Создание репозитория
 // ---------------------------------------------------------------------
 func TestNewFilesRepository(t *testing.T) {
 	t.Run("successfully creates directory", func(t *testing.T) {
 		tmpDir := t.TempDir()
-		repo, err := NewFilesRepository(tmpDir)
+		repo, err := NewFilesRepository(tmpDir, Options{})
 		require.NoError(t, err)
 		assert.NotNil(t, repo)
 		assert.Equal(t, tmpDir, repo.storagePath)
@@ -44,7 +51,7 @@ func TestNewFilesRepository(t *testing.T) {
 	t.Run("fails on invalid path", func(t *testing.T) {
 		// Попытка создать директорию в защищённом месте вызовет ошибку
 		// (зависит от ОС, пропускаем, если нет прав)
-		_, err := NewFilesRepository("/invalid/protected/path")
+		_, err := NewFilesRepository("/invalid/protected/path", Options{})
 		if err != nil {
 			assert.Error(t, err)
 		}
@@ -62,7 +69,7 @@ func TestFilesRepository_Save(t *testing.T) {
 		filename := "new.txt"
 		data := []byte("hello world")
 
-		err := repo.Save(ctx, filename, data)
+		_, err := repo.Save(ctx, filename, data, SaveOptions{})
 		require.NoError(t, err)
 
 		// Проверяем, что файл создан на диске
@@ -89,7 +96,7 @@ func TestFilesRepository_Save(t *testing.T) {
 		newData := []byte("new content longer")
 
 		// Сохраняем первый раз
-		err := repo.Save(ctx, filename, oldData)
+		_, err := repo.Save(ctx, filename, oldData, SaveOptions{})
 		require.NoError(t, err)
 
 		// Запоминаем время создания
@@ -101,7 +108,7 @@ func TestFilesRepository_Save(t *testing.T) {
 		time.Sleep(10 * time.Millisecond)
 
 		// Обновляем файл
-		err = repo.Save(ctx, filename, newData)
+		_, err = repo.Save(ctx, filename, newData, SaveOptions{})
 		require.NoError(t, err)
 
 		// Проверяем содержимое на диске
@@ -122,10 +129,24 @@ func TestFilesRepository_Save(t *testing.T) {
 
 	t.Run("save with empty data", func(t *testing.T) {
 		repo, _ := setupTestRepo(t)
-		err := repo.Save(ctx, "empty.txt", []byte{})
+		_, err := repo.Save(ctx, "empty.txt", []byte{}, SaveOptions{})
 		require.NoError(t, err) // репозиторий позволяет сохранять пустые файлы
 		// проверка что файл создан (0 байт)
 	})
+
+	t.Run("cancelled context does not touch disk", func(t *testing.T) {
+		repo, tmpDir := setupTestRepo(t)
+		cctx, cancel := context.WithCancel(ctx)
+		cancel()
+
+		_, err := repo.Save(cctx, "cancelled.txt", []byte("data"), SaveOptions{})
+		assert.ErrorIs(t, err, context.Canceled)
+
+		_, err = os.Stat(filepath.Join(tmpDir, "cancelled.txt"))
+		assert.True(t, os.IsNotExist(err))
+		list, _ := repo.List(ctx)
+		assert.Empty(t, list)
+	})
 }
 
 // ---------------------------------------------------------------------
@@ -138,7 +159,7 @@ func TestFilesRepository_Get(t *testing.T) {
 		repo, _ := setupTestRepo(t)
 		filename := "exists.txt"
 		data := []byte("some data")
-		err := repo.Save(ctx, filename, data)
+		_, err := repo.Save(ctx, filename, data, SaveOptions{})
 		require.NoError(t, err)
 
 		got, err := repo.Get(ctx, filename)
@@ -151,6 +172,20 @@ func TestFilesRepository_Get(t *testing.T) {
 		_, err := repo.Get(ctx, "missing.txt")
 		assert.Error(t, err)
 		assert.Contains(t, err.Error(), "file not found")
+		_, _, err = repo.GetWithMeta(ctx, "missing.txt")
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("with metadata", func(t *testing.T) {
+		repo, _ := setupTestRepo(t)
+		saved, err := repo.Save(ctx, "a.json", []byte("{}"), SaveOptions{ContentType: "application/json"})
+		require.NoError(t, err)
+
+		data, meta, err := repo.GetWithMeta(ctx, "a.json")
+		require.NoError(t, err)
+		assert.Equal(t, []byte("{}"), data)
+		assert.Equal(t, saved.ETag, meta.ETag)
+		assert.Equal(t, "application/json", meta.ContentType)
 	})
 }
 
@@ -179,7 +214,7 @@ func TestFilesRepository_List(t *testing.T) {
 			{"c.go", []byte("ccc")},
 		}
 		for _, f := range files {
-			err := repo.Save(ctx, f.name, f.data)
+			_, err := repo.Save(ctx, f.name, f.data, SaveOptions{})
 			require.NoError(t, err)
 			time.Sleep(2 * time.Millisecond) // чтобы created_at различалось
 		}
@@ -210,32 +245,118 @@ func TestFilesRepository_List(t *testing.T) {
 func TestFilesRepository_UpdateAccess(t *testing.T) {
 	ctx := context.Background()
 
-	t.Run("update existing file", func(t *testing.T) {
+	t.Run("counts downloads without touching UpdatedAt", func(t *testing.T) {
 		repo, _ := setupTestRepo(t)
-		filename := "access.txt"
-		err := repo.Save(ctx, filename, []byte("data"))
+		saved := mustSave(t, repo, "access.txt", []byte("data"))
+
+		require.NoError(t, repo.UpdateAccess(ctx, "access.txt", 4))
+		require.NoError(t, repo.UpdateAccess(ctx, "access.txt", 4))
+
+		meta, err := repo.Stat(ctx, "access.txt")
 		require.NoError(t, err)
+		assert.Equal(t, saved.CreatedAt, meta.CreatedAt)
+		assert.Equal(t, saved.UpdatedAt, meta.UpdatedAt)
+		assert.Equal(t, int64(2), meta.DownloadCount)
+		assert.Equal(t, int64(8), meta.BytesServed)
+		// Режим по умолчанию - off
+		assert.True(t, meta.LastAccessedAt.IsZero())
+	})
 
-		repo.mu.RLock()
-		oldMeta := repo.metadata[filename]
-		repo.mu.RUnlock()
+	t.Run("strict persists every access", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		repo, err := NewFilesRepository(tmpDir, Options{AccessTime: AccessTimeStrict})
+		require.NoError(t, err)
+		mustSave(t, repo, "a.txt", []byte("data"))
 
-		time.Sleep(5 * time.Millisecond)
+		require.NoError(t, repo.UpdateAccess(ctx, "a.txt", 4))
+		first, _ := repo.readMeta("a.txt")
+		assert.False(t, first.LastAccessedAt.IsZero())
+		assert.Equal(t, int64(1), first.DownloadCount)
+
+		require.NoError(t, repo.UpdateAccess(ctx, "a.txt", 4))
+		second, _ := repo.readMeta("a.txt")
+		assert.Equal(t, int64(2), second.DownloadCount)
+
+		// Статистика переживает перезапуск
+		reopened, err := NewFilesRepository(tmpDir, Options{})
+		require.NoError(t, err)
+		_, err = reopened.Reindex(ctx)
+		require.NoError(t, err)
+		meta, err := reopened.Stat(ctx, "a.txt")
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), meta.DownloadCount)
+		assert.Equal(t, int64(8), meta.BytesServed)
+	})
 
-		err = repo.UpdateAccess(ctx, filename)
+	t.Run("relatime skips recent accesses", func(t *testing.T) {
+		repo, err := NewFilesRepository(t.TempDir(), Options{AccessTime: AccessTimeRelatime})
 		require.NoError(t, err)
+		mustSave(t, repo, "a.txt", []byte("data"))
 
-		repo.mu.RLock()
-		newMeta := repo.metadata[filename]
-		repo.mu.RUnlock()
+		require.NoError(t, repo.UpdateAccess(ctx, "a.txt", 4))
+		first, err := repo.Stat(ctx, "a.txt")
+		require.NoError(t, err)
+		require.False(t, first.LastAccessedAt.IsZero())
 
-		assert.Equal(t, oldMeta.CreatedAt, newMeta.CreatedAt)
-		assert.True(t, newMeta.UpdatedAt.After(oldMeta.UpdatedAt))
+		// В памяти время доступа точное, на диске остаётся первое
+		time.Sleep(5 * time.Millisecond)
+		require.NoError(t, repo.UpdateAccess(ctx, "a.txt", 4))
+		second, err := repo.Stat(ctx, "a.txt")
+		require.NoError(t, err)
+		assert.True(t, second.LastAccessedAt.After(first.LastAccessedAt))
+		assert.Equal(t, int64(2), second.DownloadCount)
+		stored, _ := repo.readMeta("a.txt")
+		assert.Equal(t, int64(1), stored.DownloadCount)
+		assert.True(t, stored.LastAccessedAt.Equal(first.LastAccessedAt))
+
+		// При остановке время доступа из памяти сохраняется
+		require.NoError(t, repo.FlushAccessTimes())
+		stored, _ = repo.readMeta("a.txt")
+		assert.True(t, stored.LastAccessedAt.Equal(second.LastAccessedAt))
+		assert.Equal(t, int64(2), stored.DownloadCount)
+
+		// После перезаписи первое скачивание снова обновляет время
+		time.Sleep(5 * time.Millisecond)
+		mustSave(t, repo, "a.txt", []byte("new"))
+		require.NoError(t, repo.UpdateAccess(ctx, "a.txt", 3))
+		third, err := repo.Stat(ctx, "a.txt")
+		require.NoError(t, err)
+		assert.True(t, third.LastAccessedAt.After(second.LastAccessedAt))
+		assert.Equal(t, int64(3), third.DownloadCount)
+		stored, _ = repo.readMeta("a.txt")
+		assert.True(t, stored.LastAccessedAt.Equal(third.LastAccessedAt))
+	})
+
+	t.Run("concurrent metadata writes keep counters", func(t *testing.T) {
+		repo, err := NewFilesRepository(t.TempDir(), Options{AccessTime: AccessTimeStrict})
+		require.NoError(t, err)
+		mustSave(t, repo, "a.txt", []byte("data"))
+
+		const n = 50
+		var wg sync.WaitGroup
+		for i := range n {
+			wg.Go(func() { assert.NoError(t, repo.UpdateAccess(ctx, "a.txt", 4)) })
+			wg.Go(func() {
+				_, err := repo.SetMetadata(ctx, "a.txt", map[string]string{"i": fmt.Sprint(i)}, nil)
+				assert.NoError(t, err)
+			})
+		}
+		wg.Wait()
+
+		meta, err := repo.Stat(ctx, "a.txt")
+		require.NoError(t, err)
+		assert.Equal(t, int64(n), meta.DownloadCount)
+		assert.Equal(t, int64(4*n), meta.BytesServed)
+		stored, _ := repo.readMeta("a.txt")
+		// На диске - последняя копия
+		assert.Equal(t, meta.DownloadCount, stored.DownloadCount)
+		assert.Equal(t, meta.Metadata, stored.Metadata)
+		assert.True(t, meta.LastAccessedAt.Equal(stored.LastAccessedAt))
 	})
 
 	t.Run("update non-existing file", func(t *testing.T) {
 		repo, _ := setupTestRepo(t)
-		err := repo.UpdateAccess(ctx, "ghost.txt")
+		err := repo.UpdateAccess(ctx, "ghost.txt", 1)
 		require.NoError(t, err) // метод не возвращает ошибку, просто ничего не делает
 		// можно дополнительно проверить, что файл не появился
 		_, err = repo.Get(ctx, "ghost.txt")
@@ -260,7 +381,7 @@ func TestFilesRepository_ConcurrentAccess(t *testing.T) {
 			defer wg.Done()
 			// Уникальное имя файла (включает индекс)
 			filename := fmt.Sprintf("concurrent_%d.txt", n)
-			err := repo.Save(ctx, filename, []byte("data"))
+			_, err := repo.Save(ctx, filename, []byte("data"), SaveOptions{})
 			if err != nil {
 				errCh <- err
 			}
@@ -278,3 +399,116 @@ func TestFilesRepository_ConcurrentAccess(t *testing.T) {
 	require.NoError(t, err)
 	assert.Len(t, list, goroutines)
 }
+
+// ---------------------------------------------------------------------
+// Reindex
+// ---------------------------------------------------------------------
+func TestFilesRepository_Reindex(t *testing.T) {
+	ctx := context.Background()
+	repo, tmpDir := setupTestRepo(t)
+
+	mustSave(t, repo, "kept.txt", []byte("kept"))
+	mustSave(t, repo, "deleted.txt", []byte("deleted"))
+	repo.mu.RLock()
+	keptMeta := repo.metadata["kept.txt"]
+	repo.mu.RUnlock()
+
+	// Изменения на диске в обход репозитория
+	require.NoError(t, os.Remove(filepath.Join(tmpDir, "deleted.txt")))
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "external.txt"), []byte("external"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, TempPrefix+"upload"), []byte("tmp"), 0644))
+	require.NoError(t, os.Mkdir(filepath.Join(tmpDir, "subdir"), 0755))
+
+	res, err := repo.Reindex(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, ReindexResult{Files: 2, Added: 1, Removed: 1}, res)
+
+	list, err := repo.List(ctx)
+	require.NoError(t, err)
+	metaMap := make(map[string]FileMeta)
+	for _, m := range list {
+		metaMap[m.Filename] = m
+	}
+	assert.Len(t, metaMap, 2)
+	assert.Equal(t, keptMeta.CreatedAt, metaMap["kept.txt"].CreatedAt) // существующие метаданные сохраняются
+	assert.Equal(t, int64(len("external")), metaMap["external.txt"].Size)
+}
+
+// ---------------------------------------------------------------------
+// CollectGarbage
+// ---------------------------------------------------------------------
+func TestFilesRepository_CollectGarbage(t *testing.T) {
+	ctx := context.Background()
+	repo, tmpDir := setupTestRepo(t)
+
+	old := filepath.Join(tmpDir, TempPrefix+"old")
+	fresh := filepath.Join(tmpDir, TempPrefix+"fresh")
+	require.NoError(t, os.WriteFile(old, []byte("x"), 0644))
+	require.NoError(t, os.WriteFile(fresh, []byte("x"), 0644))
+	require.NoError(t, os.Chtimes(old, time.Now().Add(-2*time.Hour), time.Now().Add(-2*time.Hour)))
+	mustSave(t, repo, "user.txt", []byte("data"))
+
+	t.Run("dry run", func(t *testing.T) {
+		removed, err := repo.CollectGarbage(ctx, time.Hour, true)
+		require.NoError(t, err)
+		assert.Equal(t, []string{TempPrefix + "old"}, removed)
+		assert.FileExists(t, old)
+	})
+
+	t.Run("removes only old temp files", func(t *testing.T) {
+		removed, err := repo.CollectGarbage(ctx, time.Hour, false)
+		require.NoError(t, err)
+		assert.Equal(t, []string{TempPrefix + "old"}, removed)
+		assert.NoFileExists(t, old)
+		assert.FileExists(t, fresh)
+		assert.FileExists(t, filepath.Join(tmpDir, "user.txt"))
+	})
+}
+
+// ---------------------------------------------------------------------
+// Delete
+// ---------------------------------------------------------------------
+func TestFilesRepository_Delete(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("removes file and metadata", func(t *testing.T) {
+		repo, tmpDir := setupTestRepo(t)
+		meta := mustSave(t, repo, "a.txt", []byte("data"))
+
+		require.NoError(t, repo.Delete(ctx, "a.txt", Preconditions{IfMatch: meta.ETag}))
+		assert.NoFileExists(t, filepath.Join(tmpDir, "a.txt"))
+		assert.NoFileExists(t, repo.metaPath("a.txt"))
+		_, err := repo.Stat(ctx, "a.txt")
+		assert.ErrorIs(t, err, ErrNotFound)
+
+		assert.ErrorIs(t, repo.Delete(ctx, "a.txt", Preconditions{}), ErrNotFound)
+	})
+
+	t.Run("precondition failed", func(t *testing.T) {
+		repo, tmpDir := setupTestRepo(t)
+		mustSave(t, repo, "a.txt", []byte("data"))
+
+		err := repo.Delete(ctx, "a.txt", Preconditions{IfMatch: etagOf([]byte("other"))})
+		assert.ErrorIs(t, err, ErrPreconditionFailed)
+		assert.FileExists(t, filepath.Join(tmpDir, "a.txt"))
+	})
+
+	t.Run("versioning keeps content", func(t *testing.T) {
+		repo, _ := setupVersionedRepo(t)
+		mustSave(t, repo, "a.txt", []byte("data"))
+
+		require.NoError(t, repo.Delete(ctx, "a.txt", Preconditions{}))
+		data, err := repo.GetVersion(ctx, "a.txt", 1)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("data"), data)
+	})
+
+	t.Run("ttl is kept on overwrite", func(t *testing.T) {
+		repo, _ := setupTestRepo(t)
+		expires := time.Now().Add(time.Hour)
+		_, err := repo.Save(ctx, "a.txt", []byte("v1"), SaveOptions{ExpiresAt: expires})
+		require.NoError(t, err)
+		meta := mustSave(t, repo, "a.txt", []byte("v2"))
+		assert.True(t, meta.ExpiresAt.Equal(expires))
+	})
+}
@@ -0,0 +1,162 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// Reindex сверяет метаданные с содержимым storagePath: добавляет файлы,
+// которых нет в памяти (из MetaDir, а без него CreatedAt берётся из mtime),
+// обновляет размер и etag и убирает записи об отсутствующих файлах.
+func (r *FilesRepository) Reindex(ctx context.Context) (ReindexResult, error) {
+	_, span := tracer.Start(ctx, "FilesRepository.Reindex")
+	defer span.End()
+
+	// Не даём записи изменить файлы, пока считаем etag
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+
+	entries, err := os.ReadDir(r.storagePath)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "read dir failed")
+		return ReindexResult{}, fmt.Errorf("failed to read repo dir: %w", err)
+	}
+
+	type diskFile struct {
+		info      os.FileInfo
+		etag      string
+		stored    FileMeta
+		hasStored bool
+	}
+	onDisk := make(map[string]diskFile, len(entries))
+	for _, e := range entries {
+		if !e.Type().IsRegular() || strings.HasPrefix(e.Name(), TempPrefix) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			// Файл удалили между ReadDir и Info
+			continue
+		}
+		etag, err := fileETag(filepath.Join(r.storagePath, e.Name()))
+		if err != nil {
+			continue
+		}
+		stored, hasStored := r.readMeta(e.Name())
+		onDisk[e.Name()] = diskFile{info: info, etag: etag, stored: stored, hasStored: hasStored}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var res ReindexResult
+	for name := range r.metadata {
+		if _, ok := onDisk[name]; !ok {
+			delete(r.metadata, name)
+			res.Removed++
+		}
+	}
+	for name, f := range onDisk {
+		meta, exists := r.metadata[name]
+		if !exists {
+			if f.hasStored {
+				meta = f.stored
+			} else {
+				meta = FileMeta{
+					Filename:  name,
+					CreatedAt: f.info.ModTime(),
+					UpdatedAt: f.info.ModTime(),
+				}
+			}
+			res.Added++
+		}
+		meta.Size = f.info.Size()
+		meta.ETag = f.etag
+		r.metadata[name] = meta
+	}
+	res.Files = len(r.metadata)
+	r.removeOrphanMeta(func(name string) bool { _, ok := onDisk[name]; return ok })
+
+	span.SetAttributes(
+		attribute.Int("files.count", res.Files),
+		attribute.Int("files.added", res.Added),
+		attribute.Int("files.removed", res.Removed),
+	)
+	return res, nil
+}
+
+// removeOrphanMeta удаляет сохранённые метаданные файлов, которых нет
+func (r *FilesRepository) removeOrphanMeta(exists func(name string) bool) {
+	entries, err := os.ReadDir(filepath.Join(r.storagePath, MetaDir))
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ".json")
+		if ok && !exists(name) {
+			r.removeMeta(name)
+		}
+	}
+}
+
+// CollectGarbage удаляет временные файлы (TempPrefix), оставшиеся после
+// прерванных операций. Свежие файлы моложе olderThan не трогаем - они
+// могут принадлежать идущей загрузке. Незавершённая загрузка, открытая
+// сейчас через OpenUpload, не удаляется, сколько бы клиент ни молчал.
+func (r *FilesRepository) CollectGarbage(ctx context.Context, olderThan time.Duration, dryRun bool) ([]string, error) {
+	_, span := tracer.Start(ctx, "FilesRepository.CollectGarbage")
+	defer span.End()
+
+	entries, err := os.ReadDir(r.storagePath)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "read dir failed")
+		return nil, fmt.Errorf("failed to read repo dir: %w", err)
+	}
+
+	removed := []string{}
+	deadline := time.Now().Add(-olderThan)
+	for _, e := range entries {
+		if !e.Type().IsRegular() || !strings.HasPrefix(e.Name(), TempPrefix) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil || info.ModTime().After(deadline) {
+			continue
+		}
+		ok, err := r.removeGarbage(e.Name(), dryRun)
+		if err != nil {
+			return removed, fmt.Errorf("failed to remove %s: %w", e.Name(), err)
+		}
+		if ok {
+			removed = append(removed, e.Name())
+		}
+	}
+	span.SetAttributes(attribute.Int("files.removed", len(removed)), attribute.Bool("dry_run", dryRun))
+	return removed, nil
+}
+
+// removeGarbage удаляет временный файл name; false - это открытая сейчас
+// загрузка. uploadsMu держится до удаления, чтобы загрузку не открыли
+// между проверкой и удалением.
+func (r *FilesRepository) removeGarbage(name string, dryRun bool) (bool, error) {
+	r.uploadsMu.Lock()
+	defer r.uploadsMu.Unlock()
+	if id, ok := strings.CutPrefix(name, uploadPrefix); ok && r.uploads[id] {
+		return false, nil
+	}
+	if dryRun {
+		return true, nil
+	}
+	if err := os.Remove(filepath.Join(r.storagePath, name)); err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	return true, nil
+}
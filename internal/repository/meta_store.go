@@ -0,0 +1,124 @@
+package repository
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// clone копирует метаданные вместе с map и slice, чтобы вызывающий код не
+// менял состояние репозитория
+func (m FileMeta) clone() FileMeta {
+	if m.Metadata != nil {
+		md := make(map[string]string, len(m.Metadata))
+		for k, v := range m.Metadata {
+			md[k] = v
+		}
+		m.Metadata = md
+	}
+	m.Tags = slices.Clone(m.Tags)
+	return m
+}
+
+// storeMeta сохраняет метаданные в памяти и на диске. Счётчики скачиваний
+// и время доступа берутся из памяти: UpdateAccess меняет их без блокировки
+// файла, и meta, прочитанная вызывающим до этого, их бы откатила. Вернёт
+// сохранённые метаданные.
+func (r *FilesRepository) storeMeta(meta FileMeta) (FileMeta, error) {
+	r.mu.Lock()
+	if cur, ok := r.metadata[meta.Filename]; ok {
+		meta.DownloadCount, meta.BytesServed = cur.DownloadCount, cur.BytesServed
+		if cur.LastAccessedAt.After(meta.LastAccessedAt) {
+			meta.LastAccessedAt = cur.LastAccessedAt
+		}
+	}
+	r.metadata[meta.Filename] = meta.clone()
+	r.mu.Unlock()
+	return meta, r.flushMeta(meta.Filename)
+}
+
+// flushMeta записывает на диск текущие метаданные файла из памяти. Запись
+// идёт без r.mu, так что медленный диск не останавливает List и Stat
+// остальных файлов. Блокировка файла в metaFiles упорядочивает записи:
+// каждая пишет копию не старее изменения, после которого вызвана, поэтому
+// на диске не остаётся более старая.
+func (r *FilesRepository) flushMeta(filename string) error {
+	defer r.metaFiles.lock(filename)()
+	r.mu.RLock()
+	meta, ok := r.metadata[filename]
+	meta = meta.clone()
+	r.mu.RUnlock()
+	if !ok {
+		// Файл удалён - метаданные убрал forget
+		return nil
+	}
+	if err := r.writeMeta(meta); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	if _, ok := r.atimeFlushed[filename]; ok {
+		r.atimeFlushed[filename] = meta.LastAccessedAt
+	}
+	r.mu.Unlock()
+	return nil
+}
+
+// FlushAccessTimes сохраняет на диск время доступа, которое relatime
+// держал только в памяти. Вызывается при остановке, чтобы после перезапуска
+// очистка по правилам не сочла недавно скачанные файлы заброшенными.
+func (r *FilesRepository) FlushAccessTimes() error {
+	r.mu.RLock()
+	var stale []string
+	for name, flushed := range r.atimeFlushed {
+		if meta, ok := r.metadata[name]; ok && meta.LastAccessedAt.After(flushed) {
+			stale = append(stale, name)
+		}
+	}
+	r.mu.RUnlock()
+
+	var errs []error
+	for _, name := range stale {
+		if err := r.flushMeta(name); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (r *FilesRepository) metaPath(filename string) string {
+	return filepath.Join(r.storagePath, MetaDir, filename+".json")
+}
+
+// writeMeta сохраняет метаданные файла на диск рядом с хранилищем.
+// Вызывается под блокировкой файла в metaFiles.
+func (r *FilesRepository) writeMeta(meta FileMeta) error {
+	if err := os.MkdirAll(filepath.Join(r.storagePath, MetaDir), 0755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return r.writeFileAtomic(r.metaPath(meta.Filename), data)
+}
+
+// readMeta читает сохранённые метаданные; false - их нет или они повреждены
+func (r *FilesRepository) readMeta(filename string) (FileMeta, bool) {
+	data, err := os.ReadFile(r.metaPath(filename))
+	if err != nil {
+		return FileMeta{}, false
+	}
+	var meta FileMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return FileMeta{}, false
+	}
+	meta.Filename = filename
+	return meta, true
+}
+
+func (r *FilesRepository) removeMeta(filename string) {
+	os.Remove(r.metaPath(filename))
+}
@@ -0,0 +1,91 @@
+package repository
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ---------------------------------------------------------------------
+// Пользовательские метаданные
+// ---------------------------------------------------------------------
+func TestFilesRepository_Metadata(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("save and overwrite keep metadata", func(t *testing.T) {
+		repo, _ := setupTestRepo(t)
+		_, err := repo.Save(ctx, "a.txt", []byte("v1"), SaveOptions{
+			Metadata: map[string]string{"owner": "alice"},
+			Tags:     []string{"report"},
+		})
+		require.NoError(t, err)
+
+		// Запись без метаданных оставляет текущие
+		meta := mustSave(t, repo, "a.txt", []byte("v2"))
+		assert.Equal(t, map[string]string{"owner": "alice"}, meta.Metadata)
+		assert.Equal(t, []string{"report"}, meta.Tags)
+	})
+
+	t.Run("set metadata replaces", func(t *testing.T) {
+		repo, _ := setupTestRepo(t)
+		_, err := repo.Save(ctx, "a.txt", []byte("v1"), SaveOptions{Tags: []string{"old"}})
+		require.NoError(t, err)
+
+		meta, err := repo.SetMetadata(ctx, "a.txt", map[string]string{"k": "v"}, nil)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{"k": "v"}, meta.Metadata)
+		assert.Empty(t, meta.Tags)
+
+		_, err = repo.SetMetadata(ctx, "missing.txt", nil, nil)
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("list returns copies", func(t *testing.T) {
+		repo, _ := setupTestRepo(t)
+		_, err := repo.Save(ctx, "a.txt", []byte("v1"), SaveOptions{Metadata: map[string]string{"k": "v"}})
+		require.NoError(t, err)
+
+		list, err := repo.List(ctx)
+		require.NoError(t, err)
+		list[0].Metadata["k"] = "changed"
+
+		list, err = repo.List(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, "v", list[0].Metadata["k"])
+	})
+}
+
+// ---------------------------------------------------------------------
+// Восстановление метаданных после перезапуска
+// ---------------------------------------------------------------------
+func TestFilesRepository_MetadataPersistence(t *testing.T) {
+	ctx := context.Background()
+	repo, dir := setupTestRepo(t)
+	saved, err := repo.Save(ctx, "a.txt", []byte("v1"), SaveOptions{
+		Metadata: map[string]string{"owner": "alice"},
+		Tags:     []string{"report"},
+	})
+	require.NoError(t, err)
+	mustSave(t, repo, "deleted.txt", []byte("x"))
+	require.NoError(t, os.Remove(filepath.Join(dir, "deleted.txt")))
+
+	restarted, err := NewFilesRepository(dir, Options{})
+	require.NoError(t, err)
+	res, err := restarted.Reindex(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, res.Files)
+
+	list, err := restarted.List(ctx)
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	assert.True(t, saved.CreatedAt.Equal(list[0].CreatedAt))
+	assert.Equal(t, saved.Metadata, list[0].Metadata)
+	assert.Equal(t, saved.Tags, list[0].Tags)
+
+	// Метаданные удалённого файла убраны
+	assert.NoFileExists(t, filepath.Join(dir, MetaDir, "deleted.txt.json"))
+}
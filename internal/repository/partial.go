@@ -0,0 +1,129 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// Префикс незавершённых загрузок: <storage>/.tmp-upload-<id>. Как и другие
+// временные файлы, забытые загрузки удаляет CollectGarbage.
+const uploadPrefix = TempPrefix + "upload-"
+
+// PartialUpload - незавершённая загрузка на диске. Данные дописываются в
+// конец; после Sync первые Size() байт переживут падение сервера.
+type PartialUpload struct {
+	id      string
+	path    string
+	f       *os.File
+	size    int64
+	release func()
+	closed  bool
+}
+
+// OpenUpload открывает незавершённую загрузку id для дозаписи, создавая её
+// при отсутствии. Уже записанное сбрасывается на диск, так что Size() сразу
+// можно сообщить клиенту как сохранённое. Одну загрузку может держать
+// открытой только один стрим, иначе ErrUploadInProgress.
+func (r *FilesRepository) OpenUpload(ctx context.Context, id string) (*PartialUpload, error) {
+	_, span := tracer.Start(ctx, "FilesRepository.OpenUpload")
+	defer span.End()
+	span.SetAttributes(attribute.String("upload.id", id))
+
+	r.uploadsMu.Lock()
+	if r.uploads[id] {
+		r.uploadsMu.Unlock()
+		span.SetStatus(codes.Error, "in progress")
+		return nil, fmt.Errorf("upload %s: %w", id, ErrUploadInProgress)
+	}
+	r.uploads[id] = true
+	r.uploadsMu.Unlock()
+	release := func() {
+		r.uploadsMu.Lock()
+		delete(r.uploads, id)
+		r.uploadsMu.Unlock()
+	}
+
+	p, err := openPartial(id, r.uploadPath(id))
+	if err != nil {
+		release()
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "open failed")
+		return nil, fmt.Errorf("failed to open upload %s: %w", id, err)
+	}
+	p.release = release
+	span.SetAttributes(attribute.Int64("upload.offset", p.size))
+	return p, nil
+}
+
+func openPartial(id, path string) (*PartialUpload, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &PartialUpload{id: id, path: path, f: f, size: info.Size()}, nil
+}
+
+// RemoveUpload удаляет незавершённую загрузку; отсутствие - не ошибка
+func (r *FilesRepository) RemoveUpload(ctx context.Context, id string) error {
+	_, span := tracer.Start(ctx, "FilesRepository.RemoveUpload")
+	defer span.End()
+	span.SetAttributes(attribute.String("upload.id", id))
+
+	if err := os.Remove(r.uploadPath(id)); err != nil && !os.IsNotExist(err) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "remove failed")
+		return fmt.Errorf("failed to remove upload %s: %w", id, err)
+	}
+	return nil
+}
+
+func (r *FilesRepository) uploadPath(id string) string {
+	return filepath.Join(r.storagePath, uploadPrefix+id)
+}
+
+// ID - идентификатор загрузки
+func (p *PartialUpload) ID() string { return p.id }
+
+// Size - сколько байт записано
+func (p *PartialUpload) Size() int64 { return p.size }
+
+// Write дописывает данные в конец загрузки
+func (p *PartialUpload) Write(data []byte) (int, error) {
+	n, err := p.f.Write(data)
+	p.size += int64(n)
+	return n, err
+}
+
+// Sync сбрасывает записанное на диск
+func (p *PartialUpload) Sync() error {
+	return p.f.Sync()
+}
+
+// Bytes читает всё содержимое загрузки
+func (p *PartialUpload) Bytes() ([]byte, error) {
+	return os.ReadFile(p.path)
+}
+
+// Close закрывает загрузку, оставляя её на диске для продолжения.
+// Повторный вызов ничего не делает.
+func (p *PartialUpload) Close() error {
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	defer p.release()
+	return p.f.Close()
+}
@@ -0,0 +1,92 @@
+package repository
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ---------------------------------------------------------------------
+// OpenUpload
+// ---------------------------------------------------------------------
+func TestFilesRepository_OpenUpload(t *testing.T) {
+	ctx := context.Background()
+	repo, tmpDir := setupTestRepo(t)
+
+	p, err := repo.OpenUpload(ctx, "abc")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), p.Size())
+	_, err = p.Write([]byte("hello "))
+	require.NoError(t, err)
+	require.NoError(t, p.Sync())
+
+	t.Run("one stream per upload", func(t *testing.T) {
+		_, err := repo.OpenUpload(ctx, "abc")
+		assert.ErrorIs(t, err, ErrUploadInProgress)
+	})
+
+	require.NoError(t, p.Close())
+
+	t.Run("resume appends", func(t *testing.T) {
+		p, err := repo.OpenUpload(ctx, "abc")
+		require.NoError(t, err)
+		defer p.Close()
+		assert.Equal(t, int64(len("hello ")), p.Size())
+		_, err = p.Write([]byte("world"))
+		require.NoError(t, err)
+		data, err := p.Bytes()
+		require.NoError(t, err)
+		assert.Equal(t, "hello world", string(data))
+	})
+
+	t.Run("invisible to listing and collected as temp file", func(t *testing.T) {
+		_, err := repo.Reindex(ctx)
+		require.NoError(t, err)
+		list, err := repo.List(ctx)
+		require.NoError(t, err)
+		assert.Empty(t, list)
+
+		removed, err := repo.CollectGarbage(ctx, 0, true)
+		require.NoError(t, err)
+		assert.Equal(t, []string{TempPrefix + "upload-abc"}, removed)
+	})
+
+	t.Run("open upload survives garbage collection", func(t *testing.T) {
+		p, err := repo.OpenUpload(ctx, "paused")
+		require.NoError(t, err)
+		_, err = p.Write([]byte("part"))
+		require.NoError(t, err)
+		// Клиент молчит дольше olderThan, но стрим ещё открыт
+		path := filepath.Join(tmpDir, uploadPrefix+"paused")
+		old := time.Now().Add(-2 * time.Hour)
+		require.NoError(t, os.Chtimes(path, old, old))
+
+		removed, err := repo.CollectGarbage(ctx, time.Hour, false)
+		require.NoError(t, err)
+		assert.NotContains(t, removed, uploadPrefix+"paused")
+		_, err = p.Write([]byte("ial"))
+		require.NoError(t, err)
+		data, err := p.Bytes()
+		require.NoError(t, err)
+		assert.Equal(t, "partial", string(data))
+
+		// Закрытая и забытая загрузка удаляется
+		require.NoError(t, p.Close())
+		require.NoError(t, os.Chtimes(path, old, old))
+		removed, err = repo.CollectGarbage(ctx, time.Hour, false)
+		require.NoError(t, err)
+		assert.Contains(t, removed, uploadPrefix+"paused")
+		assert.NoFileExists(t, path)
+	})
+
+	t.Run("remove", func(t *testing.T) {
+		require.NoError(t, repo.RemoveUpload(ctx, "abc"))
+		assert.NoFileExists(t, filepath.Join(tmpDir, TempPrefix+"upload-abc"))
+		require.NoError(t, repo.RemoveUpload(ctx, "abc"), "повторное удаление - не ошибка")
+	})
+}
@@ -2,23 +2,205 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"time"
 )
 
 type FileMeta struct {
-	Filename  string
-	CreatedAt time.Time
-	UpdatedAt time.Time
-	Size      int64
+	Filename  string    `json:"filename"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Size      int64     `json:"size"`
+	ETag      string    `json:"etag"` // sha256 содержимого
+
+	ContentType string `json:"content_type,omitempty"`
+
+	// Статистика скачиваний, UpdatedAt меняет только запись
+	LastAccessedAt time.Time `json:"last_accessed_at,omitzero"`
+	DownloadCount  int64     `json:"download_count,omitempty"`
+	BytesServed    int64     `json:"bytes_served,omitempty"`
+
+	// Когда файл удалит очистка по сроку жизни, нулевое - бессрочно
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
+
+	// Пользовательские метаданные и теги
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Tags     []string          `json:"tags,omitempty"`
+}
+
+// Префикс временных файлов в хранилище. Такие файлы не попадают в
+// метаданные и удаляются сборщиком мусора.
+const TempPrefix = ".tmp-"
+
+// Директория в хранилище с предыдущими версиями файлов:
+// <storage>/.versions/<filename>/<номер>
+const VersionsDir = ".versions"
+
+// Директория с метаданными файлов: <storage>/.meta/<filename>.json.
+// По ним Reindex восстанавливает метаданные после перезапуска.
+const MetaDir = ".meta"
+
+// Директория, куда Scrub переносит повреждённые файлы:
+// <storage>/.quarantine/<filename>.<время переноса>
+const QuarantineDir = ".quarantine"
+
+// Директория журнала репликации: <storage>/.replication
+const ReplicationDir = ".replication"
+
+var (
+	// ErrNotFound - нет файла или версии
+	ErrNotFound = errors.New("not found")
+	// ErrAlreadyExists - файл уже есть, а запись разрешена только как создание
+	ErrAlreadyExists = errors.New("already exists")
+	// ErrPreconditionFailed - текущий файл не удовлетворяет условиям записи
+	ErrPreconditionFailed = errors.New("precondition failed")
+	// ErrUploadInProgress - незавершённую загрузку уже дописывает другой стрим
+	ErrUploadInProgress = errors.New("upload in progress")
+)
+
+// Preconditions - условия записи, пустое значение - без условий
+type Preconditions struct {
+	IfNoneMatch       bool      // только создать, файла быть не должно
+	IfMatch           string    // etag текущего содержимого, "*" - файл должен существовать
+	IfUnmodifiedSince time.Time // файл не менялся после этого момента
+}
+
+// AccessTimeMode - как обновлять LastAccessedAt при скачивании
+type AccessTimeMode string
+
+const (
+	// Не обновлять LastAccessedAt
+	AccessTimeOff AccessTimeMode = "off"
+	// Обновлять в памяти всегда, а на диск сохранять, если сохранённый
+	// доступ был раньше изменения файла или больше RelatimeInterval назад -
+	// как relatime в Linux
+	AccessTimeRelatime AccessTimeMode = "relatime"
+	// Обновлять и сохранять на диск при каждом скачивании
+	AccessTimeStrict AccessTimeMode = "strict"
+)
+
+// Как часто relatime сохраняет время доступа одного файла
+const RelatimeInterval = 24 * time.Hour
+
+// DurabilityMode - что сбрасывать на диск (fsync) при записи. Запись
+// атомарна в любом режиме: временный файл переименовывается в итоговый.
+type DurabilityMode string
+
+const (
+	// Не вызывать fsync: при падении ОС файл может оказаться пустым
+	DurabilityNone DurabilityMode = "none"
+	// fsync содержимого перед переименованием
+	DurabilityFile DurabilityMode = "file"
+	// fsync содержимого и директории - переименование тоже переживёт падение
+	DurabilityFull DurabilityMode = "full"
+)
+
+// Options - необязательные возможности репозитория
+type Options struct {
+	// Сохранять предыдущее содержимое при перезаписи как версию
+	Versioning bool
+	// Обновление LastAccessedAt, пустое значение - AccessTimeOff
+	AccessTime AccessTimeMode
+	// Сброс записи на диск, пустое значение - DurabilityFull
+	Durability DurabilityMode
+}
+
+// SaveOptions - необязательные параметры записи
+type SaveOptions struct {
+	Cond Preconditions
+	// nil - оставить текущие метаданные/теги файла
+	Metadata map[string]string
+	Tags     []string
+	// "" - оставить текущий
+	ContentType string
+	// Нулевое - оставить текущий срок жизни
+	ExpiresAt time.Time
+}
+
+// ScrubOptions - что делать с найденными проблемами, по умолчанию только
+// сообщить о них
+type ScrubOptions struct {
+	// Привести метаданные к диску: убрать записи об отсутствующих файлах,
+	// добавить файлы без метаданных, принять размер и etag изменённых
+	RepairMetadata bool
+	// Перенести файлы, не совпадающие с метаданными, в QuarantineDir.
+	// Важнее RepairMetadata: повреждённый файл не принимается как есть.
+	Quarantine bool
+}
+
+// Виды проблем, которые находит Scrub
+const (
+	ScrubMissing          = "missing"           // есть метаданные, нет файла
+	ScrubOrphaned         = "orphaned"          // есть файл, нет метаданных
+	ScrubSizeMismatch     = "size_mismatch"     // размер не совпал с метаданными
+	ScrubChecksumMismatch = "checksum_mismatch" // sha256 не совпал с etag
+)
+
+// ScrubKinds - все виды проблем Scrub
+var ScrubKinds = []string{ScrubMissing, ScrubOrphaned, ScrubSizeMismatch, ScrubChecksumMismatch}
+
+// ScrubIssue - проблема с одним файлом
+type ScrubIssue struct {
+	Filename string
+	Kind     string
+	Detail   string
+	// Что сделано, "" - ничего
+	Action string
+}
+
+// ScrubReport - результат проверки хранилища
+type ScrubReport struct {
+	Checked int   // файлов проверено
+	Bytes   int64 // байт прочитано для подсчёта sha256
+	Issues  []ScrubIssue
+}
+
+// Сохранённая предыдущая версия файла
+type VersionMeta struct {
+	Version    int
+	Size       int64
+	ArchivedAt time.Time // когда версию заменили новым содержимым
+}
+
+// Итог перестроения метаданных по диску
+type ReindexResult struct {
+	Files   int
+	Added   int
+	Removed int
 }
 
 type Repository interface {
-	// Сохраняет файл на диск + метаданные
-	Save(ctx context.Context, filename string, data []byte) error
+	// Сохраняет файл на диск + метаданные, если выполнены условия opts.Cond
+	Save(ctx context.Context, filename string, data []byte, opts SaveOptions) (FileMeta, error)
 	// Вернёт содержимое файла
 	Get(ctx context.Context, filename string) ([]byte, error)
+	// Вернёт содержимое файла вместе с согласованными с ним метаданными
+	GetWithMeta(ctx context.Context, filename string) ([]byte, FileMeta, error)
 	// Вернет список всех файлов с метаданными
 	List(ctx context.Context) ([]FileMeta, error)
+	// Вернёт метаданные одного файла
+	Stat(ctx context.Context, filename string) (FileMeta, error)
 	// Обновляем дату последнего доступа
-	UpdateAccess(ctx context.Context, filename string) error
+	UpdateAccess(ctx context.Context, filename string, bytesServed int64) error
+	Delete(ctx context.Context, filename string, cond Preconditions) error
+	// Заменяет пользовательские метаданные и теги файла
+	SetMetadata(ctx context.Context, filename string, metadata map[string]string, tags []string) (FileMeta, error)
+	// Перестраивает метаданные по файлам на диске
+	Reindex(ctx context.Context) (ReindexResult, error)
+	// Открывает незавершённую загрузку для дозаписи, создаёт при отсутствии
+	OpenUpload(ctx context.Context, id string) (*PartialUpload, error)
+	// Удаляет незавершённую загрузку
+	RemoveUpload(ctx context.Context, id string) error
+	// Сверяет файлы на диске с метаданными и при opts чинит найденное
+	Scrub(ctx context.Context, opts ScrubOptions) (ScrubReport, error)
+	// Удаляет временные файлы старше olderThan, вернёт их имена
+	CollectGarbage(ctx context.Context, olderThan time.Duration, dryRun bool) ([]string, error)
+	// Вернёт сохранённые версии файла, от старых к новым
+	ListVersions(ctx context.Context, filename string) ([]VersionMeta, error)
+	// Вернёт содержимое версии
+	GetVersion(ctx context.Context, filename string, version int) ([]byte, error)
+	// Делает версию текущим содержимым файла
+	RestoreVersion(ctx context.Context, filename string, version int) error
+	// Удаляет версии сверх keepLast последних или старше maxAge, 0 - без ограничения
+	ApplyRetention(ctx context.Context, keepLast int, maxAge time.Duration) (int, error)
 }
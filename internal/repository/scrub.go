@@ -0,0 +1,169 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// Scrub сверяет файлы в storagePath с метаданными: пересчитывает sha256 и
+// сравнивает с etag, ищет отсутствующие файлы, файлы без метаданных и
+// несовпадение размера. Каждый файл проверяется под его блокировкой, так
+// что идущая запись не принимается за повреждение.
+func (r *FilesRepository) Scrub(ctx context.Context, opts ScrubOptions) (ScrubReport, error) {
+	_, span := tracer.Start(ctx, "FilesRepository.Scrub")
+	defer span.End()
+
+	entries, err := os.ReadDir(r.storagePath)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "read dir failed")
+		return ScrubReport{}, fmt.Errorf("failed to read repo dir: %w", err)
+	}
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if e.Type().IsRegular() && !strings.HasPrefix(e.Name(), TempPrefix) {
+			names = append(names, e.Name())
+		}
+	}
+	r.mu.RLock()
+	for name := range r.metadata {
+		names = append(names, name)
+	}
+	r.mu.RUnlock()
+	slices.Sort(names)
+
+	var report ScrubReport
+	for _, name := range slices.Compact(names) {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		issue, size, err := r.scrubFile(name, opts)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "scrub failed")
+			return report, fmt.Errorf("failed to check %s: %w", name, err)
+		}
+		report.Checked++
+		report.Bytes += size
+		if issue != nil {
+			report.Issues = append(report.Issues, *issue)
+		}
+	}
+	span.SetAttributes(
+		attribute.Int("files.checked", report.Checked),
+		attribute.Int("files.issues", len(report.Issues)),
+		attribute.Bool("scrub.repair", opts.RepairMetadata),
+		attribute.Bool("scrub.quarantine", opts.Quarantine),
+	)
+	return report, nil
+}
+
+// scrubFile проверяет один файл; вернёт проблему (nil - всё в порядке) и
+// сколько байт прочитано
+func (r *FilesRepository) scrubFile(name string, opts ScrubOptions) (*ScrubIssue, int64, error) {
+	defer r.lockFile(name)()
+
+	r.mu.RLock()
+	meta, known := r.metadata[name]
+	r.mu.RUnlock()
+
+	path := filepath.Join(r.storagePath, name)
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		if !known {
+			// Файл удалили после ReadDir
+			return nil, 0, nil
+		}
+		issue := &ScrubIssue{Filename: name, Kind: ScrubMissing, Detail: "file is missing on disk"}
+		if opts.RepairMetadata {
+			r.forget(name)
+			issue.Action = "metadata removed"
+		}
+		return issue, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	etag, err := fileETag(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	size := info.Size()
+
+	if !known {
+		issue := &ScrubIssue{Filename: name, Kind: ScrubOrphaned, Detail: fmt.Sprintf("no metadata, size %d", size)}
+		if opts.RepairMetadata {
+			meta, ok := r.readMeta(name)
+			if !ok {
+				meta = FileMeta{Filename: name, CreatedAt: info.ModTime(), UpdatedAt: info.ModTime()}
+			}
+			meta.Size, meta.ETag = size, etag
+			if _, err := r.storeMeta(meta); err != nil {
+				return nil, size, err
+			}
+			issue.Action = "metadata restored"
+		}
+		return issue, size, nil
+	}
+
+	issue := &ScrubIssue{Filename: name}
+	switch {
+	case size != meta.Size:
+		issue.Kind, issue.Detail = ScrubSizeMismatch, fmt.Sprintf("size %d, expected %d", size, meta.Size)
+	case etag != meta.ETag:
+		issue.Kind, issue.Detail = ScrubChecksumMismatch, fmt.Sprintf("sha256 %s, expected %s", etag, meta.ETag)
+	default:
+		return nil, size, nil
+	}
+	switch {
+	case opts.Quarantine:
+		dst, err := r.quarantine(name)
+		if err != nil {
+			return nil, size, err
+		}
+		r.forget(name)
+		issue.Action = "quarantined to " + dst
+	case opts.RepairMetadata:
+		meta.Size, meta.ETag = size, etag
+		if _, err := r.storeMeta(meta); err != nil {
+			return nil, size, err
+		}
+		issue.Action = "metadata updated"
+	}
+	return issue, size, nil
+}
+
+// quarantine переносит файл в QuarantineDir, вернёт путь относительно
+// хранилища. Вызывается под блокировкой файла.
+func (r *FilesRepository) quarantine(name string) (string, error) {
+	dir := filepath.Join(r.storagePath, QuarantineDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	rel := filepath.Join(QuarantineDir, name+"."+time.Now().UTC().Format("20060102T150405.000000000Z"))
+	if err := os.Rename(filepath.Join(r.storagePath, name), filepath.Join(r.storagePath, rel)); err != nil {
+		return "", err
+	}
+	if r.syncDirs() {
+		syncDir(dir)
+	}
+	return rel, nil
+}
+
+// forget убирает метаданные файла из памяти и с диска
+func (r *FilesRepository) forget(name string) {
+	defer r.metaFiles.lock(name)()
+	r.mu.Lock()
+	delete(r.metadata, name)
+	delete(r.atimeFlushed, name)
+	r.mu.Unlock()
+	r.removeMeta(name)
+}
@@ -0,0 +1,103 @@
+package repository
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// damagedRepo - хранилище с целым файлом и по одной проблеме каждого вида
+func damagedRepo(t *testing.T) (*FilesRepository, string) {
+	t.Helper()
+	repo, tmpDir := setupTestRepo(t)
+	mustSave(t, repo, "ok.txt", []byte("fine"))
+	mustSave(t, repo, "flipped.txt", []byte("abcd"))
+	mustSave(t, repo, "grown.txt", []byte("abcd"))
+	mustSave(t, repo, "lost.txt", []byte("abcd"))
+
+	// Изменения на диске в обход репозитория
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "flipped.txt"), []byte("abce"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "grown.txt"), []byte("abcdef"), 0644))
+	require.NoError(t, os.Remove(filepath.Join(tmpDir, "lost.txt")))
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "stray.txt"), []byte("stray"), 0644))
+	return repo, tmpDir
+}
+
+func issueKinds(report ScrubReport) map[string]string {
+	kinds := make(map[string]string, len(report.Issues))
+	for _, issue := range report.Issues {
+		kinds[issue.Filename] = issue.Kind
+	}
+	return kinds
+}
+
+// ---------------------------------------------------------------------
+// Scrub
+// ---------------------------------------------------------------------
+func TestFilesRepository_Scrub(t *testing.T) {
+	ctx := context.Background()
+	want := map[string]string{
+		"flipped.txt": ScrubChecksumMismatch,
+		"grown.txt":   ScrubSizeMismatch,
+		"lost.txt":    ScrubMissing,
+		"stray.txt":   ScrubOrphaned,
+	}
+
+	t.Run("report only", func(t *testing.T) {
+		repo, tmpDir := damagedRepo(t)
+		report, err := repo.Scrub(ctx, ScrubOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, 5, report.Checked)
+		assert.Equal(t, want, issueKinds(report))
+		for _, issue := range report.Issues {
+			assert.Empty(t, issue.Action)
+		}
+		// Ничего не изменилось
+		_, err = repo.Stat(ctx, "lost.txt")
+		assert.NoError(t, err)
+		assert.FileExists(t, filepath.Join(tmpDir, "flipped.txt"))
+	})
+
+	t.Run("repair metadata", func(t *testing.T) {
+		repo, _ := damagedRepo(t)
+		report, err := repo.Scrub(ctx, ScrubOptions{RepairMetadata: true})
+		require.NoError(t, err)
+		assert.Equal(t, want, issueKinds(report))
+
+		_, err = repo.Stat(ctx, "lost.txt")
+		assert.ErrorIs(t, err, ErrNotFound)
+		stray, err := repo.Stat(ctx, "stray.txt")
+		require.NoError(t, err)
+		assert.Equal(t, etagOf([]byte("stray")), stray.ETag)
+		grown, err := repo.Stat(ctx, "grown.txt")
+		require.NoError(t, err)
+		assert.Equal(t, int64(6), grown.Size)
+
+		report, err = repo.Scrub(ctx, ScrubOptions{})
+		require.NoError(t, err)
+		assert.Empty(t, report.Issues, "после починки проблем нет")
+	})
+
+	t.Run("quarantine", func(t *testing.T) {
+		repo, tmpDir := damagedRepo(t)
+		report, err := repo.Scrub(ctx, ScrubOptions{Quarantine: true})
+		require.NoError(t, err)
+		assert.Equal(t, want, issueKinds(report))
+
+		for _, name := range []string{"flipped.txt", "grown.txt"} {
+			assert.NoFileExists(t, filepath.Join(tmpDir, name))
+			_, err := repo.Stat(ctx, name)
+			assert.ErrorIs(t, err, ErrNotFound)
+		}
+		quarantined, err := os.ReadDir(filepath.Join(tmpDir, QuarantineDir))
+		require.NoError(t, err)
+		assert.Len(t, quarantined, 2)
+		// Без RepairMetadata остальное не трогаем
+		_, err = repo.Stat(ctx, "lost.txt")
+		assert.NoError(t, err)
+	})
+}
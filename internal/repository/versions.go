@@ -0,0 +1,207 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/Hiddan13/file_grpc/internal/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+func (r *FilesRepository) versionsPath(filename string) string {
+	return filepath.Join(r.storagePath, VersionsDir, filename)
+}
+
+// archiveCurrent переносит текущее содержимое файла в следующую по номеру
+// версию. Вызывается под блокировкой файла на запись. Вернёт функцию, возвращающую файл на
+// место, или nil, если файла ещё нет.
+func (r *FilesRepository) archiveCurrent(filename string) (func(), error) {
+	fullPath := filepath.Join(r.storagePath, filename)
+	if _, err := os.Stat(fullPath); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	dir := r.versionsPath(filename)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	versions, err := r.readVersions(filename)
+	if err != nil {
+		return nil, err
+	}
+	// Номер не повторяется, даже если прежние версии удалены очисткой:
+	// клиент мог запомнить его и восстановить бы другое содержимое
+	next := r.lastVersion(filename) + 1
+	if len(versions) > 0 {
+		next = max(next, versions[len(versions)-1].Version+1)
+	}
+	if err := r.writeFileAtomic(filepath.Join(dir, lastVersionFile), []byte(strconv.Itoa(next))); err != nil {
+		return nil, err
+	}
+
+	dst := filepath.Join(dir, strconv.Itoa(next))
+	if err := os.Rename(fullPath, dst); err != nil {
+		return nil, err
+	}
+	// mtime версии - момент, когда её заменили; по нему считается возраст
+	now := time.Now()
+	os.Chtimes(dst, now, now)
+	return func() { os.Rename(dst, fullPath) }, nil
+}
+
+// Файл в директории версий с последним выданным номером версии
+const lastVersionFile = ".last"
+
+// lastVersion - последний выданный номер версии файла, 0 - не было
+func (r *FilesRepository) lastVersion(filename string) int {
+	data, err := os.ReadFile(filepath.Join(r.versionsPath(filename), lastVersionFile))
+	if err != nil {
+		return 0
+	}
+	n, _ := strconv.Atoi(string(data))
+	return n
+}
+
+// readVersions читает версии файла с диска, от старых к новым
+func (r *FilesRepository) readVersions(filename string) ([]VersionMeta, error) {
+	entries, err := os.ReadDir(r.versionsPath(filename))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]VersionMeta, 0, len(entries))
+	for _, e := range entries {
+		n, err := strconv.Atoi(e.Name())
+		if err != nil || n <= 0 || !e.Type().IsRegular() {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		versions = append(versions, VersionMeta{Version: n, Size: info.Size(), ArchivedAt: info.ModTime()})
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Version < versions[j].Version })
+	return versions, nil
+}
+
+func (r *FilesRepository) ListVersions(ctx context.Context, filename string) ([]VersionMeta, error) {
+	_, span := tracer.Start(ctx, "FilesRepository.ListVersions")
+	defer span.End()
+	span.SetAttributes(tracing.AttrFilename.String(filename))
+
+	versions, err := r.readVersions(filename)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "read versions failed")
+		return nil, fmt.Errorf("failed to read versions: %w", err)
+	}
+	if len(versions) == 0 {
+		if _, err := os.Stat(filepath.Join(r.storagePath, filename)); os.IsNotExist(err) {
+			return nil, fmt.Errorf("file %w: %s", ErrNotFound, filename)
+		}
+	}
+	span.SetAttributes(attribute.Int("versions.count", len(versions)))
+	return versions, nil
+}
+
+func (r *FilesRepository) GetVersion(ctx context.Context, filename string, version int) ([]byte, error) {
+	_, span := tracer.Start(ctx, "FilesRepository.GetVersion")
+	defer span.End()
+	span.SetAttributes(tracing.AttrFilename.String(filename), attribute.Int("file.version", version))
+
+	defer r.files.rlock(filename)()
+	data, err := os.ReadFile(filepath.Join(r.versionsPath(filename), strconv.Itoa(version)))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "read failed")
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("version %d of %s: %w", version, filename, ErrNotFound)
+		}
+		return nil, fmt.Errorf("failed to read version: %w", err)
+	}
+	span.SetAttributes(tracing.AttrSize.Int(len(data)))
+	return data, nil
+}
+
+// RestoreVersion записывает содержимое версии как текущее. Сама версия
+// остаётся, а заменённое содержимое (при версионировании) становится новой.
+func (r *FilesRepository) RestoreVersion(ctx context.Context, filename string, version int) error {
+	ctx, span := tracer.Start(ctx, "FilesRepository.RestoreVersion")
+	defer span.End()
+	span.SetAttributes(tracing.AttrFilename.String(filename), attribute.Int("file.version", version))
+
+	data, err := r.GetVersion(ctx, filename, version)
+	if err != nil {
+		return err
+	}
+	_, err = r.Save(ctx, filename, data, SaveOptions{})
+	return err
+}
+
+// ApplyRetention удаляет версии, не попадающие в keepLast последних или
+// заменённые раньше maxAge назад. Директория версий остаётся с
+// lastVersionFile, чтобы номера удалённых версий не выдавались повторно.
+func (r *FilesRepository) ApplyRetention(ctx context.Context, keepLast int, maxAge time.Duration) (int, error) {
+	_, span := tracer.Start(ctx, "FilesRepository.ApplyRetention")
+	defer span.End()
+
+	root := filepath.Join(r.storagePath, VersionsDir)
+	entries, err := os.ReadDir(root)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "read dir failed")
+		return 0, fmt.Errorf("failed to read versions dir: %w", err)
+	}
+
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+
+	removed := 0
+	deadline := time.Now().Add(-maxAge)
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return removed, err
+		}
+		versions, err := r.readVersions(e.Name())
+		if err != nil {
+			return removed, fmt.Errorf("failed to read versions of %s: %w", e.Name(), err)
+		}
+		// Директория версий старше lastVersionFile
+		if n := len(versions); n > 0 && r.lastVersion(e.Name()) < versions[n-1].Version {
+			if err := r.writeFileAtomic(filepath.Join(r.versionsPath(e.Name()), lastVersionFile), []byte(strconv.Itoa(versions[n-1].Version))); err != nil {
+				return removed, fmt.Errorf("failed to keep last version of %s: %w", e.Name(), err)
+			}
+		}
+		for i, v := range versions {
+			expired := maxAge > 0 && v.ArchivedAt.Before(deadline)
+			extra := keepLast > 0 && i < len(versions)-keepLast
+			if !expired && !extra {
+				continue
+			}
+			path := filepath.Join(r.versionsPath(e.Name()), strconv.Itoa(v.Version))
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return removed, fmt.Errorf("failed to remove version: %w", err)
+			}
+			removed++
+		}
+	}
+	span.SetAttributes(attribute.Int("versions.removed", removed))
+	return removed, nil
+}
@@ -0,0 +1,167 @@
+package repository
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupVersionedRepo(t *testing.T) (*FilesRepository, string) {
+	t.Helper()
+	tmpDir := t.TempDir()
+	repo, err := NewFilesRepository(tmpDir, Options{Versioning: true})
+	require.NoError(t, err)
+	return repo, tmpDir
+}
+
+// ---------------------------------------------------------------------
+// Версии при перезаписи
+// ---------------------------------------------------------------------
+func TestFilesRepository_Versions(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("overwrite keeps previous content", func(t *testing.T) {
+		repo, _ := setupVersionedRepo(t)
+		mustSave(t, repo, "a.txt", []byte("v1"))
+		mustSave(t, repo, "a.txt", []byte("v2"))
+		mustSave(t, repo, "a.txt", []byte("v3"))
+
+		versions, err := repo.ListVersions(ctx, "a.txt")
+		require.NoError(t, err)
+		require.Len(t, versions, 2)
+		assert.Equal(t, 1, versions[0].Version)
+		assert.Equal(t, 2, versions[1].Version)
+		assert.Equal(t, int64(2), versions[0].Size)
+
+		data, err := repo.GetVersion(ctx, "a.txt", 1)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("v1"), data)
+
+		current, err := repo.Get(ctx, "a.txt")
+		require.NoError(t, err)
+		assert.Equal(t, []byte("v3"), current)
+	})
+
+	t.Run("disabled versioning overwrites", func(t *testing.T) {
+		repo, _ := setupTestRepo(t)
+		mustSave(t, repo, "a.txt", []byte("v1"))
+		mustSave(t, repo, "a.txt", []byte("v2"))
+
+		versions, err := repo.ListVersions(ctx, "a.txt")
+		require.NoError(t, err)
+		assert.Empty(t, versions)
+	})
+
+	t.Run("versions are not listed as files", func(t *testing.T) {
+		repo, _ := setupVersionedRepo(t)
+		mustSave(t, repo, "a.txt", []byte("v1"))
+		mustSave(t, repo, "a.txt", []byte("v2"))
+
+		res, err := repo.Reindex(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 1, res.Files)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		repo, _ := setupVersionedRepo(t)
+		_, err := repo.ListVersions(ctx, "missing.txt")
+		assert.ErrorIs(t, err, ErrNotFound)
+
+		mustSave(t, repo, "a.txt", []byte("v1"))
+		_, err = repo.GetVersion(ctx, "a.txt", 1)
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+}
+
+// ---------------------------------------------------------------------
+// RestoreVersion
+// ---------------------------------------------------------------------
+func TestFilesRepository_RestoreVersion(t *testing.T) {
+	ctx := context.Background()
+	repo, _ := setupVersionedRepo(t)
+	mustSave(t, repo, "a.txt", []byte("v1"))
+	mustSave(t, repo, "a.txt", []byte("v2"))
+
+	require.NoError(t, repo.RestoreVersion(ctx, "a.txt", 1))
+
+	current, err := repo.Get(ctx, "a.txt")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("v1"), current)
+
+	// Заменённое содержимое тоже сохранилось
+	versions, err := repo.ListVersions(ctx, "a.txt")
+	require.NoError(t, err)
+	require.Len(t, versions, 2)
+	data, err := repo.GetVersion(ctx, "a.txt", 2)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("v2"), data)
+
+	assert.ErrorIs(t, repo.RestoreVersion(ctx, "a.txt", 10), ErrNotFound)
+}
+
+// ---------------------------------------------------------------------
+// ApplyRetention
+// ---------------------------------------------------------------------
+func TestFilesRepository_ApplyRetention(t *testing.T) {
+	ctx := context.Background()
+
+	saveVersions := func(t *testing.T, repo *FilesRepository, n int) {
+		for i := 0; i <= n; i++ {
+			mustSave(t, repo, "a.txt", []byte(strconv.Itoa(i)))
+		}
+	}
+
+	t.Run("keep last", func(t *testing.T) {
+		repo, _ := setupVersionedRepo(t)
+		saveVersions(t, repo, 5)
+
+		removed, err := repo.ApplyRetention(ctx, 2, 0)
+		require.NoError(t, err)
+		assert.Equal(t, 3, removed)
+
+		versions, err := repo.ListVersions(ctx, "a.txt")
+		require.NoError(t, err)
+		require.Len(t, versions, 2)
+		assert.Equal(t, 4, versions[0].Version)
+		assert.Equal(t, 5, versions[1].Version)
+	})
+
+	t.Run("max age", func(t *testing.T) {
+		repo, tmpDir := setupVersionedRepo(t)
+		saveVersions(t, repo, 2)
+		old := filepath.Join(tmpDir, VersionsDir, "a.txt", "1")
+		require.NoError(t, os.Chtimes(old, time.Now().Add(-48*time.Hour), time.Now().Add(-48*time.Hour)))
+
+		removed, err := repo.ApplyRetention(ctx, 0, 24*time.Hour)
+		require.NoError(t, err)
+		assert.Equal(t, 1, removed)
+		assert.NoFileExists(t, old)
+	})
+
+	t.Run("numbers are not reused", func(t *testing.T) {
+		repo, tmpDir := setupVersionedRepo(t)
+		saveVersions(t, repo, 2)
+		dir := filepath.Join(tmpDir, VersionsDir, "a.txt")
+		for _, v := range []string{"1", "2"} {
+			require.NoError(t, os.Chtimes(filepath.Join(dir, v), time.Now().Add(-time.Hour), time.Now().Add(-time.Hour)))
+		}
+
+		removed, err := repo.ApplyRetention(ctx, 0, time.Minute)
+		require.NoError(t, err)
+		assert.Equal(t, 2, removed)
+
+		mustSave(t, repo, "a.txt", []byte("next"))
+		versions, err := repo.ListVersions(ctx, "a.txt")
+		require.NoError(t, err)
+		require.Len(t, versions, 1)
+		assert.Equal(t, 3, versions[0].Version)
+		_, err = repo.GetVersion(ctx, "a.txt", 1)
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+}
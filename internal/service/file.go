@@ -2,42 +2,217 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/Hiddan13/file_grpc/internal/repository"
+	"github.com/Hiddan13/file_grpc/internal/tracing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/Hiddan13/file_grpc/internal/service")
+
+var (
+	ErrInvalidFilename = errors.New("invalid filename")
+	ErrInvalidVersion  = errors.New("invalid version")
+	ErrInvalidUploadID = errors.New("invalid upload id")
 )
 
 type FileService struct {
-	repo repository.Repository
+	repo  repository.Repository
+	scrub scrubStats
 }
 
 func NewFileService(repo repository.Repository) *FileService {
 	return &FileService{repo: repo}
 }
 
-// Сохраним файл с проверкой на безопасное написание
-func (s *FileService) SaveFile(ctx context.Context, filename string, data []byte) error {
-	if strings.Contains(filename, "..") || strings.Contains(filename, "/") || strings.Contains(filename, "\\") {
-		return fmt.Errorf("invalid filename: %s", filename)
+// ValidateFilename проверяет, что имя не выходит за пределы хранилища и не
+// пересекается со служебными временными файлами
+func ValidateFilename(filename string) error {
+	if filename == "" || strings.Contains(filename, "..") || strings.Contains(filename, "/") || strings.Contains(filename, "\\") {
+		return fmt.Errorf("%w: %s", ErrInvalidFilename, filename)
+	}
+	if strings.HasPrefix(filename, repository.TempPrefix) {
+		return fmt.Errorf("%w: %s: reserved prefix %s", ErrInvalidFilename, filename, repository.TempPrefix)
+	}
+	if filename == repository.VersionsDir || filename == repository.MetaDir || filename == repository.QuarantineDir || filename == repository.ReplicationDir {
+		return fmt.Errorf("%w: %s: reserved name", ErrInvalidFilename, filename)
+	}
+	return nil
+}
+
+// ValidateUploadID проверяет идентификатор незавершённой загрузки: 1-64
+// символа из латинских букв, цифр, '-' и '_'
+func ValidateUploadID(id string) error {
+	if id == "" || len(id) > 64 {
+		return fmt.Errorf("%w: %q", ErrInvalidUploadID, id)
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return fmt.Errorf("%w: %q", ErrInvalidUploadID, id)
+		}
+	}
+	return nil
+}
+
+// Сохраним файл с проверкой на безопасное написание. Условия opts.Cond
+// проверяются репозиторием атомарно с записью.
+func (s *FileService) SaveFile(ctx context.Context, filename string, data []byte, opts repository.SaveOptions) (meta repository.FileMeta, err error) {
+	ctx, span := tracer.Start(ctx, "FileService.SaveFile")
+	defer func() { endSpan(span, err) }()
+	span.SetAttributes(tracing.AttrFilename.String(filename), tracing.AttrSize.Int(len(data)))
+
+	if err := ValidateFilename(filename); err != nil {
+		return repository.FileMeta{}, err
 	}
 	if len(data) == 0 {
-		return fmt.Errorf("empty file")
+		return repository.FileMeta{}, fmt.Errorf("empty file")
+	}
+	if opts.Metadata != nil || opts.Tags != nil {
+		if err := ValidateMetadata(opts.Metadata, opts.Tags); err != nil {
+			return repository.FileMeta{}, err
+		}
+		opts.Tags = normalizeTags(opts.Tags)
 	}
-	return s.repo.Save(ctx, filename, data)
+	return s.repo.Save(ctx, filename, data, opts)
 }
 
 // Вернем содержимое файла
-func (s *FileService) GetFile(ctx context.Context, filename string) ([]byte, error) {
-	return s.repo.Get(ctx, filename)
+func (s *FileService) GetFile(ctx context.Context, filename string) (data []byte, err error) {
+	ctx, span := tracer.Start(ctx, "FileService.GetFile")
+	defer func() { endSpan(span, err) }()
+	span.SetAttributes(tracing.AttrFilename.String(filename))
+
+	if err := ValidateFilename(filename); err != nil {
+		return nil, err
+	}
+	data, err = s.repo.Get(ctx, filename)
+	span.SetAttributes(tracing.AttrSize.Int(len(data)))
+	return data, err
+}
+
+// GetFileWithInfo вернёт содержимое файла и метаданные той же его версии
+func (s *FileService) GetFileWithInfo(ctx context.Context, filename string) (data []byte, meta repository.FileMeta, err error) {
+	ctx, span := tracer.Start(ctx, "FileService.GetFileWithInfo")
+	defer func() { endSpan(span, err) }()
+	span.SetAttributes(tracing.AttrFilename.String(filename))
+
+	if err := ValidateFilename(filename); err != nil {
+		return nil, repository.FileMeta{}, err
+	}
+	data, meta, err = s.repo.GetWithMeta(ctx, filename)
+	span.SetAttributes(tracing.AttrSize.Int(len(data)))
+	return data, meta, err
+}
+
+// GetFileInfo вернёт метаданные файла
+func (s *FileService) GetFileInfo(ctx context.Context, filename string) (meta repository.FileMeta, err error) {
+	ctx, span := tracer.Start(ctx, "FileService.GetFileInfo")
+	defer func() { endSpan(span, err) }()
+	span.SetAttributes(tracing.AttrFilename.String(filename))
+
+	if err := ValidateFilename(filename); err != nil {
+		return repository.FileMeta{}, err
+	}
+	return s.repo.Stat(ctx, filename)
+}
+
+// ListFiles возвращает список файлов с метаданными, подходящих под filter.
+func (s *FileService) ListFiles(ctx context.Context, filter ListFilter) (metas []repository.FileMeta, err error) {
+	ctx, span := tracer.Start(ctx, "FileService.ListFiles")
+	defer func() { endSpan(span, err) }()
+
+	all, err := s.repo.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	metas = all[:0]
+	for _, m := range all {
+		if filter.Match(m) {
+			metas = append(metas, m)
+		}
+	}
+	span.SetAttributes(attribute.Int("files.count", len(metas)))
+	return metas, nil
+}
+
+// UpdateAccess учитывает скачивание bytesServed байт файла.
+func (s *FileService) UpdateAccess(ctx context.Context, filename string, bytesServed int64) (err error) {
+	ctx, span := tracer.Start(ctx, "FileService.UpdateAccess")
+	defer func() { endSpan(span, err) }()
+	span.SetAttributes(tracing.AttrFilename.String(filename))
+
+	if err := ValidateFilename(filename); err != nil {
+		return err
+	}
+	return s.repo.UpdateAccess(ctx, filename, bytesServed)
+}
+
+// DeleteFile удаляет файл, если выполнены условия cond
+func (s *FileService) DeleteFile(ctx context.Context, filename string, cond repository.Preconditions) (err error) {
+	ctx, span := tracer.Start(ctx, "FileService.DeleteFile")
+	defer func() { endSpan(span, err) }()
+	span.SetAttributes(tracing.AttrFilename.String(filename))
+
+	if err := ValidateFilename(filename); err != nil {
+		return err
+	}
+	return s.repo.Delete(ctx, filename, cond)
+}
+
+// OpenUpload открывает незавершённую загрузку id для дозаписи
+func (s *FileService) OpenUpload(ctx context.Context, id string) (p *repository.PartialUpload, err error) {
+	ctx, span := tracer.Start(ctx, "FileService.OpenUpload")
+	defer func() { endSpan(span, err) }()
+
+	if err := ValidateUploadID(id); err != nil {
+		return nil, err
+	}
+	return s.repo.OpenUpload(ctx, id)
 }
 
-// ListFiles возвращает список файлов с метаданными.
-func (s *FileService) ListFiles(ctx context.Context) ([]repository.FileMeta, error) {
-	return s.repo.List(ctx)
+// RemoveUpload удаляет незавершённую загрузку id
+func (s *FileService) RemoveUpload(ctx context.Context, id string) (err error) {
+	ctx, span := tracer.Start(ctx, "FileService.RemoveUpload")
+	defer func() { endSpan(span, err) }()
+
+	if err := ValidateUploadID(id); err != nil {
+		return err
+	}
+	return s.repo.RemoveUpload(ctx, id)
 }
 
-// UpdateAccess обновляет дату последнего доступа.
-func (s *FileService) UpdateAccess(ctx context.Context, filename string) error {
-	return s.repo.UpdateAccess(ctx, filename)
+// Reindex перестраивает метаданные по файлам на диске
+func (s *FileService) Reindex(ctx context.Context) (res repository.ReindexResult, err error) {
+	ctx, span := tracer.Start(ctx, "FileService.Reindex")
+	defer func() { endSpan(span, err) }()
+
+	return s.repo.Reindex(ctx)
+}
+
+// CollectGarbage удаляет забытые временные файлы старше olderThan
+func (s *FileService) CollectGarbage(ctx context.Context, olderThan time.Duration, dryRun bool) (removed []string, err error) {
+	ctx, span := tracer.Start(ctx, "FileService.CollectGarbage")
+	defer func() { endSpan(span, err) }()
+
+	if olderThan < 0 {
+		return nil, fmt.Errorf("older than must not be negative")
+	}
+	return s.repo.CollectGarbage(ctx, olderThan, dryRun)
+}
+
+// endSpan закрывает спан, отмечая ошибку если она есть
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
 }
@@ -3,6 +3,7 @@ package service
 import (
 	"context"
 	"errors"
+	"strings"
 	"testing"
 	"time"
 
@@ -16,17 +17,29 @@ import (
 // Mock Repository
 // ---------------------------------------------------------------------
 type mockRepo struct {
-	saveFunc         func(ctx context.Context, filename string, data []byte) error
+	saveFunc         func(ctx context.Context, filename string, data []byte, opts repository.SaveOptions) (repository.FileMeta, error)
 	getFunc          func(ctx context.Context, filename string) ([]byte, error)
+	getWithMetaFunc  func(ctx context.Context, filename string) ([]byte, repository.FileMeta, error)
 	listFunc         func(ctx context.Context) ([]repository.FileMeta, error)
-	updateAccessFunc func(ctx context.Context, filename string) error
+	statFunc         func(ctx context.Context, filename string) (repository.FileMeta, error)
+	updateAccessFunc func(ctx context.Context, filename string, bytesServed int64) error
+	setMetadataFunc  func(ctx context.Context, filename string, metadata map[string]string, tags []string) (repository.FileMeta, error)
+	reindexFunc      func(ctx context.Context) (repository.ReindexResult, error)
+	gcFunc           func(ctx context.Context, olderThan time.Duration, dryRun bool) ([]string, error)
+	listVersionsFunc func(ctx context.Context, filename string) ([]repository.VersionMeta, error)
+	getVersionFunc   func(ctx context.Context, filename string, version int) ([]byte, error)
+	restoreFunc      func(ctx context.Context, filename string, version int) error
+	retentionFunc    func(ctx context.Context, keepLast int, maxAge time.Duration) (int, error)
+	deleteFunc       func(ctx context.Context, filename string, cond repository.Preconditions) error
+	openUploadFunc   func(ctx context.Context, id string) (*repository.PartialUpload, error)
+	scrubFunc        func(ctx context.Context, opts repository.ScrubOptions) (repository.ScrubReport, error)
 }
 
-func (m *mockRepo) Save(ctx context.Context, filename string, data []byte) error {
+func (m *mockRepo) Save(ctx context.Context, filename string, data []byte, opts repository.SaveOptions) (repository.FileMeta, error) {
 	if m.saveFunc != nil {
-		return m.saveFunc(ctx, filename, data)
+		return m.saveFunc(ctx, filename, data, opts)
 	}
-	return nil
+	return repository.FileMeta{}, nil
 }
 
 func (m *mockRepo) Get(ctx context.Context, filename string) ([]byte, error) {
@@ -36,6 +49,13 @@ func (m *mockRepo) Get(ctx context.Context, filename string) ([]byte, error) {
 	return nil, nil
 }
 
+func (m *mockRepo) GetWithMeta(ctx context.Context, filename string) ([]byte, repository.FileMeta, error) {
+	if m.getWithMetaFunc != nil {
+		return m.getWithMetaFunc(ctx, filename)
+	}
+	return nil, repository.FileMeta{}, nil
+}
+
 func (m *mockRepo) List(ctx context.Context) ([]repository.FileMeta, error) {
 	if m.listFunc != nil {
 		return m.listFunc(ctx)
@@ -43,13 +63,94 @@ func (m *mockRepo) List(ctx context.Context) ([]repository.FileMeta, error) {
 	return nil, nil
 }
 
-func (m *mockRepo) UpdateAccess(ctx context.Context, filename string) error {
+func (m *mockRepo) Stat(ctx context.Context, filename string) (repository.FileMeta, error) {
+	if m.statFunc != nil {
+		return m.statFunc(ctx, filename)
+	}
+	return repository.FileMeta{}, nil
+}
+
+func (m *mockRepo) UpdateAccess(ctx context.Context, filename string, bytesServed int64) error {
 	if m.updateAccessFunc != nil {
-		return m.updateAccessFunc(ctx, filename)
+		return m.updateAccessFunc(ctx, filename, bytesServed)
 	}
 	return nil
 }
 
+func (m *mockRepo) Delete(ctx context.Context, filename string, cond repository.Preconditions) error {
+	if m.deleteFunc != nil {
+		return m.deleteFunc(ctx, filename, cond)
+	}
+	return nil
+}
+
+func (m *mockRepo) SetMetadata(ctx context.Context, filename string, metadata map[string]string, tags []string) (repository.FileMeta, error) {
+	if m.setMetadataFunc != nil {
+		return m.setMetadataFunc(ctx, filename, metadata, tags)
+	}
+	return repository.FileMeta{}, nil
+}
+
+func (m *mockRepo) Reindex(ctx context.Context) (repository.ReindexResult, error) {
+	if m.reindexFunc != nil {
+		return m.reindexFunc(ctx)
+	}
+	return repository.ReindexResult{}, nil
+}
+
+func (m *mockRepo) CollectGarbage(ctx context.Context, olderThan time.Duration, dryRun bool) ([]string, error) {
+	if m.gcFunc != nil {
+		return m.gcFunc(ctx, olderThan, dryRun)
+	}
+	return nil, nil
+}
+
+func (m *mockRepo) OpenUpload(ctx context.Context, id string) (*repository.PartialUpload, error) {
+	if m.openUploadFunc != nil {
+		return m.openUploadFunc(ctx, id)
+	}
+	return nil, nil
+}
+
+func (m *mockRepo) RemoveUpload(ctx context.Context, id string) error {
+	return nil
+}
+
+func (m *mockRepo) Scrub(ctx context.Context, opts repository.ScrubOptions) (repository.ScrubReport, error) {
+	if m.scrubFunc != nil {
+		return m.scrubFunc(ctx, opts)
+	}
+	return repository.ScrubReport{}, nil
+}
+
+func (m *mockRepo) ListVersions(ctx context.Context, filename string) ([]repository.VersionMeta, error) {
+	if m.listVersionsFunc != nil {
+		return m.listVersionsFunc(ctx, filename)
+	}
+	return nil, nil
+}
+
+func (m *mockRepo) GetVersion(ctx context.Context, filename string, version int) ([]byte, error) {
+	if m.getVersionFunc != nil {
+		return m.getVersionFunc(ctx, filename, version)
+	}
+	return nil, nil
+}
+
+func (m *mockRepo) RestoreVersion(ctx context.Context, filename string, version int) error {
+	if m.restoreFunc != nil {
+		return m.restoreFunc(ctx, filename, version)
+	}
+	return nil
+}
+
+func (m *mockRepo) ApplyRetention(ctx context.Context, keepLast int, maxAge time.Duration) (int, error) {
+	if m.retentionFunc != nil {
+		return m.retentionFunc(ctx, keepLast, maxAge)
+	}
+	return 0, nil
+}
+
 // ---------------------------------------------------------------------
 // SaveFile
 // ---------------------------------------------------------------------
@@ -59,50 +160,76 @@ func TestFileService_SaveFile(t *testing.T) {
 	t.Run("successful save", func(t *testing.T) {
 		var capturedFilename string
 		var capturedData []byte
+		var capturedOpts repository.SaveOptions
 
 		mock := &mockRepo{
-			saveFunc: func(ctx context.Context, filename string, data []byte) error {
+			saveFunc: func(ctx context.Context, filename string, data []byte, opts repository.SaveOptions) (repository.FileMeta, error) {
 				capturedFilename = filename
 				capturedData = data
-				return nil
+				capturedOpts = opts
+				return repository.FileMeta{Filename: filename, ETag: "etag"}, nil
 			},
 		}
 		svc := NewFileService(mock)
 
 		filename := "valid.txt"
 		data := []byte("hello")
-		err := svc.SaveFile(ctx, filename, data)
+		opts := repository.SaveOptions{Cond: repository.Preconditions{IfMatch: "abc"}}
+		meta, err := svc.SaveFile(ctx, filename, data, opts)
 		require.NoError(t, err)
 		assert.Equal(t, filename, capturedFilename)
 		assert.Equal(t, data, capturedData)
+		assert.Equal(t, opts, capturedOpts)
+		assert.Equal(t, "etag", meta.ETag)
 	})
 
 	t.Run("invalid filename with ..", func(t *testing.T) {
 		mock := &mockRepo{}
 		svc := NewFileService(mock)
 
-		err := svc.SaveFile(ctx, "../evil.txt", []byte("bad"))
+		_, err := svc.SaveFile(ctx, "../evil.txt", []byte("bad"), repository.SaveOptions{})
 		assert.Error(t, err)
 		assert.Contains(t, err.Error(), "invalid filename")
 	})
 
 	t.Run("invalid filename with /", func(t *testing.T) {
 		svc := NewFileService(&mockRepo{})
-		err := svc.SaveFile(ctx, "sub/dir/file.txt", []byte("bad"))
+		_, err := svc.SaveFile(ctx, "sub/dir/file.txt", []byte("bad"), repository.SaveOptions{})
 		assert.Error(t, err)
 		assert.Contains(t, err.Error(), "invalid filename")
 	})
 
 	t.Run("invalid filename with \\", func(t *testing.T) {
 		svc := NewFileService(&mockRepo{})
-		err := svc.SaveFile(ctx, "sub\\dir\\file.txt", []byte("bad"))
+		_, err := svc.SaveFile(ctx, "sub\\dir\\file.txt", []byte("bad"), repository.SaveOptions{})
 		assert.Error(t, err)
 		assert.Contains(t, err.Error(), "invalid filename")
 	})
 
+	t.Run("empty filename", func(t *testing.T) {
+		svc := NewFileService(&mockRepo{})
+		_, err := svc.SaveFile(ctx, "", []byte("bad"), repository.SaveOptions{})
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "invalid filename")
+	})
+
+	t.Run("reserved temp prefix", func(t *testing.T) {
+		svc := NewFileService(&mockRepo{})
+		_, err := svc.SaveFile(ctx, repository.TempPrefix+"x", []byte("bad"), repository.SaveOptions{})
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "reserved prefix")
+	})
+
+	t.Run("reserved versions dir", func(t *testing.T) {
+		svc := NewFileService(&mockRepo{})
+		_, err := svc.SaveFile(ctx, repository.VersionsDir, []byte("bad"), repository.SaveOptions{})
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "reserved name")
+	})
+
 	t.Run("empty data", func(t *testing.T) {
 		svc := NewFileService(&mockRepo{})
-		err := svc.SaveFile(ctx, "empty.txt", []byte{})
+		_, err := svc.SaveFile(ctx, "empty.txt", []byte{}, repository.SaveOptions{})
 		assert.Error(t, err)
 		assert.Contains(t, err.Error(), "empty file")
 	})
@@ -110,12 +237,12 @@ func TestFileService_SaveFile(t *testing.T) {
 	t.Run("repository error propagation", func(t *testing.T) {
 		expectedErr := errors.New("disk full")
 		mock := &mockRepo{
-			saveFunc: func(ctx context.Context, filename string, data []byte) error {
-				return expectedErr
+			saveFunc: func(ctx context.Context, filename string, data []byte, opts repository.SaveOptions) (repository.FileMeta, error) {
+				return repository.FileMeta{}, expectedErr
 			},
 		}
 		svc := NewFileService(mock)
-		err := svc.SaveFile(ctx, "valid.txt", []byte("data"))
+		_, err := svc.SaveFile(ctx, "valid.txt", []byte("data"), repository.SaveOptions{})
 		assert.ErrorIs(t, err, expectedErr)
 	})
 }
@@ -173,7 +300,7 @@ func TestFileService_ListFiles(t *testing.T) {
 		}
 		svc := NewFileService(mock)
 
-		metas, err := svc.ListFiles(ctx)
+		metas, err := svc.ListFiles(ctx, ListFilter{})
 		require.NoError(t, err)
 		assert.Equal(t, expectedMetas, metas)
 	})
@@ -187,7 +314,7 @@ func TestFileService_ListFiles(t *testing.T) {
 		}
 		svc := NewFileService(mock)
 
-		_, err := svc.ListFiles(ctx)
+		_, err := svc.ListFiles(ctx, ListFilter{})
 		assert.ErrorIs(t, err, expectedErr)
 	})
 }
@@ -200,29 +327,122 @@ func TestFileService_UpdateAccess(t *testing.T) {
 
 	t.Run("successful update", func(t *testing.T) {
 		var calledFilename string
+		var calledBytes int64
 		mock := &mockRepo{
-			updateAccessFunc: func(ctx context.Context, filename string) error {
+			updateAccessFunc: func(ctx context.Context, filename string, bytesServed int64) error {
 				calledFilename = filename
+				calledBytes = bytesServed
 				return nil
 			},
 		}
 		svc := NewFileService(mock)
 
-		err := svc.UpdateAccess(ctx, "test.txt")
+		err := svc.UpdateAccess(ctx, "test.txt", 42)
 		require.NoError(t, err)
 		assert.Equal(t, "test.txt", calledFilename)
+		assert.Equal(t, int64(42), calledBytes)
 	})
 
 	t.Run("repository error", func(t *testing.T) {
 		expectedErr := errors.New("update failed")
 		mock := &mockRepo{
-			updateAccessFunc: func(ctx context.Context, filename string) error {
+			updateAccessFunc: func(ctx context.Context, filename string, bytesServed int64) error {
 				return expectedErr
 			},
 		}
 		svc := NewFileService(mock)
 
-		err := svc.UpdateAccess(ctx, "test.txt")
+		err := svc.UpdateAccess(ctx, "test.txt", 1)
 		assert.ErrorIs(t, err, expectedErr)
 	})
 }
+
+// ---------------------------------------------------------------------
+// DeleteFile
+// ---------------------------------------------------------------------
+func TestFileService_DeleteFile(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("passes conditions to repository", func(t *testing.T) {
+		var gotName string
+		var gotCond repository.Preconditions
+		mock := &mockRepo{
+			deleteFunc: func(ctx context.Context, filename string, cond repository.Preconditions) error {
+				gotName, gotCond = filename, cond
+				return nil
+			},
+		}
+		svc := NewFileService(mock)
+
+		err := svc.DeleteFile(ctx, "a.txt", repository.Preconditions{IfMatch: "abc"})
+		require.NoError(t, err)
+		assert.Equal(t, "a.txt", gotName)
+		assert.Equal(t, "abc", gotCond.IfMatch)
+	})
+
+	t.Run("reserved name", func(t *testing.T) {
+		svc := NewFileService(&mockRepo{
+			deleteFunc: func(ctx context.Context, filename string, cond repository.Preconditions) error {
+				t.Fatal("repository must not be called")
+				return nil
+			},
+		})
+		err := svc.DeleteFile(ctx, repository.ReplicationDir, repository.Preconditions{})
+		assert.ErrorIs(t, err, ErrInvalidFilename)
+	})
+}
+
+// ---------------------------------------------------------------------
+// CollectGarbage
+// ---------------------------------------------------------------------
+func TestFileService_CollectGarbage(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("passes options to repository", func(t *testing.T) {
+		var gotAge time.Duration
+		var gotDryRun bool
+		mock := &mockRepo{
+			gcFunc: func(ctx context.Context, olderThan time.Duration, dryRun bool) ([]string, error) {
+				gotAge, gotDryRun = olderThan, dryRun
+				return []string{".tmp-1"}, nil
+			},
+		}
+		svc := NewFileService(mock)
+
+		removed, err := svc.CollectGarbage(ctx, time.Hour, true)
+		require.NoError(t, err)
+		assert.Equal(t, []string{".tmp-1"}, removed)
+		assert.Equal(t, time.Hour, gotAge)
+		assert.True(t, gotDryRun)
+	})
+
+	t.Run("negative age", func(t *testing.T) {
+		svc := NewFileService(&mockRepo{})
+		_, err := svc.CollectGarbage(ctx, -time.Second, false)
+		assert.Error(t, err)
+	})
+}
+
+// ---------------------------------------------------------------------
+// OpenUpload
+// ---------------------------------------------------------------------
+func TestFileService_OpenUpload(t *testing.T) {
+	ctx := context.Background()
+	called := false
+	svc := NewFileService(&mockRepo{
+		openUploadFunc: func(ctx context.Context, id string) (*repository.PartialUpload, error) {
+			called = true
+			return nil, nil
+		},
+	})
+
+	for _, id := range []string{"", "../etc", "a/b", ".tmp", strings.Repeat("x", 65)} {
+		_, err := svc.OpenUpload(ctx, id)
+		assert.ErrorIs(t, err, ErrInvalidUploadID, id)
+	}
+	assert.False(t, called)
+
+	_, err := svc.OpenUpload(ctx, "0f3a-Upload_1")
+	require.NoError(t, err)
+	assert.True(t, called)
+}
@@ -0,0 +1,130 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/Hiddan13/file_grpc/internal/repository"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// LifecycleRule - удалять файлы с префиксом Prefix ("" - все), к которым не
+// обращались дольше NotAccessedFor
+type LifecycleRule struct {
+	Prefix         string
+	NotAccessedFor time.Duration
+}
+
+// Lifecycle - политика автоматического удаления файлов. Файлы с истёкшим
+// ExpiresAt удаляются всегда, остальные - по правилам.
+type Lifecycle struct {
+	Rules  []LifecycleRule
+	DryRun bool // только найти и записать в лог, не удаляя
+}
+
+// Expired - файл, удалённый (или при DryRun подлежащий удалению) очисткой
+type Expired struct {
+	Filename string
+	Reason   string
+}
+
+// lastUsed - последнее обращение к файлу: запись или скачивание. Без
+// отслеживания доступа (access_time: off) это время записи.
+func lastUsed(m repository.FileMeta) time.Time {
+	if m.LastAccessedAt.After(m.UpdatedAt) {
+		return m.LastAccessedAt
+	}
+	return m.UpdatedAt
+}
+
+// expiredReason вернёт, почему файл пора удалить, или "", если он ещё нужен
+func (p Lifecycle) expiredReason(m repository.FileMeta, now time.Time) string {
+	if !m.ExpiresAt.IsZero() && !now.Before(m.ExpiresAt) {
+		return fmt.Sprintf("ttl expired at %s", m.ExpiresAt.Format(time.RFC3339))
+	}
+	last := lastUsed(m)
+	for _, rule := range p.Rules {
+		if strings.HasPrefix(m.Filename, rule.Prefix) && now.Sub(last) >= rule.NotAccessedFor {
+			return fmt.Sprintf("not accessed since %s (rule prefix=%q, %s)", last.Format(time.RFC3339), rule.Prefix, rule.NotAccessedFor)
+		}
+	}
+	return ""
+}
+
+// ApplyLifecycle удаляет файлы с истёкшим сроком жизни и попавшие под
+// правила policy. Каждое удаление пишется в лог с тегом [LIFECYCLE].
+// Файл, изменённый после проверки, не удаляется.
+func (s *FileService) ApplyLifecycle(ctx context.Context, policy Lifecycle) (expired []Expired, err error) {
+	ctx, span := tracer.Start(ctx, "FileService.ApplyLifecycle")
+	defer func() { endSpan(span, err) }()
+
+	for _, rule := range policy.Rules {
+		if rule.NotAccessedFor <= 0 {
+			return nil, fmt.Errorf("lifecycle rule %q: not accessed period must be positive", rule.Prefix)
+		}
+	}
+
+	metas, err := s.repo.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var errs []error
+	for _, m := range metas {
+		if err := ctx.Err(); err != nil {
+			return expired, err
+		}
+		reason := policy.expiredReason(m, now)
+		if reason == "" {
+			continue
+		}
+		if policy.DryRun {
+			log.Printf("[LIFECYCLE] dry-run: удалил бы %s: %s", m.Filename, reason)
+			expired = append(expired, Expired{Filename: m.Filename, Reason: reason})
+			continue
+		}
+
+		// Удаляем только то содержимое, которое проверили
+		cond := repository.Preconditions{IfMatch: m.ETag, IfUnmodifiedSince: m.UpdatedAt}
+		err := s.repo.Delete(ctx, m.Filename, cond)
+		if errors.Is(err, repository.ErrNotFound) || errors.Is(err, repository.ErrPreconditionFailed) {
+			log.Printf("[LIFECYCLE] пропущен %s: файл изменился или уже удалён", m.Filename)
+			continue
+		}
+		if err != nil {
+			log.Printf("[LIFECYCLE] ошибка удаления %s: %v", m.Filename, err)
+			errs = append(errs, fmt.Errorf("failed to delete %s: %w", m.Filename, err))
+			continue
+		}
+		log.Printf("[LIFECYCLE] удалён %s (размер=%d, etag=%s): %s", m.Filename, m.Size, m.ETag, reason)
+		expired = append(expired, Expired{Filename: m.Filename, Reason: reason})
+	}
+	span.SetAttributes(attribute.Int("files.expired", len(expired)), attribute.Bool("dry_run", policy.DryRun))
+	return expired, errors.Join(errs...)
+}
+
+// RunLifecycle применяет политику удаления файлов каждые interval до отмены ctx
+func (s *FileService) RunLifecycle(ctx context.Context, interval time.Duration, policy Lifecycle) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			expired, err := s.ApplyLifecycle(ctx, policy)
+			if err != nil {
+				log.Printf("[LIFECYCLE] ошибка: %v", err)
+			}
+			if len(expired) > 0 {
+				log.Printf("[LIFECYCLE] обработано файлов: %d, dry-run: %t", len(expired), policy.DryRun)
+			}
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Hiddan13/file_grpc/internal/repository"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ---------------------------------------------------------------------
+// ApplyLifecycle
+// ---------------------------------------------------------------------
+func TestFileService_ApplyLifecycle(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	files := []repository.FileMeta{
+		{Filename: "keep.txt", UpdatedAt: now, ETag: "k"},
+		{Filename: "scratch.txt", UpdatedAt: now, ExpiresAt: now.Add(-time.Minute), ETag: "s"},
+		{Filename: "tmp-old", UpdatedAt: now.Add(-48 * time.Hour), ETag: "o"},
+		{Filename: "tmp-read", UpdatedAt: now.Add(-48 * time.Hour), LastAccessedAt: now, ETag: "r"},
+		{Filename: "old.txt", UpdatedAt: now.Add(-48 * time.Hour), ETag: "x"},
+	}
+	policy := Lifecycle{Rules: []LifecycleRule{{Prefix: "tmp-", NotAccessedFor: 24 * time.Hour}}}
+
+	newMock := func(deleted map[string]repository.Preconditions) *mockRepo {
+		return &mockRepo{
+			listFunc: func(ctx context.Context) ([]repository.FileMeta, error) { return files, nil },
+			deleteFunc: func(ctx context.Context, filename string, cond repository.Preconditions) error {
+				deleted[filename] = cond
+				return nil
+			},
+		}
+	}
+
+	t.Run("deletes expired files", func(t *testing.T) {
+		deleted := map[string]repository.Preconditions{}
+		svc := NewFileService(newMock(deleted))
+
+		expired, err := svc.ApplyLifecycle(ctx, policy)
+		require.NoError(t, err)
+		require.Len(t, expired, 2)
+		assert.Contains(t, expired[0].Reason, "ttl")
+		assert.Equal(t, "tmp-old", expired[1].Filename)
+		assert.Len(t, deleted, 2)
+		assert.Equal(t, "s", deleted["scratch.txt"].IfMatch)
+	})
+
+	t.Run("dry run", func(t *testing.T) {
+		deleted := map[string]repository.Preconditions{}
+		svc := NewFileService(newMock(deleted))
+
+		expired, err := svc.ApplyLifecycle(ctx, Lifecycle{Rules: policy.Rules, DryRun: true})
+		require.NoError(t, err)
+		assert.Len(t, expired, 2)
+		assert.Empty(t, deleted)
+	})
+
+	t.Run("changed file is skipped", func(t *testing.T) {
+		mock := &mockRepo{
+			listFunc: func(ctx context.Context) ([]repository.FileMeta, error) { return files, nil },
+			deleteFunc: func(ctx context.Context, filename string, cond repository.Preconditions) error {
+				return repository.ErrPreconditionFailed
+			},
+		}
+		expired, err := NewFileService(mock).ApplyLifecycle(ctx, policy)
+		require.NoError(t, err)
+		assert.Empty(t, expired)
+	})
+
+	t.Run("invalid rule", func(t *testing.T) {
+		svc := NewFileService(&mockRepo{})
+		_, err := svc.ApplyLifecycle(ctx, Lifecycle{Rules: []LifecycleRule{{Prefix: "tmp-"}}})
+		assert.Error(t, err)
+	})
+}
+
+// ---------------------------------------------------------------------
+// ApplyLifecycle с access_time relatime
+// ---------------------------------------------------------------------
+func TestFileService_ApplyLifecycleRelatime(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	repo, err := repository.NewFilesRepository(dir, repository.Options{AccessTime: repository.AccessTimeRelatime})
+	require.NoError(t, err)
+	_, err = repo.Save(ctx, "tmp-a", []byte("a"), repository.SaveOptions{})
+	require.NoError(t, err)
+
+	// На диске: файл записан двое суток назад, время доступа сохранено 23
+	// часа назад - relatime ещё не сохранит его снова
+	metaPath := filepath.Join(dir, repository.MetaDir, "tmp-a.json")
+	raw, err := os.ReadFile(metaPath)
+	require.NoError(t, err)
+	var meta repository.FileMeta
+	require.NoError(t, json.Unmarshal(raw, &meta))
+	meta.UpdatedAt = time.Now().Add(-48 * time.Hour)
+	meta.LastAccessedAt = time.Now().Add(-23 * time.Hour)
+	raw, err = json.Marshal(meta)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(metaPath, raw, 0644))
+
+	repo, err = repository.NewFilesRepository(dir, repository.Options{AccessTime: repository.AccessTimeRelatime})
+	require.NoError(t, err)
+	_, err = repo.Reindex(ctx)
+	require.NoError(t, err)
+	svc := NewFileService(repo)
+
+	// Файл только что скачали: правило на час его не трогает
+	require.NoError(t, svc.UpdateAccess(ctx, "tmp-a", 1))
+	expired, err := svc.ApplyLifecycle(ctx, Lifecycle{Rules: []LifecycleRule{{Prefix: "tmp-", NotAccessedFor: time.Hour}}})
+	require.NoError(t, err)
+	assert.Empty(t, expired)
+	_, err = svc.GetFileInfo(ctx, "tmp-a")
+	assert.NoError(t, err)
+}
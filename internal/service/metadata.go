@@ -0,0 +1,91 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/Hiddan13/file_grpc/internal/repository"
+	"github.com/Hiddan13/file_grpc/internal/tracing"
+)
+
+// Ограничения на пользовательские метаданные
+const (
+	MaxMetadataKeys  = 64
+	MaxMetadataKey   = 128
+	MaxMetadataValue = 1024
+	MaxTags          = 32
+	MaxTagLength     = 128
+)
+
+var ErrInvalidMetadata = errors.New("invalid metadata")
+
+// ListFilter - отбор файлов в ListFiles, пустой - все файлы
+type ListFilter struct {
+	Prefix string   // имя файла начинается с Prefix
+	Tags   []string // у файла должны быть все перечисленные теги
+}
+
+// Match проверяет, подходит ли файл под фильтр
+func (f ListFilter) Match(meta repository.FileMeta) bool {
+	if !strings.HasPrefix(meta.Filename, f.Prefix) {
+		return false
+	}
+	for _, tag := range f.Tags {
+		if !slices.Contains(meta.Tags, tag) {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidateMetadata проверяет размеры пользовательских метаданных и тегов
+func ValidateMetadata(metadata map[string]string, tags []string) error {
+	if len(metadata) > MaxMetadataKeys {
+		return fmt.Errorf("%w: more than %d keys", ErrInvalidMetadata, MaxMetadataKeys)
+	}
+	for k, v := range metadata {
+		if k == "" || len(k) > MaxMetadataKey {
+			return fmt.Errorf("%w: key %q must be 1..%d bytes", ErrInvalidMetadata, k, MaxMetadataKey)
+		}
+		if len(v) > MaxMetadataValue {
+			return fmt.Errorf("%w: value of %q is longer than %d bytes", ErrInvalidMetadata, k, MaxMetadataValue)
+		}
+	}
+	if len(tags) > MaxTags {
+		return fmt.Errorf("%w: more than %d tags", ErrInvalidMetadata, MaxTags)
+	}
+	for _, tag := range tags {
+		if tag == "" || len(tag) > MaxTagLength {
+			return fmt.Errorf("%w: tag %q must be 1..%d bytes", ErrInvalidMetadata, tag, MaxTagLength)
+		}
+	}
+	return nil
+}
+
+// normalizeTags сортирует теги и убирает повторы
+func normalizeTags(tags []string) []string {
+	if tags == nil {
+		return nil
+	}
+	tags = slices.Clone(tags)
+	slices.Sort(tags)
+	return slices.Compact(tags)
+}
+
+// SetMetadata заменяет пользовательские метаданные и теги файла
+func (s *FileService) SetMetadata(ctx context.Context, filename string, metadata map[string]string, tags []string) (meta repository.FileMeta, err error) {
+	ctx, span := tracer.Start(ctx, "FileService.SetMetadata")
+	defer func() { endSpan(span, err) }()
+	span.SetAttributes(tracing.AttrFilename.String(filename))
+
+	if err := ValidateFilename(filename); err != nil {
+		return repository.FileMeta{}, err
+	}
+	if err := ValidateMetadata(metadata, tags); err != nil {
+		return repository.FileMeta{}, err
+	}
+	return s.repo.SetMetadata(ctx, filename, metadata, normalizeTags(tags))
+}
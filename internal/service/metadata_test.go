@@ -0,0 +1,90 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/Hiddan13/file_grpc/internal/repository"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ---------------------------------------------------------------------
+// Метаданные и теги
+// ---------------------------------------------------------------------
+func TestValidateMetadata(t *testing.T) {
+	assert.NoError(t, ValidateMetadata(map[string]string{"owner": "alice"}, []string{"report"}))
+	assert.NoError(t, ValidateMetadata(nil, nil))
+
+	tests := []struct {
+		name     string
+		metadata map[string]string
+		tags     []string
+	}{
+		{"empty key", map[string]string{"": "x"}, nil},
+		{"long value", map[string]string{"k": strings.Repeat("x", MaxMetadataValue+1)}, nil},
+		{"empty tag", nil, []string{""}},
+		{"too many tags", nil, make([]string, MaxTags+1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.ErrorIs(t, ValidateMetadata(tt.metadata, tt.tags), ErrInvalidMetadata)
+		})
+	}
+}
+
+func TestFileService_SetMetadata(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("normalizes tags", func(t *testing.T) {
+		var gotTags []string
+		mock := &mockRepo{
+			setMetadataFunc: func(ctx context.Context, filename string, metadata map[string]string, tags []string) (repository.FileMeta, error) {
+				gotTags = tags
+				return repository.FileMeta{Filename: filename, Tags: tags}, nil
+			},
+		}
+		_, err := NewFileService(mock).SetMetadata(ctx, "a.txt", nil, []string{"b", "a", "b"})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"a", "b"}, gotTags)
+	})
+
+	t.Run("invalid metadata", func(t *testing.T) {
+		_, err := NewFileService(&mockRepo{}).SetMetadata(ctx, "a.txt", map[string]string{"": "x"}, nil)
+		assert.ErrorIs(t, err, ErrInvalidMetadata)
+	})
+
+	t.Run("invalid filename", func(t *testing.T) {
+		_, err := NewFileService(&mockRepo{}).SetMetadata(ctx, "../a.txt", nil, nil)
+		assert.ErrorIs(t, err, ErrInvalidFilename)
+	})
+}
+
+func TestFileService_ListFilesByTag(t *testing.T) {
+	mock := &mockRepo{
+		listFunc: func(ctx context.Context) ([]repository.FileMeta, error) {
+			return []repository.FileMeta{
+				{Filename: "a.txt", Tags: []string{"report", "2024"}},
+				{Filename: "b.txt", Tags: []string{"report"}},
+				{Filename: "c.txt"},
+			}, nil
+		},
+	}
+	svc := NewFileService(mock)
+
+	metas, err := svc.ListFiles(context.Background(), ListFilter{Tags: []string{"report", "2024"}})
+	require.NoError(t, err)
+	require.Len(t, metas, 1)
+	assert.Equal(t, "a.txt", metas[0].Filename)
+
+	metas, err = svc.ListFiles(context.Background(), ListFilter{Prefix: "b", Tags: []string{"report"}})
+	require.NoError(t, err)
+	require.Len(t, metas, 1)
+	assert.Equal(t, "b.txt", metas[0].Filename)
+
+	metas, err = svc.ListFiles(context.Background(), ListFilter{})
+	require.NoError(t, err)
+	assert.Len(t, metas, 3)
+}
@@ -0,0 +1,119 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/Hiddan13/file_grpc/internal/repository"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// scrubStats - итоги проверок хранилища для метрик
+type scrubStats struct {
+	mu       sync.Mutex
+	runs     int64
+	failures int64
+	last     repository.ScrubReport
+	lastAt   time.Time
+	duration time.Duration
+	repaired int64 // проблем исправлено за всё время
+}
+
+// Scrub проверяет целостность хранилища и запоминает итог для метрик.
+// Каждая проблема пишется в лог с тегом [SCRUB].
+func (s *FileService) Scrub(ctx context.Context, opts repository.ScrubOptions) (report repository.ScrubReport, err error) {
+	ctx, span := tracer.Start(ctx, "FileService.Scrub")
+	defer func() { endSpan(span, err) }()
+
+	start := time.Now()
+	report, err = s.repo.Scrub(ctx, opts)
+	for _, issue := range report.Issues {
+		if issue.Action != "" {
+			log.Printf("[SCRUB] %s: %s (%s): %s", issue.Filename, issue.Kind, issue.Detail, issue.Action)
+		} else {
+			log.Printf("[SCRUB] %s: %s (%s)", issue.Filename, issue.Kind, issue.Detail)
+		}
+	}
+
+	st := &s.scrub
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.runs++
+	if err != nil {
+		st.failures++
+		return report, err
+	}
+	st.last, st.lastAt, st.duration = report, start, time.Since(start)
+	for _, issue := range report.Issues {
+		if issue.Action != "" {
+			st.repaired++
+		}
+	}
+	span.SetAttributes(attribute.Int("files.checked", report.Checked), attribute.Int("files.issues", len(report.Issues)))
+	return report, nil
+}
+
+// RunScrub проверяет хранилище каждые interval до отмены ctx
+func (s *FileService) RunScrub(ctx context.Context, interval time.Duration, opts repository.ScrubOptions) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			report, err := s.Scrub(ctx, opts)
+			if err != nil {
+				log.Printf("[SCRUB] ошибка: %v", err)
+				continue
+			}
+			log.Printf("[SCRUB] проверено файлов: %d, байт: %d, проблем: %d", report.Checked, report.Bytes, len(report.Issues))
+		}
+	}
+}
+
+// WriteScrubMetrics пишет итоги проверок в текстовом формате Prometheus
+func (s *FileService) WriteScrubMetrics(w io.Writer) error {
+	st := &s.scrub
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	byKind := make(map[string]int, len(repository.ScrubKinds))
+	for _, issue := range st.last.Issues {
+		byKind[issue.Kind]++
+	}
+	var lastAt int64
+	if !st.lastAt.IsZero() {
+		lastAt = st.lastAt.Unix()
+	}
+
+	metric := func(name, typ, help string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	}
+	metric("file_grpc_scrub_runs_total", "counter", "Storage integrity checks started.")
+	fmt.Fprintf(w, "file_grpc_scrub_runs_total %d\n", st.runs)
+	metric("file_grpc_scrub_failures_total", "counter", "Storage integrity checks that failed to complete.")
+	fmt.Fprintf(w, "file_grpc_scrub_failures_total %d\n", st.failures)
+	metric("file_grpc_scrub_repaired_total", "counter", "Issues repaired or quarantined.")
+	fmt.Fprintf(w, "file_grpc_scrub_repaired_total %d\n", st.repaired)
+	metric("file_grpc_scrub_last_run_timestamp_seconds", "gauge", "Start of the last completed check, 0 - none yet.")
+	fmt.Fprintf(w, "file_grpc_scrub_last_run_timestamp_seconds %d\n", lastAt)
+	metric("file_grpc_scrub_last_duration_seconds", "gauge", "Duration of the last completed check.")
+	fmt.Fprintf(w, "file_grpc_scrub_last_duration_seconds %g\n", st.duration.Seconds())
+	metric("file_grpc_scrub_files_checked", "gauge", "Files checked by the last completed check.")
+	fmt.Fprintf(w, "file_grpc_scrub_files_checked %d\n", st.last.Checked)
+	metric("file_grpc_scrub_bytes_checked", "gauge", "Bytes hashed by the last completed check.")
+	fmt.Fprintf(w, "file_grpc_scrub_bytes_checked %d\n", st.last.Bytes)
+	metric("file_grpc_scrub_issues", "gauge", "Issues found by the last completed check.")
+	for _, kind := range repository.ScrubKinds {
+		if _, err := fmt.Fprintf(w, "file_grpc_scrub_issues{kind=%q} %d\n", kind, byKind[kind]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
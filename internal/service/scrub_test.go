@@ -0,0 +1,61 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/Hiddan13/file_grpc/internal/repository"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ---------------------------------------------------------------------
+// Scrub
+// ---------------------------------------------------------------------
+func TestFileService_Scrub(t *testing.T) {
+	ctx := context.Background()
+	var gotOpts repository.ScrubOptions
+	fail := false
+	svc := NewFileService(&mockRepo{
+		scrubFunc: func(ctx context.Context, opts repository.ScrubOptions) (repository.ScrubReport, error) {
+			gotOpts = opts
+			if fail {
+				return repository.ScrubReport{}, errors.New("disk error")
+			}
+			return repository.ScrubReport{Checked: 3, Bytes: 42, Issues: []repository.ScrubIssue{
+				{Filename: "a", Kind: repository.ScrubChecksumMismatch, Action: "quarantined to .quarantine/a"},
+				{Filename: "b", Kind: repository.ScrubOrphaned},
+			}}, nil
+		},
+	})
+
+	report, err := svc.Scrub(ctx, repository.ScrubOptions{Quarantine: true})
+	require.NoError(t, err)
+	assert.Len(t, report.Issues, 2)
+	assert.True(t, gotOpts.Quarantine)
+
+	fail = true
+	_, err = svc.Scrub(ctx, repository.ScrubOptions{})
+	assert.Error(t, err)
+
+	t.Run("metrics keep the last completed check", func(t *testing.T) {
+		var buf strings.Builder
+		require.NoError(t, svc.WriteScrubMetrics(&buf))
+		out := buf.String()
+		for _, line := range []string{
+			"file_grpc_scrub_runs_total 2",
+			"file_grpc_scrub_failures_total 1",
+			"file_grpc_scrub_repaired_total 1",
+			"file_grpc_scrub_files_checked 3",
+			"file_grpc_scrub_bytes_checked 42",
+			`file_grpc_scrub_issues{kind="checksum_mismatch"} 1`,
+			`file_grpc_scrub_issues{kind="orphaned"} 1`,
+			`file_grpc_scrub_issues{kind="missing"} 0`,
+		} {
+			assert.Contains(t, out, line+"\n")
+		}
+	})
+}
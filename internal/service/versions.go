@@ -0,0 +1,96 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/Hiddan13/file_grpc/internal/repository"
+	"github.com/Hiddan13/file_grpc/internal/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Retention - сколько хранить предыдущие версии, 0 - без ограничения
+type Retention struct {
+	KeepLast int
+	MaxAge   time.Duration
+}
+
+// ListVersions вернёт сохранённые версии файла
+func (s *FileService) ListVersions(ctx context.Context, filename string) (versions []repository.VersionMeta, err error) {
+	ctx, span := tracer.Start(ctx, "FileService.ListVersions")
+	defer func() { endSpan(span, err) }()
+	span.SetAttributes(tracing.AttrFilename.String(filename))
+
+	if err := ValidateFilename(filename); err != nil {
+		return nil, err
+	}
+	return s.repo.ListVersions(ctx, filename)
+}
+
+// GetFileVersion вернёт содержимое версии файла
+func (s *FileService) GetFileVersion(ctx context.Context, filename string, version int) (data []byte, err error) {
+	ctx, span := tracer.Start(ctx, "FileService.GetFileVersion")
+	defer func() { endSpan(span, err) }()
+	span.SetAttributes(tracing.AttrFilename.String(filename), attribute.Int("file.version", version))
+
+	if err := ValidateFilename(filename); err != nil {
+		return nil, err
+	}
+	if version <= 0 {
+		return nil, fmt.Errorf("%w: %d", ErrInvalidVersion, version)
+	}
+	return s.repo.GetVersion(ctx, filename, version)
+}
+
+// RestoreVersion делает версию текущим содержимым файла
+func (s *FileService) RestoreVersion(ctx context.Context, filename string, version int) (err error) {
+	ctx, span := tracer.Start(ctx, "FileService.RestoreVersion")
+	defer func() { endSpan(span, err) }()
+	span.SetAttributes(tracing.AttrFilename.String(filename), attribute.Int("file.version", version))
+
+	if err := ValidateFilename(filename); err != nil {
+		return err
+	}
+	if version <= 0 {
+		return fmt.Errorf("%w: %d", ErrInvalidVersion, version)
+	}
+	return s.repo.RestoreVersion(ctx, filename, version)
+}
+
+// ApplyRetention удаляет версии, вышедшие за политику хранения
+func (s *FileService) ApplyRetention(ctx context.Context, policy Retention) (removed int, err error) {
+	ctx, span := tracer.Start(ctx, "FileService.ApplyRetention")
+	defer func() { endSpan(span, err) }()
+
+	if policy.KeepLast < 0 || policy.MaxAge < 0 {
+		return 0, fmt.Errorf("retention must not be negative")
+	}
+	if policy.KeepLast == 0 && policy.MaxAge == 0 {
+		return 0, nil
+	}
+	return s.repo.ApplyRetention(ctx, policy.KeepLast, policy.MaxAge)
+}
+
+// RunRetention применяет политику хранения версий каждые interval до отмены ctx
+func (s *FileService) RunRetention(ctx context.Context, interval time.Duration, policy Retention) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			removed, err := s.ApplyRetention(ctx, policy)
+			if err != nil {
+				log.Printf("[RETENTION] ошибка: %v", err)
+				continue
+			}
+			if removed > 0 {
+				log.Printf("[RETENTION] удалено версий: %d", removed)
+			}
+		}
+	}
+}
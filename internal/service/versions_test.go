@@ -0,0 +1,80 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ---------------------------------------------------------------------
+// Версии
+// ---------------------------------------------------------------------
+func TestFileService_Versions(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("get version", func(t *testing.T) {
+		mock := &mockRepo{
+			getVersionFunc: func(ctx context.Context, filename string, version int) ([]byte, error) {
+				assert.Equal(t, "a.txt", filename)
+				assert.Equal(t, 2, version)
+				return []byte("v2"), nil
+			},
+		}
+		data, err := NewFileService(mock).GetFileVersion(ctx, "a.txt", 2)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("v2"), data)
+	})
+
+	t.Run("invalid version", func(t *testing.T) {
+		svc := NewFileService(&mockRepo{})
+		_, err := svc.GetFileVersion(ctx, "a.txt", 0)
+		assert.Error(t, err)
+		assert.Error(t, svc.RestoreVersion(ctx, "a.txt", -1))
+	})
+
+	t.Run("invalid filename", func(t *testing.T) {
+		svc := NewFileService(&mockRepo{})
+		_, err := svc.ListVersions(ctx, "../a.txt")
+		assert.Error(t, err)
+		assert.Error(t, svc.RestoreVersion(ctx, "../a.txt", 1))
+	})
+}
+
+// ---------------------------------------------------------------------
+// ApplyRetention
+// ---------------------------------------------------------------------
+func TestFileService_ApplyRetention(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("passes policy to repository", func(t *testing.T) {
+		mock := &mockRepo{
+			retentionFunc: func(ctx context.Context, keepLast int, maxAge time.Duration) (int, error) {
+				assert.Equal(t, 3, keepLast)
+				assert.Equal(t, time.Hour, maxAge)
+				return 2, nil
+			},
+		}
+		removed, err := NewFileService(mock).ApplyRetention(ctx, Retention{KeepLast: 3, MaxAge: time.Hour})
+		require.NoError(t, err)
+		assert.Equal(t, 2, removed)
+	})
+
+	t.Run("no policy", func(t *testing.T) {
+		mock := &mockRepo{
+			retentionFunc: func(ctx context.Context, keepLast int, maxAge time.Duration) (int, error) {
+				t.Fatal("retention без политики не должна удалять версии")
+				return 0, nil
+			},
+		}
+		_, err := NewFileService(mock).ApplyRetention(ctx, Retention{})
+		assert.NoError(t, err)
+	})
+
+	t.Run("negative policy", func(t *testing.T) {
+		_, err := NewFileService(&mockRepo{}).ApplyRetention(ctx, Retention{KeepLast: -1})
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,77 @@
+package tracing
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Hiddan13/file_grpc/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/metadata"
+)
+
+// ---------------------------------------------------------------------
+// Setup
+// ---------------------------------------------------------------------
+func TestSetup(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("file exporter writes spans", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "traces.json")
+		shutdown, err := Setup(ctx, "test", &config.Config{TracingExporter: ExporterFile, TracingFile: path})
+		require.NoError(t, err)
+
+		_, span := otel.Tracer("test").Start(ctx, "test-span")
+		span.SetAttributes(AttrFilename.String("a.txt"))
+		span.End()
+		require.NoError(t, shutdown(ctx))
+
+		content, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Contains(t, string(content), "test-span")
+		assert.Contains(t, string(content), "a.txt")
+	})
+
+	t.Run("none exporter", func(t *testing.T) {
+		shutdown, err := Setup(ctx, "test", &config.Config{TracingExporter: ExporterNone})
+		require.NoError(t, err)
+		assert.NoError(t, shutdown(ctx))
+	})
+
+	t.Run("unknown exporter", func(t *testing.T) {
+		_, err := Setup(ctx, "test", &config.Config{TracingExporter: "jaeger"})
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "unknown tracing exporter")
+	})
+}
+
+// ---------------------------------------------------------------------
+// Пропагация через gRPC metadata
+// ---------------------------------------------------------------------
+func TestInjectExtract(t *testing.T) {
+	_, err := Setup(context.Background(), "test", &config.Config{TracingExporter: ExporterNone})
+	require.NoError(t, err)
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	ctx, span := otel.Tracer("test").Start(context.Background(), "client")
+	defer span.End()
+
+	outCtx := Inject(ctx)
+	md, ok := metadata.FromOutgoingContext(outCtx)
+	require.True(t, ok)
+	assert.NotEmpty(t, md.Get("traceparent"))
+
+	// Сервер видит те же metadata как входящие
+	inCtx := Extract(metadata.NewIncomingContext(context.Background(), md))
+	remote := trace.SpanContextFromContext(inCtx)
+	assert.True(t, remote.IsRemote())
+	assert.Equal(t, span.SpanContext().TraceID(), remote.TraceID())
+}
@@ -0,0 +1,200 @@
+package grpc
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"log"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/Hiddan13/file_grpc/api/proto/pb"
+	"github.com/Hiddan13/file_grpc/internal/config"
+	"github.com/Hiddan13/file_grpc/internal/repository"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// AdminServer - операционные RPC поверх FileServer
+type AdminServer struct {
+	pb.UnimplementedAdminServiceServer
+	fileServer *FileServer
+	cfg        atomic.Pointer[config.Config]
+}
+
+func NewAdminServer(fileServer *FileServer, cfg *config.Config) *AdminServer {
+	a := &AdminServer{fileServer: fileServer}
+	a.cfg.Store(cfg)
+	return a
+}
+
+// SetConfig подменяет конфиг, который отдаёт GetConfig (после SIGHUP)
+func (a *AdminServer) SetConfig(cfg *config.Config) {
+	a.cfg.Store(cfg)
+}
+
+func (a *AdminServer) GetLimits(ctx context.Context, _ *pb.Empty) (*pb.LimitsResponse, error) {
+	return a.fileServer.limitsResponse(), nil
+}
+
+func (a *AdminServer) SetLimits(ctx context.Context, req *pb.SetLimitsRequest) (*pb.LimitsResponse, error) {
+	err := a.fileServer.SetLimits(int(req.GetUploadLimit()), int(req.GetDownloadLimit()), int(req.GetListLimit()))
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return a.fileServer.limitsResponse(), nil
+}
+
+func (a *AdminServer) ListTransfers(ctx context.Context, _ *pb.Empty) (*pb.ListTransfersResponse, error) {
+	resp := &pb.ListTransfersResponse{}
+	for _, tr := range a.fileServer.transfers.list() {
+		resp.Transfers = append(resp.Transfers, &pb.Transfer{
+			Id:        tr.id,
+			Kind:      tr.kind,
+			Filename:  tr.getFilename(),
+			Peer:      tr.peer,
+			Bytes:     tr.bytes.Load(),
+			StartedAt: tr.startedAt.Format(time.RFC3339),
+		})
+	}
+	return resp, nil
+}
+
+func (a *AdminServer) CancelTransfer(ctx context.Context, req *pb.CancelTransferRequest) (*pb.Empty, error) {
+	if !a.fileServer.transfers.cancel(req.GetId()) {
+		return nil, status.Errorf(codes.NotFound, "transfer not found: %s", req.GetId())
+	}
+	log.Printf("[ADMIN] передача %s отменена", req.GetId())
+	return &pb.Empty{}, nil
+}
+
+func (a *AdminServer) Reindex(ctx context.Context, _ *pb.Empty) (*pb.ReindexResponse, error) {
+	res, err := a.fileServer.fileService.Reindex(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "reindex failed: %v", err)
+	}
+	log.Printf("[ADMIN] reindex: файлов=%d, добавлено=%d, удалено=%d", res.Files, res.Added, res.Removed)
+	return &pb.ReindexResponse{
+		Files:   int32(res.Files),
+		Added:   int32(res.Added),
+		Removed: int32(res.Removed),
+	}, nil
+}
+
+func (a *AdminServer) CollectGarbage(ctx context.Context, req *pb.CollectGarbageRequest) (*pb.CollectGarbageResponse, error) {
+	olderThan := time.Duration(req.GetOlderThanSeconds()) * time.Second
+	removed, err := a.fileServer.fileService.CollectGarbage(ctx, olderThan, req.GetDryRun())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "gc failed: %v", err)
+	}
+	log.Printf("[ADMIN] gc: удалено=%d, dry_run=%v", len(removed), req.GetDryRun())
+	return &pb.CollectGarbageResponse{Removed: removed}, nil
+}
+
+func (a *AdminServer) Scrub(ctx context.Context, req *pb.ScrubRequest) (*pb.ScrubResponse, error) {
+	report, err := a.fileServer.fileService.Scrub(ctx, repository.ScrubOptions{
+		RepairMetadata: req.GetRepairMetadata(),
+		Quarantine:     req.GetQuarantine(),
+	})
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "scrub failed: %v", err)
+	}
+	log.Printf("[ADMIN] scrub: проверено=%d, проблем=%d", report.Checked, len(report.Issues))
+	resp := &pb.ScrubResponse{Checked: int32(report.Checked), Bytes: report.Bytes}
+	for _, issue := range report.Issues {
+		resp.Issues = append(resp.Issues, &pb.ScrubIssue{
+			Filename: issue.Filename,
+			Kind:     issue.Kind,
+			Detail:   issue.Detail,
+			Action:   issue.Action,
+		})
+	}
+	return resp, nil
+}
+
+func (a *AdminServer) GetConfig(ctx context.Context, _ *pb.Empty) (*pb.ConfigResponse, error) {
+	var buf bytes.Buffer
+	if err := a.cfg.Load().Print(&buf); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to render config: %v", err)
+	}
+	return &pb.ConfigResponse{
+		Yaml:   buf.String(),
+		Limits: a.fileServer.limitsResponse(),
+	}, nil
+}
+
+func (s *FileServer) limitsResponse() *pb.LimitsResponse {
+	st := func(l *limiter) *pb.LimitStatus {
+		return &pb.LimitStatus{
+			Limit:  int32(l.capacity()),
+			InUse:  int32(l.inUse()),
+			Queued: int32(l.queued()),
+		}
+	}
+	return &pb.LimitsResponse{
+		Upload:   st(s.uploadLimiter),
+		Download: st(s.downloadLimiter),
+		List:     st(s.listLimiter),
+	}
+}
+
+// Проверка admin токена ("authorization: Bearer <token>") для AdminService
+// и ReplicationService.
+// Пустой токен в конфиге отключает админские RPC.
+func checkAdminToken(ctx context.Context, token string) error {
+	if token == "" {
+		return status.Error(codes.PermissionDenied, "admin API is disabled")
+	}
+	if ValidAdminToken(ctx, token) {
+		return nil
+	}
+	log.Printf("[ADMIN] ОТКАЗ: неверный токен")
+	return status.Error(codes.Unauthenticated, "invalid admin token")
+}
+
+// ValidAdminToken - запрос передал admin токен token (непустой). Так узлы
+// кластера отличают запросы друг друга от клиентских.
+func ValidAdminToken(ctx context.Context, token string) bool {
+	if token == "" {
+		return false
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, v := range md.Get("authorization") {
+		got := strings.TrimPrefix(v, "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+func isAdminMethod(fullMethod string) bool {
+	return strings.HasPrefix(fullMethod, "/"+pb.AdminService_ServiceDesc.ServiceName+"/") ||
+		strings.HasPrefix(fullMethod, "/"+pb.ReplicationService_ServiceDesc.ServiceName+"/")
+}
+
+func AdminAuthUnaryInterceptor(token string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if isAdminMethod(info.FullMethod) {
+			if err := checkAdminToken(ctx, token); err != nil {
+				return nil, err
+			}
+		}
+		return handler(ctx, req)
+	}
+}
+
+func AdminAuthStreamInterceptor(token string) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if isAdminMethod(info.FullMethod) {
+			if err := checkAdminToken(ss.Context(), token); err != nil {
+				return err
+			}
+		}
+		return handler(srv, ss)
+	}
+}
@@ -0,0 +1,169 @@
+package grpc
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Hiddan13/file_grpc/api/proto/pb"
+	"github.com/Hiddan13/file_grpc/internal/config"
+	"github.com/Hiddan13/file_grpc/internal/repository"
+	"github.com/Hiddan13/file_grpc/internal/service"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// ---------------------------------------------------------------------
+// Проверка admin токена
+// ---------------------------------------------------------------------
+func TestAdminAuthUnaryInterceptor(t *testing.T) {
+	handler := func(ctx context.Context, req any) (any, error) { return "ok", nil }
+	adminInfo := &grpc.UnaryServerInfo{FullMethod: "/file.AdminService/GetLimits"}
+	withToken := func(token string) context.Context {
+		return metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+token))
+	}
+
+	t.Run("valid token", func(t *testing.T) {
+		_, err := AdminAuthUnaryInterceptor("secret")(withToken("secret"), nil, adminInfo, handler)
+		assert.NoError(t, err)
+	})
+
+	t.Run("invalid token", func(t *testing.T) {
+		_, err := AdminAuthUnaryInterceptor("secret")(withToken("wrong"), nil, adminInfo, handler)
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	})
+
+	t.Run("disabled without token", func(t *testing.T) {
+		_, err := AdminAuthUnaryInterceptor("")(withToken(""), nil, adminInfo, handler)
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	})
+
+	t.Run("file service is not guarded", func(t *testing.T) {
+		info := &grpc.UnaryServerInfo{FullMethod: "/file.FileService/ListFiles"}
+		_, err := AdminAuthUnaryInterceptor("secret")(context.Background(), nil, info, handler)
+		assert.NoError(t, err)
+	})
+}
+
+// ---------------------------------------------------------------------
+// SetLimits
+// ---------------------------------------------------------------------
+func TestAdminServer_SetLimits(t *testing.T) {
+	fs := NewFileServer(nil, 10, 10, 100, Admission{})
+	admin := NewAdminServer(fs, config.Default())
+
+	resp, err := admin.SetLimits(context.Background(), &pb.SetLimitsRequest{UploadLimit: 5})
+	require.NoError(t, err)
+	assert.Equal(t, int32(5), resp.Upload.Limit)
+	assert.Equal(t, int32(10), resp.Download.Limit) // 0 - не меняем
+	assert.Equal(t, int32(100), resp.List.Limit)
+
+	_, err = admin.SetLimits(context.Background(), &pb.SetLimitsRequest{ListLimit: -1})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+// ---------------------------------------------------------------------
+// ListTransfers / CancelTransfer
+// ---------------------------------------------------------------------
+func TestAdminServer_Transfers(t *testing.T) {
+	fs, _ := newTestFileServer(t, repository.Options{})
+	admin := NewAdminServer(fs, config.Default())
+	client := startTestServer(t, fs)
+
+	// Клиент прислал первый чанк и замолчал: сервер ждёт в Recv
+	stream, err := client.Upload(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&pb.UploadRequest{Filename: "a.txt", Chunk: []byte("hello")}))
+
+	var resp *pb.ListTransfersResponse
+	require.Eventually(t, func() bool {
+		resp, err = admin.ListTransfers(context.Background(), &pb.Empty{})
+		return err == nil && len(resp.Transfers) == 1 && resp.Transfers[0].Bytes == 5
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, "upload", resp.Transfers[0].Kind)
+	assert.Equal(t, "a.txt", resp.Transfers[0].Filename)
+
+	_, err = admin.CancelTransfer(context.Background(), &pb.CancelTransferRequest{Id: resp.Transfers[0].Id})
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool { return fs.transfers.count() == 0 }, time.Second, 5*time.Millisecond,
+		"отменённая передача не должна висеть в Recv")
+	_, err = stream.CloseAndRecv()
+	assert.Equal(t, codes.Canceled, status.Code(err))
+
+	_, err = admin.CancelTransfer(context.Background(), &pb.CancelTransferRequest{Id: "404"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+// ---------------------------------------------------------------------
+// Reindex / CollectGarbage / Scrub / GetConfig
+// ---------------------------------------------------------------------
+func TestAdminServer_Maintenance(t *testing.T) {
+	dir := t.TempDir()
+	repo, err := repository.NewFilesRepository(dir, repository.Options{})
+	require.NoError(t, err)
+	fs := NewFileServer(service.NewFileService(repo), 10, 10, 100, Admission{})
+	cfg := config.Default()
+	cfg.AdminToken = "secret"
+	admin := NewAdminServer(fs, cfg)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.txt"), []byte("a"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, repository.TempPrefix+"upload"), []byte("x"), 0644))
+
+	t.Run("reindex", func(t *testing.T) {
+		resp, err := admin.Reindex(context.Background(), &pb.Empty{})
+		require.NoError(t, err)
+		assert.Equal(t, int32(1), resp.Files)
+		assert.Equal(t, int32(1), resp.Added)
+	})
+
+	t.Run("gc dry run", func(t *testing.T) {
+		resp, err := admin.CollectGarbage(context.Background(), &pb.CollectGarbageRequest{DryRun: true})
+		require.NoError(t, err)
+		assert.Equal(t, []string{repository.TempPrefix + "upload"}, resp.Removed)
+		assert.FileExists(t, filepath.Join(dir, repository.TempPrefix+"upload"))
+	})
+
+	t.Run("gc negative age", func(t *testing.T) {
+		_, err := admin.CollectGarbage(context.Background(), &pb.CollectGarbageRequest{OlderThanSeconds: -1})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("scrub", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "a.txt"), []byte("b"), 0644))
+
+		resp, err := admin.Scrub(context.Background(), &pb.ScrubRequest{})
+		require.NoError(t, err)
+		assert.Equal(t, int32(1), resp.Checked)
+		require.Len(t, resp.Issues, 1)
+		assert.Equal(t, repository.ScrubChecksumMismatch, resp.Issues[0].Kind)
+		assert.Empty(t, resp.Issues[0].Action)
+
+		resp, err = admin.Scrub(context.Background(), &pb.ScrubRequest{Quarantine: true})
+		require.NoError(t, err)
+		require.Len(t, resp.Issues, 1)
+		assert.Contains(t, resp.Issues[0].Action, repository.QuarantineDir)
+		assert.NoFileExists(t, filepath.Join(dir, "a.txt"))
+	})
+
+	t.Run("config", func(t *testing.T) {
+		resp, err := admin.GetConfig(context.Background(), &pb.Empty{})
+		require.NoError(t, err)
+		assert.Contains(t, resp.Yaml, "upload_limit: 10")
+		assert.NotContains(t, resp.Yaml, "secret")
+		assert.Equal(t, int32(10), resp.Limits.Upload.Limit)
+
+		newCfg := config.Default()
+		newCfg.UploadLimit = 20
+		admin.SetConfig(newCfg)
+		resp, err = admin.GetConfig(context.Background(), &pb.Empty{})
+		require.NoError(t, err)
+		assert.Contains(t, resp.Yaml, "upload_limit: 20")
+	})
+}
@@ -0,0 +1,244 @@
+package grpc
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"strings"
+
+	"github.com/Hiddan13/file_grpc/api/proto/pb"
+	"github.com/Hiddan13/file_grpc/internal/repository"
+	"github.com/Hiddan13/file_grpc/internal/service"
+	"github.com/Hiddan13/file_grpc/internal/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Форматы DownloadArchive
+const (
+	ArchiveTar   = "tar"
+	ArchiveTarGz = "tar.gz"
+	ArchiveZip   = "zip"
+)
+
+var archiveContentTypes = map[string]string{
+	ArchiveTar:   "application/x-tar",
+	ArchiveTarGz: "application/gzip",
+	ArchiveZip:   "application/zip",
+}
+
+// archiveWriter дописывает файлы в архив; Close завершает архив, не
+// закрывая нижний writer
+type archiveWriter interface {
+	add(meta repository.FileMeta, data []byte) error
+	Close() error
+}
+
+type tarArchive struct {
+	tw *tar.Writer
+	gz *gzip.Writer // nil для tar без сжатия
+}
+
+func (a *tarArchive) add(meta repository.FileMeta, data []byte) error {
+	hdr := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     meta.Filename,
+		Mode:     0644,
+		Size:     int64(len(data)),
+		ModTime:  meta.UpdatedAt,
+	}
+	if err := a.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := a.tw.Write(data)
+	return err
+}
+
+func (a *tarArchive) Close() error {
+	err := a.tw.Close()
+	if a.gz != nil {
+		err = errors.Join(err, a.gz.Close())
+	}
+	return err
+}
+
+type zipArchive struct {
+	zw *zip.Writer
+}
+
+func (a *zipArchive) add(meta repository.FileMeta, data []byte) error {
+	w, err := a.zw.CreateHeader(&zip.FileHeader{
+		Name:     meta.Filename,
+		Method:   zip.Deflate,
+		Modified: meta.UpdatedAt,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func (a *zipArchive) Close() error {
+	return a.zw.Close()
+}
+
+func newArchiveWriter(format string, w io.Writer) (archiveWriter, error) {
+	switch format {
+	case ArchiveTar:
+		return &tarArchive{tw: tar.NewWriter(w)}, nil
+	case ArchiveTarGz:
+		gz := gzip.NewWriter(w)
+		return &tarArchive{tw: tar.NewWriter(gz), gz: gz}, nil
+	case ArchiveZip:
+		return &zipArchive{zw: zip.NewWriter(w)}, nil
+	}
+	return nil, fmt.Errorf("unknown archive format %q, want %s, %s or %s", format, ArchiveTar, ArchiveTarGz, ArchiveZip)
+}
+
+// chunkWriter отдаёт всё записанное в стрим через sendChunks
+type chunkWriter struct {
+	ctx  context.Context
+	tr   *transfer
+	send func([]byte) error
+}
+
+func (w chunkWriter) Write(p []byte) (int, error) {
+	if _, err := sendChunks(w.ctx, w.tr, p, w.send); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// DownloadArchive собирает архив из выбранных файлов и отдаёт его по мере
+// записи: в памяти одновременно только один файл.
+func (s *FileServer) DownloadArchive(req *pb.DownloadArchiveRequest, stream pb.FileService_DownloadArchiveServer) (err error) {
+	ctx, span := tracer.Start(stream.Context(), "FileServer.DownloadArchive", trace.WithSpanKind(trace.SpanKindServer))
+	defer func() { endSpan(span, err) }()
+
+	format := req.GetFormat()
+	if format == "" {
+		format = ArchiveTar
+	}
+	if _, ok := archiveContentTypes[format]; !ok {
+		return status.Errorf(codes.InvalidArgument, "unknown archive format %q", format)
+	}
+	span.SetAttributes(attribute.String("archive.format", format))
+
+	tr, ctx, err := s.transfers.begin(ctx, "download", "archive")
+	if err != nil {
+		log.Printf("[ARCHIVE] ОТКАЗ: сервер останавливается")
+		return err
+	}
+	defer s.transfers.end(tr)
+
+	// Тип известен заранее: он уйдёт в одном заголовке с позицией в очереди
+	if err := stream.SetHeader(metadata.Pairs(ContentTypeHeader, archiveContentTypes[format])); err != nil {
+		log.Printf("[ARCHIVE] ошибка установки заголовка: %v", err)
+	}
+	if err := admit(ctx, s.downloadLimiter, stream.SendHeader); err != nil {
+		return err
+	}
+	defer func() {
+		s.downloadLimiter.release()
+		log.Printf("[ARCHIVE] завершён, активных скачиваний: %d", s.downloadLimiter.inUse())
+	}()
+
+	files, err := s.archiveFiles(ctx, req)
+	if err != nil {
+		log.Printf("[ARCHIVE] ошибка выбора файлов: %v", err)
+		return err
+	}
+	log.Printf("[ARCHIVE] формат=%s, файлов=%d", format, len(files))
+
+	// Буфер собирает мелкие записи архиватора в чанки по downloadChunkSize
+	out := bufio.NewWriterSize(chunkWriter{ctx: ctx, tr: tr, send: func(chunk []byte) error {
+		return stream.Send(&pb.DownloadArchiveResponse{Chunk: chunk})
+	}}, downloadChunkSize)
+	archive, err := newArchiveWriter(format, out)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	added := 0
+	for _, meta := range files {
+		tr.setFilename(meta.Filename)
+		data, err := s.fileService.GetFile(ctx, meta.Filename)
+		if errors.Is(err, repository.ErrNotFound) {
+			// Файл удалили после выбора - архив уже отдаётся, пропускаем
+			log.Printf("[ARCHIVE] пропущен удалённый файл %s", meta.Filename)
+			continue
+		}
+		if err != nil {
+			return fileStatus(err, codes.Internal, "failed to read file")
+		}
+		if err := archive.add(meta, data); err != nil {
+			log.Printf("[ARCHIVE] прервано на %s: %v", meta.Filename, err)
+			return archiveStatus(err)
+		}
+		if err := s.fileService.UpdateAccess(ctx, meta.Filename, int64(len(data))); err != nil {
+			log.Printf("[ARCHIVE] ошибка учёта доступа к %s: %v", meta.Filename, err)
+		}
+		added++
+	}
+	if err := archive.Close(); err != nil {
+		return archiveStatus(err)
+	}
+	if err := out.Flush(); err != nil {
+		return archiveStatus(err)
+	}
+
+	log.Printf("[ARCHIVE] отправлено файлов=%d, байт=%d", added, tr.bytes.Load())
+	span.SetAttributes(attribute.Int("files.count", added), tracing.AttrSize.Int64(tr.bytes.Load()))
+	return nil
+}
+
+// archiveFiles выбирает файлы архива: по списку имён (все должны
+// существовать) или по префиксу и тегам. Порядок - по имени.
+func (s *FileServer) archiveFiles(ctx context.Context, req *pb.DownloadArchiveRequest) ([]repository.FileMeta, error) {
+	var files []repository.FileMeta
+	if names := req.GetFilenames(); len(names) > 0 {
+		names = slices.Clone(names)
+		slices.Sort(names)
+		for _, name := range slices.Compact(names) {
+			if err := service.ValidateFilename(name); err != nil {
+				return nil, fileStatus(err, codes.InvalidArgument, "invalid filename")
+			}
+			meta, err := s.fileService.GetFileInfo(ctx, name)
+			if err != nil {
+				return nil, fileStatus(err, codes.Internal, "failed to stat file")
+			}
+			files = append(files, meta)
+		}
+		return files, nil
+	}
+
+	files, err := s.fileService.ListFiles(ctx, service.ListFilter{Prefix: req.GetPrefix(), Tags: req.GetTags()})
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to list files: %v", err)
+	}
+	if len(files) == 0 {
+		return nil, status.Error(codes.NotFound, "no files match")
+	}
+	slices.SortFunc(files, func(a, b repository.FileMeta) int { return strings.Compare(a.Filename, b.Filename) })
+	return files, nil
+}
+
+// archiveStatus сохраняет статус ошибки отправки, остальное - Internal
+func archiveStatus(err error) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	return status.Errorf(codes.Internal, "failed to build archive: %v", err)
+}
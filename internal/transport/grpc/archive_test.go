@@ -0,0 +1,108 @@
+package grpc
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/Hiddan13/file_grpc/api/proto/pb"
+	"github.com/Hiddan13/file_grpc/internal/repository"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func downloadArchive(t *testing.T, client pb.FileServiceClient, req *pb.DownloadArchiveRequest) ([]byte, string, error) {
+	t.Helper()
+	stream, err := client.DownloadArchive(context.Background(), req)
+	require.NoError(t, err)
+	var data []byte
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		data = append(data, resp.GetChunk()...)
+	}
+	md, err := stream.Header()
+	require.NoError(t, err)
+	return data, md.Get(ContentTypeHeader)[0], nil
+}
+
+func readTar(t *testing.T, r io.Reader) map[string]string {
+	t.Helper()
+	files := map[string]string{}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files
+		}
+		require.NoError(t, err)
+		data, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		files[hdr.Name] = string(data)
+	}
+}
+
+// ---------------------------------------------------------------------
+// DownloadArchive
+// ---------------------------------------------------------------------
+func TestFileServer_DownloadArchive(t *testing.T) {
+	fs, svc := newTestFileServer(t, repository.Options{})
+	client := startTestServer(t, fs)
+	ctx := context.Background()
+	big := bytes.Repeat([]byte("0123456789"), 20000) // больше одного чанка
+	for name, data := range map[string][]byte{"logs-a.txt": []byte("a"), "logs-b.txt": big, "other.txt": []byte("o")} {
+		_, err := svc.SaveFile(ctx, name, data, repository.SaveOptions{Tags: []string{"t-" + name[:1]}})
+		require.NoError(t, err)
+	}
+
+	t.Run("tar by prefix", func(t *testing.T) {
+		data, ct, err := downloadArchive(t, client, &pb.DownloadArchiveRequest{Prefix: "logs-"})
+		require.NoError(t, err)
+		assert.Equal(t, "application/x-tar", ct)
+		assert.Equal(t, map[string]string{"logs-a.txt": "a", "logs-b.txt": string(big)}, readTar(t, bytes.NewReader(data)))
+	})
+
+	t.Run("tar.gz by tag", func(t *testing.T) {
+		data, _, err := downloadArchive(t, client, &pb.DownloadArchiveRequest{Tags: []string{"t-o"}, Format: ArchiveTarGz})
+		require.NoError(t, err)
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{"other.txt": "o"}, readTar(t, gz))
+	})
+
+	t.Run("zip by names", func(t *testing.T) {
+		data, ct, err := downloadArchive(t, client, &pb.DownloadArchiveRequest{Filenames: []string{"other.txt", "logs-a.txt"}, Format: ArchiveZip})
+		require.NoError(t, err)
+		assert.Equal(t, "application/zip", ct)
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		require.NoError(t, err)
+		require.Len(t, zr.File, 2)
+		assert.Equal(t, "logs-a.txt", zr.File[0].Name)
+		f, err := zr.File[1].Open()
+		require.NoError(t, err)
+		content, err := io.ReadAll(f)
+		require.NoError(t, err)
+		assert.Equal(t, "o", string(content))
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		_, _, err := downloadArchive(t, client, &pb.DownloadArchiveRequest{Filenames: []string{"missing.txt"}})
+		assert.Equal(t, codes.NotFound, status.Code(err))
+		_, _, err = downloadArchive(t, client, &pb.DownloadArchiveRequest{Prefix: "none-"})
+		assert.Equal(t, codes.NotFound, status.Code(err))
+		_, _, err = downloadArchive(t, client, &pb.DownloadArchiveRequest{Format: "rar"})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+}
@@ -0,0 +1,186 @@
+package grpc
+
+import (
+	"io"
+	"log"
+
+	"github.com/Hiddan13/file_grpc/api/proto/pb"
+	"github.com/Hiddan13/file_grpc/internal/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// UploadMany принимает несколько файлов в одном стриме и одном слоте лимита.
+// Ошибка одного файла (неверное имя, условия записи) приходит в его
+// результате и не прерывает остальные.
+func (s *FileServer) UploadMany(stream pb.FileService_UploadManyServer) (err error) {
+	ctx, span := tracer.Start(stream.Context(), "FileServer.UploadMany", trace.WithSpanKind(trace.SpanKindServer))
+	defer func() { endSpan(span, err) }()
+
+	tr, ctx, err := s.transfers.begin(ctx, "upload", "")
+	if err != nil {
+		log.Printf("[UPLOAD] ОТКАЗ: сервер останавливается")
+		return err
+	}
+	defer s.transfers.end(tr)
+
+	if err := admit(ctx, s.uploadLimiter, stream.SendHeader); err != nil {
+		return err
+	}
+	defer func() {
+		s.uploadLimiter.release()
+		log.Printf("[UPLOAD] пакет завершён, активных загрузок: %d", s.uploadLimiter.inUse())
+	}()
+
+	var (
+		current   *uploadHeader // nil - файл ещё не начат
+		headerErr error         // заголовок неверный: чанки файла пропускаем
+		data      []byte
+		files     int
+		failed    int
+	)
+	// finish сохраняет текущий файл и отправляет его результат
+	finish := func() error {
+		if current == nil {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return status.FromContextError(err).Err()
+		}
+		filename := current.req.GetFilename()
+		resp := &pb.UploadManyResponse{Filename: filename}
+		err := headerErr
+		if err == nil {
+			resp.Result, err = s.saveUpload(ctx, *current, data)
+		}
+		if err != nil {
+			st := status.Convert(err)
+			resp.Code, resp.Error = int32(st.Code()), st.Message()
+			log.Printf("[UPLOAD] пакет: файл %s не сохранён: %v", filename, err)
+			failed++
+		}
+		files++
+		current, headerErr, data = nil, nil, nil
+		return stream.Send(resp)
+	}
+
+	for {
+		req, err := interruptible(ctx, stream.Recv)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Printf("[UPLOAD] пакет: ошибка получения: %v", err)
+			return err
+		}
+
+		var chunk []byte
+		switch msg := req.GetMsg().(type) {
+		case *pb.UploadManyRequest_Header:
+			if err := finish(); err != nil {
+				return err
+			}
+			header, err := parseUploadHeader(msg.Header)
+			current, headerErr = &header, err
+			tr.setFilename(msg.Header.GetFilename())
+			chunk = msg.Header.GetChunk()
+		case *pb.UploadManyRequest_Chunk:
+			if current == nil {
+				return status.Error(codes.InvalidArgument, "chunk before the first file header")
+			}
+			chunk = msg.Chunk
+		default:
+			return status.Error(codes.InvalidArgument, "empty message")
+		}
+
+		if err := receiveChunk(ctx, tr, chunk); err != nil {
+			log.Printf("[UPLOAD] пакет прерван: %v", err)
+			return err
+		}
+		if headerErr == nil {
+			data = append(data, chunk...)
+		}
+	}
+	if err := finish(); err != nil {
+		return err
+	}
+
+	log.Printf("[UPLOAD] пакет: файлов=%d, с ошибкой=%d, байт=%d", files, failed, tr.bytes.Load())
+	span.SetAttributes(attribute.Int("files.count", files), attribute.Int("files.failed", failed))
+	return nil
+}
+
+// DownloadMany отдаёт несколько файлов подряд в одном стриме и одном слоте
+// лимита. Отсутствующий файл получает заголовок с кодом ошибки.
+func (s *FileServer) DownloadMany(req *pb.DownloadManyRequest, stream pb.FileService_DownloadManyServer) (err error) {
+	ctx, span := tracer.Start(stream.Context(), "FileServer.DownloadMany", trace.WithSpanKind(trace.SpanKindServer))
+	defer func() { endSpan(span, err) }()
+
+	filenames := req.GetFilenames()
+	if len(filenames) == 0 {
+		return status.Error(codes.InvalidArgument, "no filenames")
+	}
+
+	tr, ctx, err := s.transfers.begin(ctx, "download", "")
+	if err != nil {
+		log.Printf("[DOWNLOAD] ОТКАЗ: сервер останавливается")
+		return err
+	}
+	defer s.transfers.end(tr)
+
+	if err := admit(ctx, s.downloadLimiter, stream.SendHeader); err != nil {
+		return err
+	}
+	defer func() {
+		s.downloadLimiter.release()
+		log.Printf("[DOWNLOAD] пакет завершён, активных скачиваний: %d", s.downloadLimiter.inUse())
+	}()
+
+	log.Printf("[DOWNLOAD] пакет: запрошено файлов: %d", len(filenames))
+	failed := 0
+	for _, filename := range filenames {
+		if err := ctx.Err(); err != nil {
+			return status.FromContextError(err).Err()
+		}
+		tr.setFilename(filename)
+
+		header := &pb.DownloadManyHeader{Filename: filename}
+		data, meta, err := s.fileService.GetFileWithInfo(ctx, filename)
+		if err != nil {
+			st := status.Convert(fileStatus(err, codes.NotFound, "file not found"))
+			header.Code, header.Error = int32(st.Code()), st.Message()
+			log.Printf("[DOWNLOAD] пакет: файл %s недоступен: %v", filename, err)
+			failed++
+			if err := stream.Send(&pb.DownloadManyResponse{Msg: &pb.DownloadManyResponse_Header{Header: header}}); err != nil {
+				return err
+			}
+			continue
+		}
+
+		header.Size = int64(len(data))
+		header.Etag, header.ContentType = meta.ETag, meta.ContentType
+		if header.ContentType == "" {
+			header.ContentType = detectContentType(filename, data)
+		}
+		if err := stream.Send(&pb.DownloadManyResponse{Msg: &pb.DownloadManyResponse_Header{Header: header}}); err != nil {
+			return err
+		}
+		if _, err := sendChunks(ctx, tr, data, func(chunk []byte) error {
+			return stream.Send(&pb.DownloadManyResponse{Msg: &pb.DownloadManyResponse_Chunk{Chunk: chunk}})
+		}); err != nil {
+			log.Printf("[DOWNLOAD] пакет прерван на %s: %v", filename, err)
+			return err
+		}
+		if err := s.fileService.UpdateAccess(ctx, filename, int64(len(data))); err != nil {
+			log.Printf("[DOWNLOAD] ошибка учёта доступа к %s: %v", filename, err)
+		}
+		span.AddEvent("file sent", trace.WithAttributes(tracing.AttrFilename.String(filename), tracing.AttrSize.Int(len(data))))
+	}
+
+	log.Printf("[DOWNLOAD] пакет: отправлено файлов=%d, с ошибкой=%d, байт=%d", len(filenames)-failed, failed, tr.bytes.Load())
+	span.SetAttributes(attribute.Int("files.count", len(filenames)), attribute.Int("files.failed", failed))
+	return nil
+}
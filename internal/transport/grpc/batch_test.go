@@ -0,0 +1,123 @@
+package grpc
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/Hiddan13/file_grpc/api/proto/pb"
+	"github.com/Hiddan13/file_grpc/internal/repository"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func uploadManyHeader(h *pb.UploadRequest) *pb.UploadManyRequest {
+	return &pb.UploadManyRequest{Msg: &pb.UploadManyRequest_Header{Header: h}}
+}
+
+func uploadManyChunk(c []byte) *pb.UploadManyRequest {
+	return &pb.UploadManyRequest{Msg: &pb.UploadManyRequest_Chunk{Chunk: c}}
+}
+
+// ---------------------------------------------------------------------
+// UploadMany
+// ---------------------------------------------------------------------
+func TestFileServer_UploadMany(t *testing.T) {
+	fs, svc := newTestFileServer(t, repository.Options{})
+	client := startTestServer(t, fs)
+	ctx := context.Background()
+
+	t.Run("per-file results", func(t *testing.T) {
+		stream, err := client.UploadMany(ctx)
+		require.NoError(t, err)
+		for _, req := range []*pb.UploadManyRequest{
+			uploadManyHeader(&pb.UploadRequest{Filename: "a.txt", Chunk: []byte("aa")}),
+			uploadManyChunk([]byte("aa")),
+			uploadManyHeader(&pb.UploadRequest{Filename: "../bad", Chunk: []byte("x")}),
+			uploadManyHeader(&pb.UploadRequest{Filename: "c.txt", Chunk: []byte("c"), ContentType: "bad type"}),
+			uploadManyChunk([]byte("c")),
+			uploadManyHeader(&pb.UploadRequest{Filename: "d.txt", Chunk: []byte("d")}),
+		} {
+			require.NoError(t, stream.Send(req))
+		}
+		require.NoError(t, stream.CloseSend())
+
+		var results []*pb.UploadManyResponse
+		for {
+			resp, err := stream.Recv()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+			results = append(results, resp)
+		}
+		require.Len(t, results, 4)
+		assert.Equal(t, "a.txt", results[0].Filename)
+		assert.Equal(t, int64(4), results[0].Result.GetSize())
+		assert.Equal(t, int32(codes.InvalidArgument), results[1].Code)
+		assert.Equal(t, int32(codes.InvalidArgument), results[2].Code)
+		assert.Equal(t, int32(codes.OK), results[3].Code)
+
+		data, err := svc.GetFile(ctx, "a.txt")
+		require.NoError(t, err)
+		assert.Equal(t, []byte("aaaa"), data)
+		_, err = svc.GetFile(ctx, "c.txt")
+		assert.Error(t, err)
+	})
+
+	t.Run("chunk before header", func(t *testing.T) {
+		stream, err := client.UploadMany(ctx)
+		require.NoError(t, err)
+		require.NoError(t, stream.Send(uploadManyChunk([]byte("x"))))
+		require.NoError(t, stream.CloseSend())
+		_, err = stream.Recv()
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+}
+
+// ---------------------------------------------------------------------
+// DownloadMany
+// ---------------------------------------------------------------------
+func TestFileServer_DownloadMany(t *testing.T) {
+	fs, svc := newTestFileServer(t, repository.Options{})
+	client := startTestServer(t, fs)
+	ctx := context.Background()
+	_, err := svc.SaveFile(ctx, "a.txt", []byte("hello"), repository.SaveOptions{})
+	require.NoError(t, err)
+	_, err = svc.SaveFile(ctx, "b.txt", []byte("world"), repository.SaveOptions{})
+	require.NoError(t, err)
+
+	stream, err := client.DownloadMany(ctx, &pb.DownloadManyRequest{Filenames: []string{"a.txt", "missing.txt", "../b.txt", repository.MetaDir, "b.txt"}})
+	require.NoError(t, err)
+
+	var headers []*pb.DownloadManyHeader
+	files := map[string][]byte{}
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		if h := resp.GetHeader(); h != nil {
+			headers = append(headers, h)
+			continue
+		}
+		name := headers[len(headers)-1].Filename
+		files[name] = append(files[name], resp.GetChunk()...)
+	}
+	require.Len(t, headers, 5)
+	assert.Equal(t, int32(codes.NotFound), headers[1].Code)
+	assert.Equal(t, int32(codes.InvalidArgument), headers[2].Code, "выход за пределы хранилища")
+	assert.Equal(t, int32(codes.InvalidArgument), headers[3].Code, "служебная директория")
+	assert.Equal(t, int64(5), headers[0].Size)
+	assert.NotEmpty(t, headers[0].Etag)
+	assert.Equal(t, map[string][]byte{"a.txt": []byte("hello"), "b.txt": []byte("world")}, files)
+
+	empty, err := client.DownloadMany(ctx, &pb.DownloadManyRequest{})
+	require.NoError(t, err)
+	_, err = empty.Recv()
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
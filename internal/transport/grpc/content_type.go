@@ -0,0 +1,35 @@
+package grpc
+
+import (
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// Заголовок Download с MIME типом файла. "content-type" в gRPC занят
+// самим протоколом.
+const ContentTypeHeader = "x-content-type"
+
+// detectContentType определяет MIME тип по первым байтам файла, а если они
+// ничего не говорят (octet-stream, простой текст) - по расширению имени
+func detectContentType(filename string, head []byte) string {
+	sniffed := http.DetectContentType(head)
+	if sniffed != "application/octet-stream" && !strings.HasPrefix(sniffed, "text/plain") {
+		return sniffed
+	}
+	if byExt := mime.TypeByExtension(strings.ToLower(filepath.Ext(filename))); byExt != "" {
+		return byExt
+	}
+	return sniffed
+}
+
+// normalizeContentType проверяет тип, заданный клиентом, и приводит его к
+// каноническому виду
+func normalizeContentType(v string) (string, error) {
+	mediaType, params, err := mime.ParseMediaType(v)
+	if err != nil {
+		return "", err
+	}
+	return mime.FormatMediaType(mediaType, params), nil
+}
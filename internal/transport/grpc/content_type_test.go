@@ -0,0 +1,41 @@
+package grpc
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ---------------------------------------------------------------------
+// Определение MIME типа
+// ---------------------------------------------------------------------
+func TestDetectContentType(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+		head     []byte
+		want     string
+	}{
+		{"png by content", "image.bin", []byte("\x89PNG\r\n\x1a\n0000"), "image/png"},
+		{"content wins over extension", "image.txt", []byte("%PDF-1.7"), "application/pdf"},
+		{"json by extension", "data.json", []byte(`{"a": 1}`), "application/json"},
+		{"uppercase extension", "STYLE.CSS", []byte("body {}"), "text/css; charset=utf-8"},
+		{"plain text without extension", "README", []byte("hello"), "text/plain; charset=utf-8"},
+		{"unknown binary", "blob", []byte{0x00, 0x01, 0x02}, "application/octet-stream"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, detectContentType(tt.filename, tt.head))
+		})
+	}
+}
+
+func TestNormalizeContentType(t *testing.T) {
+	got, err := normalizeContentType("Text/HTML; Charset=UTF-8")
+	require.NoError(t, err)
+	assert.Equal(t, "text/html; charset=UTF-8", got)
+
+	_, err = normalizeContentType("not a type")
+	assert.Error(t, err)
+}
@@ -0,0 +1,201 @@
+package grpc
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"path"
+	"strings"
+
+	"github.com/Hiddan13/file_grpc/api/proto/pb"
+	"github.com/Hiddan13/file_grpc/internal/service"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ExtractLimits - ограничения на распаковку архива при загрузке
+type ExtractLimits struct {
+	MaxEntries int   // файлов в архиве
+	MaxBytes   int64 // суммарный размер распакованных файлов
+}
+
+// DefaultExtractLimits - ограничения, пока не вызван SetExtractLimits
+var DefaultExtractLimits = ExtractLimits{MaxEntries: 1000, MaxBytes: 512 << 20}
+
+// ErrInvalidArchive - архив не читается, нарушает ограничения или содержит
+// недопустимые записи
+var ErrInvalidArchive = errors.New("invalid archive")
+
+// SetExtractLimits меняет ограничения распаковки без перезапуска
+func (s *FileServer) SetExtractLimits(l ExtractLimits) error {
+	if l.MaxEntries <= 0 || l.MaxBytes <= 0 {
+		return fmt.Errorf("extract limits must be positive")
+	}
+	s.extractLimits.Store(&l)
+	return nil
+}
+
+// archiveEntry - файл из архива
+type archiveEntry struct {
+	name string
+	data []byte
+}
+
+// readArchive читает все файлы архива в память. Директории пропускаются;
+// ссылки, абсолютные пути и ".." (zip-slip), вложенные пути и повторы
+// имён, а также превышение limits - ошибка ErrInvalidArchive.
+func readArchive(format string, data []byte, limits ExtractLimits) ([]archiveEntry, error) {
+	r := &archiveReader{limits: limits, seen: make(map[string]bool)}
+	var err error
+	switch format {
+	case ArchiveTar:
+		err = r.readTar(bytes.NewReader(data))
+	case ArchiveTarGz:
+		var gz *gzip.Reader
+		if gz, err = gzip.NewReader(bytes.NewReader(data)); err == nil {
+			err = r.readTar(gz)
+		}
+	case ArchiveZip:
+		err = r.readZip(data)
+	default:
+		return nil, fmt.Errorf("%w: unknown format %q", ErrInvalidArchive, format)
+	}
+	if err != nil && !errors.Is(err, ErrInvalidArchive) {
+		err = fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+	}
+	return r.entries, err
+}
+
+type archiveReader struct {
+	limits  ExtractLimits
+	entries []archiveEntry
+	total   int64
+	seen    map[string]bool
+}
+
+func (r *archiveReader) readTar(src io.Reader) error {
+	tr := tar.NewReader(src)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			continue
+		case tar.TypeReg:
+			if err := r.add(hdr.Name, tr); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("%w: %s: unsupported entry type %q", ErrInvalidArchive, hdr.Name, hdr.Typeflag)
+		}
+	}
+}
+
+func (r *archiveReader) readZip(data []byte) error {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return err
+	}
+	for _, f := range zr.File {
+		mode := f.Mode()
+		if mode.IsDir() {
+			continue
+		}
+		if mode.Type() != 0 {
+			return fmt.Errorf("%w: %s: unsupported entry type %s", ErrInvalidArchive, f.Name, mode.Type())
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		err = r.add(f.Name, rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// add проверяет имя записи и читает её с учётом ограничений
+func (r *archiveReader) add(name string, src io.Reader) error {
+	name, err := entryName(name)
+	if err != nil {
+		return err
+	}
+	if r.seen[name] {
+		return fmt.Errorf("%w: duplicate entry %s", ErrInvalidArchive, name)
+	}
+	if len(r.entries) >= r.limits.MaxEntries {
+		return fmt.Errorf("%w: more than %d entries", ErrInvalidArchive, r.limits.MaxEntries)
+	}
+	// Читаем на байт больше остатка, чтобы заметить превышение
+	left := r.limits.MaxBytes - r.total
+	data, err := io.ReadAll(io.LimitReader(src, left+1))
+	if err != nil {
+		return err
+	}
+	if int64(len(data)) > left {
+		return fmt.Errorf("%w: extracted size exceeds %d bytes", ErrInvalidArchive, r.limits.MaxBytes)
+	}
+	r.total += int64(len(data))
+	r.seen[name] = true
+	r.entries = append(r.entries, archiveEntry{name: name, data: data})
+	return nil
+}
+
+// entryName проверяет путь записи: выход за пределы хранилища (zip-slip)
+// и имена, которые не пропустил бы SaveFile
+func entryName(name string) (string, error) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if path.IsAbs(name) || !fs.ValidPath(strings.TrimPrefix(name, "./")) {
+		return "", fmt.Errorf("%w: unsafe path %q", ErrInvalidArchive, name)
+	}
+	name = path.Clean(name)
+	if err := service.ValidateFilename(name); err != nil {
+		return "", fmt.Errorf("%w: entry %q: %v", ErrInvalidArchive, name, err)
+	}
+	return name, nil
+}
+
+// extractUpload распаковывает загруженный архив в отдельные файлы с
+// метаданными и тегами заголовка. Условия записи и срок жизни относятся к
+// самому архиву и к файлам не применяются, MIME тип определяется для
+// каждого. Ошибка сохранения одного файла попадает в его результат.
+func (s *FileServer) extractUpload(ctx context.Context, h uploadHeader, data []byte) (*pb.UploadResponse, error) {
+	entries, err := readArchive(h.extract, data, *s.extractLimits.Load())
+	if err != nil {
+		log.Printf("[UPLOAD] архив %s отклонён: %v", h.req.GetFilename(), err)
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	resp := &pb.UploadResponse{Message: "archive extracted", Size: int64(len(data))}
+	for _, e := range entries {
+		entry := uploadHeader{req: &pb.UploadRequest{
+			Filename: e.name,
+			Metadata: h.req.GetMetadata(),
+			Tags:     h.req.GetTags(),
+		}}
+		result := &pb.UploadManyResponse{Filename: e.name}
+		if result.Result, err = s.saveUpload(ctx, entry, e.data); err != nil {
+			st := status.Convert(err)
+			result.Code, result.Error = int32(st.Code()), st.Message()
+		}
+		resp.Extracted = append(resp.Extracted, result)
+	}
+	log.Printf("[UPLOAD] архив %s распакован, файлов: %d", h.req.GetFilename(), len(entries))
+	return resp, nil
+}
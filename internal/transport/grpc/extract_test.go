@@ -0,0 +1,165 @@
+package grpc
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/Hiddan13/file_grpc/api/proto/pb"
+	"github.com/Hiddan13/file_grpc/internal/repository"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type testEntry struct {
+	name string
+	body string
+	kind byte // tar.TypeReg по умолчанию
+}
+
+func makeTar(t *testing.T, gz bool, entries ...testEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	var zw *gzip.Writer
+	tw := tar.NewWriter(&buf)
+	if gz {
+		zw = gzip.NewWriter(&buf)
+		tw = tar.NewWriter(zw)
+	}
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0644, Size: int64(len(e.body)), Typeflag: tar.TypeReg}
+		if e.kind != 0 {
+			hdr.Typeflag, hdr.Size, hdr.Linkname = e.kind, 0, "/etc/passwd"
+		}
+		require.NoError(t, tw.WriteHeader(hdr))
+		_, err := tw.Write([]byte(e.body))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	if zw != nil {
+		require.NoError(t, zw.Close())
+	}
+	return buf.Bytes()
+}
+
+func makeZip(t *testing.T, entries ...testEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range entries {
+		w, err := zw.Create(e.name)
+		require.NoError(t, err)
+		_, err = w.Write([]byte(e.body))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+// ---------------------------------------------------------------------
+// readArchive
+// ---------------------------------------------------------------------
+func TestReadArchive(t *testing.T) {
+	limits := ExtractLimits{MaxEntries: 3, MaxBytes: 10}
+
+	t.Run("formats", func(t *testing.T) {
+		for format, data := range map[string][]byte{
+			ArchiveTar:   makeTar(t, false, testEntry{name: "./a.txt", body: "a"}, testEntry{name: "./", kind: tar.TypeDir}, testEntry{name: "b.txt", body: "b"}),
+			ArchiveTarGz: makeTar(t, true, testEntry{name: "a.txt", body: "a"}, testEntry{name: "b.txt", body: "b"}),
+			ArchiveZip:   makeZip(t, testEntry{name: "a.txt", body: "a"}, testEntry{name: "b.txt", body: "b"}),
+		} {
+			entries, err := readArchive(format, data, limits)
+			require.NoError(t, err, format)
+			assert.Equal(t, []archiveEntry{{name: "a.txt", data: []byte("a")}, {name: "b.txt", data: []byte("b")}}, entries, format)
+		}
+	})
+
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"zip slip", makeTar(t, false, testEntry{name: "../evil", body: "x"}), "unsafe path"},
+		{"zip slip in zip", makeZip(t, testEntry{name: "a/../../evil", body: "x"}), "unsafe path"},
+		{"absolute path", makeTar(t, false, testEntry{name: "/etc/evil", body: "x"}), "unsafe path"},
+		{"nested path", makeTar(t, false, testEntry{name: "dir/a.txt", body: "x"}), "invalid filename"},
+		{"reserved name", makeTar(t, false, testEntry{name: ".tmp-x", body: "x"}), "invalid filename"},
+		{"symlink", makeTar(t, false, testEntry{name: "link", kind: tar.TypeSymlink}), "unsupported entry type"},
+		{"duplicate", makeTar(t, false, testEntry{name: "a", body: "1"}, testEntry{name: "./a", body: "2"}), "duplicate"},
+		{"too many entries", makeTar(t, false, testEntry{name: "a", body: "1"}, testEntry{name: "b", body: "1"}, testEntry{name: "c", body: "1"}, testEntry{name: "d", body: "1"}), "entries"},
+		{"too large", makeTar(t, false, testEntry{name: "a", body: "123456"}, testEntry{name: "b", body: "123456"}), "exceeds"},
+		{"corrupted", []byte("not a tar"), "invalid archive"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			format := ArchiveTar
+			if strings.Contains(tt.name, "in zip") {
+				format = ArchiveZip
+			}
+			_, err := readArchive(format, tt.data, limits)
+			require.ErrorIs(t, err, ErrInvalidArchive)
+			assert.Contains(t, err.Error(), tt.want)
+		})
+	}
+}
+
+// ---------------------------------------------------------------------
+// Upload с распаковкой
+// ---------------------------------------------------------------------
+func TestFileServer_UploadExtract(t *testing.T) {
+	fs, svc := newTestFileServer(t, repository.Options{})
+	client := startTestServer(t, fs)
+	ctx := context.Background()
+
+	archive := makeTar(t, true, testEntry{name: "a.txt", body: "hello"}, testEntry{name: "b.json", body: `{"b":1}`}, testEntry{name: "empty", body: ""})
+	resp, err := upload(t, client, &pb.UploadRequest{Filename: "bundle.tar.gz", Chunk: archive, Extract: ArchiveTarGz, Tags: []string{"bundle"}})
+	require.NoError(t, err)
+	require.Len(t, resp.Extracted, 3)
+	assert.Equal(t, "application/json", resp.Extracted[1].Result.GetContentType())
+	// Пустой файл SaveFile не принимает - ошибка только у него
+	assert.NotEqual(t, int32(codes.OK), resp.Extracted[2].Code)
+	assert.Contains(t, resp.Extracted[2].Error, "empty file")
+
+	data, err := svc.GetFile(ctx, "a.txt")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("hello"), data)
+	meta, err := svc.GetFileInfo(ctx, "b.json")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bundle"}, meta.Tags)
+	_, err = svc.GetFileInfo(ctx, "bundle.tar.gz")
+	assert.ErrorIs(t, err, repository.ErrNotFound)
+
+	t.Run("archive preconditions and ttl not applied to entries", func(t *testing.T) {
+		// a.txt уже есть: if_none_match относится к архиву, не к файлам
+		again := makeTar(t, false, testEntry{name: "a.txt", body: "again"})
+		resp, err := upload(t, client, &pb.UploadRequest{Filename: "again.tar", Chunk: again, Extract: ArchiveTar, IfNoneMatch: true, TtlSeconds: 60})
+		require.NoError(t, err)
+		require.Len(t, resp.Extracted, 1)
+		assert.Equal(t, int32(codes.OK), resp.Extracted[0].Code, resp.Extracted[0].Error)
+		meta, err := svc.GetFileInfo(ctx, "a.txt")
+		require.NoError(t, err)
+		assert.True(t, meta.ExpiresAt.IsZero())
+	})
+
+	t.Run("rejected archive writes nothing", func(t *testing.T) {
+		bad := makeTar(t, false, testEntry{name: "c.txt", body: "c"}, testEntry{name: "../evil", body: "x"})
+		_, err := upload(t, client, &pb.UploadRequest{Filename: "bad.tar", Chunk: bad, Extract: ArchiveTar})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		_, err = svc.GetFileInfo(ctx, "c.txt")
+		assert.ErrorIs(t, err, repository.ErrNotFound)
+	})
+
+	t.Run("limits", func(t *testing.T) {
+		require.NoError(t, fs.SetExtractLimits(ExtractLimits{MaxEntries: 1, MaxBytes: 100}))
+		_, err := upload(t, client, &pb.UploadRequest{Filename: "x.tar.gz", Chunk: archive, Extract: ArchiveTarGz})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		assert.Error(t, fs.SetExtractLimits(ExtractLimits{}))
+	})
+}
@@ -0,0 +1,150 @@
+package grpc
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	errQueueFull    = errors.New("queue is full")
+	errQueueTimeout = errors.New("queue wait timeout")
+)
+
+// Admission задаёт поведение при достижении лимита: сразу отказать или
+// поставить запрос в очередь ограниченной длины.
+type Admission struct {
+	Wait        bool
+	QueueLength int
+	MaxWait     time.Duration
+}
+
+// limiter - семафор с FIFO очередью ожидания. Освободившийся слот передаётся
+// первому в очереди, поэтому новые запросы не обгоняют ожидающих.
+type limiter struct {
+	name     string
+	maxQueue int
+	maxWait  time.Duration
+
+	mu      sync.Mutex
+	limit   int
+	active  int
+	waiters *list.List // chan struct{}, закрывается при выдаче слота
+}
+
+func newLimiter(name string, limit int, admission Admission) *limiter {
+	l := &limiter{
+		name:    name,
+		limit:   limit,
+		waiters: list.New(),
+	}
+	if admission.Wait {
+		l.maxQueue = admission.QueueLength
+		l.maxWait = admission.MaxWait
+	}
+	return l
+}
+
+// acquire занимает слот. Если слотов нет, ждёт в очереди не дольше maxWait
+// и дедлайна ctx; queued вызывается с позицией в очереди (с 1).
+func (l *limiter) acquire(ctx context.Context, queued func(pos int)) error {
+	l.mu.Lock()
+	if l.active < l.limit && l.waiters.Len() == 0 {
+		l.active++
+		l.mu.Unlock()
+		return nil
+	}
+	if l.waiters.Len() >= l.maxQueue {
+		l.mu.Unlock()
+		return errQueueFull
+	}
+	ready := make(chan struct{})
+	elem := l.waiters.PushBack(ready)
+	pos := l.waiters.Len()
+	l.mu.Unlock()
+
+	if queued != nil {
+		queued(pos)
+	}
+
+	var timeout <-chan time.Time
+	if l.maxWait > 0 {
+		timer := time.NewTimer(l.maxWait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	var err error
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-timeout:
+		err = errQueueTimeout
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	select {
+	case <-ready:
+		// Слот выдали одновременно с отменой - отдаём его следующему
+		l.releaseLocked()
+	default:
+		l.waiters.Remove(elem)
+	}
+	return err
+}
+
+func (l *limiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.releaseLocked()
+}
+
+func (l *limiter) releaseLocked() {
+	if front := l.waiters.Front(); front != nil && l.active <= l.limit {
+		// Слот переходит ожидающему, active не меняется
+		l.waiters.Remove(front)
+		close(front.Value.(chan struct{}))
+		return
+	}
+	l.active--
+}
+
+// resize меняет лимит на лету. При увеличении сразу пропускает ожидающих,
+// при уменьшении активные передачи доживают, а новые ждут, пока занятость
+// не опустится ниже нового лимита.
+func (l *limiter) resize(limit int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limit = limit
+	for l.active < l.limit && l.waiters.Len() > 0 {
+		front := l.waiters.Front()
+		l.waiters.Remove(front)
+		close(front.Value.(chan struct{}))
+		l.active++
+	}
+}
+
+// inUse вернёт число занятых слотов
+func (l *limiter) inUse() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.active
+}
+
+// queued вернёт длину очереди ожидания
+func (l *limiter) queued() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.waiters.Len()
+}
+
+func (l *limiter) capacity() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.limit
+}
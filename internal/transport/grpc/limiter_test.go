@@ -0,0 +1,135 @@
+package grpc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ---------------------------------------------------------------------
+// Режим reject
+// ---------------------------------------------------------------------
+func TestLimiter_Reject(t *testing.T) {
+	ctx := context.Background()
+	l := newLimiter("upload", 2, Admission{})
+
+	require.NoError(t, l.acquire(ctx, nil))
+	require.NoError(t, l.acquire(ctx, nil))
+	assert.ErrorIs(t, l.acquire(ctx, nil), errQueueFull)
+
+	l.release()
+	assert.NoError(t, l.acquire(ctx, nil))
+	assert.Equal(t, 2, l.inUse())
+}
+
+// ---------------------------------------------------------------------
+// Режим wait
+// ---------------------------------------------------------------------
+func TestLimiter_Wait(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("fifo order", func(t *testing.T) {
+		l := newLimiter("upload", 1, Admission{Wait: true, QueueLength: 10})
+		require.NoError(t, l.acquire(ctx, nil))
+
+		order := make(chan int, 3)
+		for i := 1; i <= 3; i++ {
+			queued := make(chan int, 1)
+			go func(n int) {
+				if err := l.acquire(ctx, func(pos int) { queued <- pos }); err == nil {
+					order <- n
+				}
+			}(i)
+			// Ждём, пока горутина встанет в очередь, чтобы порядок был детерминирован
+			assert.Equal(t, i, <-queued)
+		}
+
+		for i := 1; i <= 3; i++ {
+			l.release()
+			assert.Equal(t, i, <-order)
+		}
+	})
+
+	t.Run("queue full", func(t *testing.T) {
+		l := newLimiter("upload", 1, Admission{Wait: true, QueueLength: 1})
+		require.NoError(t, l.acquire(ctx, nil))
+
+		queued := make(chan int, 1)
+		go l.acquire(ctx, func(pos int) { queued <- pos })
+		<-queued
+
+		assert.ErrorIs(t, l.acquire(ctx, nil), errQueueFull)
+	})
+
+	t.Run("max wait", func(t *testing.T) {
+		l := newLimiter("upload", 1, Admission{Wait: true, QueueLength: 1, MaxWait: 10 * time.Millisecond})
+		require.NoError(t, l.acquire(ctx, nil))
+
+		assert.ErrorIs(t, l.acquire(ctx, nil), errQueueTimeout)
+		assert.Equal(t, 0, l.queued())
+	})
+
+	t.Run("context deadline", func(t *testing.T) {
+		l := newLimiter("upload", 1, Admission{Wait: true, QueueLength: 1, MaxWait: time.Minute})
+		require.NoError(t, l.acquire(ctx, nil))
+
+		dctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+		assert.ErrorIs(t, l.acquire(dctx, nil), context.DeadlineExceeded)
+		assert.Equal(t, 0, l.queued())
+
+		// Слот не потерян
+		l.release()
+		assert.Equal(t, 0, l.inUse())
+	})
+}
+
+// ---------------------------------------------------------------------
+// Изменение лимита на лету
+// ---------------------------------------------------------------------
+func TestLimiter_Resize(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("grow admits waiters", func(t *testing.T) {
+		l := newLimiter("upload", 1, Admission{Wait: true, QueueLength: 10})
+		require.NoError(t, l.acquire(ctx, nil))
+
+		admitted := make(chan struct{}, 2)
+		for i := 0; i < 2; i++ {
+			queued := make(chan int, 1)
+			go func() {
+				if l.acquire(ctx, func(pos int) { queued <- pos }) == nil {
+					admitted <- struct{}{}
+				}
+			}()
+			<-queued
+		}
+
+		l.resize(3)
+		<-admitted
+		<-admitted
+		assert.Equal(t, 3, l.inUse())
+		assert.Equal(t, 0, l.queued())
+	})
+
+	t.Run("shrink keeps active transfers", func(t *testing.T) {
+		l := newLimiter("upload", 3, Admission{})
+		for i := 0; i < 3; i++ {
+			require.NoError(t, l.acquire(ctx, nil))
+		}
+
+		l.resize(1)
+		assert.Equal(t, 3, l.inUse())
+		assert.ErrorIs(t, l.acquire(ctx, nil), errQueueFull)
+
+		l.release()
+		l.release()
+		// Занятость опустилась до нового лимита, но не ниже
+		assert.ErrorIs(t, l.acquire(ctx, nil), errQueueFull)
+		l.release()
+		assert.NoError(t, l.acquire(ctx, nil))
+	})
+}
@@ -0,0 +1,61 @@
+package grpc
+
+import (
+	"context"
+	"log"
+
+	"github.com/Hiddan13/file_grpc/api/proto/pb"
+)
+
+type admittedKey struct{}
+
+// Admitted помечает загрузку, которую уже допустил узел кластера, принявший
+// её от клиента (см. RelayUpload): Upload не занимает для неё слот
+// upload_limit. Иначе узел, сам владеющий файлом, ждал бы слота, который
+// занят пересылаемой им же загрузкой.
+func Admitted(ctx context.Context) context.Context {
+	return context.WithValue(ctx, admittedKey{}, true)
+}
+
+func admitted(ctx context.Context) bool {
+	v, _ := ctx.Value(admittedKey{}).(bool)
+	return v
+}
+
+// RelayUpload принимает Upload, содержимое которого сохраняет не этот
+// сервер, а relay (узел кластера пересылает его владельцам файла). Как и
+// Upload, вызов виден в списке передач и отменяется через AdminService,
+// занимает слот upload_limit до завершения relay, а recv отдаёт сообщения
+// клиента с учётом его лимита скорости.
+func (s *FileServer) RelayUpload(stream pb.FileService_UploadServer, relay func(ctx context.Context, recv func() (*pb.UploadRequest, error)) error) error {
+	tr, ctx, err := s.transfers.begin(stream.Context(), "upload", "")
+	if err != nil {
+		log.Printf("[UPLOAD] ОТКАЗ: сервер останавливается")
+		return err
+	}
+	defer s.transfers.end(tr)
+
+	if err := admit(ctx, s.uploadLimiter, stream.SendHeader); err != nil {
+		return err
+	}
+	defer func() {
+		s.uploadLimiter.release()
+		log.Printf("[UPLOAD] пересылка завершена, активных загрузок: %d", s.uploadLimiter.inUse())
+	}()
+
+	first := true
+	return relay(ctx, func() (*pb.UploadRequest, error) {
+		req, err := interruptible(ctx, stream.Recv)
+		if err != nil {
+			return nil, err
+		}
+		if first {
+			tr.setFilename(req.GetFilename())
+			first = false
+		}
+		if err := receiveChunk(ctx, tr, req.GetChunk()); err != nil {
+			return nil, err
+		}
+		return req, nil
+	})
+}
@@ -2,62 +2,135 @@ package grpc
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"log"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/Hiddan13/file_grpc/api/proto/pb"
+	"github.com/Hiddan13/file_grpc/internal/ratelimit"
+	"github.com/Hiddan13/file_grpc/internal/repository"
 	"github.com/Hiddan13/file_grpc/internal/service"
+	"github.com/Hiddan13/file_grpc/internal/tracing"
 
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 )
 
+// Заголовок с позицией запроса в очереди ожидания (режим Admission.Wait)
+const QueuePositionHeader = "x-queue-position"
+
+var tracer = otel.Tracer("github.com/Hiddan13/file_grpc/internal/transport/grpc")
+
 type FileServer struct {
 	pb.UnimplementedFileServiceServer
-	fileService       *service.FileService
-	uploadSemophore   chan struct{}
-	downloadSemophore chan struct{}
-	listSemophore     chan struct{}
+	fileService     *service.FileService
+	uploadLimiter   *limiter
+	downloadLimiter *limiter
+	listLimiter     *limiter
+	transfers       *transfers
+	extractLimits   atomic.Pointer[ExtractLimits]
+}
+
+func NewFileServer(fileService *service.FileService, uploadLimit, downloadLimit, listLimit int, admission Admission) *FileServer {
+	s := &FileServer{
+		fileService:     fileService,
+		uploadLimiter:   newLimiter("upload", uploadLimit, admission),
+		downloadLimiter: newLimiter("download", downloadLimit, admission),
+		listLimiter:     newLimiter("list", listLimit, admission),
+		transfers:       newTransfers(),
+	}
+	limits := DefaultExtractLimits
+	s.extractLimits.Store(&limits)
+	return s
+}
+
+// SetLimits меняет лимиты без перезапуска; 0 - оставить текущий
+func (s *FileServer) SetLimits(uploadLimit, downloadLimit, listLimit int) error {
+	if uploadLimit < 0 || downloadLimit < 0 || listLimit < 0 {
+		return fmt.Errorf("limits must not be negative")
+	}
+	for _, r := range []struct {
+		l     *limiter
+		limit int
+	}{
+		{s.uploadLimiter, uploadLimit},
+		{s.downloadLimiter, downloadLimit},
+		{s.listLimiter, listLimit},
+	} {
+		if r.limit > 0 && r.limit != r.l.capacity() {
+			log.Printf("[LIMITS] %s: %d -> %d", r.l.name, r.l.capacity(), r.limit)
+			r.l.resize(r.limit)
+		}
+	}
+	return nil
 }
 
-func NewFileServer(fileService *service.FileService, uploadLimit, downloadLimit, listLimit int) *FileServer {
-	return &FileServer{
-		fileService:       fileService,
-		uploadSemophore:   make(chan struct{}, uploadLimit),
-		downloadSemophore: make(chan struct{}, downloadLimit),
-		listSemophore:     make(chan struct{}, listLimit),
+// Drain перестаёт принимать новые Upload/Download и ждёт завершения активных
+// до отмены ctx. Вызывается перед GracefulStop.
+func (s *FileServer) Drain(ctx context.Context) error {
+	log.Printf("[DRAIN] ожидание активных передач: %d", s.transfers.count())
+	if err := s.transfers.drain(ctx); err != nil {
+		log.Printf("[DRAIN] не дождались, осталось передач: %d", s.transfers.count())
+		return err
 	}
+	log.Printf("[DRAIN] все передачи завершены")
+	return nil
 }
 
 // Загрузка файла на ссервер стрим
-func (s *FileServer) Upload(stream pb.FileService_UploadServer) error {
-	// Лимит
-	select {
-	case s.uploadSemophore <- struct{}{}:
+func (s *FileServer) Upload(stream pb.FileService_UploadServer) (err error) {
+	ctx, span := tracer.Start(stream.Context(), "FileServer.Upload", trace.WithSpanKind(trace.SpanKindServer))
+	defer func() { endSpan(span, err) }()
+
+	tr, ctx, err := s.transfers.begin(ctx, "upload", "")
+	if err != nil {
+		log.Printf("[UPLOAD] ОТКАЗ: сервер останавливается")
+		return err
+	}
+	defer s.transfers.end(tr)
+
+	// Лимит; загрузку, пересланную узлом кластера, он уже допустил
+	if !admitted(ctx) {
+		if err := admit(ctx, s.uploadLimiter, stream.SendHeader); err != nil {
+			return err
+		}
 		defer func() {
-			<-s.uploadSemophore
-			log.Printf("[UPLOAD] завершён, активных загрузок: %d", len(s.uploadSemophore))
+			s.uploadLimiter.release()
+			log.Printf("[UPLOAD] завершён, активных загрузок: %d", s.uploadLimiter.inUse())
 		}()
-	default:
-		log.Printf("[UPLOAD] ОТКАЗ: превышен лимит (%d)", cap(s.uploadSemophore))
-		return status.Error(codes.ResourceExhausted, "upload limit exceeded")
 	}
 
-	req, err := stream.Recv()
+	req, err := interruptible(ctx, stream.Recv)
 	if err != nil {
 		log.Printf("[UPLOAD] ошибка получения первого чанка: %v", err)
 		return err
 	}
 	filename := req.GetFilename()
-	var totalSize int
+	tr.setFilename(filename)
+	header, err := parseUploadHeader(req)
+	if err != nil {
+		log.Printf("[UPLOAD] неверные параметры %s: %v", filename, err)
+		return err
+	}
 	var chunkCount = 1
-	var data []byte
-	data = append(data, req.GetChunk()...)
-	totalSize += len(req.GetChunk())
+	if err := receiveChunk(ctx, tr, req.GetChunk()); err != nil {
+		return err
+	}
+	data := append([]byte(nil), req.GetChunk()...)
 
 	for {
-		req, err := stream.Recv()
+		req, err := interruptible(ctx, stream.Recv)
 		if err == io.EOF {
 			break
 		}
@@ -65,96 +138,410 @@ func (s *FileServer) Upload(stream pb.FileService_UploadServer) error {
 			log.Printf("[UPLOAD] ошибка получения чанка: %v", err)
 			return err
 		}
+		if err := receiveChunk(ctx, tr, req.GetChunk()); err != nil {
+			log.Printf("[UPLOAD] прервана: %s: %v", filename, err)
+			return err
+		}
 		chunkCount++
 		data = append(data, req.GetChunk()...)
-		totalSize += len(req.GetChunk())
 	}
 
-	log.Printf("[UPLOAD] файл=%s, чанков=%d, размер=%d байт", filename, chunkCount, totalSize)
+	log.Printf("[UPLOAD] файл=%s, чанков=%d, размер=%d байт", filename, chunkCount, len(data))
+	span.SetAttributes(
+		tracing.AttrFilename.String(filename),
+		tracing.AttrSize.Int(len(data)),
+		tracing.AttrChunks.Int(chunkCount),
+	)
 
-	if err := s.fileService.SaveFile(stream.Context(), filename, data); err != nil {
-		log.Printf("[UPLOAD] ошибка сохранения: %v", err)
-		return status.Errorf(codes.Internal, "failed to save file: %v", err)
+	resp, err := s.saveUpload(ctx, header, data)
+	if err != nil {
+		return err
 	}
+	span.SetAttributes(tracing.AttrContentType.String(resp.ContentType))
+	return stream.SendAndClose(resp)
+}
 
-	log.Printf("[UPLOAD] успешно сохранён: %s", filename)
-	return stream.SendAndClose(&pb.UploadResponse{
-		Message: "file uploaded successfully",
-		Size:    int64(totalSize),
+// uploadHeader - проверенные параметры файла из первого сообщения загрузки
+type uploadHeader struct {
+	req         *pb.UploadRequest
+	cond        repository.Preconditions
+	contentType string
+	ttl         time.Duration
+	extract     string // формат архива для распаковки, "" - сохранить как есть
+}
+
+// parseUploadHeader проверяет условия записи, MIME тип и срок жизни
+func parseUploadHeader(req *pb.UploadRequest) (uploadHeader, error) {
+	h := uploadHeader{req: req, ttl: time.Duration(req.GetTtlSeconds()) * time.Second}
+	var err error
+	if h.cond, err = uploadPreconditions(req); err != nil {
+		return h, err
+	}
+	if ct := req.GetContentType(); ct != "" {
+		if h.contentType, err = normalizeContentType(ct); err != nil {
+			return h, status.Errorf(codes.InvalidArgument, "invalid content_type: %v", err)
+		}
+	}
+	if h.ttl < 0 {
+		return h, status.Errorf(codes.InvalidArgument, "ttl_seconds must not be negative, got %d", req.GetTtlSeconds())
+	}
+	if h.extract = req.GetExtract(); h.extract != "" {
+		if _, ok := archiveContentTypes[h.extract]; !ok {
+			return h, status.Errorf(codes.InvalidArgument, "unknown archive format %q", h.extract)
+		}
+	}
+	return h, nil
+}
+
+// receiveChunk учитывает принятый чанк: проверяет отмену передачи (например,
+// через AdminService) и ограничивает скорость клиента
+func receiveChunk(ctx context.Context, tr *transfer, chunk []byte) error {
+	if err := ctx.Err(); err != nil {
+		return status.FromContextError(err).Err()
+	}
+	if err := ratelimit.WaitUpload(ctx, len(chunk)); err != nil {
+		return err
+	}
+	tr.bytes.Add(int64(len(chunk)))
+	return nil
+}
+
+// saveUpload сохраняет принятое содержимое с параметрами из заголовка, а
+// архив при h.extract распаковывает
+func (s *FileServer) saveUpload(ctx context.Context, h uploadHeader, data []byte) (*pb.UploadResponse, error) {
+	if h.extract != "" {
+		return s.extractUpload(ctx, h, data)
+	}
+	filename := h.req.GetFilename()
+	contentType := h.contentType
+	if contentType == "" {
+		contentType = detectContentType(filename, data)
+	}
+	var expiresAt time.Time
+	if h.ttl > 0 {
+		expiresAt = time.Now().Add(h.ttl)
+	}
+	meta, err := s.fileService.SaveFile(ctx, filename, data, repository.SaveOptions{
+		Cond:        h.cond,
+		Metadata:    h.req.GetMetadata(),
+		Tags:        h.req.GetTags(),
+		ContentType: contentType,
+		ExpiresAt:   expiresAt,
 	})
+	if err != nil {
+		log.Printf("[UPLOAD] ошибка сохранения %s: %v", filename, err)
+		return nil, fileStatus(err, codes.Internal, "failed to save file")
+	}
+
+	log.Printf("[UPLOAD] успешно сохранён: %s, etag=%s, тип=%s", filename, meta.ETag, meta.ContentType)
+	return &pb.UploadResponse{
+		Message:     "file uploaded successfully",
+		Size:        int64(len(data)),
+		Etag:        meta.ETag,
+		ContentType: meta.ContentType,
+		ExpiresAt:   formatOptionalTime(meta.ExpiresAt),
+	}, nil
 }
 
-// Скачаем файл через стрим
-func (s *FileServer) Download(req *pb.DownloadRequest, stream pb.FileService_DownloadServer) error {
-	select {
-	case s.downloadSemophore <- struct{}{}:
-		defer func() {
-			<-s.downloadSemophore
-			log.Printf("[DOWNLOAD] завершён, активных скачиваний: %d", len(s.downloadSemophore))
-		}()
-	default:
-		log.Printf("[DOWNLOAD] ОТКАЗ: превышен лимит (%d)", cap(s.downloadSemophore))
-		return status.Error(codes.ResourceExhausted, "download limit exceeded")
+// uploadPreconditions достаёт условия записи из первого сообщения Upload
+func uploadPreconditions(req *pb.UploadRequest) (repository.Preconditions, error) {
+	cond := repository.Preconditions{
+		IfNoneMatch: req.GetIfNoneMatch(),
+		IfMatch:     req.GetIfMatch(),
+	}
+	if cond.IfNoneMatch && cond.IfMatch != "" {
+		return cond, status.Error(codes.InvalidArgument, "if_none_match and if_match are mutually exclusive")
 	}
+	if v := req.GetIfUnmodifiedSince(); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return cond, status.Errorf(codes.InvalidArgument, "invalid if_unmodified_since: %v", err)
+		}
+		cond.IfUnmodifiedSince = t
+	}
+	return cond, nil
+}
+
+// Скачаем файл через стрим
+func (s *FileServer) Download(req *pb.DownloadRequest, stream pb.FileService_DownloadServer) (err error) {
+	ctx, span := tracer.Start(stream.Context(), "FileServer.Download", trace.WithSpanKind(trace.SpanKindServer))
+	defer func() { endSpan(span, err) }()
 
 	filename := req.GetFilename()
-	log.Printf("[DOWNLOAD] запрос файла: %s", filename)
+	tr, ctx, err := s.transfers.begin(ctx, "download", filename)
+	if err != nil {
+		log.Printf("[DOWNLOAD] ОТКАЗ: сервер останавливается")
+		return err
+	}
+	defer s.transfers.end(tr)
+
+	// Позиция в очереди уходит в одном заголовке с MIME типом: заголовок
+	// отправляется один раз, а тип известен только после чтения файла
+	if err := admit(ctx, s.downloadLimiter, stream.SetHeader); err != nil {
+		return err
+	}
+	defer func() {
+		s.downloadLimiter.release()
+		log.Printf("[DOWNLOAD] завершён, активных скачиваний: %d", s.downloadLimiter.inUse())
+	}()
 
-	data, err := s.fileService.GetFile(stream.Context(), filename)
+	version := int(req.GetVersion())
+	log.Printf("[DOWNLOAD] запрос файла: %s, версия: %d", filename, version)
+	span.SetAttributes(tracing.AttrFilename.String(filename), attribute.Int("file.version", version))
+
+	var data []byte
+	var meta repository.FileMeta
+	if version != 0 {
+		data, err = s.fileService.GetFileVersion(ctx, filename, version)
+	} else {
+		data, meta, err = s.fileService.GetFileWithInfo(ctx, filename)
+	}
+	if err != nil {
+		log.Printf("[DOWNLOAD] файл не найден: %s: %v", filename, err)
+		return fileStatus(err, codes.NotFound, "file not found")
+	}
+
+	// Версии и файлы, появившиеся в обход Upload, типа не имеют
+	contentType := meta.ContentType
+	if contentType == "" {
+		contentType = detectContentType(filename, data)
+	}
+	span.SetAttributes(tracing.AttrContentType.String(contentType))
+	// Заголовок уйдёт с первым чанком вместе с позицией в очереди
+	if err := stream.SetHeader(metadata.Pairs(ContentTypeHeader, contentType)); err != nil {
+		log.Printf("[DOWNLOAD] ошибка установки заголовка: %v", err)
+	}
+
+	chunks, err := sendChunks(ctx, tr, data, func(chunk []byte) error {
+		return stream.Send(&pb.DownloadResponse{Chunk: chunk})
+	})
 	if err != nil {
-		log.Printf("[DOWNLOAD] файл не найден: %s", filename)
-		return status.Errorf(codes.NotFound, "file not found: %v", err)
+		log.Printf("[DOWNLOAD] прервано %s: %v", filename, err)
+		return err
+	}
+	log.Printf("[DOWNLOAD] отправлен файл=%s, размер=%d, чанков=%d", filename, len(data), chunks)
+	span.SetAttributes(tracing.AttrSize.Int(len(data)), tracing.AttrChunks.Int(chunks))
+	// Учитываем только полностью отданное текущее содержимое
+	if version == 0 {
+		if err := s.fileService.UpdateAccess(ctx, filename, int64(len(data))); err != nil {
+			log.Printf("[DOWNLOAD] ошибка учёта доступа к %s: %v", filename, err)
+		}
 	}
+	return nil
+}
 
-	_ = s.fileService.UpdateAccess(stream.Context(), filename)
+// Размер чанка при отдаче файлов
+const downloadChunkSize = 64 * 1024
 
-	const chunkSize = 64 * 1024
+// sendChunks отдаёт data чанками через send с учётом отмены передачи и
+// ограничения скорости клиента. Вернёт число отправленных чанков.
+func sendChunks(ctx context.Context, tr *transfer, data []byte, send func([]byte) error) (int, error) {
 	chunks := 0
-	for i := 0; i < len(data); i += chunkSize {
-		end := i + chunkSize
-		if end > len(data) {
-			end = len(data)
+	for i := 0; i < len(data); i += downloadChunkSize {
+		end := min(i+downloadChunkSize, len(data))
+		if err := ctx.Err(); err != nil {
+			return chunks, status.FromContextError(err).Err()
 		}
-		if err := stream.Send(&pb.DownloadResponse{Chunk: data[i:end]}); err != nil {
-			log.Printf("[DOWNLOAD] ошибка отправки чанка: %v", err)
-			return err
+		if err := ratelimit.WaitDownload(ctx, end-i); err != nil {
+			return chunks, err
+		}
+		chunk := data[i:end]
+		if _, err := interruptible(ctx, func() (struct{}, error) { return struct{}{}, send(chunk) }); err != nil {
+			return chunks, err
 		}
+		tr.bytes.Add(int64(end - i))
 		chunks++
 	}
-	log.Printf("[DOWNLOAD] отправлен файл=%s, размер=%d, чанков=%d", filename, len(data), chunks)
-	return nil
+	return chunks, nil
 }
 
 // Получаем список файлов
-func (s *FileServer) ListFiles(ctx context.Context, _ *pb.Empty) (*pb.ListFilesResponse, error) {
+func (s *FileServer) ListFiles(ctx context.Context, req *pb.ListFilesRequest) (_ *pb.ListFilesResponse, err error) {
+	ctx, span := tracer.Start(ctx, "FileServer.ListFiles", trace.WithSpanKind(trace.SpanKindServer))
+	defer func() { endSpan(span, err) }()
+
 	// 1. Лимит
-	select {
-	case s.listSemophore <- struct{}{}:
-		defer func() {
-			<-s.listSemophore
-			log.Printf("[LIST] завершён, активных запросов: %d", len(s.listSemophore))
-		}()
-	default:
-		log.Printf("[LIST] ОТКАЗ: превышен лимит (%d)", cap(s.listSemophore))
-		return nil, status.Error(codes.ResourceExhausted, "list limit exceeded")
+	sendHeader := func(md metadata.MD) error { return grpc.SendHeader(ctx, md) }
+	if err := admit(ctx, s.listLimiter, sendHeader); err != nil {
+		return nil, err
 	}
+	defer func() {
+		s.listLimiter.release()
+		log.Printf("[LIST] завершён, активных запросов: %d", s.listLimiter.inUse())
+	}()
 
 	// Получаем список
-	metas, err := s.fileService.ListFiles(ctx)
+	metas, err := s.fileService.ListFiles(ctx, service.ListFilter{Prefix: req.GetPrefix(), Tags: req.GetTags()})
 	if err != nil {
 		log.Printf("[LIST] ошибка получения списка: %v", err)
 		return nil, status.Errorf(codes.Internal, "failed to list files: %v", err)
 	}
 	log.Printf("[LIST] найдено файлов: %d", len(metas))
+	span.SetAttributes(attribute.Int("files.count", len(metas)))
 	// Преобразование в pb
 	pbFiles := make([]*pb.FileInfo, 0, len(metas))
 	for _, m := range metas {
-		pbFiles = append(pbFiles, &pb.FileInfo{
-			Filename:  m.Filename,
-			CreatedAt: m.CreatedAt.Format(time.RFC3339),
-			UpdatedAt: m.UpdatedAt.Format(time.RFC3339),
-			Size:      m.Size,
-		})
+		pbFiles = append(pbFiles, fileInfo(m))
 	}
 	return &pb.ListFilesResponse{Files: pbFiles}, nil
 }
+
+// Замена пользовательских метаданных и тегов
+func (s *FileServer) SetMetadata(ctx context.Context, req *pb.SetMetadataRequest) (_ *pb.FileInfo, err error) {
+	ctx, span := tracer.Start(ctx, "FileServer.SetMetadata", trace.WithSpanKind(trace.SpanKindServer))
+	defer func() { endSpan(span, err) }()
+	span.SetAttributes(tracing.AttrFilename.String(req.GetFilename()))
+
+	meta, err := s.fileService.SetMetadata(ctx, req.GetFilename(), req.GetMetadata(), req.GetTags())
+	if err != nil {
+		log.Printf("[METADATA] ошибка обновления %s: %v", req.GetFilename(), err)
+		return nil, fileStatus(err, codes.Internal, "failed to set metadata")
+	}
+	log.Printf("[METADATA] %s: ключей=%d, теги=%v", meta.Filename, len(meta.Metadata), meta.Tags)
+	return fileInfo(meta), nil
+}
+
+func fileInfo(m repository.FileMeta) *pb.FileInfo {
+	return &pb.FileInfo{
+		Filename:       m.Filename,
+		CreatedAt:      m.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:      m.UpdatedAt.Format(time.RFC3339),
+		Size:           m.Size,
+		Etag:           m.ETag,
+		Metadata:       m.Metadata,
+		Tags:           m.Tags,
+		ContentType:    m.ContentType,
+		DownloadCount:  m.DownloadCount,
+		BytesServed:    m.BytesServed,
+		LastAccessedAt: formatOptionalTime(m.LastAccessedAt),
+		ExpiresAt:      formatOptionalTime(m.ExpiresAt),
+	}
+}
+
+// formatOptionalTime - RFC3339, для нулевого времени пустая строка
+func formatOptionalTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+// Сохранённые версии файла
+func (s *FileServer) ListVersions(ctx context.Context, req *pb.ListVersionsRequest) (_ *pb.ListVersionsResponse, err error) {
+	ctx, span := tracer.Start(ctx, "FileServer.ListVersions", trace.WithSpanKind(trace.SpanKindServer))
+	defer func() { endSpan(span, err) }()
+	span.SetAttributes(tracing.AttrFilename.String(req.GetFilename()))
+
+	sendHeader := func(md metadata.MD) error { return grpc.SendHeader(ctx, md) }
+	if err := admit(ctx, s.listLimiter, sendHeader); err != nil {
+		return nil, err
+	}
+	defer s.listLimiter.release()
+
+	versions, err := s.fileService.ListVersions(ctx, req.GetFilename())
+	if err != nil {
+		log.Printf("[VERSIONS] ошибка получения версий %s: %v", req.GetFilename(), err)
+		return nil, fileStatus(err, codes.Internal, "failed to list versions")
+	}
+	resp := &pb.ListVersionsResponse{Versions: make([]*pb.VersionInfo, 0, len(versions))}
+	for _, v := range versions {
+		resp.Versions = append(resp.Versions, &pb.VersionInfo{
+			Version:    int32(v.Version),
+			Size:       v.Size,
+			ArchivedAt: v.ArchivedAt.Format(time.RFC3339),
+		})
+	}
+	return resp, nil
+}
+
+// Восстановление версии - это запись, поэтому занимает слот загрузки
+func (s *FileServer) RestoreVersion(ctx context.Context, req *pb.RestoreVersionRequest) (_ *pb.Empty, err error) {
+	ctx, span := tracer.Start(ctx, "FileServer.RestoreVersion", trace.WithSpanKind(trace.SpanKindServer))
+	defer func() { endSpan(span, err) }()
+	span.SetAttributes(tracing.AttrFilename.String(req.GetFilename()), attribute.Int("file.version", int(req.GetVersion())))
+
+	sendHeader := func(md metadata.MD) error { return grpc.SendHeader(ctx, md) }
+	if err := admit(ctx, s.uploadLimiter, sendHeader); err != nil {
+		return nil, err
+	}
+	defer s.uploadLimiter.release()
+
+	if err := s.fileService.RestoreVersion(ctx, req.GetFilename(), int(req.GetVersion())); err != nil {
+		log.Printf("[RESTORE] ошибка восстановления %s@%d: %v", req.GetFilename(), req.GetVersion(), err)
+		return nil, fileStatus(err, codes.Internal, "failed to restore version")
+	}
+	log.Printf("[RESTORE] %s восстановлен из версии %d", req.GetFilename(), req.GetVersion())
+	return &pb.Empty{}, nil
+}
+
+func (s *FileServer) Delete(ctx context.Context, req *pb.DeleteRequest) (_ *pb.Empty, err error) {
+	ctx, span := tracer.Start(ctx, "FileServer.Delete", trace.WithSpanKind(trace.SpanKindServer))
+	defer func() { endSpan(span, err) }()
+	span.SetAttributes(tracing.AttrFilename.String(req.GetFilename()))
+
+	cond := repository.Preconditions{IfMatch: req.GetIfMatch()}
+	if err := s.fileService.DeleteFile(ctx, req.GetFilename(), cond); err != nil {
+		log.Printf("[DELETE] ошибка удаления %s: %v", req.GetFilename(), err)
+		return nil, fileStatus(err, codes.Internal, "failed to delete file")
+	}
+	log.Printf("[DELETE] %s удалён", req.GetFilename())
+	return &pb.Empty{}, nil
+}
+
+func (s *FileServer) GetLimits(ctx context.Context, _ *pb.Empty) (*pb.LimitsResponse, error) {
+	return s.limitsResponse(), nil
+}
+
+// fileStatus переводит ошибку сервиса в gRPC статус, fallback - для
+// остальных ошибок
+func fileStatus(err error, fallback codes.Code, msg string) error {
+	code := fallback
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		code = codes.NotFound
+	case errors.Is(err, repository.ErrAlreadyExists):
+		code = codes.AlreadyExists
+	case errors.Is(err, repository.ErrPreconditionFailed):
+		code = codes.FailedPrecondition
+	case errors.Is(err, repository.ErrUploadInProgress):
+		code = codes.Aborted
+	case errors.Is(err, service.ErrInvalidFilename), errors.Is(err, service.ErrInvalidVersion),
+		errors.Is(err, service.ErrInvalidMetadata), errors.Is(err, service.ErrInvalidUploadID):
+		code = codes.InvalidArgument
+	}
+	return status.Errorf(code, "%s: %v", msg, err)
+}
+
+// admit занимает слот лимитера. Если запрос попал в очередь, клиенту сразу
+// уходит заголовок с позицией, чтобы он мог решить, ждать ли дальше.
+func admit(ctx context.Context, l *limiter, sendHeader func(metadata.MD) error) error {
+	tag := strings.ToUpper(l.name)
+	err := l.acquire(ctx, func(pos int) {
+		log.Printf("[%s] в очереди, позиция %d", tag, pos)
+		if err := sendHeader(metadata.Pairs(QueuePositionHeader, strconv.Itoa(pos))); err != nil {
+			log.Printf("[%s] ошибка отправки заголовка: %v", tag, err)
+		}
+	})
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, errQueueFull):
+		log.Printf("[%s] ОТКАЗ: превышен лимит (%d), очередь: %d", tag, l.capacity(), l.queued())
+		return status.Errorf(codes.ResourceExhausted, "%s limit exceeded", l.name)
+	case errors.Is(err, errQueueTimeout):
+		log.Printf("[%s] ОТКАЗ: истекло время ожидания в очереди", tag)
+		return status.Errorf(codes.ResourceExhausted, "%s queue wait timeout", l.name)
+	default:
+		log.Printf("[%s] ОТКАЗ: запрос отменён в очереди: %v", tag, err)
+		return status.FromContextError(err).Err()
+	}
+}
+
+// endSpan закрывает спан RPC, отмечая ошибку если она есть
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(otelcodes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,343 @@
+package grpc
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Hiddan13/file_grpc/api/proto/pb"
+	"github.com/Hiddan13/file_grpc/internal/repository"
+	"github.com/Hiddan13/file_grpc/internal/service"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func newTestFileServer(t *testing.T, opts repository.Options) (*FileServer, *service.FileService) {
+	t.Helper()
+	repo, err := repository.NewFilesRepository(t.TempDir(), opts)
+	require.NoError(t, err)
+	svc := service.NewFileService(repo)
+	return NewFileServer(svc, 10, 10, 100, Admission{}), svc
+}
+
+// startTestServer поднимает FileService в памяти и вернёт клиента к нему
+func startTestServer(t *testing.T, fs *FileServer) pb.FileServiceClient {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	pb.RegisterFileServiceServer(srv, fs)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewFileServiceClient(conn)
+}
+
+func upload(t *testing.T, client pb.FileServiceClient, first *pb.UploadRequest, chunks ...[]byte) (*pb.UploadResponse, error) {
+	t.Helper()
+	stream, err := client.Upload(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(first))
+	for _, c := range chunks {
+		require.NoError(t, stream.Send(&pb.UploadRequest{Chunk: c}))
+	}
+	return stream.CloseAndRecv()
+}
+
+func download(t *testing.T, client pb.FileServiceClient, req *pb.DownloadRequest) ([]byte, metadata.MD, error) {
+	t.Helper()
+	stream, err := client.Download(context.Background(), req)
+	require.NoError(t, err)
+	var data []byte
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		data = append(data, resp.GetChunk()...)
+	}
+	md, err := stream.Header()
+	return data, md, err
+}
+
+// ---------------------------------------------------------------------
+// Upload / Download: MIME тип
+// ---------------------------------------------------------------------
+func TestFileServer_ContentType(t *testing.T) {
+	fs, _ := newTestFileServer(t, repository.Options{})
+	client := startTestServer(t, fs)
+
+	t.Run("detected on upload", func(t *testing.T) {
+		resp, err := upload(t, client, &pb.UploadRequest{Filename: "data.json", Chunk: []byte(`{"a":`)}, []byte(`1}`))
+		require.NoError(t, err)
+		assert.Equal(t, "application/json", resp.ContentType)
+
+		data, md, err := download(t, client, &pb.DownloadRequest{Filename: "data.json"})
+		require.NoError(t, err)
+		assert.Equal(t, []byte(`{"a":1}`), data)
+		assert.Equal(t, []string{"application/json"}, md.Get(ContentTypeHeader))
+	})
+
+	t.Run("client override", func(t *testing.T) {
+		resp, err := upload(t, client, &pb.UploadRequest{Filename: "page", Chunk: []byte("<p>x</p>"), ContentType: "text/markdown"})
+		require.NoError(t, err)
+		assert.Equal(t, "text/markdown", resp.ContentType)
+
+		list, err := client.ListFiles(context.Background(), &pb.ListFilesRequest{})
+		require.NoError(t, err)
+		for _, f := range list.Files {
+			if f.Filename == "page" {
+				assert.Equal(t, "text/markdown", f.ContentType)
+			}
+		}
+	})
+
+	t.Run("invalid override", func(t *testing.T) {
+		_, err := upload(t, client, &pb.UploadRequest{Filename: "bad", Chunk: []byte("x"), ContentType: "not a type"})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+}
+
+// ---------------------------------------------------------------------
+// Download: статистика доступа
+// ---------------------------------------------------------------------
+func TestFileServer_AccessStats(t *testing.T) {
+	fs, _ := newTestFileServer(t, repository.Options{AccessTime: repository.AccessTimeStrict})
+	client := startTestServer(t, fs)
+
+	_, err := upload(t, client, &pb.UploadRequest{Filename: "a.txt", Chunk: []byte("hello")})
+	require.NoError(t, err)
+	before, err := client.ListFiles(context.Background(), &pb.ListFilesRequest{})
+	require.NoError(t, err)
+	require.Len(t, before.Files, 1)
+	assert.Empty(t, before.Files[0].LastAccessedAt)
+
+	for range 2 {
+		_, _, err := download(t, client, &pb.DownloadRequest{Filename: "a.txt"})
+		require.NoError(t, err)
+	}
+
+	after, err := client.ListFiles(context.Background(), &pb.ListFilesRequest{})
+	require.NoError(t, err)
+	require.Len(t, after.Files, 1)
+	f := after.Files[0]
+	assert.Equal(t, int64(2), f.DownloadCount)
+	assert.Equal(t, int64(10), f.BytesServed)
+	assert.NotEmpty(t, f.LastAccessedAt)
+	assert.Equal(t, before.Files[0].UpdatedAt, f.UpdatedAt)
+}
+
+// ---------------------------------------------------------------------
+// Upload: срок жизни
+// ---------------------------------------------------------------------
+func TestFileServer_UploadTTL(t *testing.T) {
+	fs, _ := newTestFileServer(t, repository.Options{})
+	client := startTestServer(t, fs)
+
+	resp, err := upload(t, client, &pb.UploadRequest{Filename: "scratch.txt", Chunk: []byte("x"), TtlSeconds: 3600})
+	require.NoError(t, err)
+	expires, err := time.Parse(time.RFC3339, resp.ExpiresAt)
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), expires, time.Minute)
+
+	resp, err = upload(t, client, &pb.UploadRequest{Filename: "keep.txt", Chunk: []byte("x")})
+	require.NoError(t, err)
+	assert.Empty(t, resp.ExpiresAt)
+
+	_, err = upload(t, client, &pb.UploadRequest{Filename: "bad.txt", Chunk: []byte("x"), TtlSeconds: -1})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+// ---------------------------------------------------------------------
+// ListVersions / RestoreVersion
+// ---------------------------------------------------------------------
+func TestFileServer_Versions(t *testing.T) {
+	ctx := context.Background()
+	fs, svc := newTestFileServer(t, repository.Options{Versioning: true})
+	_, err := svc.SaveFile(ctx, "a.txt", []byte("v1"), repository.SaveOptions{})
+	require.NoError(t, err)
+	_, err = svc.SaveFile(ctx, "a.txt", []byte("v2"), repository.SaveOptions{})
+	require.NoError(t, err)
+
+	resp, err := fs.ListVersions(ctx, &pb.ListVersionsRequest{Filename: "a.txt"})
+	require.NoError(t, err)
+	require.Len(t, resp.Versions, 1)
+	assert.Equal(t, int32(1), resp.Versions[0].Version)
+
+	_, err = fs.RestoreVersion(ctx, &pb.RestoreVersionRequest{Filename: "a.txt", Version: 1})
+	require.NoError(t, err)
+	data, err := svc.GetFile(ctx, "a.txt")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("v1"), data)
+
+	t.Run("errors", func(t *testing.T) {
+		_, err := fs.ListVersions(ctx, &pb.ListVersionsRequest{Filename: "missing.txt"})
+		assert.Equal(t, codes.NotFound, status.Code(err))
+
+		_, err = fs.RestoreVersion(ctx, &pb.RestoreVersionRequest{Filename: "a.txt", Version: 0})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+		_, err = fs.RestoreVersion(ctx, &pb.RestoreVersionRequest{Filename: "a.txt", Version: 42})
+		assert.Equal(t, codes.NotFound, status.Code(err))
+	})
+}
+
+// ---------------------------------------------------------------------
+// Условия записи Upload
+// ---------------------------------------------------------------------
+func TestUploadPreconditions(t *testing.T) {
+	t.Run("parses fields", func(t *testing.T) {
+		cond, err := uploadPreconditions(&pb.UploadRequest{IfMatch: "abc", IfUnmodifiedSince: "2025-01-02T03:04:05Z"})
+		require.NoError(t, err)
+		assert.Equal(t, "abc", cond.IfMatch)
+		assert.Equal(t, time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC), cond.IfUnmodifiedSince.UTC())
+	})
+
+	t.Run("invalid time", func(t *testing.T) {
+		_, err := uploadPreconditions(&pb.UploadRequest{IfUnmodifiedSince: "yesterday"})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("conflicting conditions", func(t *testing.T) {
+		_, err := uploadPreconditions(&pb.UploadRequest{IfNoneMatch: true, IfMatch: "abc"})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+}
+
+func TestFileStatus(t *testing.T) {
+	ctx := context.Background()
+	_, svc := newTestFileServer(t, repository.Options{})
+	meta, err := svc.SaveFile(ctx, "a.txt", []byte("v1"), repository.SaveOptions{})
+	require.NoError(t, err)
+
+	_, err = svc.SaveFile(ctx, "a.txt", []byte("v2"), repository.SaveOptions{Cond: repository.Preconditions{IfNoneMatch: true}})
+	assert.Equal(t, codes.AlreadyExists, status.Code(fileStatus(err, codes.Internal, "save")))
+
+	_, err = svc.SaveFile(ctx, "a.txt", []byte("v2"), repository.SaveOptions{Cond: repository.Preconditions{IfMatch: "stale"}})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(fileStatus(err, codes.Internal, "save")))
+
+	_, err = svc.SaveFile(ctx, "a.txt", []byte("v2"), repository.SaveOptions{Cond: repository.Preconditions{IfMatch: meta.ETag}})
+	assert.NoError(t, err)
+
+	_, err = svc.SaveFile(ctx, "../a.txt", []byte("v2"), repository.SaveOptions{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(fileStatus(err, codes.Internal, "save")))
+}
+
+// ---------------------------------------------------------------------
+// SetMetadata / ListFiles по тегам
+// ---------------------------------------------------------------------
+func TestFileServer_Metadata(t *testing.T) {
+	ctx := context.Background()
+	fs, svc := newTestFileServer(t, repository.Options{})
+	_, err := svc.SaveFile(ctx, "a.txt", []byte("a"), repository.SaveOptions{Tags: []string{"report"}})
+	require.NoError(t, err)
+	_, err = svc.SaveFile(ctx, "b.txt", []byte("b"), repository.SaveOptions{})
+	require.NoError(t, err)
+
+	info, err := fs.SetMetadata(ctx, &pb.SetMetadataRequest{Filename: "b.txt", Metadata: map[string]string{"owner": "bob"}, Tags: []string{"report", "draft"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"draft", "report"}, info.Tags)
+	assert.Equal(t, "bob", info.Metadata["owner"])
+
+	resp, err := fs.ListFiles(ctx, &pb.ListFilesRequest{Tags: []string{"draft"}})
+	require.NoError(t, err)
+	require.Len(t, resp.Files, 1)
+	assert.Equal(t, "b.txt", resp.Files[0].Filename)
+
+	_, err = fs.SetMetadata(ctx, &pb.SetMetadataRequest{Filename: "missing.txt"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	_, err = fs.SetMetadata(ctx, &pb.SetMetadataRequest{Filename: "a.txt", Tags: []string{""}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+// ---------------------------------------------------------------------
+// Delete
+// ---------------------------------------------------------------------
+func TestFileServer_Delete(t *testing.T) {
+	ctx := context.Background()
+	fs, svc := newTestFileServer(t, repository.Options{})
+	meta, err := svc.SaveFile(ctx, "a.txt", []byte("a"), repository.SaveOptions{})
+	require.NoError(t, err)
+
+	_, err = fs.Delete(ctx, &pb.DeleteRequest{Filename: "a.txt", IfMatch: "other"})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	_, err = fs.Delete(ctx, &pb.DeleteRequest{Filename: "a.txt", IfMatch: meta.ETag})
+	require.NoError(t, err)
+	_, err = svc.GetFileInfo(ctx, "a.txt")
+	assert.ErrorIs(t, err, repository.ErrNotFound)
+
+	_, err = fs.Delete(ctx, &pb.DeleteRequest{Filename: "a.txt"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	_, err = fs.Delete(ctx, &pb.DeleteRequest{Filename: "../a.txt"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+// ---------------------------------------------------------------------
+// Download: недопустимые имена
+// ---------------------------------------------------------------------
+func TestFileServer_DownloadInvalidName(t *testing.T) {
+	fs, _ := newTestFileServer(t, repository.Options{})
+	client := startTestServer(t, fs)
+	// Метаданные x лежат в .meta/x.json
+	_, err := upload(t, client, &pb.UploadRequest{Filename: "x", Chunk: []byte("x")})
+	require.NoError(t, err)
+
+	for _, name := range []string{"../x", repository.MetaDir + "/x.json"} {
+		t.Run(name, func(t *testing.T) {
+			_, _, err := download(t, client, &pb.DownloadRequest{Filename: name})
+			assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		})
+	}
+}
+
+// ---------------------------------------------------------------------
+// Download: ожидание в очереди
+// ---------------------------------------------------------------------
+func TestFileServer_DownloadQueued(t *testing.T) {
+	ctx := context.Background()
+	repo, err := repository.NewFilesRepository(t.TempDir(), repository.Options{})
+	require.NoError(t, err)
+	svc := service.NewFileService(repo)
+	fs := NewFileServer(svc, 10, 1, 100, Admission{Wait: true, QueueLength: 10})
+	client := startTestServer(t, fs)
+	_, err = svc.SaveFile(ctx, "data", []byte(`{"a":1}`), repository.SaveOptions{ContentType: "application/json"})
+	require.NoError(t, err)
+
+	// Единственный слот занят - скачивание встаёт в очередь
+	require.NoError(t, fs.downloadLimiter.acquire(ctx, nil))
+	type result struct {
+		data []byte
+		md   metadata.MD
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		data, md, err := download(t, client, &pb.DownloadRequest{Filename: "data"})
+		done <- result{data, md, err}
+	}()
+	require.Eventually(t, func() bool { return fs.downloadLimiter.queued() == 1 }, time.Second, 5*time.Millisecond)
+	fs.downloadLimiter.release()
+
+	res := <-done
+	require.NoError(t, res.err)
+	assert.Equal(t, []byte(`{"a":1}`), res.data)
+	assert.Equal(t, []string{"1"}, res.md.Get(QueuePositionHeader))
+	assert.Equal(t, []string{"application/json"}, res.md.Get(ContentTypeHeader))
+}
@@ -0,0 +1,152 @@
+package grpc
+
+import (
+	"crypto/rand"
+	"io"
+	"log"
+
+	"github.com/Hiddan13/file_grpc/api/proto/pb"
+	"github.com/Hiddan13/file_grpc/internal/service"
+	"github.com/Hiddan13/file_grpc/internal/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Через сколько принятых байт UploadStream сбрасывает данные на диск и
+// присылает ack
+const streamAckInterval = 1 << 20
+
+// UploadStream принимает файл с подтверждениями: принятое дописывается в
+// незавершённую загрузку на диске, и после каждого fsync клиент получает
+// сохранённое смещение. Оборванную загрузку можно продолжить с того же
+// upload_id; при ошибке сохранения она тоже остаётся для повтора.
+func (s *FileServer) UploadStream(stream pb.FileService_UploadStreamServer) (err error) {
+	ctx, span := tracer.Start(stream.Context(), "FileServer.UploadStream", trace.WithSpanKind(trace.SpanKindServer))
+	defer func() { endSpan(span, err) }()
+
+	tr, ctx, err := s.transfers.begin(ctx, "upload", "")
+	if err != nil {
+		log.Printf("[UPLOAD] ОТКАЗ: сервер останавливается")
+		return err
+	}
+	defer s.transfers.end(tr)
+
+	if err := admit(ctx, s.uploadLimiter, stream.SendHeader); err != nil {
+		return err
+	}
+	defer func() {
+		s.uploadLimiter.release()
+		log.Printf("[UPLOAD] завершён, активных загрузок: %d", s.uploadLimiter.inUse())
+	}()
+
+	req, err := interruptible(ctx, stream.Recv)
+	if err != nil {
+		log.Printf("[UPLOAD] ошибка получения заголовка: %v", err)
+		return err
+	}
+	hdr := req.GetHeader()
+	if hdr == nil {
+		return status.Error(codes.InvalidArgument, "first message must be a header")
+	}
+	file := hdr.GetFile()
+	if len(file.GetChunk()) > 0 {
+		return status.Error(codes.InvalidArgument, "header must not carry data, send it as chunks after the first ack")
+	}
+	filename := file.GetFilename()
+	tr.setFilename(filename)
+	header, err := parseUploadHeader(file)
+	if err != nil {
+		log.Printf("[UPLOAD] неверные параметры %s: %v", filename, err)
+		return err
+	}
+	// Имя проверяем до приёма данных, а не после
+	if header.extract == "" {
+		if err := service.ValidateFilename(filename); err != nil {
+			return fileStatus(err, codes.InvalidArgument, "invalid filename")
+		}
+	}
+
+	id := hdr.GetUploadId()
+	if id == "" {
+		id = rand.Text()
+	}
+	partial, err := s.fileService.OpenUpload(ctx, id)
+	if err != nil {
+		log.Printf("[UPLOAD] ошибка открытия загрузки %s: %v", id, err)
+		return fileStatus(err, codes.Internal, "failed to open upload")
+	}
+	defer partial.Close()
+	resumed := partial.Size()
+	log.Printf("[UPLOAD] стрим: файл=%s, upload_id=%s, продолжение с %d байт", filename, id, resumed)
+	span.SetAttributes(tracing.AttrFilename.String(filename), attribute.String("upload.id", id), attribute.Int64("upload.resumed", resumed))
+
+	// ack сбрасывает принятое на диск и сообщает клиенту смещение
+	synced := int64(-1)
+	ack := func() error {
+		if partial.Size() == synced {
+			return nil
+		}
+		if err := partial.Sync(); err != nil {
+			log.Printf("[UPLOAD] ошибка fsync %s: %v", id, err)
+			return status.Errorf(codes.Internal, "failed to sync upload: %v", err)
+		}
+		synced = partial.Size()
+		return stream.Send(&pb.UploadStreamResponse{Msg: &pb.UploadStreamResponse_Ack{
+			Ack: &pb.UploadStreamAck{UploadId: id, CommittedOffset: synced},
+		}})
+	}
+	if err := ack(); err != nil {
+		return err
+	}
+
+	for {
+		req, err := interruptible(ctx, stream.Recv)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Printf("[UPLOAD] стрим %s оборван на %d байт: %v", id, partial.Size(), err)
+			return err
+		}
+		msg, ok := req.GetMsg().(*pb.UploadStreamRequest_Chunk)
+		if !ok {
+			return status.Error(codes.InvalidArgument, "expected chunk after the header")
+		}
+		if err := receiveChunk(ctx, tr, msg.Chunk); err != nil {
+			log.Printf("[UPLOAD] прервана: %s: %v", filename, err)
+			return err
+		}
+		if _, err := partial.Write(msg.Chunk); err != nil {
+			log.Printf("[UPLOAD] ошибка записи %s: %v", id, err)
+			return status.Errorf(codes.Internal, "failed to write upload: %v", err)
+		}
+		if partial.Size()-synced >= streamAckInterval {
+			if err := ack(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := ack(); err != nil {
+		return err
+	}
+
+	data, err := partial.Bytes()
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to read upload: %v", err)
+	}
+	partial.Close()
+	log.Printf("[UPLOAD] стрим: файл=%s, размер=%d байт, принято=%d", filename, len(data), tr.bytes.Load())
+	span.SetAttributes(tracing.AttrSize.Int(len(data)))
+
+	resp, err := s.saveUpload(ctx, header, data)
+	if err != nil {
+		return err
+	}
+	if err := s.fileService.RemoveUpload(ctx, id); err != nil {
+		log.Printf("[UPLOAD] ошибка удаления загрузки %s: %v", id, err)
+	}
+	return stream.Send(&pb.UploadStreamResponse{Msg: &pb.UploadStreamResponse_Result{Result: resp}})
+}
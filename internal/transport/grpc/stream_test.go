@@ -0,0 +1,132 @@
+package grpc
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/Hiddan13/file_grpc/api/proto/pb"
+	"github.com/Hiddan13/file_grpc/internal/repository"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func uploadStreamHeader(id string, file *pb.UploadRequest) *pb.UploadStreamRequest {
+	return &pb.UploadStreamRequest{Msg: &pb.UploadStreamRequest_Header{
+		Header: &pb.UploadStreamHeader{File: file, UploadId: id},
+	}}
+}
+
+func uploadStreamChunk(c []byte) *pb.UploadStreamRequest {
+	return &pb.UploadStreamRequest{Msg: &pb.UploadStreamRequest_Chunk{Chunk: c}}
+}
+
+// recvAll читает ответы до конца стрима
+func recvAll(t *testing.T, stream pb.FileService_UploadStreamClient) ([]*pb.UploadStreamAck, *pb.UploadResponse, error) {
+	t.Helper()
+	var acks []*pb.UploadStreamAck
+	var result *pb.UploadResponse
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			return acks, result, nil
+		}
+		if err != nil {
+			return acks, result, err
+		}
+		if ack := resp.GetAck(); ack != nil {
+			acks = append(acks, ack)
+		} else {
+			result = resp.GetResult()
+		}
+	}
+}
+
+// ---------------------------------------------------------------------
+// UploadStream
+// ---------------------------------------------------------------------
+func TestFileServer_UploadStream(t *testing.T) {
+	fs, svc := newTestFileServer(t, repository.Options{})
+	client := startTestServer(t, fs)
+	ctx := context.Background()
+
+	t.Run("acks committed offsets", func(t *testing.T) {
+		stream, err := client.UploadStream(ctx)
+		require.NoError(t, err)
+		require.NoError(t, stream.Send(uploadStreamHeader("", &pb.UploadRequest{Filename: "big.bin"})))
+
+		first, err := stream.Recv()
+		require.NoError(t, err)
+		id := first.GetAck().GetUploadId()
+		assert.NotEmpty(t, id)
+		assert.Equal(t, int64(0), first.GetAck().GetCommittedOffset())
+
+		chunk := make([]byte, 256*1024)
+		for range 6 {
+			require.NoError(t, stream.Send(uploadStreamChunk(chunk)))
+		}
+		require.NoError(t, stream.CloseSend())
+
+		acks, result, err := recvAll(t, stream)
+		require.NoError(t, err)
+		// Промежуточный ack после 1 МБ и последний - после конца стрима
+		require.Len(t, acks, 2)
+		assert.Equal(t, int64(streamAckInterval), acks[0].CommittedOffset)
+		assert.Equal(t, int64(6*len(chunk)), acks[1].CommittedOffset)
+		require.NotNil(t, result)
+		assert.Equal(t, int64(6*len(chunk)), result.Size)
+
+		data, err := svc.GetFile(ctx, "big.bin")
+		require.NoError(t, err)
+		assert.Len(t, data, 6*len(chunk))
+	})
+
+	t.Run("resume after interruption", func(t *testing.T) {
+		stream, err := client.UploadStream(ctx)
+		require.NoError(t, err)
+		require.NoError(t, stream.Send(uploadStreamHeader("resume-1", &pb.UploadRequest{Filename: "resumed.txt"})))
+		require.NoError(t, stream.Send(uploadStreamChunk([]byte("hello "))))
+		// Повторный заголовок обрывает стрим после записи чанка
+		require.NoError(t, stream.Send(uploadStreamHeader("resume-1", &pb.UploadRequest{Filename: "resumed.txt"})))
+		_, result, err := recvAll(t, stream)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		assert.Nil(t, result)
+
+		stream, err = client.UploadStream(ctx)
+		require.NoError(t, err)
+		require.NoError(t, stream.Send(uploadStreamHeader("resume-1", &pb.UploadRequest{Filename: "resumed.txt"})))
+		first, err := stream.Recv()
+		require.NoError(t, err)
+		assert.Equal(t, int64(len("hello ")), first.GetAck().GetCommittedOffset())
+		require.NoError(t, stream.Send(uploadStreamChunk([]byte("world"))))
+		require.NoError(t, stream.CloseSend())
+		_, result, err = recvAll(t, stream)
+		require.NoError(t, err)
+		require.NotNil(t, result)
+
+		data, err := svc.GetFile(ctx, "resumed.txt")
+		require.NoError(t, err)
+		assert.Equal(t, "hello world", string(data))
+		removed, err := svc.CollectGarbage(ctx, 0, true)
+		require.NoError(t, err)
+		assert.Empty(t, removed, "завершённая загрузка удалена")
+	})
+
+	t.Run("invalid requests", func(t *testing.T) {
+		for name, req := range map[string]*pb.UploadStreamRequest{
+			"chunk first":    uploadStreamChunk([]byte("x")),
+			"data in header": uploadStreamHeader("", &pb.UploadRequest{Filename: "a.txt", Chunk: []byte("x")}),
+			"bad filename":   uploadStreamHeader("", &pb.UploadRequest{Filename: "../a.txt"}),
+			"bad upload id":  uploadStreamHeader("../x", &pb.UploadRequest{Filename: "a.txt"}),
+		} {
+			stream, err := client.UploadStream(ctx)
+			require.NoError(t, err)
+			require.NoError(t, stream.Send(req))
+			_, _, err = recvAll(t, stream)
+			assert.Equal(t, codes.InvalidArgument, status.Code(err), name)
+		}
+	})
+}
@@ -0,0 +1,90 @@
+package grpc
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/Hiddan13/file_grpc/api/proto/pb"
+	"github.com/Hiddan13/file_grpc/internal/repository"
+	"github.com/Hiddan13/file_grpc/internal/tracing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// Трейсеры пакетов берутся из глобального провайдера при инициализации и
+// привязываются к первому установленному, поэтому провайдер ставится один
+// раз на весь тестовый бинарник (в том числе при -count > 1)
+var testRecorder = sync.OnceValue(func() *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	return recorder
+})
+
+// findSpan вернёт законченный span с именем name
+func findSpan(t *testing.T, recorder *tracetest.SpanRecorder, name string) sdktrace.ReadOnlySpan {
+	t.Helper()
+	for _, s := range recorder.Ended() {
+		if s.Name() == name {
+			return s
+		}
+	}
+	require.Failf(t, "span not found", "%s", name)
+	return nil
+}
+
+// spanAttr вернёт значение атрибута span
+func spanAttr(s sdktrace.ReadOnlySpan, key attribute.Key) attribute.Value {
+	for _, kv := range s.Attributes() {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+// ---------------------------------------------------------------------
+// Трассировка Upload / Download
+// ---------------------------------------------------------------------
+func TestFileServer_Tracing(t *testing.T) {
+	recorder := testRecorder()
+	recorder.Reset()
+
+	fs, _ := newTestFileServer(t, repository.Options{})
+	client := startTestServer(t, fs)
+	content := []byte("hello tracing")
+
+	// chain проверяет цепочку span от сервера до репозитория: один трейс,
+	// каждый следующий - дочерний предыдущего, у всех имя и размер файла
+	chain := func(t *testing.T, names ...string) {
+		t.Helper()
+		var parent sdktrace.ReadOnlySpan
+		for _, name := range names {
+			s := findSpan(t, recorder, name)
+			if parent != nil {
+				assert.Equal(t, parent.SpanContext().TraceID(), s.SpanContext().TraceID(), name)
+				assert.Equal(t, parent.SpanContext().SpanID(), s.Parent().SpanID(), name)
+			}
+			assert.Equal(t, "a.txt", spanAttr(s, tracing.AttrFilename).AsString(), name)
+			assert.Equal(t, int64(len(content)), spanAttr(s, tracing.AttrSize).AsInt64(), name)
+			parent = s
+		}
+	}
+
+	t.Run("upload", func(t *testing.T) {
+		_, err := upload(t, client, &pb.UploadRequest{Filename: "a.txt", Chunk: content})
+		require.NoError(t, err)
+		chain(t, "FileServer.Upload", "FileService.SaveFile", "FilesRepository.Save")
+	})
+
+	t.Run("download", func(t *testing.T) {
+		data, _, err := download(t, client, &pb.DownloadRequest{Filename: "a.txt"})
+		require.NoError(t, err)
+		assert.Equal(t, content, data)
+		chain(t, "FileServer.Download", "FileService.GetFileWithInfo", "FilesRepository.GetWithMeta")
+	})
+}
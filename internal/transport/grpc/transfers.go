@@ -0,0 +1,167 @@
+package grpc
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// transfer - одна активная загрузка или скачивание
+type transfer struct {
+	id        string
+	kind      string
+	peer      string
+	startedAt time.Time
+	cancel    context.CancelFunc
+	bytes     atomic.Int64
+
+	mu       sync.Mutex
+	filename string
+}
+
+func (t *transfer) setFilename(name string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.filename = name
+}
+
+func (t *transfer) getFilename() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.filename
+}
+
+// transfers учитывает активные загрузки/скачивания: позволяет посмотреть и
+// отменить их из AdminService и дождаться их завершения при остановке сервера
+type transfers struct {
+	mu       sync.Mutex
+	nextID   int64
+	active   map[string]*transfer
+	draining bool
+	idle     chan struct{}
+}
+
+func newTransfers() *transfers {
+	return &transfers{
+		active: make(map[string]*transfer),
+		idle:   make(chan struct{}),
+	}
+}
+
+// begin регистрирует новую передачу и вернёт контекст, который отменяется
+// через cancel. После начала остановки отказывает.
+func (t *transfers) begin(ctx context.Context, kind, filename string) (*transfer, context.Context, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.draining {
+		return nil, nil, status.Error(codes.Unavailable, "server is shutting down")
+	}
+	t.nextID++
+	ctx, cancel := context.WithCancel(ctx)
+	tr := &transfer{
+		id:        strconv.FormatInt(t.nextID, 10),
+		kind:      kind,
+		peer:      peerAddr(ctx),
+		startedAt: time.Now(),
+		cancel:    cancel,
+		filename:  filename,
+	}
+	t.active[tr.id] = tr
+	return tr, ctx, nil
+}
+
+func (t *transfers) end(tr *transfer) {
+	tr.cancel()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.active, tr.id)
+	if t.draining && len(t.active) == 0 {
+		close(t.idle)
+	}
+}
+
+// cancel прерывает передачу по id; false - такой нет
+func (t *transfers) cancel(id string) bool {
+	t.mu.Lock()
+	tr, ok := t.active[id]
+	t.mu.Unlock()
+	if ok {
+		tr.cancel()
+	}
+	return ok
+}
+
+// interruptible выполняет блокирующий вызов стрима (Recv или Send), но не
+// дольше, чем живёт ctx передачи: сами они отмену через cancel не видят, и
+// клиент, переставший слать или читать, держал бы передачу бесконечно.
+// Брошенный вызов завершится, когда обработчик вернёт ошибку и gRPC
+// закроет стрим.
+func interruptible[T any](ctx context.Context, call func() (T, error)) (T, error) {
+	type result struct {
+		v   T
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		v, err := call()
+		done <- result{v, err}
+	}()
+	select {
+	case r := <-done:
+		return r.v, r.err
+	case <-ctx.Done():
+		var zero T
+		return zero, status.FromContextError(ctx.Err()).Err()
+	}
+}
+
+// list вернёт активные передачи в порядке начала
+func (t *transfers) list() []*transfer {
+	t.mu.Lock()
+	list := make([]*transfer, 0, len(t.active))
+	for _, tr := range t.active {
+		list = append(list, tr)
+	}
+	t.mu.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].startedAt.Before(list[j].startedAt) })
+	return list
+}
+
+// drain запрещает новые передачи и ждёт завершения текущих
+func (t *transfers) drain(ctx context.Context) error {
+	t.mu.Lock()
+	if !t.draining {
+		t.draining = true
+		if len(t.active) == 0 {
+			close(t.idle)
+		}
+	}
+	t.mu.Unlock()
+
+	select {
+	case <-t.idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (t *transfers) count() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.active)
+}
+
+func peerAddr(ctx context.Context) string {
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		return p.Addr.String()
+	}
+	return ""
+}
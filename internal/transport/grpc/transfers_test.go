@@ -0,0 +1,100 @@
+package grpc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ---------------------------------------------------------------------
+// Ожидание активных передач при остановке
+// ---------------------------------------------------------------------
+func TestTransfers_Drain(t *testing.T) {
+	t.Run("no active transfers", func(t *testing.T) {
+		tr := newTransfers()
+		require.NoError(t, tr.drain(context.Background()))
+	})
+
+	t.Run("waits for active transfer", func(t *testing.T) {
+		tr := newTransfers()
+		x, _, err := tr.begin(context.Background(), "upload", "a.txt")
+		require.NoError(t, err)
+
+		done := make(chan error, 1)
+		go func() { done <- tr.drain(context.Background()) }()
+
+		select {
+		case <-done:
+			t.Fatal("drain вернулся до завершения передачи")
+		case <-time.After(20 * time.Millisecond):
+		}
+
+		tr.end(x)
+		select {
+		case err := <-done:
+			assert.NoError(t, err)
+		case <-time.After(time.Second):
+			t.Fatal("drain не завершился")
+		}
+	})
+
+	t.Run("rejects new transfers while draining", func(t *testing.T) {
+		tr := newTransfers()
+		require.NoError(t, tr.drain(context.Background()))
+
+		_, _, err := tr.begin(context.Background(), "upload", "a.txt")
+		assert.Equal(t, codes.Unavailable, status.Code(err))
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		tr := newTransfers()
+		_, _, err := tr.begin(context.Background(), "download", "a.txt")
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		assert.ErrorIs(t, tr.drain(ctx), context.DeadlineExceeded)
+		assert.Equal(t, 1, tr.count())
+	})
+}
+
+// ---------------------------------------------------------------------
+// Список и отмена передач
+// ---------------------------------------------------------------------
+func TestTransfers_ListAndCancel(t *testing.T) {
+	tr := newTransfers()
+	up, upCtx, err := tr.begin(context.Background(), "upload", "")
+	require.NoError(t, err)
+	down, _, err := tr.begin(context.Background(), "download", "b.txt")
+	require.NoError(t, err)
+
+	up.setFilename("a.txt")
+	up.bytes.Add(100)
+
+	list := tr.list()
+	require.Len(t, list, 2)
+	assert.Equal(t, "upload", list[0].kind)
+	assert.Equal(t, "a.txt", list[0].getFilename())
+	assert.Equal(t, int64(100), list[0].bytes.Load())
+	assert.Equal(t, "b.txt", list[1].getFilename())
+
+	t.Run("cancel", func(t *testing.T) {
+		require.True(t, tr.cancel(up.id))
+		assert.ErrorIs(t, upCtx.Err(), context.Canceled)
+	})
+
+	t.Run("unknown id", func(t *testing.T) {
+		assert.False(t, tr.cancel("404"))
+	})
+
+	t.Run("end removes transfer", func(t *testing.T) {
+		tr.end(up)
+		tr.end(down)
+		assert.Empty(t, tr.list())
+	})
+}